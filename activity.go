@@ -0,0 +1,61 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// activity records when a Client last sent and received packets, so that
+// supervisors can detect a socket that silently stopped working.
+type activity struct {
+	created  time.Time
+	sent     atomic.Int64 // unix nanoseconds, zero if never
+	received atomic.Int64 // unix nanoseconds, zero if never
+}
+
+// markSent records that a packet was just sent.
+func (a *activity) markSent() {
+	a.sent.Store(time.Now().UnixNano())
+}
+
+// markReceived records that a packet was just received.
+func (a *activity) markReceived() {
+	a.received.Store(time.Now().UnixNano())
+}
+
+// unixTime converts unix nanoseconds to a time, mapping zero to the zero time.
+func unixTime(nsec int64) time.Time {
+	if nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nsec)
+}
+
+// LastSent returns when the Client last sent a query, or the zero time if it
+// never has.
+func (c *Client) LastSent() time.Time {
+	return unixTime(c.activity.sent.Load())
+}
+
+// LastReceived returns when the Client last received a packet, or the zero
+// time if it never has. A Client that keeps sending queries but stops
+// receiving anything, for example after resuming from suspend, may have a
+// wedged socket and need to be recreated.
+func (c *Client) LastReceived() time.Time {
+	return unixTime(c.activity.received.Load())
+}
+
+// IdleSince returns how long it has been since the Client last sent or
+// received a packet, or since it was created if it has done neither.
+func (c *Client) IdleSince() time.Duration {
+	last := c.activity.created
+	for _, t := range []time.Time{c.LastSent(), c.LastReceived()} {
+		if t.After(last) {
+			last = t
+		}
+	}
+	return time.Since(last)
+}
@@ -0,0 +1,42 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"log"
+	"testing"
+	"time"
+)
+
+func TestClient_Activity(t *testing.T) {
+	c, err := NewClient(true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	if !c.LastSent().IsZero() {
+		t.Fatalf("nothing was sent yet: %v", c.LastSent())
+	}
+	if idle := c.IdleSince(); idle < 0 || idle > time.Second {
+		t.Fatalf("bad idle time for a new Client: %v", idle)
+	}
+
+	before := time.Now()
+	if err := c.Flush("_activity._tcp"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if c.LastSent().Before(before) {
+		t.Fatalf("send was not recorded: %v", c.LastSent())
+	}
+
+	// The query is looped back to our own multicast listener
+	deadline := time.Now().Add(time.Second)
+	for c.LastReceived().Before(before) {
+		if time.Now().After(deadline) {
+			t.Fatalf("receive was not recorded: %v", c.LastReceived())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,408 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultFlapWindow and defaultFlapThreshold flag a record whose TTL
+	// changes three times within a minute.
+	defaultFlapWindow    = time.Minute
+	defaultFlapThreshold = 3
+
+	// defaultRateWindow and defaultMaxResponses flag a host sending more
+	// than 20 responses a second.
+	defaultRateWindow   = time.Second
+	defaultMaxResponses = 20
+)
+
+// FindingKind is the kind of anomaly a Finding reports.
+type FindingKind int
+
+const (
+	// FindingConflict is reported when two hosts claim the same unique
+	// record, such as the SRV record of an instance, with different data.
+	FindingConflict FindingKind = iota
+
+	// FindingTTLFlap is reported when the TTL of a record keeps changing.
+	FindingTTLFlap
+
+	// FindingResponseRate is reported when a host sends responses faster
+	// than MonitorConfig allows.
+	FindingResponseRate
+
+	// FindingSpoofedSource is reported for responses that cannot come from
+	// a compliant responder on the link, e.g. because their source port is
+	// not 5353.
+	FindingSpoofedSource
+)
+
+// String returns the name of the kind.
+func (k FindingKind) String() string {
+	switch k {
+	case FindingConflict:
+		return "conflict"
+	case FindingTTLFlap:
+		return "ttl-flap"
+	case FindingResponseRate:
+		return "response-rate"
+	case FindingSpoofedSource:
+		return "spoofed-source"
+	default:
+		return "unknown"
+	}
+}
+
+// Finding is an anomaly spotted by Client.Monitor.
+type Finding struct {
+	Kind    FindingKind
+	Name    string         // Owner name of the record concerned, empty if none
+	Type    uint16         // Type of the record concerned, zero if none
+	Sources []*net.UDPAddr // Hosts involved, the offending one last
+	Detail  string         // Human readable description
+	Time    time.Time
+}
+
+// String returns a single line description of the finding.
+func (f Finding) String() string {
+	var b strings.Builder
+	b.WriteString(f.Kind.String())
+	if f.Name != "" {
+		b.WriteString(" " + f.Name + " " + dns.TypeToString[f.Type])
+	}
+	for _, src := range f.Sources {
+		b.WriteString(" " + src.String())
+	}
+	b.WriteString(": " + f.Detail)
+	return b.String()
+}
+
+// MonitorConfig sets the thresholds of Client.Monitor. Zero values select the
+// defaults.
+type MonitorConfig struct {
+	// FlapThreshold is how many times the TTL of a record may change within
+	// FlapWindow before it is reported, default 3 times a minute.
+	FlapThreshold int
+	FlapWindow    time.Duration
+
+	// MaxResponses is how many responses a host may send within RateWindow
+	// before it is reported, default 20 a second.
+	MaxResponses int
+	RateWindow   time.Duration
+}
+
+// withDefaults fills in the unset fields of a MonitorConfig.
+func (c MonitorConfig) withDefaults() MonitorConfig {
+	if c.FlapThreshold <= 0 {
+		c.FlapThreshold = defaultFlapThreshold
+	}
+	if c.FlapWindow <= 0 {
+		c.FlapWindow = defaultFlapWindow
+	}
+	if c.MaxResponses <= 0 {
+		c.MaxResponses = defaultMaxResponses
+	}
+	if c.RateWindow <= 0 {
+		c.RateWindow = defaultRateWindow
+	}
+	return c
+}
+
+// Monitor inspects every response the Client receives, whether it answers one
+// of its queries or was overheard, and sends a Finding to ch for each anomaly,
+// until the returned function is called. It is meant for network operators:
+// findings are structured so that they can be alerted on. Each anomaly is
+// reported once per window rather than for every packet. Findings are dropped
+// if ch is not ready to receive them, so it should be buffered.
+func (c *Client) Monitor(cfg MonitorConfig, ch chan<- Finding) (stop func()) {
+	m := newMonitor(cfg, ch)
+	c.mu.Lock()
+	if c.monitors == nil {
+		c.monitors = make(map[*monitor]struct{})
+	}
+	c.monitors[m] = struct{}{}
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.monitors, m)
+	}
+}
+
+// inspect passes a received message to the monitors and surveyors.
+func (c *Client) inspect(msg *dns.Msg, src *net.UDPAddr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for m := range c.monitors {
+		m.inspect(msg, src)
+	}
+	for s := range c.surveyors {
+		s.inspect(msg, src)
+	}
+}
+
+// claim is the data a host published for a unique record name and type. It
+// is a set, as a host may publish several records of a type, such as an
+// address record for each of its interfaces, and answer from each of these
+// addresses.
+type claim struct {
+	src     *net.UDPAddr
+	records map[string]time.Time // expiry by record data
+}
+
+// expire forgets the records of the claim whose TTL ran out, and reports
+// whether any is left.
+func (c *claim) expire(now time.Time) bool {
+	for data, expires := range c.records {
+		if now.After(expires) {
+			delete(c.records, data)
+		}
+	}
+	return len(c.records) > 0
+}
+
+// data returns the record data of the claim, sorted.
+func (c *claim) data() []string {
+	out := make([]string, 0, len(c.records))
+	for data := range c.records {
+		out = append(out, data)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ttlHistory tracks the TTL changes of a record.
+type ttlHistory struct {
+	ttl      uint32
+	changes  []time.Time
+	reported time.Time
+}
+
+// rateCount counts the responses of a host in the current window.
+type rateCount struct {
+	start    time.Time
+	count    int
+	reported bool
+}
+
+// monitor holds the state of a Client.Monitor.
+type monitor struct {
+	cfg MonitorConfig
+	ch  chan<- Finding
+	now func() time.Time
+
+	mu         sync.Mutex
+	claims     map[string]*claim      // by name and type
+	ttls       map[string]*ttlHistory // by record
+	rates      map[string]*rateCount  // by source address
+	spoofed    map[string]time.Time   // sources reported as spoofed
+	lastPruned time.Time
+}
+
+// newMonitor returns a monitor sending its findings to ch.
+func newMonitor(cfg MonitorConfig, ch chan<- Finding) *monitor {
+	return &monitor{
+		cfg:     cfg.withDefaults(),
+		ch:      ch,
+		now:     time.Now,
+		claims:  make(map[string]*claim),
+		ttls:    make(map[string]*ttlHistory),
+		rates:   make(map[string]*rateCount),
+		spoofed: make(map[string]time.Time),
+	}
+}
+
+// inspect checks a received message for anomalies.
+func (m *monitor) inspect(msg *dns.Msg, src *net.UDPAddr) {
+	if !msg.Response || src == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.prune(now)
+
+	m.checkSource(src, now)
+	m.checkRate(src, now)
+	for _, rr := range append(msg.Answer, msg.Extra...) {
+		m.checkTTL(rr, src, now)
+		if !isShared(rr) {
+			m.checkClaim(rr, src, now)
+		}
+	}
+}
+
+// checkSource reports responses whose source no compliant responder would
+// use. RFC 6762, section 11, requires responses to be sent from port 5353.
+func (m *monitor) checkSource(src *net.UDPAddr, now time.Time) {
+	var detail string
+	switch {
+	case src.Port != mdnsPort:
+		detail = fmt.Sprintf("response sent from port %d", src.Port)
+	case src.IP.IsUnspecified() || src.IP.IsMulticast() || src.IP.Equal(net.IPv4bcast):
+		detail = "response sent from a non-unicast address"
+	default:
+		return
+	}
+	key := src.String()
+	if last, ok := m.spoofed[key]; ok && now.Sub(last) < m.cfg.FlapWindow {
+		return
+	}
+	m.spoofed[key] = now
+	m.report(Finding{Kind: FindingSpoofedSource, Sources: []*net.UDPAddr{src}, Detail: detail, Time: now})
+}
+
+// checkRate reports hosts sending too many responses.
+func (m *monitor) checkRate(src *net.UDPAddr, now time.Time) {
+	key := src.IP.String()
+	r := m.rates[key]
+	if r == nil || now.Sub(r.start) >= m.cfg.RateWindow {
+		r = &rateCount{start: now}
+		m.rates[key] = r
+	}
+	r.count++
+	if r.count > m.cfg.MaxResponses && !r.reported {
+		r.reported = true
+		m.report(Finding{
+			Kind:    FindingResponseRate,
+			Sources: []*net.UDPAddr{src},
+			Detail:  fmt.Sprintf("more than %d responses within %v", m.cfg.MaxResponses, m.cfg.RateWindow),
+			Time:    now,
+		})
+	}
+}
+
+// checkTTL reports records whose TTL keeps changing. Goodbyes are not
+// changes.
+func (m *monitor) checkTTL(rr dns.RR, src *net.UDPAddr, now time.Time) {
+	ttl := rr.Header().Ttl
+	if ttl == 0 {
+		return
+	}
+	key := recordKey(rr)
+	h := m.ttls[key]
+	if h == nil {
+		m.ttls[key] = &ttlHistory{ttl: ttl}
+		return
+	}
+	if h.ttl == ttl {
+		return
+	}
+	h.ttl = ttl
+	h.changes = append(h.changes, now)
+	for len(h.changes) > 0 && now.Sub(h.changes[0]) > m.cfg.FlapWindow {
+		h.changes = h.changes[1:]
+	}
+	if len(h.changes) >= m.cfg.FlapThreshold && now.Sub(h.reported) > m.cfg.FlapWindow {
+		h.reported = now
+		m.report(Finding{
+			Kind:    FindingTTLFlap,
+			Name:    rr.Header().Name,
+			Type:    rr.Header().Rrtype,
+			Sources: []*net.UDPAddr{src},
+			Detail:  fmt.Sprintf("TTL changed %d times within %v", len(h.changes), m.cfg.FlapWindow),
+			Time:    now,
+		})
+	}
+}
+
+// checkClaim reports a unique record published by a host with data its owner,
+// the first host to claim it, never published while the owner's claim is
+// still valid. The owner may publish several records of the name and type,
+// and other hosts may repeat any of them, e.g. a proxy, or the owner itself
+// answering from another of its addresses.
+func (m *monitor) checkClaim(rr dns.RR, src *net.UDPAddr, now time.Time) {
+	hdr := rr.Header()
+	key := strings.ToLower(hdr.Name) + "/" + dns.TypeToString[hdr.Rrtype]
+	data := rdata(rr)
+	c := m.claims[key]
+	if c != nil && !c.expire(now) {
+		delete(m.claims, key)
+		c = nil
+	}
+	if hdr.Ttl == 0 {
+		if c != nil && c.src.IP.Equal(src.IP) {
+			delete(c.records, data)
+			if len(c.records) == 0 {
+				delete(m.claims, key)
+			}
+		}
+		return
+	}
+	expires := now.Add(time.Duration(hdr.Ttl) * time.Second)
+	if c == nil {
+		m.claims[key] = &claim{src: src, records: map[string]time.Time{data: expires}}
+		return
+	}
+	if _, ok := c.records[data]; ok || c.src.IP.Equal(src.IP) {
+		c.records[data] = expires
+		return
+	}
+	m.claims[key] = &claim{src: src, records: map[string]time.Time{data: expires}}
+	m.report(Finding{
+		Kind:    FindingConflict,
+		Name:    hdr.Name,
+		Type:    hdr.Rrtype,
+		Sources: []*net.UDPAddr{c.src, src},
+		Detail:  fmt.Sprintf("claimed as %q and %q", strings.Join(c.data(), ", "), data),
+		Time:    now,
+	})
+}
+
+// prune forgets the state older than the monitor's windows, at most once per
+// flap window.
+func (m *monitor) prune(now time.Time) {
+	if now.Sub(m.lastPruned) < m.cfg.FlapWindow {
+		return
+	}
+	m.lastPruned = now
+	for key, c := range m.claims {
+		if !c.expire(now) {
+			delete(m.claims, key)
+		}
+	}
+	for key, h := range m.ttls {
+		if len(h.changes) == 0 || now.Sub(h.changes[len(h.changes)-1]) > m.cfg.FlapWindow {
+			delete(m.ttls, key)
+		}
+	}
+	for key, r := range m.rates {
+		if now.Sub(r.start) >= m.cfg.RateWindow {
+			delete(m.rates, key)
+		}
+	}
+	for key, t := range m.spoofed {
+		if now.Sub(t) >= m.cfg.FlapWindow {
+			delete(m.spoofed, key)
+		}
+	}
+}
+
+// report sends a finding without blocking.
+func (m *monitor) report(f Finding) {
+	select {
+	case m.ch <- f:
+	default:
+	}
+}
+
+// recordKey identifies a record by its name, type, and data, ignoring its
+// TTL and class.
+func recordKey(rr dns.RR) string {
+	return strings.ToLower(rr.Header().Name) + "/" + dns.TypeToString[rr.Header().Rrtype] + "/" + rdata(rr)
+}
+
+// rdata returns the presentation format of a record's data.
+func rdata(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
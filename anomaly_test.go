@@ -0,0 +1,150 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestClient_Monitor(t *testing.T) {
+	c, err := NewClient(true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	findings := make(chan Finding, 16)
+	stop := c.Monitor(MonitorConfig{}, findings)
+	next := func(want FindingKind) Finding {
+		t.Helper()
+		select {
+		case f := <-findings:
+			if f.Kind != want {
+				t.Fatalf("got %v, want %v", f, want)
+			}
+			return f
+		default:
+			t.Fatalf("missing %v finding", want)
+		}
+		return Finding{}
+	}
+	srv := func(port uint16, ttl uint32) *dns.Msg {
+		return &dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{&dns.SRV{
+			Hdr:    dns.RR_Header{Name: "a._http._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET | cacheFlushBit, Ttl: ttl},
+			Target: "host.local.",
+			Port:   port,
+		}}}
+	}
+	first := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+	second := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 5353}
+
+	// Repeating a claim, or its owner changing it, is fine
+	c.handleMsg(srv(80, 120), first, 0)
+	c.handleMsg(srv(80, 120), second, 0)
+	c.handleMsg(srv(81, 120), first, 0)
+	if len(findings) != 0 {
+		t.Fatalf("unexpected finding: %v", <-findings)
+	}
+
+	// Another host claiming it with different data is a conflict
+	c.handleMsg(srv(82, 120), second, 0)
+	if f := next(FindingConflict); f.Name != "a._http._tcp.local." || f.Type != dns.TypeSRV ||
+		len(f.Sources) != 2 || !f.Sources[0].IP.Equal(first.IP) || !f.Sources[1].IP.Equal(second.IP) {
+		t.Fatalf("bad: %v", f)
+	}
+
+	// TTL flapping is reported once
+	for _, ttl := range []uint32{60, 120, 60, 120} {
+		c.handleMsg(srv(82, ttl), second, 0)
+	}
+	if f := next(FindingTTLFlap); f.Name != "a._http._tcp.local." {
+		t.Fatalf("bad: %v", f)
+	}
+	if len(findings) != 0 {
+		t.Fatalf("unexpected finding: %v", <-findings)
+	}
+
+	// So is a source port other than 5353
+	spoofed := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 3), Port: 40000}
+	c.handleMsg(srv(82, 120), spoofed, 0)
+	next(FindingSpoofedSource)
+	c.handleMsg(srv(82, 120), spoofed, 0)
+	if len(findings) != 0 {
+		t.Fatalf("unexpected finding: %v", <-findings)
+	}
+
+	stop()
+	c.handleMsg(srv(83, 120), first, 0)
+	if len(findings) != 0 {
+		t.Fatalf("findings sent after stop: %v", <-findings)
+	}
+}
+
+func TestMonitor_ClaimSeveralAddresses(t *testing.T) {
+	findings := make(chan Finding, 4)
+	m := newMonitor(MonitorConfig{}, findings)
+	a := func(ip string, ttl uint32) dns.RR {
+		return &dns.A{
+			Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlushBit, Ttl: ttl},
+			A:   net.ParseIP(ip),
+		}
+	}
+
+	// A host with two addresses sends the same response from each of them
+	resp := &dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{a("192.168.1.5", 120), a("10.0.0.5", 120)}}
+	m.inspect(resp, &net.UDPAddr{IP: net.ParseIP("192.168.1.5"), Port: 5353})
+	m.inspect(resp, &net.UDPAddr{IP: net.ParseIP("10.0.0.5"), Port: 5353})
+	if len(findings) != 0 {
+		t.Fatalf("unexpected finding: %v", <-findings)
+	}
+
+	// Another host claiming the name with an address the owner never
+	// published is a conflict
+	other := &net.UDPAddr{IP: net.ParseIP("192.168.1.9"), Port: 5353}
+	m.inspect(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{a("192.168.1.9", 120)}}, other)
+	if len(findings) != 1 {
+		t.Fatalf("got %d findings, want 1", len(findings))
+	}
+	if f := <-findings; f.Kind != FindingConflict || f.Detail != `claimed as "10.0.0.5, 192.168.1.5" and "192.168.1.9"` {
+		t.Fatalf("bad: %v", f)
+	}
+}
+
+func TestMonitor_ResponseRate(t *testing.T) {
+	findings := make(chan Finding, 4)
+	m := newMonitor(MonitorConfig{MaxResponses: 3}, findings)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+	resp := &dns.Msg{MsgHdr: dns.MsgHdr{Response: true}}
+	for i := 0; i < 3; i++ {
+		m.inspect(resp, src)
+	}
+	if len(findings) != 0 {
+		t.Fatalf("unexpected finding: %v", <-findings)
+	}
+	m.inspect(resp, src)
+	m.inspect(resp, src)
+	if len(findings) != 1 {
+		t.Fatalf("got %d findings, want 1", len(findings))
+	}
+	if f := <-findings; f.Kind != FindingResponseRate || f.Sources[0] != src {
+		t.Fatalf("bad: %v", f)
+	}
+
+	// The count starts over with the next window
+	now = now.Add(time.Second)
+	for i := 0; i < 3; i++ {
+		m.inspect(resp, src)
+	}
+	if len(findings) != 0 {
+		t.Fatalf("unexpected finding: %v", <-findings)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// answerCacheSize bounds the number of packed messages a Server keeps. Once
+// it is reached the cache starts over, as busy responders send few distinct
+// messages.
+const answerCacheSize = 256
+
+// packedMsg is a message along with its wire format.
+type packedMsg struct {
+	buf []byte
+	msg *dns.Msg
+}
+
+// withID returns the message with its ID set to id, copying it if needed.
+func (p *packedMsg) withID(id uint16) *packedMsg {
+	if p == nil || p.msg.Id == id {
+		return p
+	}
+	buf := append([]byte(nil), p.buf...)
+	binary.BigEndian.PutUint16(buf, id)
+	msg := *p.msg
+	msg.Id = id
+	return &packedMsg{buf: buf, msg: &msg}
+}
+
+// cachedResponse holds the responses to a query, nil where there is none.
+type cachedResponse struct {
+	multicast, unicast *packedMsg
+}
+
+// answerCache keeps the wire format of the messages a Server sends
+// repeatedly, announcements and responses to common queries, so that they are
+// not packed again every time. It is emptied whenever the records of the
+// Server change.
+type answerCache struct {
+	mu        sync.Mutex
+	responses map[string]cachedResponse
+}
+
+// get returns the cached responses for a key.
+func (c *answerCache) get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.responses[key]
+	return r, ok
+}
+
+// put caches the responses for a key.
+func (c *answerCache) put(key string, r cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.responses == nil || len(c.responses) >= answerCacheSize {
+		c.responses = make(map[string]cachedResponse)
+	}
+	c.responses[key] = r
+}
+
+// invalidate empties the cache.
+func (c *answerCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responses = nil
+}
+
+// InvalidateAnswers discards the packed answers cached with
+// Config.CacheAnswers. Applications must call it after changing the records
+// of Config.Zone; changes made with Register, Update, and Deregister take
+// effect without it.
+func (s *Server) InvalidateAnswers() {
+	s.answers.invalidate()
+}
+
+// queryKey identifies the questions of a query, which the responses are
+// cached for.
+func queryKey(query *dns.Msg) string {
+	var b strings.Builder
+	for _, q := range query.Question {
+		fmt.Fprintf(&b, "%s/%d/%d;", strings.ToLower(q.Name), q.Qtype, q.Qclass)
+	}
+	return b.String()
+}
+
+// legacyQueryKey identifies the questions of a legacy query, whose response
+// repeats them, and so is only reused for the same names in the same case.
+func legacyQueryKey(query *dns.Msg) string {
+	var b strings.Builder
+	b.WriteString("legacy;")
+	for _, q := range query.Question {
+		fmt.Fprintf(&b, "%s/%d/%d;", q.Name, q.Qtype, q.Qclass)
+	}
+	return b.String()
+}
+
+// cachesAnswers reports whether the responses to queries may be cached. The
+// diagnostics beacon changes with every query, so they are not while it is
+// enabled.
+func (s *Server) cachesAnswers() bool {
+	if !s.config.CacheAnswers {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.diagnostics == nil
+}
+
+// pack returns the wire format of msg, nil if msg is nil.
+func (s *Server) pack(msg *dns.Msg) (*packedMsg, error) {
+	if msg == nil {
+		return nil, nil
+	}
+	buf, err := s.codec().Pack(msg)
+	if err != nil {
+		return nil, err
+	}
+	return &packedMsg{buf: buf, msg: msg}, nil
+}
+
+// announcement returns the packed announcement of a service, from the cache
+// unless the records of the Server changed since it was last packed. Its
+// unique records carry the cache-flush bit, so that those of an updated
+// service replace the old ones in caches, as per 8.3 in RFC.
+func (s *Server) announcement(service *MDNSService) (*packedMsg, error) {
+	key := fmt.Sprintf("announce %p", service)
+	if r, ok := s.answers.get(key); ok {
+		return r.multicast, nil
+	}
+	recs := service.Records(dns.Question{Name: service.serviceAddr, Qtype: dns.TypePTR})
+	for _, rr := range recs {
+		if !isShared(rr) {
+			rr.Header().Class |= cacheFlushBit
+		}
+	}
+	p, err := s.pack(unsolicitedResponse(recs))
+	if err != nil {
+		return nil, err
+	}
+	s.answers.put(key, cachedResponse{multicast: p})
+	return p, nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+// answerServer returns a server on a memnet network answering for service,
+// and a connection to send its queries from.
+func answerServer(tb testing.TB, service *MDNSService, cacheAnswers bool) (*Server, net.PacketConn) {
+	network := memnet.New(memnet.Config{})
+	s, err := NewServer(&Config{
+		Zone:         service,
+		Transport:    network.Host(net.ParseIP("10.0.0.1")),
+		CacheAnswers: cacheAnswers,
+	})
+	if err != nil {
+		tb.Fatalf("err: %v", err)
+	}
+	tb.Cleanup(func() { s.Shutdown() })
+	conn, err := network.Host(net.ParseIP("10.0.0.2")).ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		tb.Fatalf("err: %v", err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	return s, conn
+}
+
+func TestServer_CacheAnswers(t *testing.T) {
+	service := makeService(t)
+	s, conn := answerServer(t, service, true)
+
+	ask := func(id uint16, unicast bool) *dns.Msg {
+		t.Helper()
+		q := new(dns.Msg)
+		q.SetQuestion(service.serviceAddr, dns.TypePTR)
+		q.Id = id
+		if unicast {
+			q.Question[0].Qclass |= 1 << 15
+		}
+		if err := s.handleQuery(q, conn.LocalAddr()); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		buf := make([]byte, 9000)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(buf[:n]); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return resp
+	}
+	port := func(resp *dns.Msg) uint16 {
+		for _, rr := range append(resp.Answer, resp.Extra...) {
+			if srv, ok := rr.(*dns.SRV); ok {
+				return srv.Port
+			}
+		}
+		t.Fatalf("no SRV record in %v", resp)
+		return 0
+	}
+
+	if resp := ask(0, false); port(resp) != 80 {
+		t.Fatalf("bad: %v", resp)
+	}
+
+	// The packed response is reused until the cache is invalidated
+	service.Port = 81
+	if resp := ask(0, false); port(resp) != 80 {
+		t.Fatalf("response not cached: %v", resp)
+	}
+	s.InvalidateAnswers()
+	if resp := ask(0, false); port(resp) != 81 {
+		t.Fatalf("cache not invalidated: %v", resp)
+	}
+
+	// Cached unicast responses carry the ID of each query
+	if resp := ask(1234, true); resp.Id != 1234 || port(resp) != 81 {
+		t.Fatalf("bad: %v", resp)
+	}
+	if resp := ask(4321, true); resp.Id != 4321 || port(resp) != 81 {
+		t.Fatalf("bad: %v", resp)
+	}
+}
+
+func TestServer_AnnouncementCache(t *testing.T) {
+	service := makeService(t)
+	s, _ := answerServer(t, service, false)
+
+	first, err := s.announcement(service)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if again, _ := s.announcement(service); again != first {
+		t.Fatalf("announcement packed again")
+	}
+	s.answers.invalidate()
+	if again, _ := s.announcement(service); again == first {
+		t.Fatalf("announcement not packed again after invalidation")
+	}
+}
+
+func benchmarkHandleQuery(b *testing.B, cacheAnswers bool) {
+	service := makeService(b)
+	s, conn := answerServer(b, service, cacheAnswers)
+	q := new(dns.Msg)
+	q.SetQuestion(service.serviceAddr, dns.TypePTR)
+
+	// Drain the responses so that they are not dropped for a full queue
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.handleQuery(q, conn.LocalAddr()); err != nil {
+			b.Fatalf("err: %v", err)
+		}
+	}
+}
+
+func BenchmarkServer_HandleQuery(b *testing.B)       { benchmarkHandleQuery(b, false) }
+func BenchmarkServer_HandleQueryCached(b *testing.B) { benchmarkHandleQuery(b, true) }
+
+func BenchmarkServer_Announcement(b *testing.B) {
+	service := makeService(b)
+	s, _ := answerServer(b, service, false)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.announcement(service); err != nil {
+			b.Fatalf("err: %v", err)
+		}
+	}
+}
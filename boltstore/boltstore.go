@@ -0,0 +1,122 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+// Package boltstore implements mdns.Store on a bbolt database file, so that
+// appliances keep the devices they discovered across restarts:
+//
+//	store, err := boltstore.Open("/var/lib/app/mdns.db")
+//	...
+//	var lc mdns.Lifecycle
+//	lc.AddState("mdns state", client, store)
+//	lc.AddClient("mdns client", client)
+//
+// It is a separate package so that applications keeping their state in
+// memory do not depend on bbolt.
+package boltstore
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/sloweclair/mdns"
+	bolt "go.etcd.io/bbolt"
+)
+
+// bucket is the bucket the values are kept in.
+var bucket = []byte("mdns")
+
+// Store is an mdns.Store keeping its values in a bbolt database.
+type Store struct {
+	db *bolt.DB
+}
+
+var _ mdns.Store = (*Store)(nil)
+
+// Open opens the database at path, creating it if needed. It fails if the
+// database is held open by another process for more than a second.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put sets the value of a key.
+func (s *Store) Put(key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), value)
+	})
+}
+
+// Get returns the value of a key, and false if it is not set.
+func (s *Store) Get(key string) ([]byte, bool, error) {
+	var value []byte
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Values are only valid within the transaction
+		if v := tx.Bucket(bucket).Get([]byte(key)); v != nil {
+			value, ok = append([]byte(nil), v...), true
+		}
+		return nil
+	})
+	return value, ok, err
+}
+
+// Delete removes a key.
+func (s *Store) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
+
+// Iterate calls fn with every key starting with prefix, in order.
+func (s *Store) Iterate(prefix string, fn func(key string, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			if err := fn(string(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Replace removes every key starting with one of prefixes and sets values in
+// their place, in a single transaction.
+func (s *Store) Replace(prefixes []string, values map[string][]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		for _, prefix := range prefixes {
+			c := b.Cursor()
+			p := []byte(prefix)
+			// Deleting moves the cursor to the next key
+			for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Seek(p) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		for key, value := range values {
+			if err := b.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
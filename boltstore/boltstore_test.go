@@ -0,0 +1,68 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package boltstore
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mdns.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, key := range []string{"b/2", "a/1", "b/1"} {
+		if err := s.Put(key, []byte(key)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := s.Delete("b/2"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The values survive reopening the database
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s.Close()
+	if v, ok, err := s.Get("a/1"); err != nil || !ok || string(v) != "a/1" {
+		t.Fatalf("bad: %q %v %v", v, ok, err)
+	}
+	if _, ok, err := s.Get("b/2"); err != nil || ok {
+		t.Fatalf("deleted key still set: %v", err)
+	}
+	var keys []string
+	err = s.Iterate("b/", func(key string, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil || strings.Join(keys, ",") != "b/1" {
+		t.Fatalf("bad: %v %v", keys, err)
+	}
+
+	// Replacing drops the keys of the prefixes only
+	for _, key := range []string{"b/2", "b/3", "c/1"} {
+		if err := s.Put(key, []byte(key)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := s.Replace([]string{"b/", "d/"}, map[string][]byte{"b/4": []byte("b/4")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keys = nil
+	err = s.Iterate("", func(key string, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil || strings.Join(keys, ",") != "a/1,b/4,c/1" {
+		t.Fatalf("bad: %v %v", keys, err)
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// browseSweepInterval is how often a browse checks for instances that went
+// away.
+const browseSweepInterval = time.Second
+
+// BrowseEventType is the kind of change a BrowseEvent describes.
+type BrowseEventType int
+
+const (
+	// BrowseAdded is sent when an instance is discovered.
+	BrowseAdded BrowseEventType = iota
+
+	// BrowseUpdated is sent when the host, port, addresses, or TXT records
+	// of a discovered instance change.
+	BrowseUpdated
+
+	// BrowseRemoved is sent when an instance said goodbye or its records
+	// expired, and the device did not reappear under another name.
+	BrowseRemoved
+
+	// BrowseRenamed is sent when an instance is replaced by one of the same
+	// device under a new name: one with the same "id=" TXT field, or else the
+	// same host, port, and TXT records. Previous is the instance replaced.
+	BrowseRenamed
+)
+
+// String returns the name of the type.
+func (t BrowseEventType) String() string {
+	switch t {
+	case BrowseAdded:
+		return "added"
+	case BrowseUpdated:
+		return "updated"
+	case BrowseRemoved:
+		return "removed"
+	case BrowseRenamed:
+		return "renamed"
+	}
+	return fmt.Sprintf("BrowseEventType(%d)", int(t))
+}
+
+// BrowseEvent describes a change to the instances of a browsed service.
+type BrowseEvent struct {
+	Type     BrowseEventType
+	Entry    *ServiceEntry // The instance as last seen
+	Previous *ServiceEntry // The instance renamed, for BrowseRenamed
+	Time     time.Time
+}
+
+// Key returns the key of the instance, see ServiceEntry.Key. For
+// BrowseRenamed it is the key of the new name.
+func (e BrowseEvent) Key() string {
+	return e.Entry.Key()
+}
+
+// Browse tracks the instances of a service in the "local" domain until ctx is
+// done, see BrowseParams, which also browses other domains.
+func (c *Client) Browse(ctx context.Context, service string, events chan<- BrowseEvent) error {
+	return c.BrowseParams(ctx, QueryParam{Service: service}, events)
+}
+
+// BrowseParams keeps discovering the instances of the service described by
+// params until ctx is done, sending an event to events whenever an instance
+// appears, changes, or goes away. Unlike a query it has no timeout: the
+// questions are re-issued at increasing intervals, up to MaxRetransmitInterval
+// apart, and every response received in between is taken into account.
+// Removals are reported two seconds late, so that a device renaming its
+// instance is reported as renamed rather than removed and added. The Timeout
+// and Entries fields of params are ignored, and browsing does not count
+// towards the query limit. Events are sent as the Delivery of params asks,
+// and by default dropped if events is not ready to receive them, so it should
+// be buffered. The error is that of ctx, or ErrClientClosed if the Client is
+// closed, unless browsing could not start.
+func (c *Client) BrowseParams(ctx context.Context, params QueryParam, events chan<- BrowseEvent) error {
+	par := params.withDefaults()
+	via := par.stacks()
+	if !via.usable(c) {
+		return fmt.Errorf("mdns: browse disables every IP stack the Client uses")
+	}
+	serviceAddr := ServiceName(par.Service, par.Domain)
+
+	c.history.start(serviceAddr)
+	defer c.history.done(serviceAddr)
+	sub, err := c.askQuestions(ctx, []QueryParam{par})
+	if err != nil {
+		return err
+	}
+	defer c.unsubscribe(sub)
+
+	inprogress := make(map[string]*ServiceEntry)
+	services := map[string]*QueryParam{strings.ToLower(serviceAddr): &par}
+	b := newBrowseState(ctx, c.clk(), c.cache, events, &c.browseHistory, c.closedCh)
+	defer b.out.flush()
+	if par.RemoveOnClose {
+		defer b.close()
+	}
+	deliver := func(inp *ServiceEntry) {
+		c.deliverEntry(ctx, inp, nil, via)
+		if inp.sent {
+			b.deliver(inp)
+		}
+	}
+
+	// Start with whatever is already known about the service
+	for _, instance := range c.cache.instances(serviceAddr) {
+		if inp := c.cache.entry(instance); inp != nil {
+			claimEntry(inp, &par, c.clk().Now())
+			inprogress[strings.ToLower(instance)] = inp
+			deliver(inp)
+		}
+	}
+	sub.track(inprogress)
+
+	retransmits := newRetransmissions([]QueryParam{par}, c.clk().Now())
+	retransmit := c.clk().NewTimer(0)
+	defer retransmit.Stop()
+	retransmits.reset(c.clk(), retransmit)
+	limit := newRateLimit([]QueryParam{par}, c.clk().Now())
+	send := func(retransmission bool) {
+		if limit.hold(c.clk(), []QueryParam{par}, retransmission) {
+			return
+		}
+		if err := c.sendQuestions(ctx, []QueryParam{par}, retransmission); err != nil {
+			c.log.Printf("[ERR] mdns: Failed to re-issue browse: %v", err)
+		}
+		limit.sent(c.clk().Now())
+	}
+	var deferred <-chan time.Time // a question delayed by the send hook
+	sweep := c.clk().NewTimer(browseSweepInterval)
+	defer sweep.Stop()
+
+	for {
+		select {
+		case <-retransmit.Chan():
+			due := retransmits.due([]QueryParam{par}, c.clk().Now())
+			retransmits.reset(c.clk(), retransmit)
+			if len(due) == 0 {
+				continue
+			}
+			if d, ok := c.schedule(TransmitRetransmit, serviceAddr); ok && d > 0 {
+				deferred = after(c.clk(), d)
+			} else if ok {
+				send(true)
+			}
+
+		case <-deferred:
+			deferred = nil
+			send(true)
+
+		case <-limit.ready():
+			_, retransmission := limit.release()
+			send(retransmission)
+
+		case <-sub.resend:
+			// The network changed under us, so start a new series of questions
+			send(false)
+			retransmits = newRetransmissions([]QueryParam{par}, c.clk().Now())
+			retransmits.reset(c.clk(), retransmit)
+
+		case resp := <-sub.ch:
+			if !par.accepts(resp) {
+				continue
+			}
+			for _, inp := range c.updateEntries(inprogress, services, resp) {
+				if isInstanceOf(inp.Name, serviceAddr) {
+					deliver(inp)
+				} else {
+					// Don't accumulate the instances of other services
+					delete(inprogress, strings.ToLower(inp.Name))
+				}
+			}
+			sub.track(inprogress)
+
+		case <-sweep.Chan():
+			// Instances whose PTR record left the cache, by a goodbye or
+			// because it expired, are gone
+			present := make(map[string]bool)
+			for _, instance := range c.cache.instances(serviceAddr) {
+				present[strings.ToLower(instance)] = true
+			}
+			now := c.clk().Now()
+			for key, inp := range inprogress {
+				if !present[key] {
+					delete(inprogress, key)
+					b.remove(inp, now)
+				}
+			}
+			sub.track(inprogress)
+			b.expire(now)
+			sweep.Reset(browseSweepInterval)
+
+		case b.out.ready() <- b.out.next():
+			b.out.sent()
+
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-c.closedCh:
+			return ErrClientClosed
+		}
+	}
+}
+
+// browseState tracks what a browse, or a query with Events, reported about
+// each instance.
+type browseState struct {
+	out     *outbox[BrowseEvent]
+	history *eventRing
+	clock   clock
+	cache   *cache
+
+	// delivered holds the instances reported, as last reported.
+	delivered map[*ServiceEntry]ServiceEntry
+
+	// superseded holds the instances replaced by a rename while they were
+	// still cached, which are not reported again.
+	superseded map[*ServiceEntry]bool
+
+	renames renames
+}
+
+// newBrowseState returns a browseState for the instances in cache, sending
+// its events, stamped with the time of clk, to events until ctx is done or
+// closed is, and recording them in history if not nil.
+func newBrowseState(ctx context.Context, clk clock, cache *cache, events chan<- BrowseEvent, history *eventRing, closed <-chan struct{}) *browseState {
+	return &browseState{
+		out:        newOutbox(ctx, events, closed),
+		history:    history,
+		clock:      clk,
+		cache:      cache,
+		delivered:  make(map[*ServiceEntry]ServiceEntry),
+		superseded: make(map[*ServiceEntry]bool),
+	}
+}
+
+// emit sends an event as the delivery of its entry asks, and records it in
+// the history.
+func (b *browseState) emit(t BrowseEventType, e ServiceEntry, prev *ServiceEntry) {
+	ev := BrowseEvent{Type: t, Entry: &e, Previous: prev, Time: b.clock.Now()}
+	if b.history != nil {
+		b.history.add(ev)
+	}
+	b.out.send(ev)
+}
+
+// deliver reports an instance that is ready to be sent, unless nothing
+// changed since it was last reported.
+func (b *browseState) deliver(inp *ServiceEntry) {
+	if b.superseded[inp] {
+		return
+	}
+	if last, ok := b.delivered[inp]; ok {
+		// An instance no PTR record names any more is about to be removed,
+		// rather than updated as the rest of its records go
+		if b.cache != nil && len(b.cache.instancePTRs(inp.Name)) == 0 {
+			return
+		}
+		if entryChanged(&last, inp) {
+			b.delivered[inp] = *inp
+			b.emit(BrowseUpdated, *inp, nil)
+		}
+		return
+	}
+	b.delivered[inp] = *inp
+
+	// The device may have gone away under another name, or, if its id says
+	// so, still be cached under it
+	if prev, ok := b.renames.match(inp); ok {
+		if !strings.EqualFold(prev.Name, inp.Name) {
+			b.emit(BrowseRenamed, *inp, &prev)
+		} else if entryChanged(&prev, inp) {
+			b.emit(BrowseUpdated, *inp, nil)
+		}
+		return
+	}
+	if id := deviceID(inp); id != "" {
+		for old, last := range b.delivered {
+			if old != inp && deviceID(&last) == id {
+				delete(b.delivered, old)
+				b.superseded[old] = true
+				b.emit(BrowseRenamed, *inp, &last)
+				return
+			}
+		}
+	}
+	b.emit(BrowseAdded, *inp, nil)
+}
+
+// remove handles an instance that went away. Its removal is held back in case
+// the device reappears under another name.
+func (b *browseState) remove(inp *ServiceEntry, now time.Time) {
+	delete(b.superseded, inp)
+	last, ok := b.delivered[inp]
+	if !ok {
+		return
+	}
+	delete(b.delivered, inp)
+	if !b.renames.hold(last, now) {
+		b.emit(BrowseRemoved, last, nil)
+	}
+}
+
+// expire reports the removals held back for longer than renameWindow.
+func (b *browseState) expire(now time.Time) {
+	for _, e := range b.renames.expire(now) {
+		b.emit(BrowseRemoved, e, nil)
+	}
+}
+
+// close reports every instance still known as removed, including those whose
+// removal was held back, see QueryParam.RemoveOnClose, and sends the events
+// still queued, even though the context may be done.
+func (b *browseState) close() {
+	var gone []ServiceEntry
+	for inp, last := range b.delivered {
+		gone = append(gone, last)
+		delete(b.delivered, inp)
+	}
+	gone = append(gone, b.renames.expire(b.clock.Now().Add(renameWindow))...)
+	sort.Slice(gone, func(i, j int) bool { return gone[i].Name < gone[j].Name })
+	b.out.finish(func() {
+		for _, e := range gone {
+			b.emit(BrowseRemoved, e, nil)
+		}
+	})
+}
+
+// isInstanceOf reports whether name is an instance of the service, or of the
+// service a subtype belongs to.
+func isInstanceOf(name, serviceAddr string) bool {
+	return strings.EqualFold(instanceService(name), ParentService(serviceAddr))
+}
+
+// entryChanged reports whether the details of an instance an application
+// acts on differ between two versions of its entry.
+func entryChanged(a, b *ServiceEntry) bool {
+	return a.Host != b.Host || a.Port != b.Port || a.Info != b.Info ||
+		a.Priority != b.Priority || a.Weight != b.Weight ||
+		!a.AddrV4.Equal(b.AddrV4) || !a.AddrV6.Equal(b.AddrV6) ||
+		fmt.Sprint(a.AddrsV4, a.AddrsV6) != fmt.Sprint(b.AddrsV4, b.AddrsV6) ||
+		strings.Join(a.InfoFields, "\x00") != strings.Join(b.InfoFields, "\x00")
+}
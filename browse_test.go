@@ -0,0 +1,182 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestClient_Browse(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events := make(chan BrowseEvent, 16)
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.Browse(ctx, "_browse._tcp", events) }()
+	next := func(want BrowseEventType) *ServiceEntry {
+		t.Helper()
+		select {
+		case ev := <-events:
+			if ev.Type != want || ev.Entry.Name != "hostname._browse._tcp.local." {
+				t.Fatalf("got %v %+v, want %v", ev.Type, ev.Entry, want)
+			}
+			return ev.Entry
+		case <-ctx.Done():
+			t.Fatalf("missing %v event", want)
+		}
+		return nil
+	}
+
+	// Services registered after browsing started are found
+	time.Sleep(100 * time.Millisecond)
+	service := makeServiceWithServiceName(t, "_browse._tcp")
+	if err := serv.Register(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if e := next(BrowseAdded); e.Port != 80 {
+		t.Fatalf("bad: %+v", e)
+	}
+
+	// A record replacing the SRV record updates the instance
+	srv := &dns.SRV{
+		Hdr:    dns.RR_Header{Name: "hostname._browse._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET | cacheFlushBit, Ttl: 120},
+		Target: "testhost.",
+		Port:   8080,
+	}
+	c.handleMsg(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{srv}}, &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5353}, 0)
+	if e := next(BrowseUpdated); e.Port != 8080 {
+		t.Fatalf("bad: %+v", e)
+	}
+
+	if err := serv.Deregister(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	next(BrowseRemoved)
+
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestClient_BrowseClientClosed(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Closing the Client ends the browse with an error callers can check
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.Browse(context.Background(), "_closed._tcp", make(chan BrowseEvent, 16)) }()
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrClientClosed) {
+			t.Fatalf("got %v, want ErrClientClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("browse did not stop")
+	}
+}
+
+func TestClient_BrowseRemoveOnClose(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_close._tcp"), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	for _, remove := range []bool{false, true} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		events := make(chan BrowseEvent, 16)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- c.BrowseParams(ctx, QueryParam{Service: "_close._tcp", RemoveOnClose: remove}, events)
+		}()
+		if ev := <-events; ev.Type != BrowseAdded {
+			t.Fatalf("bad: %v", ev.Type)
+		}
+		cancel()
+		<-errCh
+
+		// The instance is still published, so it is only removed on request
+		select {
+		case ev := <-events:
+			if !remove || ev.Type != BrowseRemoved || ev.Entry.Name != "hostname._close._tcp.local." {
+				t.Fatalf("remove %v: got %v %+v", remove, ev.Type, ev.Entry)
+			}
+		default:
+			if remove {
+				t.Fatalf("missing removal")
+			}
+		}
+	}
+}
+
+func TestClient_BrowseRemoveOnCloseBlock(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_close._tcp"), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events := make(chan BrowseEvent)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.BrowseParams(ctx, QueryParam{Service: "_close._tcp", RemoveOnClose: true, Delivery: DeliverBlock}, events)
+	}()
+	if ev := <-events; ev.Type != BrowseAdded {
+		t.Fatalf("bad: %v", ev.Type)
+	}
+
+	// The removal waits for a reader that is not ready when the browse stops
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case ev := <-events:
+		if ev.Type != BrowseRemoved || ev.Entry.Name != "hostname._close._tcp.local." {
+			t.Fatalf("got %v %+v", ev.Type, ev.Entry)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("missing removal")
+	}
+	if err := <-errCh; err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
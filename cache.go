@@ -0,0 +1,865 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultCacheMaxRecords is the default limit on the number of records a
+	// Client caches.
+	defaultCacheMaxRecords = 10000
+)
+
+// CacheStats reports the size of a Client's record cache and how many records
+// have left it.
+type CacheStats struct {
+	Records     int    // Records currently cached
+	Bytes       int    // Approximate wire size of the cached records
+	Evictions   uint64 // Records evicted to stay within the configured limits
+	Expirations uint64 // Records dropped because their TTL ran out
+}
+
+// RecordEventType is the kind of change a RecordEvent describes.
+type RecordEventType int
+
+const (
+	// RecordAdded is sent when a record enters the cache.
+	RecordAdded RecordEventType = iota
+
+	// RecordRefreshed is sent when a cached record is received again. Old
+	// and New have the same data; New carries the new TTL.
+	RecordRefreshed
+
+	// RecordReplaced is sent when a unique record is superseded by New,
+	// which was received with the cache-flush bit set.
+	RecordReplaced
+
+	// RecordRemoved is sent when a record is removed by a goodbye, or by
+	// Client.Flush or Client.Forget.
+	RecordRemoved
+
+	// RecordExpired is sent when a record is dropped because its TTL ran
+	// out.
+	RecordExpired
+
+	// RecordEvicted is sent when a record is evicted to keep the cache
+	// within its limits.
+	RecordEvicted
+)
+
+func (t RecordEventType) String() string {
+	switch t {
+	case RecordAdded:
+		return "added"
+	case RecordRefreshed:
+		return "refreshed"
+	case RecordReplaced:
+		return "replaced"
+	case RecordRemoved:
+		return "removed"
+	case RecordExpired:
+		return "expired"
+	case RecordEvicted:
+		return "evicted"
+	}
+	return fmt.Sprintf("RecordEventType(%d)", int(t))
+}
+
+// RecordEvent describes a change to a single record in a Client's cache. The
+// name and type are those of the records' headers.
+type RecordEvent struct {
+	Type RecordEventType
+	Old  dns.RR    // Record before the change, nil for RecordAdded
+	New  dns.RR    // Record after the change, nil once it left the cache
+	Zone string    // Interface the record was received on
+	Time time.Time // When the change happened
+}
+
+// cacheRecord is a single record held by the cache along with the time at
+// which it expires.
+type cacheRecord struct {
+	rr       dns.RR
+	expires  time.Time
+	received time.Time // when the record was last received
+
+	// zone is the interface the record was received on, used to qualify
+	// link-local addresses.
+	zone string
+
+	// src is the address the record was received from, nil if unknown.
+	src net.IP
+
+	key  string        // key the record is stored under
+	size int           // approximate wire size of rr
+	elem *list.Element // position in the LRU list
+
+	// passive is the position of the record in the LRU list of passive
+	// records, those last received in a response no running query asked
+	// for, see ShedPassiveFirst, and nil for the others.
+	passive *list.Element
+}
+
+// cache holds the records learned from mDNS responses until their TTL expires.
+// Records are keyed by their lower-cased owner name. When limits are set, the
+// least recently used records are evicted to stay within them.
+type cache struct {
+	mu      sync.Mutex
+	records map[string][]*cacheRecord
+	lru     *list.List // front is most recently used
+	passive *list.List // the passive records, front is most recently used
+
+	maxRecords int    // zero means no limit
+	maxBytes   int    // zero means no limit
+	maxTTL     uint32 // in seconds, zero means no limit
+
+	// budget limits the memory used by the Client as a whole, of which
+	// reserved is taken by other parts than the cache, see SetMemoryBudget.
+	budget   int // zero means no limit
+	reserved int
+	shedding ShedPolicy
+
+	bytes       int
+	evictions   uint64
+	expirations uint64
+	shed        uint64
+
+	now func() time.Time
+
+	// watchers receive record events, see watch.
+	watchers map[chan<- RecordEvent]struct{}
+}
+
+// newCache returns an empty cache.
+func newCache() *cache {
+	return &cache{
+		records:    make(map[string][]*cacheRecord),
+		lru:        list.New(),
+		passive:    list.New(),
+		maxRecords: defaultCacheMaxRecords,
+		now:        time.Now,
+	}
+}
+
+// watch sends the events of every record change to ch until the returned
+// function is called. Events are dropped if ch is not ready to receive them.
+func (c *cache) watch(ch chan<- RecordEvent) (stop func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.watchers == nil {
+		c.watchers = make(map[chan<- RecordEvent]struct{})
+	}
+	c.watchers[ch] = struct{}{}
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.watchers, ch)
+	}
+}
+
+// notify sends an event to the watchers. The caller must hold the lock.
+func (c *cache) notify(t RecordEventType, old, cur dns.RR, zone string) {
+	if len(c.watchers) == 0 {
+		return
+	}
+	ev := RecordEvent{Type: t, Old: old, New: cur, Zone: zone, Time: c.now()}
+	for ch := range c.watchers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// cacheKey returns the key a name is stored under.
+func cacheKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// setLimits sets the maximum number of records and bytes the cache may hold,
+// evicting records if the cache is already over the new limits. A zero limit
+// means no limit.
+func (c *cache) setLimits(maxRecords, maxBytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxRecords = maxRecords
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+// setMaxTTL clamps the TTL of every record the cache holds to at most ttl
+// seconds, including the records already cached. Zero removes the limit.
+func (c *cache) setMaxTTL(ttl uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxTTL = ttl
+	if ttl == 0 {
+		return
+	}
+	for _, recs := range c.records {
+		for _, cr := range recs {
+			if cr.rr.Header().Ttl <= ttl {
+				continue
+			}
+			cr.rr = dns.Copy(cr.rr)
+			cr.rr.Header().Ttl = ttl
+			if latest := c.now().Add(time.Duration(ttl) * time.Second); cr.expires.After(latest) {
+				cr.expires = latest
+			}
+		}
+	}
+}
+
+// stats returns the current cache statistics.
+func (c *cache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Records:     c.lru.Len(),
+		Bytes:       c.bytes,
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+	}
+}
+
+// insert adds all answer and additional records of a response received from
+// src to the cache.
+func (c *cache) insert(msg *dns.Msg, src *net.UDPAddr) {
+	c.insertFrom(msg, src, false)
+}
+
+// insertFrom is like insert, marking the records as passive if no running
+// query asked for them.
+func (c *cache) insertFrom(msg *dns.Msg, src *net.UDPAddr, passive bool) {
+	if !msg.Response {
+		return
+	}
+	zone := ""
+	var ip net.IP
+	if src != nil {
+		zone = src.Zone
+		ip = src.IP
+	}
+	for _, rr := range append(msg.Answer, msg.Extra...) {
+		c.addFrom(rr, zone, ip, passive)
+	}
+}
+
+// cacheFlushBit is the top bit of a record's class, which marks the record as
+// unique and asks caches to replace older records of the same name and type.
+// See RFC 6762, section 10.2.
+const cacheFlushBit = 1 << 15
+
+// isShared reports whether a record belongs to a shared record set, which
+// many hosts may contribute to. In DNS-SD these are the PTR records; SRV,
+// TXT, A, and AAAA records are unique to the host that owns the name.
+func isShared(rr dns.RR) bool {
+	return rr.Header().Rrtype == dns.TypePTR
+}
+
+// add stores a record, refreshing the expiry of an identical record that is
+// already cached. A record with a TTL of zero is a goodbye and removes the
+// matching record instead.
+//
+// Records of shared sets accumulate. A unique record with the cache-flush bit
+// set replaces the other records of the same name and type received more than
+// a second before, while a unique record without it is added alongside them.
+// Records received within the last second are kept, as they belong to the
+// same announcement, such as the A records of a host with several addresses
+// (RFC 6762, section 10.2). The RFC has the older records expire a second
+// later rather than at once, but their replacement is already cached, and
+// keeping them would have entries report stale data.
+func (c *cache) add(rr dns.RR, zone string) {
+	c.addFrom(rr, zone, nil, false)
+}
+
+// addFrom is like add, recording the address the record was received from
+// and whether it is passive.
+func (c *cache) addFrom(rr dns.RR, zone string, src net.IP, passive bool) {
+	flush := rr.Header().Class&cacheFlushBit != 0 && !isShared(rr)
+	copied := false
+	if rr.Header().Class&cacheFlushBit != 0 {
+		rr = dns.Copy(rr)
+		rr.Header().Class &^= cacheFlushBit
+		copied = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxTTL > 0 && rr.Header().Ttl > c.maxTTL {
+		if !copied {
+			rr = dns.Copy(rr)
+		}
+		rr.Header().Ttl = c.maxTTL
+	}
+
+	key := cacheKey(rr.Header().Name)
+	now := c.now()
+	if flush && rr.Header().Ttl != 0 {
+		for _, cr := range append([]*cacheRecord(nil), c.records[key]...) {
+			if cr.rr.Header().Rrtype != rr.Header().Rrtype || dns.IsDuplicate(cr.rr, rr) {
+				continue
+			}
+			if now.Sub(cr.received) > time.Second {
+				c.drop(cr)
+				c.notify(RecordReplaced, cr.rr, rr, zone)
+			}
+		}
+	}
+	for _, cr := range c.records[key] {
+		if !dns.IsDuplicate(cr.rr, rr) {
+			continue
+		}
+		if rr.Header().Ttl == 0 {
+			c.drop(cr)
+			c.notify(RecordRemoved, cr.rr, nil, cr.zone)
+			return
+		}
+		c.notify(RecordRefreshed, cr.rr, rr, zone)
+		c.bytes += dns.Len(rr) - cr.size
+		cr.rr = rr
+		cr.size = dns.Len(rr)
+		cr.expires = c.expiry(rr)
+		cr.received = now
+		cr.zone = zone
+		cr.src = src
+		c.setPassive(cr, passive)
+		c.touch(cr)
+		c.evict()
+		return
+	}
+	if rr.Header().Ttl == 0 {
+		return
+	}
+	cr := &cacheRecord{
+		rr:       rr,
+		expires:  c.expiry(rr),
+		received: now,
+		zone:     zone,
+		src:      src,
+		key:      key,
+		size:     dns.Len(rr),
+	}
+	cr.elem = c.lru.PushFront(cr)
+	c.setPassive(cr, passive)
+	c.bytes += cr.size
+	c.records[key] = append(c.records[key], cr)
+	c.notify(RecordAdded, nil, rr, zone)
+	c.evict()
+}
+
+// expiry returns the time at which rr expires.
+func (c *cache) expiry(rr dns.RR) time.Time {
+	return c.now().Add(time.Duration(rr.Header().Ttl) * time.Second)
+}
+
+// snapshot returns a copy of the unexpired records, least recently used
+// first, so that adding them back in order restores their recency.
+func (c *cache) snapshot() []cacheRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	var out []cacheRecord
+	for e := c.lru.Back(); e != nil; e = e.Prev() {
+		cr := e.Value.(*cacheRecord)
+		if now.Before(cr.expires) {
+			out = append(out, *cr)
+		}
+	}
+	return out
+}
+
+// drop removes a record from the cache. The caller must hold the lock.
+func (c *cache) drop(cr *cacheRecord) {
+	recs := c.records[cr.key]
+	for i, r := range recs {
+		if r == cr {
+			recs = append(recs[:i], recs[i+1:]...)
+			break
+		}
+	}
+	if len(recs) == 0 {
+		delete(c.records, cr.key)
+	} else {
+		c.records[cr.key] = recs
+	}
+	c.lru.Remove(cr.elem)
+	c.setPassive(cr, false)
+	c.bytes -= cr.size
+}
+
+// touch marks a record as the most recently used. The caller must hold the
+// lock.
+func (c *cache) touch(cr *cacheRecord) {
+	c.lru.MoveToFront(cr.elem)
+	if cr.passive != nil {
+		c.passive.MoveToFront(cr.passive)
+	}
+}
+
+// setPassive adds a record to the passive records or removes it from them.
+// A record becoming passive is the most recently used of them. The caller
+// must hold the lock.
+func (c *cache) setPassive(cr *cacheRecord, passive bool) {
+	switch {
+	case passive && cr.passive == nil:
+		cr.passive = c.passive.PushFront(cr)
+	case !passive && cr.passive != nil:
+		c.passive.Remove(cr.passive)
+		cr.passive = nil
+	}
+}
+
+// evict drops the least recently used records until the cache is within its
+// limits, then sheds records as its shedding policy says until the Client is
+// within its memory budget. The caller must hold the lock.
+func (c *cache) evict() {
+	for (c.maxRecords > 0 && c.lru.Len() > c.maxRecords) ||
+		(c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.evictRecord(c.lru.Back().Value.(*cacheRecord))
+	}
+	for c.budget > 0 && c.lru.Len() > 0 && c.reserved+c.memory() > c.budget {
+		c.evictRecord(c.shedVictim())
+		c.shed++
+	}
+}
+
+// evictRecord drops a record to stay within the limits. The caller must hold
+// the lock.
+func (c *cache) evictRecord(cr *cacheRecord) {
+	c.drop(cr)
+	c.evictions++
+	c.notify(RecordEvicted, cr.rr, nil, cr.zone)
+}
+
+// shedVictim returns the record to shed first: the least recently used of the
+// passive records under ShedPassiveFirst, unless there are none, or else the
+// least recently used record. The caller must hold the lock.
+func (c *cache) shedVictim() *cacheRecord {
+	if c.shedding == ShedPassiveFirst && c.passive.Len() > 0 {
+		return c.passive.Back().Value.(*cacheRecord)
+	}
+	return c.lru.Back().Value.(*cacheRecord)
+}
+
+// memory returns the approximate memory used by the cached records. The
+// caller must hold the lock.
+func (c *cache) memory() int {
+	return c.bytes + c.lru.Len()*recordOverhead
+}
+
+// setBudget sets the memory budget of the Client and how the cache sheds
+// records to stay within it, shedding records if it is already exceeded.
+func (c *cache) setBudget(budget int, shedding ShedPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.budget = budget
+	c.shedding = shedding
+	c.evict()
+}
+
+// reserve records how much memory the other parts of the Client use,
+// shedding records if the budget is now exceeded.
+func (c *cache) reserve(reserved int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reserved = reserved
+	c.evict()
+}
+
+// get returns the unexpired records of the given type for name.
+func (c *cache) get(name string, rrtype uint16) []dns.RR {
+	var out []dns.RR
+	for _, cr := range c.lookup(name, rrtype) {
+		out = append(out, cr.rr)
+	}
+	return out
+}
+
+// lookup returns copies of the unexpired cache records of the given type for
+// name, or of every type for dns.TypeANY, marking them as recently used.
+// Expired records are dropped as they are encountered.
+func (c *cache) lookup(name string, rrtype uint16) []cacheRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	var out []cacheRecord
+	for _, cr := range append([]*cacheRecord(nil), c.records[cacheKey(name)]...) {
+		if !now.Before(cr.expires) {
+			c.drop(cr)
+			c.expirations++
+			c.notify(RecordExpired, cr.rr, nil, cr.zone)
+			continue
+		}
+		if rrtype == dns.TypeANY || cr.rr.Header().Rrtype == rrtype {
+			c.touch(cr)
+			out = append(out, *cr)
+		}
+	}
+	return out
+}
+
+// knownAnswers returns the PTR records cached for name that have more than
+// half their TTL left, with the TTL they have left, as a query lists them in
+// its Known-Answer Section, see RFC 6762, section 7.1.
+func (c *cache) knownAnswers(name string) []dns.RR {
+	now := c.now()
+	var out []dns.RR
+	for _, cr := range c.lookup(name, dns.TypePTR) {
+		ttl := cr.rr.Header().Ttl
+		left := cr.expires.Sub(now)
+		if left <= time.Duration(ttl)*time.Second/2 {
+			continue
+		}
+		rr := dns.Copy(cr.rr)
+		rr.Header().Ttl = uint32(left / time.Second)
+		out = append(out, rr)
+	}
+	return out
+}
+
+// has reports whether an unexpired record with the same data as rr is
+// cached.
+func (c *cache) has(rr dns.RR) bool {
+	rr = dns.Copy(rr)
+	rr.Header().Class &^= cacheFlushBit
+	for _, cr := range c.lookup(rr.Header().Name, rr.Header().Rrtype) {
+		if dns.IsDuplicate(cr.rr, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// denies reports whether the owner of name asserted, with a cached NSEC
+// record, that it has no records of rrtype, see RFC 6762, section 6.1. Such
+// records are not worth waiting or asking for. Only NSEC records trusted for
+// the owners and host addresses given count, see trusted, and of these the
+// latest received.
+func (c *cache) denies(name string, rrtype uint16, owners, host []net.IP) bool {
+	var latest *cacheRecord
+	for _, cr := range c.lookup(name, dns.TypeNSEC) {
+		if !trusted(cr, owners, host) {
+			continue
+		}
+		if latest == nil || !cr.received.Before(latest.received) {
+			latest = &cr
+		}
+	}
+	return latest != nil && !slices.Contains(latest.rr.(*dns.NSEC).TypeBitMap, rrtype)
+}
+
+// remove drops every record owned by name.
+func (c *cache) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cr := range append([]*cacheRecord(nil), c.records[cacheKey(name)]...) {
+		c.drop(cr)
+		c.notify(RecordRemoved, cr.rr, nil, cr.zone)
+	}
+}
+
+// removePTR drops the PTR records owned by name that point to target.
+func (c *cache) removePTR(name, target string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cr := range append([]*cacheRecord(nil), c.records[cacheKey(name)]...) {
+		if ptr, ok := cr.rr.(*dns.PTR); ok && strings.EqualFold(ptr.Ptr, target) {
+			c.drop(cr)
+			c.notify(RecordRemoved, cr.rr, nil, cr.zone)
+		}
+	}
+}
+
+// instances returns the instance names the cache knows for a service.
+func (c *cache) instances(service string) []string {
+	var names []string
+	for _, rr := range c.get(service, dns.TypePTR) {
+		names = append(names, rr.(*dns.PTR).Ptr)
+	}
+	return names
+}
+
+// entry assembles a ServiceEntry for the instance from cached records. It
+// returns nil if nothing is known about the instance.
+func (c *cache) entry(instance string) *ServiceEntry {
+	e := &ServiceEntry{Name: instance}
+	if !c.fill(e) {
+		return nil
+	}
+	return e
+}
+
+// fill updates an entry with the cached SRV, TXT, and address records of its
+// instance, reporting whether the cache knew anything about it.
+func (c *cache) fill(e *ServiceEntry) bool {
+	// Only records from the instance's owners are merged into the entry
+	owners := c.owners(e.Name)
+	// Prefer the lowest priority, then the highest weight (RFC 2782)
+	var srv *dns.SRV
+	var srvRecord cacheRecord
+	for _, cr := range c.lookup(e.Name, dns.TypeSRV) {
+		r := cr.rr.(*dns.SRV)
+		if !trusted(cr, owners, c.hostAddrs(r.Target, owners)) {
+			continue
+		}
+		if srv == nil || r.Priority < srv.Priority || (r.Priority == srv.Priority && r.Weight > srv.Weight) {
+			srv, srvRecord = r, cr
+		}
+	}
+	var host []net.IP
+	if srv != nil {
+		host = c.hostAddrs(srv.Target, owners)
+	}
+	var txts []cacheRecord
+	for _, cr := range c.lookup(e.Name, dns.TypeTXT) {
+		if trusted(cr, owners, host) {
+			txts = append(txts, cr)
+		}
+	}
+	if srv == nil && len(txts) == 0 {
+		return false
+	}
+
+	var exp entryExpiry
+	for _, cr := range c.instancePTRs(e.Name) {
+		exp.add(&exp.ttls.PTR, cr)
+	}
+	if srv != nil {
+		exp.add(&exp.ttls.SRV, srvRecord)
+		if !strings.EqualFold(e.Host, srv.Target) {
+			// The addresses of the previous host are not those of the new
+			e.Addr, e.AddrV4, e.AddrV6, e.AddrV6IPAddr = nil, nil, nil, nil
+		}
+		e.Host = srv.Target
+		e.Port = int(srv.Port)
+		e.Priority = int(srv.Priority)
+		e.Weight = int(srv.Weight)
+		e.TTL = srv.Hdr.Ttl
+		// Unlike AddrV4 and AddrV6, which keep the last address of the host,
+		// the lists hold the addresses cached now, so that those the host
+		// withdrew are gone
+		var v4s []net.IP
+		var v6s []net.IPAddr
+		for _, cr := range c.lookup(srv.Target, dns.TypeA) {
+			if !trusted(cr, owners, host) {
+				continue
+			}
+			exp.add(&exp.ttls.A, cr)
+			e.Addr = cr.rr.(*dns.A).A // @Deprecated
+			e.AddrV4 = cr.rr.(*dns.A).A
+			v4s = append(v4s, e.AddrV4)
+		}
+		for _, cr := range c.lookup(srv.Target, dns.TypeAAAA) {
+			if !trusted(cr, owners, host) {
+				continue
+			}
+			exp.add(&exp.ttls.AAAA, cr)
+			aaaa := cr.rr.(*dns.AAAA).AAAA
+			e.Addr = aaaa   // @Deprecated
+			e.AddrV6 = aaaa // @Deprecated
+			e.AddrV6IPAddr = &net.IPAddr{IP: aaaa}
+			// link-local IPv6 addresses must be qualified with a zone (interface). Zone is
+			// specific to this machine/network-namespace and so won't be carried in the
+			// mDNS message itself. We borrow the zone from the source address of the UDP
+			// packet, as the link-local address should be valid on that interface.
+			if aaaa.IsLinkLocalUnicast() || aaaa.IsLinkLocalMulticast() {
+				e.AddrV6IPAddr.Zone = cr.zone
+			}
+			v6s = append(v6s, *e.AddrV6IPAddr)
+		}
+		e.AddrsV4, e.AddrsV6 = v4s, v6s
+	}
+	e.noTXT = len(txts) == 0 && c.denies(e.Name, dns.TypeTXT, owners, host)
+	e.noAddrs = srv != nil && e.AddrV4 == nil && e.AddrV6 == nil &&
+		c.denies(srv.Target, dns.TypeA, owners, host) && c.denies(srv.Target, dns.TypeAAAA, owners, host)
+	if len(txts) > 0 {
+		exp.add(&exp.ttls.TXT, txts[0])
+		txt := txts[0].rr.(*dns.TXT)
+		e.Info = strings.Join(txt.Txt, "|")
+		e.InfoFields = txt.Txt
+		e.hasTXT = true
+		e.txt = parseTXT(txt.Txt)
+	}
+	e.TTLs, e.ExpiresAt = exp.ttls, exp.expires
+	return true
+}
+
+// entryExpiry collects the TTLs of the records an entry is built from.
+type entryExpiry struct {
+	ttls    RecordTTLs
+	expires time.Time
+}
+
+// add accounts for a record, whose TTL is kept in ttl unless a shorter one
+// was seen for the same type.
+func (x *entryExpiry) add(ttl *time.Duration, cr cacheRecord) {
+	d := time.Duration(cr.rr.Header().Ttl) * time.Second
+	if *ttl == 0 || d < *ttl {
+		*ttl = d
+	}
+	if x.expires.IsZero() || cr.expires.Before(x.expires) {
+		x.expires = cr.expires
+	}
+}
+
+// instancePTRs returns the cached PTR records naming an instance.
+func (c *cache) instancePTRs(instance string) []cacheRecord {
+	_, service, domain, err := SplitInstanceName(instance)
+	if err != nil {
+		return nil
+	}
+	var out []cacheRecord
+	for _, cr := range c.lookup(ServiceName(service, domain), dns.TypePTR) {
+		if strings.EqualFold(cr.rr.(*dns.PTR).Ptr, instance) {
+			out = append(out, cr)
+		}
+	}
+	return out
+}
+
+// owners returns the addresses the PTR records naming an instance were
+// received from. Only records from these addresses, or from the instance's
+// host itself, are attributed to the instance, so that the records of an
+// unrelated device using the same name are not merged into its entry. It
+// returns nil if no such PTR record with a known source is cached.
+func (c *cache) owners(instance string) []net.IP {
+	var ips []net.IP
+	for _, cr := range c.instancePTRs(instance) {
+		if cr.src != nil {
+			ips = append(ips, cr.src)
+		}
+	}
+	return ips
+}
+
+// hostAddrs returns the trusted addresses of a host: those the owners
+// vouched for, and those the host announced from one of these. An address
+// announced from anywhere else does not count, even from the address itself,
+// as any device can claim a host name.
+func (c *cache) hostAddrs(host string, owners []net.IP) []net.IP {
+	var records []cacheRecord
+	for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		records = append(records, c.lookup(host, rrtype)...)
+	}
+	vouched := addrsFrom(records, owners, nil)
+	return addrsFrom(records, owners, vouched)
+}
+
+// addrsFrom returns the addresses of the address records trusted for the
+// owners and host addresses given.
+func addrsFrom(records []cacheRecord, owners, host []net.IP) []net.IP {
+	var ips []net.IP
+	for _, cr := range records {
+		if !trusted(cr, owners, host) {
+			continue
+		}
+		switch rr := cr.rr.(type) {
+		case *dns.A:
+			ips = append(ips, rr.A)
+		case *dns.AAAA:
+			ips = append(ips, rr.AAAA)
+		}
+	}
+	return ips
+}
+
+// hostIPAddrs returns the cached addresses of a host, whatever their source,
+// with the zone of the interface they were received on for link-local IPv6
+// addresses.
+func (c *cache) hostIPAddrs(host string) []net.IPAddr {
+	var addrs []net.IPAddr
+	for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		for _, cr := range c.lookup(host, rrtype) {
+			switch rr := cr.rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, net.IPAddr{IP: rr.A})
+			case *dns.AAAA:
+				addr := net.IPAddr{IP: rr.AAAA}
+				if rr.AAAA.IsLinkLocalUnicast() || rr.AAAA.IsLinkLocalMulticast() {
+					addr.Zone = cr.zone
+				}
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs
+}
+
+// zoned returns addresses of a host, with the zone of the interface they were
+// last received on for the link-local IPv6 addresses still cached, see
+// hostIPAddrs.
+func (c *cache) zoned(host string, ips []net.IP) []net.IPAddr {
+	cached := c.hostIPAddrs(host)
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addr := net.IPAddr{IP: ip}
+		for _, a := range cached {
+			if a.IP.Equal(ip) {
+				addr.Zone = a.Zone
+				break
+			}
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// hostResolved reports whether addrs, the cached addresses of a host, are all
+// it has: those of both IP versions, with those of either replaced by an NSEC
+// record denying them. Once some addresses are cached, only NSEC records from
+// the devices that sent them count, so that another device claiming the host
+// name cannot deny the addresses still to come. Before that, as a host alone
+// has no owners, they may come from anywhere.
+func (c *cache) hostResolved(host string, addrs []net.IPAddr) bool {
+	v4, v6 := ipVersions(addrs)
+	owners := c.hostSources(host)
+	return (v4 || c.denies(host, dns.TypeA, owners, nil)) && (v6 || c.denies(host, dns.TypeAAAA, owners, nil))
+}
+
+// hostSources returns the addresses the cached address records of a host were
+// received from, nil if none has a known source.
+func (c *cache) hostSources(host string) []net.IP {
+	var ips []net.IP
+	for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		for _, cr := range c.lookup(host, rrtype) {
+			if cr.src != nil && !containsIP(ips, cr.src) {
+				ips = append(ips, cr.src)
+			}
+		}
+	}
+	return ips
+}
+
+// trusted reports whether a record of an instance was received from one of
+// its owners or from one of its host's trusted addresses, see hostAddrs.
+// Records of unknown source, and every record of an instance whose owners are
+// unknown, are trusted.
+func trusted(cr cacheRecord, owners, host []net.IP) bool {
+	if cr.src == nil || len(owners) == 0 {
+		return true
+	}
+	return containsIP(owners, cr.src) || containsIP(host, cr.src)
+}
+
+// containsIP reports whether ips contains ip.
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
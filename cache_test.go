@@ -0,0 +1,646 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func makeResponse(t *testing.T, s *MDNSService) *dns.Msg {
+	recs := s.Records(dns.Question{Name: s.serviceAddr, Qtype: dns.TypePTR})
+	if len(recs) == 0 {
+		t.Fatalf("no records for %s", s.serviceAddr)
+	}
+	return &dns.Msg{
+		MsgHdr: dns.MsgHdr{Response: true},
+		Answer: recs,
+	}
+}
+
+func TestCache_Entry(t *testing.T) {
+	c := newCache()
+	c.insert(makeResponse(t, makeService(t)), nil)
+
+	instances := c.instances("_http._tcp.local.")
+	if len(instances) != 1 || instances[0] != "hostname._http._tcp.local." {
+		t.Fatalf("bad: %v", instances)
+	}
+	e := c.entry(instances[0])
+	if e == nil {
+		t.Fatalf("missing entry")
+	}
+	if e.Host != "testhost." || e.Port != 80 || e.Info != "Local web server" {
+		t.Fatalf("bad: %+v", e)
+	}
+	if e.AddrV4 == nil || e.AddrV6 == nil {
+		t.Fatalf("missing addresses: %+v", e)
+	}
+}
+
+func TestCache_Expiry(t *testing.T) {
+	now := time.Now()
+	c := newCache()
+	c.now = func() time.Time { return now }
+	c.insert(makeResponse(t, makeService(t)), nil)
+
+	now = now.Add((defaultTTL - 1) * time.Second)
+	if got := c.instances("_http._tcp.local."); len(got) != 1 {
+		t.Fatalf("bad: %v", got)
+	}
+	now = now.Add(time.Second)
+	if got := c.instances("_http._tcp.local."); len(got) != 0 {
+		t.Fatalf("record should have expired: %v", got)
+	}
+	if got := c.stats().Expirations; got != 1 {
+		t.Fatalf("got %d expirations, want 1", got)
+	}
+}
+
+func TestCache_Goodbye(t *testing.T) {
+	c := newCache()
+	resp := makeResponse(t, makeService(t))
+	c.insert(resp, nil)
+
+	bye := dns.Copy(resp.Answer[0])
+	bye.Header().Ttl = 0
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{bye}}, nil)
+	if got := c.instances("_http._tcp.local."); len(got) != 0 {
+		t.Fatalf("goodbye should remove the record: %v", got)
+	}
+}
+
+func TestCache_Remove(t *testing.T) {
+	c := newCache()
+	c.insert(makeResponse(t, makeService(t)), nil)
+
+	c.removePTR("_http._tcp.local.", "HOSTNAME._http._tcp.local.")
+	if got := c.instances("_http._tcp.local."); len(got) != 0 {
+		t.Fatalf("bad: %v", got)
+	}
+	c.remove("hostname._http._tcp.local.")
+	if e := c.entry("hostname._http._tcp.local."); e != nil {
+		t.Fatalf("bad: %+v", e)
+	}
+}
+
+func TestCache_Limits(t *testing.T) {
+	c := newCache()
+	c.insert(makeResponse(t, makeService(t)), nil)
+	if got := c.stats().Records; got != 5 {
+		t.Fatalf("got %d records, want 5", got)
+	}
+
+	// Touch the PTR so that it is the most recently used record
+	c.instances("_http._tcp.local.")
+	c.setLimits(1, 0)
+	stats := c.stats()
+	if stats.Records != 1 || stats.Evictions != 4 {
+		t.Fatalf("bad: %+v", stats)
+	}
+	if got := c.instances("_http._tcp.local."); len(got) != 1 {
+		t.Fatalf("most recently used record should survive: %v", got)
+	}
+
+	c.setLimits(0, 1)
+	if stats := c.stats(); stats.Records != 0 || stats.Bytes != 0 || stats.Evictions != 5 {
+		t.Fatalf("bad: %+v", stats)
+	}
+}
+
+func TestCache_SharedAndUnique(t *testing.T) {
+	c := newCache()
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ptr := func(target string) dns.RR {
+		return &dns.PTR{
+			Hdr: dns.RR_Header{Name: "_http._tcp.local.", Rrtype: dns.TypePTR, Class: dns.ClassINET | cacheFlushBit, Ttl: 120},
+			Ptr: target,
+		}
+	}
+	srv := func(port uint16, flush bool) dns.RR {
+		class := uint16(dns.ClassINET)
+		if flush {
+			class |= cacheFlushBit
+		}
+		return &dns.SRV{
+			Hdr:    dns.RR_Header{Name: "a._http._tcp.local.", Rrtype: dns.TypeSRV, Class: class, Ttl: 120},
+			Target: "host.local.",
+			Port:   port,
+		}
+	}
+
+	// PTR records are shared and accumulate, even with the cache-flush bit set
+	c.add(ptr("a._http._tcp.local."), "")
+	c.add(ptr("b._http._tcp.local."), "")
+	if got := c.instances("_http._tcp.local."); len(got) != 2 {
+		t.Fatalf("PTR records should accumulate: %v", got)
+	}
+
+	// Unique records without the cache-flush bit accumulate
+	c.add(srv(80, false), "")
+	c.add(srv(81, false), "")
+	if got := c.get("a._http._tcp.local.", dns.TypeSRV); len(got) != 2 {
+		t.Fatalf("bad: %v", got)
+	}
+
+	// The cache-flush bit replaces the other records of the same name and
+	// type received more than a second before
+	now = now.Add(2 * time.Second)
+	c.add(srv(82, true), "")
+	got := c.get("a._http._tcp.local.", dns.TypeSRV)
+	if len(got) != 1 || got[0].(*dns.SRV).Port != 82 {
+		t.Fatalf("bad: %v", got)
+	}
+	if got[0].Header().Class != dns.ClassINET {
+		t.Fatalf("cache-flush bit should be cleared: %v", got[0])
+	}
+
+	// Records received within the last second belong to the same
+	// announcement, and are kept
+	now = now.Add(500 * time.Millisecond)
+	c.add(srv(83, true), "")
+	if got := c.get("a._http._tcp.local.", dns.TypeSRV); len(got) != 2 {
+		t.Fatalf("bad: %v", got)
+	}
+	now = now.Add(2 * time.Second)
+	c.add(srv(83, true), "")
+	if got := c.get("a._http._tcp.local.", dns.TypeSRV); len(got) != 1 || got[0].(*dns.SRV).Port != 83 {
+		t.Fatalf("bad: %v", got)
+	}
+
+	// A goodbye with the cache-flush bit set still matches the cached record
+	bye := srv(83, true)
+	bye.Header().Ttl = 0
+	c.add(bye, "")
+	if got := c.get("a._http._tcp.local.", dns.TypeSRV); len(got) != 0 {
+		t.Fatalf("bad: %v", got)
+	}
+}
+
+func TestCache_Watch(t *testing.T) {
+	c := newCache()
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	events := make(chan RecordEvent, 16)
+	stop := c.watch(events)
+	defer stop()
+	next := func(want RecordEventType, port uint16) RecordEvent {
+		t.Helper()
+		select {
+		case ev := <-events:
+			rr := ev.New
+			if rr == nil {
+				rr = ev.Old
+			}
+			if ev.Type != want || rr.(*dns.SRV).Port != port {
+				t.Fatalf("got %v %v, want %v for port %d", ev.Type, rr, want, port)
+			}
+			return ev
+		default:
+			t.Fatalf("missing %v event", want)
+		}
+		return RecordEvent{}
+	}
+	srv := func(port uint16, ttl uint32) dns.RR {
+		return &dns.SRV{
+			Hdr:    dns.RR_Header{Name: "a._http._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET | cacheFlushBit, Ttl: ttl},
+			Target: "host.local.",
+			Port:   port,
+		}
+	}
+
+	c.add(srv(80, 120), "eth0")
+	if ev := next(RecordAdded, 80); ev.Old != nil || ev.Zone != "eth0" {
+		t.Fatalf("bad: %+v", ev)
+	}
+	c.add(srv(80, 60), "eth0")
+	if ev := next(RecordRefreshed, 80); ev.Old.Header().Ttl != 120 || ev.New.Header().Ttl != 60 {
+		t.Fatalf("bad: %+v", ev)
+	}
+	now = now.Add(2 * time.Second)
+	c.add(srv(81, 120), "eth0")
+	if ev := next(RecordReplaced, 81); ev.Old.(*dns.SRV).Port != 80 {
+		t.Fatalf("bad: %+v", ev)
+	}
+	next(RecordAdded, 81)
+	c.add(srv(81, 0), "eth0")
+	next(RecordRemoved, 81)
+
+	c.add(srv(82, 1), "eth0")
+	next(RecordAdded, 82)
+	now = now.Add(2 * time.Second)
+	c.get("a._http._tcp.local.", dns.TypeSRV)
+	next(RecordExpired, 82)
+
+	stop()
+	c.add(srv(83, 120), "eth0")
+	if len(events) != 0 {
+		t.Fatalf("events sent after stop: %v", <-events)
+	}
+}
+
+func TestCache_Ownership(t *testing.T) {
+	c := newCache()
+	owner := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+	c.insert(makeResponse(t, makeService(t)), owner)
+
+	// An unrelated device answering for the same instance is ignored
+	other := makeService(t)
+	other.Port = 8080
+	other.TXT = []string{"intruder"}
+	other.IPs = []net.IP{net.IPv4(192, 0, 2, 99)}
+	recs := append(other.Records(dns.Question{Name: other.instanceAddr, Qtype: dns.TypeSRV}),
+		other.Records(dns.Question{Name: other.instanceAddr, Qtype: dns.TypeTXT})...)
+	recs = append(recs, other.Records(dns.Question{Name: other.HostName, Qtype: dns.TypeA})...)
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: recs}, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 5353})
+
+	e := c.entry("hostname._http._tcp.local.")
+	if e.Port != 80 || e.Info != "Local web server" || !e.AddrV4.Equal(net.IPv4(192, 168, 0, 42)) {
+		t.Fatalf("records of another source were merged: %+v", e)
+	}
+
+	// The host itself may answer for the instance, once the owner vouched
+	// for its address
+	self := &dns.SRV{
+		Hdr:    dns.RR_Header{Name: "self._http._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 120},
+		Target: "self.local.",
+		Port:   81,
+	}
+	addr := &dns.A{
+		Hdr: dns.RR_Header{Name: "self.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120},
+		A:   net.IPv4(192, 0, 2, 3),
+	}
+	ptr := &dns.PTR{
+		Hdr: dns.RR_Header{Name: "_http._tcp.local.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120},
+		Ptr: "self._http._tcp.local.",
+	}
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{ptr}}, owner)
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{self, addr}}, &net.UDPAddr{IP: addr.A, Port: 5353})
+	if e := c.entry("self._http._tcp.local."); e != nil {
+		t.Fatalf("records of a host no owner vouched for were used: %+v", e)
+	}
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{addr}}, owner)
+	if e := c.entry("self._http._tcp.local."); e == nil || e.Port != 81 || !e.AddrV4.Equal(addr.A) {
+		t.Fatalf("records from the host itself were ignored: %+v", e)
+	}
+}
+
+func TestCache_OwnershipSpoofedAddress(t *testing.T) {
+	c := newCache()
+	owner := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+	c.insert(makeResponse(t, makeService(t)), owner)
+
+	// A third party claims an address for the instance's host, from that
+	// very address, and then answers for the instance as the host
+	spoofer := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 99), Port: 5353}
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{
+		mustRR(t, "testhost. 120 IN A 192.0.2.99"),
+		mustRR(t, "hostname._http._tcp.local. 120 IN SRV 0 0 1 testhost."),
+		mustRR(t, `hostname._http._tcp.local. 120 IN TXT "spoofed"`),
+	}}, spoofer)
+
+	e := c.entry("hostname._http._tcp.local.")
+	if e.Port != 80 || e.Info != "Local web server" {
+		t.Fatalf("the spoofer took over the instance: %+v", e)
+	}
+	if !e.AddrV4.Equal(net.IPv4(192, 168, 0, 42)) || len(e.AddrsV4) != 1 {
+		t.Fatalf("the spoofed address was used: %v", e.AddrsV4)
+	}
+	if ips := c.hostAddrs("testhost.", c.owners(e.Name)); containsIP(ips, spoofer.IP) {
+		t.Fatalf("the spoofed address joined the host: %v", ips)
+	}
+}
+
+func TestCache_NSECSpoofed(t *testing.T) {
+	c := newCache()
+	owner := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+	resp := makeResponse(t, makeService(t))
+	resp.Answer = slices.DeleteFunc(resp.Answer, func(rr dns.RR) bool { return rr.Header().Rrtype == dns.TypeTXT })
+	c.insert(resp, owner)
+
+	// A third party denies that the instance has a TXT record
+	spoofer := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 99), Port: 5353}
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{
+		&dns.NSEC{
+			Hdr:        dns.RR_Header{Name: "hostname._http._tcp.local.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 120},
+			NextDomain: "hostname._http._tcp.local.",
+			TypeBitMap: []uint16{dns.TypeSRV},
+		},
+	}}, spoofer)
+	e := c.entry("hostname._http._tcp.local.")
+	if e == nil || e.noTXT || RequireAll.satisfied(e) {
+		t.Fatalf("the spoofed NSEC was trusted: %+v", e)
+	}
+
+	// The owner's own denial counts
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{
+		&dns.NSEC{
+			Hdr:        dns.RR_Header{Name: "hostname._http._tcp.local.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 120},
+			NextDomain: "hostname._http._tcp.local.",
+			TypeBitMap: []uint16{dns.TypeSRV},
+		},
+	}}, owner)
+	if e := c.entry("hostname._http._tcp.local."); e == nil || !e.noTXT {
+		t.Fatalf("the owner's NSEC was ignored: %+v", e)
+	}
+}
+
+func TestCache_NSECSpoofedHost(t *testing.T) {
+	c := newCache()
+	host := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{
+		mustRR(t, "testhost. 120 IN A 192.0.2.1"),
+	}}, host)
+	addrs := c.hostIPAddrs("testhost.")
+	nsec := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "testhost.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 120},
+		NextDomain: "testhost.",
+		TypeBitMap: []uint16{dns.TypeA},
+	}
+
+	// A third party denies that the host has an IPv6 address
+	spoofer := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 99), Port: 5353}
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{nsec}}, spoofer)
+	if c.hostResolved("testhost.", addrs) {
+		t.Fatalf("the spoofed NSEC was trusted")
+	}
+
+	// The host's own denial counts
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{nsec}}, host)
+	if !c.hostResolved("testhost.", addrs) {
+		t.Fatalf("the host's NSEC was ignored")
+	}
+}
+
+func TestCache_Zoned(t *testing.T) {
+	c := newCache()
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{
+		mustRR(t, "testhost. 120 IN AAAA fe80::1"),
+		mustRR(t, "testhost. 120 IN AAAA 2001:db8::1"),
+	}}, &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 5353, Zone: "eth0"})
+	addrs := c.zoned("testhost.", []net.IP{net.ParseIP("fe80::1"), net.ParseIP("2001:db8::1"), net.ParseIP("fe80::2")})
+	if len(addrs) != 3 || addrs[0].Zone != "eth0" || addrs[1].Zone != "" || addrs[2].Zone != "" {
+		t.Fatalf("bad: %v", addrs)
+	}
+}
+
+func TestCache_KnownAnswers(t *testing.T) {
+	now := time.Now()
+	c := newCache()
+	c.now = func() time.Time { return now }
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{
+		mustRR(t, "_http._tcp.local. 120 IN PTR a._http._tcp.local."),
+		mustRR(t, "_http._tcp.local. 4500 IN PTR b._http._tcp.local."),
+	}}, nil)
+
+	// Records are listed with the TTL they have left, until only half of it
+	// is
+	now = now.Add(30 * time.Second)
+	known := c.knownAnswers("_http._tcp.local.")
+	if len(known) != 2 || known[0].Header().Ttl != 90 || known[1].Header().Ttl != 4470 {
+		t.Fatalf("bad: %v", known)
+	}
+	now = now.Add(30 * time.Second)
+	known = c.knownAnswers("_http._tcp.local.")
+	if len(known) != 1 || known[0].(*dns.PTR).Ptr != "b._http._tcp.local." {
+		t.Fatalf("bad: %v", known)
+	}
+}
+
+func TestCache_MaxTTL(t *testing.T) {
+	now := time.Now()
+	c := newCache()
+	c.now = func() time.Time { return now }
+	c.insert(makeResponse(t, makeService(t)), nil)
+
+	// Records already cached are clamped
+	c.setMaxTTL(60)
+	if e := c.entry("hostname._http._tcp.local."); e == nil || e.TTL != 60 {
+		t.Fatalf("bad: %+v", e)
+	}
+	now = now.Add(60 * time.Second)
+	if got := c.instances("_http._tcp.local."); len(got) != 0 {
+		t.Fatalf("record should have expired: %v", got)
+	}
+
+	// So are new ones, while shorter TTLs are kept
+	c.setMaxTTL(30)
+	resp := makeResponse(t, makeService(t))
+	resp.Answer[0].Header().Ttl = 10
+	c.insert(resp, nil)
+	if got := c.get("_http._tcp.local.", dns.TypePTR); len(got) != 1 || got[0].Header().Ttl != 10 {
+		t.Fatalf("bad: %v", got)
+	}
+	if e := c.entry("hostname._http._tcp.local."); e == nil || e.TTL != 30 {
+		t.Fatalf("bad: %+v", e)
+	}
+	if resp.Answer[1].Header().Ttl != defaultTTL {
+		t.Fatalf("received record was modified: %v", resp.Answer[1])
+	}
+}
+
+func TestCache_HostIPAddrs(t *testing.T) {
+	c := newCache()
+	hdr := func(t uint16) dns.RR_Header {
+		return dns.RR_Header{Name: "host.local.", Rrtype: t, Class: dns.ClassINET, Ttl: 120}
+	}
+	c.add(&dns.A{Hdr: hdr(dns.TypeA), A: net.IPv4(10, 0, 0, 1)}, "eth0")
+	c.add(&dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: net.ParseIP("fe80::1")}, "eth0")
+	c.add(&dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: net.ParseIP("2001:db8::1")}, "eth0")
+
+	// Only link-local addresses need a zone
+	got := fmt.Sprint(c.hostIPAddrs("HOST.local."))
+	if got != "[{10.0.0.1 } {fe80::1 eth0} {2001:db8::1 }]" {
+		t.Fatalf("bad: %s", got)
+	}
+	if v4, v6 := ipVersions(c.hostIPAddrs("host.local.")); !v4 || !v6 {
+		t.Fatalf("both versions expected")
+	}
+}
+
+func TestCache_EntryAddrs(t *testing.T) {
+	c := newCache()
+	service := makeService(t)
+	c.insert(makeResponse(t, service), &net.UDPAddr{IP: net.IPv4(192, 168, 0, 42), Zone: "eth0"})
+	hdr := func(t uint16) dns.RR_Header {
+		return dns.RR_Header{Name: "testhost.", Rrtype: t, Class: dns.ClassINET, Ttl: 120}
+	}
+	c.insertFrom(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{
+		&dns.A{Hdr: hdr(dns.TypeA), A: net.IPv4(10, 0, 0, 42)},
+		&dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: net.ParseIP("fe80::42")},
+	}}, &net.UDPAddr{IP: net.IPv4(192, 168, 0, 42), Zone: "eth0"}, false)
+
+	// Every address is kept, not only the last
+	e := c.entry(service.instanceAddr)
+	if got := fmt.Sprint(e.AddrsV4); got != "[192.168.0.42 10.0.0.42]" {
+		t.Fatalf("bad: %s", got)
+	}
+	if got := fmt.Sprint(e.AddrsV6); got != "[{2620:0:1000:1900:b0c2:d0b2:c411:18bc } {fe80::42 eth0}]" {
+		t.Fatalf("bad: %s", got)
+	}
+	if !e.AddrV4.Equal(e.AddrsV4[1]) || !e.AddrV6.Equal(e.AddrsV6[1].IP) {
+		t.Fatalf("bad: %+v", e)
+	}
+
+	// Addresses the host withdraws are dropped from the lists
+	bye := hdr(dns.TypeAAAA)
+	bye.Ttl = 0
+	c.insertFrom(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{
+		&dns.AAAA{Hdr: bye, AAAA: net.ParseIP("fe80::42")},
+	}}, &net.UDPAddr{IP: net.IPv4(192, 168, 0, 42), Zone: "eth0"}, false)
+	if !c.fill(e) {
+		t.Fatalf("entry is gone")
+	}
+	if got := fmt.Sprint(e.AddrsV6); got != "[{2620:0:1000:1900:b0c2:d0b2:c411:18bc }]" {
+		t.Fatalf("bad: %s", got)
+	}
+	if got := fmt.Sprint(e.AddrsV4); got != "[192.168.0.42 10.0.0.42]" {
+		t.Fatalf("bad: %s", got)
+	}
+}
+
+func TestCache_FillTargetChanged(t *testing.T) {
+	c := newCache()
+	service := makeService(t)
+	c.insert(makeResponse(t, service), nil)
+	e := c.entry(service.instanceAddr)
+	if e == nil || e.AddrV4 == nil {
+		t.Fatalf("bad: %+v", e)
+	}
+
+	// The instance moves to a host whose addresses are not cached yet
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{&dns.SRV{
+		Hdr:    dns.RR_Header{Name: service.instanceAddr, Rrtype: dns.TypeSRV, Class: dns.ClassINET | cacheFlushBit, Ttl: 120},
+		Target: "otherhost.",
+		Port:   80,
+	}}}, nil)
+	if !c.fill(e) {
+		t.Fatalf("entry is gone")
+	}
+	if e.Host != "otherhost." || e.Addr != nil || e.AddrV4 != nil || e.AddrV6 != nil || e.AddrV6IPAddr != nil ||
+		e.AddrsV4 != nil || e.AddrsV6 != nil {
+		t.Fatalf("addresses of the previous host kept: %+v", e)
+	}
+}
+
+func TestCache_EntrySRVPriority(t *testing.T) {
+	c := newCache()
+	srv := func(port, priority, weight uint16) dns.RR {
+		return &dns.SRV{
+			Hdr:      dns.RR_Header{Name: "a._http._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 120},
+			Target:   "a.local.",
+			Port:     port,
+			Priority: priority,
+			Weight:   weight,
+		}
+	}
+	c.add(srv(80, 20, 5), "")
+	c.add(srv(81, 10, 1), "")
+	c.add(srv(82, 10, 3), "")
+
+	// The lowest priority is used, then the highest weight
+	e := c.entry("a._http._tcp.local.")
+	if e == nil || e.Port != 82 || e.Priority != 10 || e.Weight != 3 {
+		t.Fatalf("bad: %+v", e)
+	}
+}
+
+func TestCache_EntryTTLs(t *testing.T) {
+	now := time.Now()
+	c := newCache()
+	c.now = func() time.Time { return now }
+	service := makeService(t)
+	c.insert(makeResponse(t, service), nil)
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "testhost.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10},
+		A:   net.IPv4(10, 0, 0, 42),
+	}}}, nil)
+
+	e := c.entry(service.instanceAddr)
+	want := RecordTTLs{
+		PTR:  defaultTTL * time.Second,
+		SRV:  defaultTTL * time.Second,
+		TXT:  defaultTTL * time.Second,
+		A:    10 * time.Second,
+		AAAA: defaultTTL * time.Second,
+	}
+	if e.TTLs != want {
+		t.Fatalf("got %+v, want %+v", e.TTLs, want)
+	}
+	// The shortest lived record expires first
+	if !e.ExpiresAt.Equal(now.Add(10 * time.Second)) {
+		t.Fatalf("bad: %v", e.ExpiresAt)
+	}
+}
+
+func TestCache_FlushKeepsAnnouncement(t *testing.T) {
+	c := newCache()
+	a := func(ip string) dns.RR {
+		return &dns.A{
+			Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlushBit, Ttl: 120},
+			A:   net.ParseIP(ip),
+		}
+	}
+
+	// A host with several addresses announces them all with the cache-flush
+	// bit set
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{a("10.0.0.1"), a("10.0.0.2")}}, nil)
+	if got := c.get("host.local.", dns.TypeA); len(got) != 2 {
+		t.Fatalf("bad: %v", got)
+	}
+}
+
+func TestCache_NSEC(t *testing.T) {
+	c := newCache()
+	c.insert(makeResponse(t, makeService(t)), nil)
+
+	// The host has an IPv4 address only, and the instance no TXT record
+	c.remove("testhost.")
+	c.remove("hostname._http._tcp.local.")
+	c.add(mustRR(t, "hostname._http._tcp.local. 120 IN SRV 10 1 80 testhost."), "")
+	c.add(&dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "hostname._http._tcp.local.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 120},
+		NextDomain: "hostname._http._tcp.local.",
+		TypeBitMap: []uint16{dns.TypeSRV},
+	}, "")
+	c.add(&dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "testhost.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 120},
+		NextDomain: "testhost.",
+		TypeBitMap: []uint16{dns.TypeA},
+	}, "")
+	if c.denies("testhost.", dns.TypeA, nil, nil) || !c.denies("TESTHOST.", dns.TypeAAAA, nil, nil) || c.denies("otherhost.", dns.TypeAAAA, nil, nil) {
+		t.Fatalf("bad denials")
+	}
+	if c.hostResolved("testhost.", nil) {
+		t.Fatalf("the IPv4 address is still to come")
+	}
+	if !c.hostResolved("testhost.", []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}) {
+		t.Fatalf("the host has no IPv6 address to wait for")
+	}
+
+	// Neither the TXT record nor addresses are required of the entry then,
+	// once the host denies having an IPv4 address too
+	e := c.entry("hostname._http._tcp.local.")
+	if e == nil || !e.noTXT || e.noAddrs || RequireAll.satisfied(e) {
+		t.Fatalf("bad: %+v", e)
+	}
+	c.add(&dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "testhost.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET | cacheFlushBit, Ttl: 120},
+		NextDomain: "testhost.",
+	}, "")
+	e = c.entry("hostname._http._tcp.local.")
+	if e == nil || !e.noAddrs || !RequireAll.satisfied(e) {
+		t.Fatalf("bad: %+v", e)
+	}
+	if q := resolveQuestion(e); len(q.Question) != 0 {
+		t.Fatalf("nothing left to ask: %v", q.Question)
+	}
+}
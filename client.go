@@ -5,10 +5,13 @@ package mdns
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"runtime/pprof"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -24,34 +27,192 @@ type ServiceEntry struct {
 	AddrV4       net.IP
 	AddrV6       net.IP // @Deprecated
 	AddrV6IPAddr *net.IPAddr
+	AddrsV4      []net.IP     // Every IPv4 address of the host, AddrV4 is the last
+	AddrsV6      []net.IPAddr // Every IPv6 address of the host, with zones, AddrV6IPAddr is the last
 	Port         int
+	Priority     int // Priority of the instance's SRV record, lower is preferred
+	Weight       int // Weight of the instance's SRV record, among those of the same priority
 	Info         string
 	InfoFields   []string
 	SrcIP        net.IP
+	ReceivedOn   string // Name of the interface the last response arrived on, empty if unknown
+	QueryID      string // ID of the QueryParam that produced this entry
+	TTL          uint32 // TTL of the instance's SRV record, in seconds
+
+	// TTLs are the TTLs of the records the entry was built from, as they
+	// were received, and ExpiresAt is when the first of them expires unless
+	// it is announced again. They let applications expire entries they keep.
+	TTLs      RecordTTLs
+	ExpiresAt time.Time
 
 	Addr net.IP // @Deprecated
 
-	hasTXT bool
-	sent   bool
+	hasTXT  bool
+	noTXT   bool              // the instance denied having a TXT record with NSEC
+	noAddrs bool              // the host denied having addresses with NSEC
+	txt     map[string]string // key/value pairs of the TXT record, see TXTMap
+	sent    bool
+	asked   bool           // the instance has been queried for missing records
+	iface   *net.Interface // interface of the query that discovered the entry
+	peers   []net.IP       // peers of the query that discovered the entry
+
+	// events is the Events channel of the query that discovered the entry.
+	events chan<- BrowseEvent
+
+	// delivery is how the query that discovered the entry sends it.
+	delivery delivery
+
+	// require decides whether the entry is complete, see
+	// QueryParam.Require. It is nil when every entry is.
+	require func(*ServiceEntry) bool
+
+	// filter decides whether the entry is sent, see QueryParam.Filter.
+	filter func(*ServiceEntry) bool
+
+	// resolveBy is when the entry is sent even if it is incomplete. It is
+	// zero when the query does not wait for entries to resolve.
+	resolveBy time.Time
 }
 
-// complete is used to check if we have all the info we need
+// complete is used to check if we have all the info the query requires.
 func (s *ServiceEntry) complete() bool {
-	return true
-	// return (s.AddrV4 != nil || s.AddrV6 != nil || s.Addr != nil) && s.Port != 0 && s.hasTXT
+	if s.require == nil {
+		return true
+	}
+	return s.require(s)
 }
 
 // QueryParam is used to customize how a Lookup is performed
 type QueryParam struct {
-	Service             string               // Service to lookup
+	Service             string               // Service to lookup, or a subtype of it, see SubtypeName
 	Domain              string               // Lookup domain, default "local"
 	Timeout             time.Duration        // Lookup timeout, default 1 second
-	Interface           *net.Interface       // Multicast interface to use
+	Interface           *net.Interface       // Multicast interface to use instead of the Client's, responses on other interfaces are ignored
 	Entries             chan<- *ServiceEntry // Entries Channel
 	WantUnicastResponse bool                 // Unicast response desired, as per 5.4 in RFC
 	DisableIPv4         bool                 // Whether to disable usage of IPv4 for MDNS operations. Does not affect discovered addresses.
 	DisableIPv6         bool                 // Whether to disable usage of IPv6 for MDNS operations. Does not affect discovered addresses.
 	Logger              *log.Logger          // Optionally provide a *log.Logger to better manage log output.
+	ID                  string               // Optional identifier copied to the QueryID of entries found by this query
+
+	// UnicastRetransmissions keeps the unicast-response bit set on
+	// retransmitted questions when WantUnicastResponse is set. By default only
+	// the first question asks for a unicast response, as per 5.4 in RFC, but
+	// networks that filter multicast responses may need it on every question.
+	UnicastRetransmissions bool
+
+	// ResolveTimeout is how long each discovered instance is given to
+	// resolve the records selected by Require before its entry is sent
+	// anyway. By default entries are only sent once complete.
+	ResolveTimeout time.Duration
+
+	// Require selects the records an instance must have resolved before its
+	// entry is sent; while waiting, the instance is queried for the missing
+	// records. Entries still incomplete when the ResolveTimeout expires are
+	// sent anyway, and without a ResolveTimeout they are not sent at all.
+	// RequireResolved is used by default.
+	Require Completion
+
+	// Complete, if set, decides whether an entry is complete, overriding
+	// Require.
+	Complete func(*ServiceEntry) bool
+
+	// Filter, if set, is called with every entry about to be sent, once it is
+	// complete, and the entries it rejects are not sent: not to Entries, to
+	// Events, nor to a browse's events. A rejected entry is offered again
+	// when more of its records arrive, so that filters on TXT keys or
+	// addresses see them once they are known. Filter is called from the
+	// goroutine running the query.
+	Filter func(*ServiceEntry) bool
+
+	// RetransmitInterval is the delay before the questions are first
+	// retransmitted, default 1 second. The interval doubles after every
+	// retransmission, up to MaxRetransmitInterval, as described in RFC 6762,
+	// section 5.2. Each retransmission is delayed by up to a tenth of the
+	// interval at random.
+	RetransmitInterval time.Duration
+
+	// MaxRetransmitInterval caps the interval between retransmissions,
+	// default 60 minutes.
+	MaxRetransmitInterval time.Duration
+
+	// MaxRetransmissions limits how many times the questions are
+	// retransmitted. Zero means no limit, and a negative value disables
+	// retransmission.
+	MaxRetransmissions int
+
+	// MinQueryInterval is the least time between two packets of questions
+	// of the lookup, whether retransmitted or re-issued after a network
+	// change. Questions due sooner are held back until it passed, and sent
+	// together. Where the queries of a lookup differ, the longest applies.
+	// By default only the retransmission schedule limits the questions.
+	MinQueryInterval time.Duration
+
+	// KnownAnswers lists the PTR records already cached for the service, with
+	// more than half their TTL left, in the Known-Answer Section of the
+	// questions, so that responders leave them out of their responses, see
+	// RFC 6762, section 7.1. Those that do not fit in the packet are left out.
+	KnownAnswers bool
+
+	// Peers are responders the questions are also sent to directly, by
+	// unicast to port 5353, for links such as VPN tunnels that do not carry
+	// multicast. Their responses are handled like any other.
+	Peers []net.IP
+
+	// GraceWindow keeps the query listening for this long after its Timeout,
+	// without delaying its return, so that responses arriving just too late,
+	// as is common on congested Wi-Fi, are cached as answers to it and the
+	// next lookup finds them. Late entries are not sent to Entries. Records
+	// received in the window are not passive, see ShedPassiveFirst. By
+	// default the query stops listening at its Timeout.
+	GraceWindow time.Duration
+
+	// Events, if set, receives the events a browse would send, see
+	// BrowseParams, for the entries sent to Entries: when one is sent, when it
+	// changes, and when its instance goes away by a goodbye or the expiry of
+	// its PTR record, for the lifetime of the query. Events are sent as
+	// Delivery asks.
+	Events chan<- BrowseEvent
+
+	// RemoveOnClose makes a browse, or a query with Events, report every
+	// instance it reported and that did not go away as removed when it
+	// stops, whether because its context is done, its Timeout passed, or the
+	// Client closed, for consumers that forget everything a stopped browse
+	// knew. The removals, and the events queued before them, are sent as
+	// Delivery asks even once the context is done, waiting up to a second in
+	// all for a channel that is not ready. By default the last events stand
+	// as a final snapshot.
+	RemoveOnClose bool
+
+	// Delivery selects what happens to an entry when the results channel is
+	// not ready to receive it: by default it is dropped, and DeliverBlock
+	// and DeliverBuffer trade the query's responsiveness for not losing
+	// entries. DeliveryBuffer is the number of entries DeliverBuffer queues.
+	Delivery       Delivery
+	DeliveryBuffer int
+
+	// OnDrop, if set, is called with every entry dropped because the results
+	// channel was not ready, from the goroutine running the query.
+	OnDrop func(*ServiceEntry)
+}
+
+// queryRetransmitInterval is the default delay before a query is first
+// retransmitted. The interval doubles after every retransmission.
+const queryRetransmitInterval = time.Second
+
+// withDefaults returns the parameters with the fields left unset taken from
+// the package defaults.
+func (p QueryParam) withDefaults() QueryParam {
+	if p.Domain == "" {
+		p.Domain = "local"
+	}
+	if p.Timeout == 0 {
+		p.Timeout = time.Second
+	}
+	if p.RetransmitInterval == 0 {
+		p.RetransmitInterval = queryRetransmitInterval
+	}
+	return p
 }
 
 // DefaultParams is used to return a default set of QueryParam's
@@ -70,7 +231,7 @@ func DefaultParams(service string) *QueryParam {
 // Query looks up a given service, in a domain, waiting at most
 // for a timeout before finishing the query. The results are streamed
 // to a channel. Sends will not block, so clients should make sure to
-// either read or buffer.
+// either read or buffer, unless QueryParam.Delivery says otherwise.
 func Query(params *[]QueryParam, respChan chan<- *ServiceEntry, queryClient *Client) error {
 	return QueryContext(context.Background(), params, respChan, queryClient)
 }
@@ -78,21 +239,25 @@ func Query(params *[]QueryParam, respChan chan<- *ServiceEntry, queryClient *Cli
 // QueryContext looks up a given service, in a domain, waiting at most
 // for a timeout before finishing the query. The results are streamed
 // to a channel. Sends will not block, so clients should make sure to
-// either read or buffer. QueryContext will attempt to stop the query
-// on cancellation.
+// either read or buffer, unless QueryParam.Delivery says otherwise.
+// QueryContext stops the query as soon as the
+// context is done, returning the context's error.
 func QueryContext(ctx context.Context, params *[]QueryParam, respChan chan<- *ServiceEntry, queryClient *Client) error {
-	for _, par := range *params {
-		if par.Domain == "" {
-			par.Domain = "local"
-		}
-		if par.Timeout == 0 {
-			par.Timeout = time.Second
-		}
-	}
 	// Ensure defaults are set
+	withDefaults := make([]QueryParam, len(*params))
+	for i, par := range *params {
+		withDefaults[i] = par.withDefaults()
+	}
+	params = &withDefaults
+
+	// Wait for a query slot
+	if err := queryClient.acquireQuery(ctx); err != nil {
+		return err
+	}
+	defer queryClient.releaseQuery()
 
 	// Run the query
-	return queryClient.query(params, respChan)
+	return queryClient.query(ctx, params, respChan)
 }
 
 // Lookup is the same as Query, however it uses all the default parameters
@@ -105,49 +270,157 @@ func QueryContext(ctx context.Context, params *[]QueryParam, respChan chan<- *Se
 // Client provides a query interface that can be used to
 // search for service providers using mDNS
 type Client struct {
+	// The stacks in use and their connections are guarded by mu, as they
+	// change when a stack is enabled or disabled.
 	use_ipv4 bool
 	use_ipv6 bool
 
-	ipv4UnicastConn *net.UDPConn
-	ipv6UnicastConn *net.UDPConn
+	ipv4UnicastConn net.PacketConn
+	ipv6UnicastConn net.PacketConn
+
+	ipv4MulticastConn net.PacketConn
+	ipv6MulticastConn net.PacketConn
 
-	ipv4MulticastConn *net.UDPConn
-	ipv6MulticastConn *net.UDPConn
+	// MsgChan is sent every received message while it has room.
+	//
+	// Deprecated: received messages are dispatched to every active query,
+	// and nothing reads MsgChan any more. Use Observe to follow the records
+	// and entries received.
+	MsgChan chan *msgAddr
 
+	// closed is set, and closedCh closed, by Close. The queries, browses,
+	// and monitors of the Client stop once closedCh is closed.
 	closed   int32
-	closedCh chan struct{} // TODO(reddaly): This doesn't appear to be used.
+	closedCh chan struct{}
+
+	// transport opens the connections, also when a stack is enabled.
+	transport Transport
 
 	log *log.Logger
 
-	MsgChan chan *msgAddr
+	// errLog samples the errors logged on the receive path.
+	errLog *logSampler
+
+	// cache holds the records learned from responses, so that queries can
+	// return known entries without waiting for the network.
+	cache *cache
+
+	mu sync.Mutex
+
+	// localZones are answered in-process, so that services published by this
+	// process can be discovered without relying on multicast loopback.
+	localZones []Zone
+
+	// iface is the multicast interface set by SetInterface.
+	iface *net.Interface
+
+	// ifaces are the interfaces queries are sent on, see SetInterfaces.
+	ifaces []*net.Interface
+
+	// codec packs and unpacks messages, see SetCodec.
+	codec Codec
+
+	// timings records how long each stage of a packet takes, see
+	// SetTimings.
+	timings *Timings
+
+	// activity tracks when packets were last sent and received.
+	activity activity
+
+	// clock runs the timers of queries and browses and the lifetimes of
+	// cached records, see clk.
+	clock clock
+
+	// history tracks when the questions for each service were last sent.
+	history questionHistory
+
+	// outstanding maps legacy unicast responses back to our queries.
+	outstanding outstandingQueries
+
+	// injected queues the messages of InjectRecords for dispatch.
+	injected injectQueue
+
+	// sendHook may delay queries by up to maxDeferral, see SetSendHook.
+	sendHook    SendHook
+	maxDeferral time.Duration
+
+	// observers receive everything discovery-related, see Observe.
+	observers map[*observer]struct{}
+
+	// monitors look for anomalies in received responses, see Monitor.
+	monitors map[*monitor]struct{}
+
+	// surveyors track the responders heard from, see Survey.
+	surveyors map[*surveyor]struct{}
+
+	// quirks tracks the device quirks worked around, see SetQuirks.
+	quirks quirks
+
+	// browseHistory keeps recent browse events, see SetBrowseHistory.
+	browseHistory eventRing
+
+	// interfaceWatch and failover stop the watch of EnableInterfaceWatch and
+	// the monitoring of EnableFailover, if enabled.
+	interfaceWatch func()
+	failover       func()
+
+	// ifaceNames names the interfaces messages are received on, see
+	// ServiceEntry.ReceivedOn.
+	ifaceNames interfaceNames
+
+	// subs are the active queries, each of which is sent every received
+	// message.
+	subs map[*subscription]struct{}
+
+	// Concurrent query limit, see SetQueryLimit
+	activeQueries int
+	maxQueries    int
+	queueQueries  bool
+	queryFreed    chan struct{} // closed when a query slot may be free
 }
 
-func NewClient(v4 bool, v6 bool, logger *log.Logger, inter *net.Interface) (*Client, error) {
-	return newClient(v4, v6, logger, inter)
-} // NewClient creates a new mdns Client that can be used to query
+// NewClient creates a new mdns Client that can be used to query
 // for records
-func newClient(v4 bool, v6 bool, logger *log.Logger, inter *net.Interface) (*Client, error) {
+func NewClient(v4 bool, v6 bool, logger *log.Logger, inter *net.Interface) (*Client, error) {
+	return newClient(DefaultTransport, v4, v6, logger, inter, systemClock{})
+}
+
+// NewClientTransport creates a Client whose connections are opened by the
+// given Transport instead of DefaultTransport.
+func NewClientTransport(transport Transport, v4 bool, v6 bool, logger *log.Logger, inter *net.Interface) (*Client, error) {
+	return newClient(transport, v4, v6, logger, inter, systemClock{})
+}
+
+// The local addresses of the connections a Client sends queries from.
+var (
+	ipv4UnicastAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	ipv6UnicastAddr = &net.UDPAddr{IP: net.IPv6zero, Port: 0}
+)
+
+// newClient creates a Client whose connections are opened by transport, and
+// whose timers run on clk.
+func newClient(transport Transport, v4 bool, v6 bool, logger *log.Logger, inter *net.Interface, clk clock) (*Client, error) {
 	if !v4 && !v6 {
 		return nil, fmt.Errorf("Must enable at least one of IPv4 and IPv6 querying")
 	}
 
 	// TODO(reddaly): At least attempt to bind to the port required in the spec.
 	// Create a IPv4 listener
-	var uconn4 *net.UDPConn
-	var uconn6 *net.UDPConn
-	var mconn4 *net.UDPConn
-	var mconn6 *net.UDPConn
+	var uconn4 net.PacketConn
+	var uconn6 net.PacketConn
+	var mconn4 net.PacketConn
+	var mconn6 net.PacketConn
 	var err error
 
 	// Establish unicast connections
 	if v4 {
-		uconn4, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353})
+		uconn4, err = transport.ListenUDP("udp4", ipv4UnicastAddr)
 		if err != nil {
 			logger.Printf("[ERR] mdns: Failed to bind to udp4 port: %v", err)
 		}
 	}
 	if v6 {
-		uconn6, err = net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6zero, Port: 0})
+		uconn6, err = transport.ListenUDP("udp6", ipv6UnicastAddr)
 		if err != nil {
 			logger.Printf("[ERR] mdns: Failed to bind to udp6 port: %v", err)
 		}
@@ -158,13 +431,13 @@ func newClient(v4 bool, v6 bool, logger *log.Logger, inter *net.Interface) (*Cli
 
 	// Establish multicast connections
 	if v4 {
-		mconn4, err = net.ListenMulticastUDP("udp4", nil, ipv4Addr)
+		mconn4, err = transport.ListenMulticastUDP("udp4", nil, ipv4Addr)
 		if err != nil {
 			logger.Printf("[ERR] mdns: Failed to bind to udp4 port: %v", err)
 		}
 	}
 	if v6 {
-		mconn6, err = net.ListenMulticastUDP("udp6", nil, ipv6Addr)
+		mconn6, err = transport.ListenMulticastUDP("udp6", nil, ipv6Addr)
 		if err != nil {
 			logger.Printf("[ERR] mdns: Failed to bind to udp6 port: %v", err)
 		}
@@ -177,10 +450,28 @@ func newClient(v4 bool, v6 bool, logger *log.Logger, inter *net.Interface) (*Cli
 	// and disable the respective protocol if not.
 	if uconn4 == nil || mconn4 == nil {
 		logger.Printf("[INFO] mdns: Failed to listen to both unicast and multicast on IPv4")
+		if uconn4 != nil {
+			uconn4.Close()
+		}
+		if mconn4 != nil {
+			mconn4.Close()
+		}
 		uconn4 = nil
 		mconn4 = nil
 		v4 = false
 	}
+	if v6 && (uconn6 == nil || mconn6 == nil) {
+		logger.Printf("[INFO] mdns: Failed to listen to both unicast and multicast on IPv6")
+		if uconn6 != nil {
+			uconn6.Close()
+		}
+		if mconn6 != nil {
+			mconn6.Close()
+		}
+		uconn6 = nil
+		mconn6 = nil
+		v6 = false
+	}
 	if !v4 && !v6 {
 		return nil, fmt.Errorf("at least one of IPv4 and IPv6 must be enabled for querying")
 	}
@@ -192,16 +483,27 @@ func newClient(v4 bool, v6 bool, logger *log.Logger, inter *net.Interface) (*Cli
 		ipv6MulticastConn: mconn6,
 		ipv4UnicastConn:   uconn4,
 		ipv6UnicastConn:   uconn6,
+		MsgChan:           make(chan *msgAddr, subscriptionQueue),
 		closedCh:          make(chan struct{}),
+		transport:         transport,
 		log:               logger,
+		errLog:            newLogSampler(logger, logSampleEvery, logSampleInterval),
+		cache:             newCache(),
+		maxQueries:        defaultMaxQueries,
+		queueQueries:      true,
+		activity:          activity{created: time.Now()},
+		clock:             clk,
 	}
-	c.MsgChan = make(chan *msgAddr, 32)
+	c.cache.now = clk.Now
+	c.browseHistory.now = clk.Now
 	err = c.SetInterface(inter)
 	if err != nil {
 		return c, err
 	}
-	go c.recv(c.ipv4UnicastConn, c.MsgChan)
-	go c.recv(c.ipv4MulticastConn, c.MsgChan)
+	go c.recv(c.ipv4UnicastConn, true)
+	go c.recv(c.ipv4MulticastConn, false)
+	go c.recv(c.ipv6UnicastConn, true)
+	go c.recv(c.ipv6MulticastConn, false)
 	return c, nil
 }
 
@@ -212,194 +514,961 @@ func (c *Client) Close() error {
 		return nil
 	}
 
-	c.log.Printf("[INFO] mdns: Closing Client %v", *c)
+	c.log.Printf("[INFO] mdns: Closing Client %p", c)
 	close(c.closedCh)
+	c.errLog.close()
 
-	if c.ipv4UnicastConn != nil {
-		c.ipv4UnicastConn.Close()
-	}
-	if c.ipv6UnicastConn != nil {
-		c.ipv6UnicastConn.Close()
-	}
-	if c.ipv4MulticastConn != nil {
-		c.ipv4MulticastConn.Close()
-	}
-	if c.ipv6MulticastConn != nil {
-		c.ipv6MulticastConn.Close()
+	cc := c.conns()
+	for _, conn := range []net.PacketConn{cc.unicast4, cc.unicast6, cc.multicast4, cc.multicast6} {
+		if conn != nil {
+			conn.Close()
+		}
 	}
 
 	return nil
 }
 
+// Capabilities describes what a Client was actually able to set up, after
+// falling back from any stack or socket that could not be opened.
+type Capabilities struct {
+	IPv4 bool // IPv4 querying is live
+	IPv6 bool // IPv6 querying is live
+
+	IPv4Unicast   bool // An IPv4 socket for sending queries is bound
+	IPv4Multicast bool // An IPv4 socket joined to the mDNS group is bound
+	IPv6Unicast   bool // An IPv6 socket for sending queries is bound
+	IPv6Multicast bool // An IPv6 socket joined to the mDNS group is bound
+
+	// Interface is the multicast interface queries are sent on, or nil if
+	// the system default is used.
+	Interface *net.Interface
+
+	// Interfaces are the interfaces queries are sent on instead, if
+	// SetInterfaces set any.
+	Interfaces []net.Interface
+}
+
+// Capabilities reports which stacks, sockets, and interface the Client is
+// using, so that callers can detect silent fallbacks made by NewClient.
+func (c *Client) Capabilities() Capabilities {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Capabilities{
+		IPv4:          c.use_ipv4,
+		IPv6:          c.use_ipv6,
+		IPv4Unicast:   c.ipv4UnicastConn != nil,
+		IPv4Multicast: c.ipv4MulticastConn != nil,
+		IPv6Unicast:   c.ipv6UnicastConn != nil,
+		IPv6Multicast: c.ipv6MulticastConn != nil,
+		Interface:     c.iface,
+		Interfaces:    interfaceValues(c.ifaces),
+	}
+}
+
 // setInterface is used to set the query interface, uses system
 // default if not provided
 func (c *Client) SetInterface(iface *net.Interface) error {
-	if c.use_ipv4 {
-		p := ipv4.NewPacketConn(c.ipv4UnicastConn)
-		if err := p.SetMulticastInterface(iface); err != nil {
-			return err
-		}
-		p = ipv4.NewPacketConn(c.ipv4MulticastConn)
-		if err := p.SetMulticastInterface(iface); err != nil {
+	cc := c.conns()
+	if cc.v4 {
+		if err := setStackInterface(false, cc.unicast4, cc.multicast4, iface); err != nil {
 			return err
 		}
 	}
-	if c.use_ipv6 {
-		p2 := ipv6.NewPacketConn(c.ipv6UnicastConn)
-		if err := p2.SetMulticastInterface(iface); err != nil {
-			return err
-		}
-		p2 = ipv6.NewPacketConn(c.ipv6MulticastConn)
-		if err := p2.SetMulticastInterface(iface); err != nil {
+	if cc.v6 {
+		if err := setStackInterface(true, cc.unicast6, cc.multicast6, iface); err != nil {
 			return err
 		}
 	}
+	c.mu.Lock()
+	c.iface = iface
+	c.mu.Unlock()
 	return nil
 }
 
+// AddLocalZone registers a zone whose records are served to this Client's
+// queries directly, without going through the network. This allows a process
+// to discover the services it publishes itself, e.g. by passing the same Zone
+// given to its Server, even where multicast loopback is unavailable.
+func (c *Client) AddLocalZone(zone Zone) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.localZones = append(c.localZones, zone)
+}
+
+// SetCacheLimits bounds the memory used by the Client's record cache. Once
+// either limit is exceeded the least recently used records are evicted. A
+// limit of zero disables it; by default at most 10000 records are cached.
+func (c *Client) SetCacheLimits(maxRecords, maxBytes int) {
+	c.cache.setLimits(maxRecords, maxBytes)
+}
+
+// SetMaxTTL caps the TTL of every record the Client caches, whatever the
+// advertising device asked for, so that consumers never act on data older than
+// they can tolerate. Records already cached are clamped too, and entries report
+// the clamped TTL. It is rounded up to whole seconds; zero, the default,
+// removes the cap.
+func (c *Client) SetMaxTTL(ttl time.Duration) {
+	if ttl < 0 {
+		ttl = 0
+	}
+	c.cache.setMaxTTL(uint32((ttl + time.Second - 1) / time.Second))
+}
+
+// CacheStats returns the size of the Client's record cache along with eviction
+// and expiration counters.
+func (c *Client) CacheStats() CacheStats {
+	return c.cache.stats()
+}
+
+// WatchRecords sends an event to ch for every change to a record in the
+// Client's cache, until the returned function is called. Events are dropped
+// if ch is not ready to receive them, so it should be buffered.
+func (c *Client) WatchRecords(ch chan<- RecordEvent) (stop func()) {
+	return c.cache.watch(ch)
+}
+
+// Flush discards everything the Client has cached about a service and
+// immediately multicasts a fresh query for it, so that the next query sees the
+// current state of the network rather than waiting for TTLs to expire.
+//
+// The service is given in the same form as QueryParam.Service, e.g.
+// "_http._tcp", and is looked up in the "local" domain unless it is already a
+// fully qualified name ending in a period.
+func (c *Client) Flush(service string) error {
+	serviceAddr := service
+	if !strings.HasSuffix(serviceAddr, ".") {
+		serviceAddr = ServiceName(service, "")
+	}
+	for _, instance := range c.cache.instances(serviceAddr) {
+		c.cache.remove(instance)
+	}
+	c.cache.remove(serviceAddr)
+
+	m := new(dns.Msg)
+	m.SetQuestion(serviceAddr, dns.TypePTR)
+	m.RecursionDesired = false
+	return c.sendQuery(context.Background(), m, allStacks, nil)
+}
+
+// Forget discards everything the Client has cached about a single service
+// instance, identified by its fully qualified name as reported in
+// ServiceEntry.Name.
+func (c *Client) Forget(instance string) {
+	c.cache.remove(instance)
+	if labels := dns.Split(instance); len(labels) > 1 {
+		c.cache.removePTR(instance[labels[1]:], instance)
+	}
+}
+
 // msgAddr carries the message and source address from recv to message processing.
 type msgAddr struct {
 	msg *dns.Msg
 	src *net.UDPAddr
+
+	// ifIndex is the interface the message arrived on, or zero if unknown.
+	ifIndex int
+}
+
+// subscriptionQueue is the number of received messages queued for an active
+// query that has yet to handle them.
+const subscriptionQueue = 32
+
+// subscription receives the messages dispatched to an active query.
+type subscription struct {
+	ch     chan *msgAddr
+	done   chan struct{}
+	resend chan struct{} // asks the query to re-issue its questions
+
+	// entries is the number of entries the query has in progress, for
+	// memory accounting.
+	entries atomic.Int64
+
+	// asks holds the names the query asks about, by cacheKey, guarded by the
+	// Client's mu, see askedFor.
+	asks map[string]bool
+}
+
+// track records the number of entries the query has in progress.
+func (s *subscription) track(inprogress map[string]*ServiceEntry) {
+	s.entries.Store(int64(len(inprogress)))
+}
+
+// subscribe registers a new subscription for received messages.
+func (c *Client) subscribe() *subscription {
+	sub := &subscription{
+		ch:     make(chan *msgAddr, subscriptionQueue),
+		done:   make(chan struct{}),
+		resend: make(chan struct{}, 1),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subs == nil {
+		c.subs = make(map[*subscription]struct{})
+	}
+	c.subs[sub] = struct{}{}
+	return sub
+}
+
+// asking records that a subscription's query asks about names, so that the
+// records answering it are not passive.
+func (c *Client) asking(sub *subscription, names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sub.asks == nil {
+		sub.asks = make(map[string]bool)
+	}
+	for _, name := range names {
+		sub.asks[cacheKey(name)] = true
+	}
+}
+
+// askedFor reports whether a running query asks about a record of a
+// response: one named as it asks, or an instance of a service it asks for.
+// The records of other responses are cached as passive, see
+// ShedPassiveFirst.
+func (c *Client) askedFor(msg *dns.Msg) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, section := range [][]dns.RR{msg.Answer, msg.Extra} {
+		for _, rr := range section {
+			name := cacheKey(rr.Header().Name)
+			for sub := range c.subs {
+				if sub.asks[name] {
+					return true
+				}
+				if _, service, ok := strings.Cut(name, "."); ok && sub.asks[service] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// unsubscribe stops delivery of messages to a subscription.
+func (c *Client) unsubscribe(sub *subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subs, sub)
+	close(sub.done)
+}
+
+// dispatch sends a received message to every active subscription, without
+// waiting for any: a query that falls behind by more than subscriptionQueue
+// messages loses the oldest it has queued, whose records are cached all the
+// same.
+func (c *Client) dispatch(m *msgAddr) {
+	c.mu.Lock()
+	subs := make([]*subscription, 0, len(c.subs))
+	for sub := range c.subs {
+		subs = append(subs, sub)
+	}
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		if sub.queue(m) && c.errLog != nil {
+			c.errLog.Printf("[WARN] mdns: Dropped a message queued for a query that fell behind")
+		}
+	}
+	select {
+	case c.MsgChan <- m:
+	default:
+	}
+}
+
+// queue adds a message to those the subscription has yet to receive, dropping
+// the oldest if it has subscriptionQueue already. It reports whether one was
+// dropped.
+func (s *subscription) queue(m *msgAddr) (dropped bool) {
+	for {
+		select {
+		case s.ch <- m:
+			return dropped
+		case <-s.done:
+			return dropped
+		default:
+		}
+		select {
+		case <-s.ch:
+			dropped = true
+		default:
+		}
+	}
 }
 
 // query is used to perform a lookup and stream results
-func (c *Client) query(params *[]QueryParam, respChan chan<- *ServiceEntry) error {
-	// Send the query
+func (c *Client) query(ctx context.Context, params *[]QueryParam, respChan chan<- *ServiceEntry) error {
+	// Only use the stacks some query asks for
+	var via stacks
 	for _, par := range *params {
-		m := new(dns.Msg)
-		serviceAddr := fmt.Sprintf("%s.%s.", trimDot(par.Service), trimDot(par.Domain))
-		m.SetQuestion(serviceAddr, dns.TypePTR)
-		// RFC 6762, section 18.12.  Repurposing of Top Bit of qclass in Question
-		// Section
-		//
-		// In the Question Section of a Multicast DNS query, the top bit of the qclass
-		// field is used to indicate that unicast responses are preferred for this
-		// particular question.  (See Section 5.4.)
-		if par.WantUnicastResponse {
-			m.Question[0].Qclass |= 1 << 15
-		}
-		m.RecursionDesired = false
-		if err := c.sendQuery(m); err != nil {
-			return err
+		via = via.union(par.stacks())
+	}
+	if !via.usable(c) {
+		return fmt.Errorf("mdns: query disables every IP stack the Client uses")
+	}
+
+	for _, par := range *params {
+		name := ServiceName(par.Service, par.Domain)
+		c.history.start(name)
+		defer c.history.done(name)
+	}
+
+	// Send the query, once the send hook allows it
+	name := ""
+	if len(*params) > 0 {
+		name = ServiceName((*params)[0].Service, (*params)[0].Domain)
+	}
+	if d, _ := c.schedule(TransmitQuery, name); d > 0 {
+		select {
+		case <-after(c.clk(), d):
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.closedCh:
+			return ErrClientClosed
 		}
 	}
+	sub, err := c.askQuestions(ctx, *params)
+	if err != nil {
+		return err
+	}
+
+	// Keep listening for late responses during the grace window, if any
+	var grace time.Duration
+	for _, par := range *params {
+		grace = max(grace, par.GraceWindow)
+	}
+	defer func() {
+		if grace > 0 {
+			go c.linger(sub, grace)
+		} else {
+			c.unsubscribe(sub)
+		}
+	}()
 
 	// Map the in-progress responses
 	inprogress := make(map[string]*ServiceEntry)
 
-	// Listen until we reach the timeout
-	finish := time.After(2 * time.Second)
+	// Map the service names to the queries for them
+	services := make(map[string]*QueryParam)
+
+	// Report the changes to the entries, if any query asks for them
+	events := newQueryEvents(ctx, c.clk(), c.cache, *params, c.closedCh)
+	defer events.close()
+	var sweep timer
+	var sweepC <-chan time.Time
+	if events.active() {
+		sweep = c.clk().NewTimer(browseSweepInterval)
+		defer sweep.Stop()
+		sweepC = sweep.Chan()
+	}
+
+	// Send the entries as each query asks
+	out := newOutbox(ctx, respChan, c.closedCh)
+	defer out.flush()
+
+	// Start with whatever is already known about the services
+	for i := range *params {
+		par := &(*params)[i]
+		serviceAddr := ServiceName(par.Service, par.Domain)
+		services[strings.ToLower(serviceAddr)] = par
+		for _, instance := range c.cache.instances(serviceAddr) {
+			inp := c.cache.entry(instance)
+			if inp == nil {
+				continue
+			}
+			claimEntry(inp, par, c.clk().Now())
+			inprogress[strings.ToLower(instance)] = inp
+			c.deliverEntry(ctx, inp, out, via)
+			events.observe(inp)
+		}
+	}
+	sub.track(inprogress)
+
+	// Entries waiting to resolve are sent once their ResolveTimeout expires
+	resolve := c.clk().NewTimer(0)
+	defer resolve.Stop()
+	resetResolve(c.clk(), resolve, inprogress)
+
+	// Retransmit the questions as described in RFC 6762, section 5.2, each
+	// query on its own schedule
+	retransmits := newRetransmissions(*params, c.clk().Now())
+	retransmit := c.clk().NewTimer(0)
+	defer retransmit.Stop()
+	retransmits.reset(c.clk(), retransmit)
+
+	// Each query listens until its own timeout, and the whole lookup
+	// finishes with the last of them
+	active := append([]QueryParam(nil), (*params)...)
+	started := c.clk().Now()
+	expired := make(map[string]bool)
+	finish := c.clk().NewTimer(nextTimeout(active))
+	defer finish.Stop()
+
+	// Questions are held back as MinQueryInterval asks
+	limit := newRateLimit(*params, c.clk().Now())
+	send := func(due []QueryParam, retransmission bool) {
+		if limit.hold(c.clk(), due, retransmission) {
+			return
+		}
+		if err := c.sendQuestions(ctx, due, retransmission); err != nil {
+			if retransmission {
+				c.log.Printf("[ERR] mdns: Failed to retransmit query: %v", err)
+			} else {
+				c.log.Printf("[ERR] mdns: Failed to re-issue query: %v", err)
+			}
+		}
+		limit.sent(c.clk().Now())
+	}
+	var deferred <-chan time.Time // a retransmission delayed by the send hook
+	var deferredDue []QueryParam
+
 	for {
+		pending := events.pending()
 		select {
-		case resp := <-c.MsgChan:
-			var inp *ServiceEntry
-			for _, answer := range append(resp.msg.Answer, resp.msg.Extra...) {
-				// TODO(reddaly): Check that response corresponds to serviceAddr?
-				switch rr := answer.(type) {
-				case *dns.PTR:
-					// Create new entry for this
-					inp = ensureName(inprogress, rr.Ptr)
-
-				case *dns.SRV:
-					// Check for a target mismatch
-					if rr.Target != rr.Hdr.Name {
-						alias(inprogress, rr.Hdr.Name, rr.Target)
-					}
-
-					// Get the port
-					inp = ensureName(inprogress, rr.Hdr.Name)
-					inp.Host = rr.Target
-					inp.Port = int(rr.Port)
-
-				case *dns.TXT:
-					// Pull out the txt
-					inp = ensureName(inprogress, rr.Hdr.Name)
-					inp.Info = strings.Join(rr.Txt, "|")
-					inp.InfoFields = rr.Txt
-					inp.hasTXT = true
-
-				case *dns.A:
-					// Pull out the IP
-					inp = ensureName(inprogress, rr.Hdr.Name)
-					inp.Addr = rr.A // @Deprecated
-					inp.AddrV4 = rr.A
-
-				case *dns.AAAA:
-					// Pull out the IP
-					inp = ensureName(inprogress, rr.Hdr.Name)
-					inp.Addr = rr.AAAA   // @Deprecated
-					inp.AddrV6 = rr.AAAA // @Deprecated
-					inp.AddrV6IPAddr = &net.IPAddr{IP: rr.AAAA}
-					// link-local IPv6 addresses must be qualified with a zone (interface). Zone is
-					// specific to this machine/network-namespace and so won't be carried in the
-					// mDNS message itself. We borrow the zone from the source address of the UDP
-					// packet, as the link-local address should be valid on that interface.
-					if rr.AAAA.IsLinkLocalUnicast() || rr.AAAA.IsLinkLocalMulticast() {
-						inp.AddrV6IPAddr.Zone = resp.src.Zone
-					}
-				}
+		case <-retransmit.Chan():
+			due := retransmits.due(active, c.clk().Now())
+			retransmits.reset(c.clk(), retransmit)
+			if len(due) == 0 {
+				continue
+			}
+			if d, ok := c.schedule(TransmitRetransmit, name); ok && d > 0 {
+				deferred, deferredDue = after(c.clk(), d), due
+			} else if ok {
+				send(due, true)
 			}
 
-			if inp == nil {
+		case <-deferred:
+			deferred = nil
+			send(deferredDue, true)
+
+		case <-limit.ready():
+			held, retransmission := limit.release()
+			if held = onlyRunning(held, services); len(held) > 0 {
+				send(held, retransmission)
+			}
+
+		case <-sub.resend:
+			// The network changed under us, so start a new series of questions
+			send(active, false)
+
+		case resp := <-sub.ch:
+			if !acceptsAny(active, resp) {
 				continue
 			}
-			inp.SrcIP = resp.src.IP
+			for _, inp := range c.updateEntries(inprogress, services, resp) {
+				if !expired[strings.ToLower(instanceService(inp.Name))] {
+					c.deliverEntry(ctx, inp, out, via)
+					events.observe(inp)
+				}
+			}
+			events.sweep(c.cache, inprogress, c.clk().Now())
+			sub.track(inprogress)
+			resetResolve(c.clk(), resolve, inprogress)
 
-			// Check if this entry is complete
-			if inp.complete() {
-				if inp.sent {
-					continue
+		case <-resolve.Chan():
+			now := c.clk().Now()
+			for _, inp := range inprogress {
+				if !inp.sent && !inp.resolveBy.IsZero() && !now.Before(inp.resolveBy) &&
+					!expired[strings.ToLower(instanceService(inp.Name))] {
+					// Past its deadline the entry is complete as it is, also
+					// if the filter rejects it for now
+					inp.require, inp.resolveBy = nil, time.Time{}
+					c.sendEntry(inp, out)
+					events.observe(inp)
 				}
-				inp.sent = true
-				select {
-				case respChan <- inp:
-				default:
+			}
+			resetResolve(c.clk(), resolve, inprogress)
+
+		case <-sweepC:
+			events.sweep(c.cache, inprogress, c.clk().Now())
+			sub.track(inprogress)
+			sweep.Reset(browseSweepInterval)
+
+		case out.ready() <- out.next():
+			out.sent()
+
+		case pending.ready() <- pending.next():
+			pending.sent()
+
+		case <-finish.Chan():
+			// Stop the queries whose timeout passed
+			now := c.clk().Now()
+			remaining := active[:0]
+			for _, par := range active {
+				if now.Sub(started) < par.Timeout {
+					remaining = append(remaining, par)
+					continue
 				}
-			} else {
-				// Fire off a node specific query
-				m := new(dns.Msg)
-				m.SetQuestion(inp.Name, dns.TypePTR)
-				m.RecursionDesired = false
-				if err := c.sendQuery(m); err != nil {
-					c.log.Printf("[ERR] mdns: Failed to query instance %s: %v", inp.Name, err)
+				serviceAddr := strings.ToLower(ServiceName(par.Service, par.Domain))
+				delete(services, serviceAddr)
+				expired[ParentService(serviceAddr)] = true
+			}
+			// Instances are named after the parent of a subtype, which a
+			// query still running may be asking for
+			for _, par := range remaining {
+				delete(expired, strings.ToLower(ServiceName(ParentService(par.Service), par.Domain)))
+			}
+			active = remaining
+			if len(active) == 0 {
+				return nil
+			}
+			finish.Reset(nextTimeout(active) - now.Sub(started))
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// linger keeps the subscription of a query that returned for the grace
+// window, so that the responses still arriving count as answers to a running
+// query when they are cached, see QueryParam.GraceWindow.
+func (c *Client) linger(sub *subscription, window time.Duration) {
+	defer c.unsubscribe(sub)
+	sub.track(nil)
+	timer := c.clk().NewTimer(window)
+	defer timer.Stop()
+	for {
+		select {
+		case <-sub.ch:
+		case <-timer.Chan():
+			return
+		case <-c.closedCh:
+			return
+		}
+	}
+}
+
+// nextTimeout returns the shortest timeout of the queries.
+func nextTimeout(params []QueryParam) time.Duration {
+	if len(params) == 0 {
+		return 0
+	}
+	next := params[0].Timeout
+	for _, par := range params[1:] {
+		if par.Timeout < next {
+			next = par.Timeout
+		}
+	}
+	return next
+}
+
+// updateEntries applies a response to the in-progress entries. A response
+// may describe several instances at once, so every record is attributed
+// separately: PTR records name an instance of a queried service, SRV and TXT
+// records are owned by the instance, and address records belong to every
+// instance whose SRV targets the host. Records answering none of the
+// questions, such as those of another query sharing the Client, are ignored.
+// The affected entries are refreshed from the cache, which the response has
+// already been added to, and returned.
+func (c *Client) updateEntries(inprogress map[string]*ServiceEntry, services map[string]*QueryParam, resp *msgAddr) []*ServiceEntry {
+	if !resp.msg.Response {
+		return nil
+	}
+
+	var touched []*ServiceEntry
+	seen := make(map[*ServiceEntry]bool)
+	touch := func(inp *ServiceEntry) {
+		if !seen[inp] {
+			seen[inp] = true
+			touched = append(touched, inp)
+		}
+	}
+
+	for _, answer := range append(resp.msg.Answer, resp.msg.Extra...) {
+		switch rr := answer.(type) {
+		case *dns.PTR:
+			par, ok := services[strings.ToLower(rr.Hdr.Name)]
+			if !ok || !par.accepts(resp) {
+				continue
+			}
+			// Create new entry for this
+			inp := ensureName(inprogress, rr.Ptr)
+			claimEntry(inp, par, c.clk().Now())
+			touch(inp)
+
+		case *dns.SRV, *dns.TXT:
+			// Records of an instance may come before its PTR record
+			name := rr.Header().Name
+			if inp, ok := inprogress[strings.ToLower(name)]; ok {
+				touch(inp)
+			} else if par := queryOf(services, name); par != nil && par.accepts(resp) {
+				inp := ensureName(inprogress, name)
+				claimEntry(inp, par, c.clk().Now())
+				touch(inp)
+			}
+
+		case *dns.A, *dns.AAAA:
+			for _, inp := range inprogress {
+				if strings.EqualFold(inp.Host, rr.Header().Name) {
+					touch(inp)
 				}
 			}
-		case <-finish:
-			return nil
 		}
 	}
+
+	for _, inp := range touched {
+		c.cache.fill(inp)
+		inp.SrcIP = resp.src.IP
+		inp.ReceivedOn = c.ifaceNames.name(resp.ifIndex)
+	}
+	return touched
+}
+
+// queryOf returns the running query for the service of an instance, or nil
+// if none asks for it. A query for a subtype only learns of its instances
+// from PTR records, as their names do not tell the subtype.
+func queryOf(services map[string]*QueryParam, instance string) *QueryParam {
+	return services[strings.ToLower(instanceService(instance))]
+}
+
+// claimEntry attributes an entry to the query that discovered it, starting
+// its resolution deadline at now if the query has one.
+func claimEntry(inp *ServiceEntry, par *QueryParam, now time.Time) {
+	inp.QueryID = par.ID
+	inp.iface = par.Interface
+	inp.peers = par.Peers
+	inp.events = par.Events
+	inp.delivery = par.delivery()
+	inp.require = par.completion()
+	inp.filter = par.Filter
+	if par.ResolveTimeout > 0 && inp.resolveBy.IsZero() {
+		inp.resolveBy = now.Add(par.ResolveTimeout)
+	}
+}
+
+// deliverEntry sends an entry to the results channel once it is complete, or
+// queries for the missing records otherwise.
+func (c *Client) deliverEntry(ctx context.Context, inp *ServiceEntry, out *outbox[*ServiceEntry], via stacks) {
+	// Check if this entry is complete
+	if inp.complete() {
+		c.sendEntry(inp, out)
+	} else if !inp.asked {
+		// Fire off a node specific query
+		inp.asked = true
+		m := new(dns.Msg)
+		m.SetQuestion(inp.Name, dns.TypeANY)
+		m.RecursionDesired = false
+		if err := c.sendQuery(ctx, m, via, inp.iface); err != nil {
+			c.log.Printf("[ERR] mdns: Failed to query instance %s: %v", inp.Name, err)
+		}
+		c.sendPeers(m, via, inp.peers)
+	}
+}
+
+// sendEntry sends an entry to the results channel and the observers, unless
+// it was already sent or the query filters it out.
+func (c *Client) sendEntry(inp *ServiceEntry, out *outbox[*ServiceEntry]) {
+	if inp.sent {
+		return
+	}
+	// Send a copy, as later responses keep updating inp
+	e := *inp
+	if inp.filter != nil && !inp.filter(&e) {
+		return
+	}
+	inp.sent = true
+	out.send(&e)
+	c.observeEntry(&e)
+}
+
+// resetResolve sets the timer to fire at the earliest resolution deadline of
+// the unsent entries, or stops it if none is waiting.
+func resetResolve(clk clock, t timer, inprogress map[string]*ServiceEntry) {
+	var next time.Time
+	for _, inp := range inprogress {
+		if inp.sent || inp.resolveBy.IsZero() {
+			continue
+		}
+		if next.IsZero() || inp.resolveBy.Before(next) {
+			next = inp.resolveBy
+		}
+	}
+	resetAt(clk, t, next)
+}
+
+// sendQuestions sends the PTR question of every query. Retransmissions are
+// flagged so that the unicast-response bit is only set where appropriate, and
+// skipped where another host just asked the same question.
+func (c *Client) sendQuestions(ctx context.Context, params []QueryParam, retransmission bool) error {
+	if retransmission {
+		params = c.withoutDuplicates(params)
+	}
+	for _, group := range groupQuestions(params, retransmission) {
+		par := group.par
+		for _, q := range group.msgs {
+			if par.KnownAnswers {
+				c.addKnownAnswers(q)
+			}
+			if err := c.sendQuery(ctx, q, par.stacks(), par.Interface); err != nil {
+				return err
+			}
+			c.sendPeers(q, par.stacks(), par.Peers)
+		}
+		for _, name := range group.names {
+			c.history.sent(name, c.clk().Now())
+		}
+	}
+	return nil
+}
+
+// addKnownAnswers lists the records cached in answer to the questions of a
+// query in its Known-Answer Section, see QueryParam.KnownAnswers. Those that
+// would make the query larger than maxQuestionPacket are left out.
+func (c *Client) addKnownAnswers(q *dns.Msg) {
+	for _, question := range q.Question {
+		for _, rr := range c.cache.knownAnswers(question.Name) {
+			q.Answer = append(q.Answer, rr)
+			if q.Len() > maxQuestionPacket {
+				q.Answer = q.Answer[:len(q.Answer)-1]
+				return
+			}
+		}
+	}
+}
+
+// maxQuestionPacket is the largest query packed with several questions, the
+// UDP payload that fits in a 1500 byte Ethernet frame over IPv6.
+const maxQuestionPacket = 1452
+
+// questionGroup holds the questions of the queries that are sent the same
+// way, coalesced into as few packets as their size allows.
+type questionGroup struct {
+	par   QueryParam // first query of the group, which the others send like
+	msgs  []*dns.Msg
+	names []string // services asked for
+}
+
+// groupQuestions coalesces the questions of queries using the same stacks,
+// interface, and peers into shared packets, as Bonjour does when browsing for
+// several services, which saves multicast traffic. A question asked by several
+// such queries is only sent once.
+func groupQuestions(params []QueryParam, retransmission bool) []*questionGroup {
+	var groups []*questionGroup
+	type question struct {
+		group *questionGroup
+		q     dns.Question
+	}
+	asked := make(map[question]bool)
+	for _, par := range params {
+		q := serviceQuestion(par, retransmission)
+		var group *questionGroup
+		for _, g := range groups {
+			if sameTransmission(&g.par, &par) {
+				group = g
+				break
+			}
+		}
+		if group == nil {
+			group = &questionGroup{par: par}
+			groups = append(groups, group)
+		}
+		group.names = append(group.names, ServiceName(par.Service, par.Domain))
+		key := question{group, q.Question[0]}
+		key.q.Name = strings.ToLower(key.q.Name)
+		if asked[key] {
+			continue
+		}
+		asked[key] = true
+
+		if n := len(group.msgs); n > 0 {
+			last := group.msgs[n-1]
+			last.Question = append(last.Question, q.Question[0])
+			if last.Len() <= maxQuestionPacket {
+				continue
+			}
+			last.Question = last.Question[:len(last.Question)-1]
+		}
+		group.msgs = append(group.msgs, q)
+	}
+	return groups
+}
+
+// sameTransmission reports whether two queries send their questions the same
+// way: on the same stacks and interface, to the same peers, and with or
+// without known answers.
+func sameTransmission(a, b *QueryParam) bool {
+	if a.stacks() != b.stacks() || a.KnownAnswers != b.KnownAnswers || (a.Interface == nil) != (b.Interface == nil) ||
+		(a.Interface != nil && a.Interface.Index != b.Interface.Index) || len(a.Peers) != len(b.Peers) {
+		return false
+	}
+	for i := range a.Peers {
+		if !a.Peers[i].Equal(b.Peers[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// serviceQuestion builds the PTR query message for a service.
+func serviceQuestion(par QueryParam, retransmission bool) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(ServiceName(par.Service, par.Domain), dns.TypePTR)
+	// RFC 6762, section 18.12.  Repurposing of Top Bit of qclass in Question
+	// Section
+	//
+	// In the Question Section of a Multicast DNS query, the top bit of the qclass
+	// field is used to indicate that unicast responses are preferred for this
+	// particular question.  (See Section 5.4.)
+	//
+	// Section 5.4 only asks for unicast responses in the first query of a
+	// series; later ones are QM questions, unless the caller insists.
+	if par.WantUnicastResponse && (!retransmission || par.UnicastRetransmissions) {
+		m.Question[0].Qclass |= 1 << 15
+	}
+	m.RecursionDesired = false
+	return m
+}
+
+// sendQuery is used to multicast a query out on the given stacks. If ifi is
+// not nil, the query is sent on that interface instead of the Client's.
+// Nothing is sent once ctx is done.
+func (c *Client) sendQuery(ctx context.Context, q *dns.Msg, via stacks, ifi *net.Interface) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tm := c.getTimings()
+	start := tm.start()
+	pprof.Do(ctx, clientLabels.send, func(context.Context) {
+		err = c.writeQuery(q, via, ifi)
+	})
+	tm.done(StageSend, start)
+	if err != nil {
+		return err
+	}
+	c.answerLocally(q, via)
+	return nil
 }
 
-// sendQuery is used to multicast a query out
-func (c *Client) sendQuery(q *dns.Msg) error {
-	buf, err := q.Pack()
+// writeQuery packs a query and writes it to the multicast group on the given
+// stacks, on ifi if it is not nil, or on each interface of SetInterfaces. A
+// query sent on several interfaces only fails if it failed on all of them.
+func (c *Client) writeQuery(q *dns.Msg, via stacks, ifi *net.Interface) error {
+	buf, err := c.getCodec().Pack(q)
 	if err != nil {
 		return err
 	}
-	if c.ipv4UnicastConn != nil {
-		_, err = c.ipv4UnicastConn.WriteToUDP(buf, ipv4Addr)
+	c.outstanding.add(q, time.Now())
+	cc := c.conns()
+	ifaces := c.queryInterfaces(ifi, cc)
+	var errs []error
+	for _, ifi := range ifaces {
+		if err := c.writeQueryOn(buf, cc, via, ifi); err != nil {
+			if len(ifaces) > 1 {
+				c.errLog.Printf("[ERR] mdns: Failed to send query on %s: %v", ifi.Name, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(ifaces) {
+		return errs[0]
+	}
+	return nil
+}
+
+// writeQueryOn writes a packed query to the multicast group on the given
+// stacks, on ifi if it is not nil.
+func (c *Client) writeQueryOn(buf []byte, cc clientConns, via stacks, ifi *net.Interface) (err error) {
+	if cc.unicast4 != nil && via.v4 {
+		if ifi != nil && isSocket(cc.unicast4) {
+			cm := &ipv4.ControlMessage{IfIndex: ifi.Index}
+			_, err = ipv4.NewPacketConn(cc.unicast4).WriteTo(buf, cm, ipv4Addr)
+		} else {
+			_, err = cc.unicast4.WriteTo(buf, ipv4Addr)
+		}
 		if err != nil {
 			return err
 		}
+		c.activity.markSent()
 	}
-	if c.ipv6UnicastConn != nil {
-		_, err = c.ipv6UnicastConn.WriteToUDP(buf, ipv6Addr)
+	if cc.unicast6 != nil && via.v6 {
+		if ifi != nil && isSocket(cc.unicast6) {
+			cm := &ipv6.ControlMessage{IfIndex: ifi.Index}
+			_, err = ipv6.NewPacketConn(cc.unicast6).WriteTo(buf, cm, ipv6Addr)
+		} else {
+			_, err = cc.unicast6.WriteTo(buf, ipv6Addr)
+		}
 		if err != nil {
 			return err
 		}
+		c.activity.markSent()
 	}
 	return nil
 }
 
+// sendPeers unicasts a query to each peer on the given stacks. Failures are
+// logged rather than returned, so that one unreachable peer does not fail
+// the query.
+func (c *Client) sendPeers(q *dns.Msg, via stacks, peers []net.IP) {
+	if len(peers) == 0 {
+		return
+	}
+	defer c.getTimings().done(StageSend, c.getTimings().start())
+	buf, err := c.getCodec().Pack(q)
+	if err != nil {
+		c.log.Printf("[ERR] mdns: Failed to pack query for peers: %v", err)
+		return
+	}
+	c.outstanding.add(q, time.Now())
+	cc := c.conns()
+	for _, peer := range peers {
+		conn := cc.unicast6
+		if peer.To4() != nil {
+			conn = cc.unicast4
+		}
+		if conn == nil || !via.allows(peer) {
+			continue
+		}
+		if _, err := conn.WriteTo(buf, &net.UDPAddr{IP: peer, Port: mdnsPort}); err != nil {
+			c.errLog.Printf("[ERR] mdns: Failed to send query to peer %s: %v", peer, err)
+			continue
+		}
+		c.activity.markSent()
+	}
+}
+
+// answerLocally answers a query from the local zones, delivering the response
+// as if it had been received from the network.
+func (c *Client) answerLocally(q *dns.Msg, via stacks) {
+	c.mu.Lock()
+	zones := c.localZones
+	c.mu.Unlock()
+
+	var answer []dns.RR
+	for _, zone := range zones {
+		for _, question := range q.Question {
+			answer = append(answer, zone.Records(question)...)
+		}
+	}
+	if len(answer) == 0 {
+		return
+	}
+
+	resp := &dns.Msg{
+		MsgHdr: dns.MsgHdr{Response: true, Authoritative: true},
+		Answer: answer,
+	}
+	src := &net.UDPAddr{IP: net.IPv6loopback, Port: mdnsPort}
+	if c.conns().v4 && via.v4 {
+		src.IP = net.IPv4(127, 0, 0, 1)
+	}
+	c.cache.insert(resp, src)
+
+	// The query loop may be the one sending, so don't block it
+	go c.dispatch(&msgAddr{msg: resp, src: src})
+}
+
 // recv is used to receive until we get a shutdown
-func (c *Client) recv(l *net.UDPConn, msgCh chan *msgAddr) {
+func (c *Client) recv(l net.PacketConn, unicast bool) {
 	if l == nil {
 		return
 	}
+	legacy := unicast && isLegacyConn(l)
+	read := packetReader(l)
 	buf := make([]byte, 65536)
+	clientLabels.wait()
 	for atomic.LoadInt32(&c.closed) == 0 {
-		n, addr, err := l.ReadFromUDP(buf)
+		n, addr, ifIndex, err := read(buf)
 
 		//fmt.Println("msg", n, addr, err)
 
@@ -407,40 +1476,235 @@ func (c *Client) recv(l *net.UDPConn, msgCh chan *msgAddr) {
 			return
 		}
 
+		if errors.Is(err, net.ErrClosed) {
+			// The stack was disabled
+			return
+		}
 		if err != nil {
-			c.log.Printf("[ERR] mdns: Failed to read packet: %v", err)
+			c.errLog.Printf("[ERR] mdns: Failed to read packet: %v", err)
 			continue
 		}
-		msg := new(dns.Msg)
-		if err := msg.Unpack(buf[:n]); err != nil {
-			c.log.Printf("[ERR] mdns: Failed to unpack packet: %v", err)
+		c.activity.markReceived()
+		addr = withZone(addr, ifIndex)
+		tm := c.getTimings()
+		clientLabels.enter(StageUnpack)
+		start := tm.start()
+		msg, err := c.getCodec().Unpack(buf[:n])
+		tm.done(StageUnpack, start)
+		if err != nil {
+			c.errLog.Printf("[ERR] mdns: Failed to unpack packet: %v", err)
+			clientLabels.wait()
 			continue
 		}
-		select {
-		case msgCh <- &msgAddr{
-			msg: msg,
-			src: addr,
-		}:
-		case <-c.closedCh:
-			return
+		clientLabels.enter(StageProcess)
+		start = tm.start()
+		c.handleMsgOn(msg, addr, ifIndex, legacy)
+		tm.done(StageProcess, start)
+		clientLabels.wait()
+	}
+}
+
+// packetReader returns a function reading a packet from l, which also reports
+// the index of the interface the packet arrived on where the system supports
+// it, and zero otherwise.
+func packetReader(l net.PacketConn) func([]byte) (int, *net.UDPAddr, int, error) {
+	if !isSocket(l) {
+		return func(buf []byte) (int, *net.UDPAddr, int, error) {
+			n, src, err := l.ReadFrom(buf)
+			return n, udpAddr(src), 0, err
+		}
+	}
+	if addr, ok := l.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		p := ipv6.NewPacketConn(l)
+		p.SetControlMessage(ipv6.FlagInterface, true)
+		return func(buf []byte) (int, *net.UDPAddr, int, error) {
+			n, cm, src, err := p.ReadFrom(buf)
+			return n, udpAddr(src), ifIndex6(cm), err
 		}
 	}
+	p := ipv4.NewPacketConn(l)
+	p.SetControlMessage(ipv4.FlagInterface, true)
+	return func(buf []byte) (int, *net.UDPAddr, int, error) {
+		n, cm, src, err := p.ReadFrom(buf)
+		return n, udpAddr(src), ifIndex4(cm), err
+	}
+}
+
+// withZone qualifies an IPv6 link-local source address with the interface it
+// arrived on, when the system reported the interface but not the zone, so
+// that the address can be used to reach the host.
+func withZone(addr *net.UDPAddr, ifIndex int) *net.UDPAddr {
+	if addr == nil || ifIndex == 0 || addr.Zone != "" || addr.IP.To4() != nil || !addr.IP.IsLinkLocalUnicast() {
+		return addr
+	}
+	ifi, err := net.InterfaceByIndex(ifIndex)
+	if err != nil {
+		return addr
+	}
+	zoned := *addr
+	zoned.Zone = ifi.Name
+	return &zoned
+}
+
+// interfaceNames maps the interface indexes reported with received packets
+// to the names of the interfaces, which are looked up once per index rather
+// than for every packet. The zero value is ready to use.
+type interfaceNames struct {
+	mu    sync.Mutex
+	names map[int]string
+}
+
+// name returns the name of the interface of an index, or "" if it is zero or
+// the interface is gone.
+func (n *interfaceNames) name(ifIndex int) string {
+	if ifIndex == 0 {
+		return ""
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if name, ok := n.names[ifIndex]; ok {
+		return name
+	}
+	ifi, err := net.InterfaceByIndex(ifIndex)
+	if err != nil {
+		return ""
+	}
+	if n.names == nil {
+		n.names = make(map[int]string)
+	}
+	n.names[ifIndex] = ifi.Name
+	return ifi.Name
+}
+
+// reset replaces the names with those of the interfaces listed, as the
+// interface watch does whenever it lists them, so that an index reused by
+// another interface is not reported under the name of the one before.
+func (n *interfaceNames) reset(cur map[int]interfaceState) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.names = make(map[int]string, len(cur))
+	for index, s := range cur {
+		n.names[index] = s.ifi.Name
+	}
+}
+
+// udpAddr returns addr as a *net.UDPAddr, or nil.
+func udpAddr(addr net.Addr) *net.UDPAddr {
+	a, _ := addr.(*net.UDPAddr)
+	return a
+}
+
+// ifIndex4 returns the interface index of a received IPv4 control message.
+func ifIndex4(cm *ipv4.ControlMessage) int {
+	if cm == nil {
+		return 0
+	}
+	return cm.IfIndex
+}
+
+// ifIndex6 returns the interface index of a received IPv6 control message.
+func ifIndex6(cm *ipv6.ControlMessage) int {
+	if cm == nil {
+		return 0
+	}
+	return cm.IfIndex
+}
+
+// handleMsg handles a message received on a multicast socket, or injected.
+func (c *Client) handleMsg(msg *dns.Msg, src *net.UDPAddr, ifIndex int) {
+	c.handleMsgOn(msg, src, ifIndex, false)
+}
+
+// handleMsgOn caches the records of a received message and dispatches it to
+// the active queries. The questions other hosts ask are noted, so that ours
+// are not repeated. Records no running query asks for are cached as passive.
+// The quirks of the sender are worked around once the monitors saw the
+// message as it was sent.
+//
+// The ID and questions of a multicast response must be ignored, RFC 6762
+// section 18.1. Only where legacy is set, as the message arrived on a socket
+// our queries are sent from without using the mDNS port, is a response
+// carrying them dropped unless it answers one of our queries.
+func (c *Client) handleMsgOn(msg *dns.Msg, src *net.UDPAddr, ifIndex int, legacy bool) {
+	c.inspect(msg, src)
+	if !msg.Response {
+		c.heardQuery(msg, src, ifIndex)
+	}
+	c.quirks.apply(msg, src.IP)
+	if legacy && isLegacyResponse(msg) && !c.outstanding.match(msg, time.Now()) {
+		return
+	}
+	c.cache.reserve(c.memoryUsage().reserved())
+	c.cache.insertFrom(msg, src, !c.askedFor(msg))
+	c.dispatch(&msgAddr{
+		msg:     msg,
+		src:     src,
+		ifIndex: ifIndex,
+	})
+}
+
+// accepts reports whether a response may be attributed to the query: it
+// arrived on an enabled stack and, if the query names an interface, on that
+// interface. Injected records of unknown source are always accepted.
+func (p *QueryParam) accepts(m *msgAddr) bool {
+	if m.src.IP != nil && !p.stacks().allows(m.src.IP) {
+		return false
+	}
+	return p.Interface == nil || m.ifIndex == 0 || m.ifIndex == p.Interface.Index
+}
+
+// acceptsAny reports whether any of the queries accepts a response.
+func acceptsAny(params []QueryParam, m *msgAddr) bool {
+	for i := range params {
+		if params[i].accepts(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// stacks selects the IP stacks used by a query.
+type stacks struct {
+	v4, v6 bool
+}
+
+// allStacks uses every stack the Client has.
+var allStacks = stacks{v4: true, v6: true}
+
+// stacks returns the stacks the query may use.
+func (p *QueryParam) stacks() stacks {
+	return stacks{v4: !p.DisableIPv4, v6: !p.DisableIPv6}
+}
+
+// union returns the stacks used by either s or o.
+func (s stacks) union(o stacks) stacks {
+	return stacks{v4: s.v4 || o.v4, v6: s.v6 || o.v6}
+}
+
+// usable reports whether the Client has any of the stacks.
+func (s stacks) usable(c *Client) bool {
+	cc := c.conns()
+	return (s.v4 && cc.v4) || (s.v6 && cc.v6)
+}
+
+// allows reports whether a message received from ip arrived on one of the
+// stacks.
+func (s stacks) allows(ip net.IP) bool {
+	if ip.To4() != nil {
+		return s.v4
+	}
+	return s.v6
 }
 
 // ensureName is used to ensure the named node is in progress
 func ensureName(inprogress map[string]*ServiceEntry, name string) *ServiceEntry {
-	if inp, ok := inprogress[name]; ok {
+	key := strings.ToLower(name)
+	if inp, ok := inprogress[key]; ok {
 		return inp
 	}
 	inp := &ServiceEntry{
 		Name: name,
 	}
-	inprogress[name] = inp
+	inprogress[key] = inp
 	return inp
 }
-
-// alias is used to setup an alias between two entries
-func alias(inprogress map[string]*ServiceEntry, src, dst string) {
-	srcEntry := ensureName(inprogress, src)
-	inprogress[dst] = srcEntry
-}
@@ -0,0 +1,762 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/loadgen"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestClient_Capabilities(t *testing.T) {
+	client, err := NewClient(true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer client.Close()
+
+	caps := client.Capabilities()
+	if !caps.IPv4 || !caps.IPv4Unicast || !caps.IPv4Multicast {
+		t.Fatalf("IPv4 should be live: %+v", caps)
+	}
+	if caps.IPv6 || caps.IPv6Unicast || caps.IPv6Multicast {
+		t.Fatalf("IPv6 should not be live: %+v", caps)
+	}
+	if caps.Interface != nil {
+		t.Fatalf("default interface should be nil: %+v", caps)
+	}
+}
+
+func TestClient_ConcurrentQueryIDs(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: MultiZone{
+		makeServiceWithServiceName(t, "_tenanta._tcp"),
+		makeServiceWithServiceName(t, "_tenantb._tcp"),
+	}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	client, err := NewClient(true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			entries := make(chan *ServiceEntry, 4)
+			params := &[]QueryParam{{Service: "_tenant" + id + "._tcp", Domain: "local", ID: id}}
+			if err := Query(params, entries, client); err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+			close(entries)
+			found := false
+			for e := range entries {
+				if e.QueryID != id {
+					continue
+				}
+				if e.Name != "hostname._tenant"+id+"._tcp.local." {
+					t.Errorf("bad entry for query %q: %+v", id, e)
+				}
+				found = true
+			}
+			if !found {
+				t.Errorf("no entry for query %q", id)
+			}
+		}(id)
+	}
+	wg.Wait()
+}
+
+func TestServiceQuestion_UnicastBit(t *testing.T) {
+	for _, test := range []struct {
+		par            QueryParam
+		retransmission bool
+		want           bool
+	}{
+		{QueryParam{Service: "_http._tcp"}, false, false},
+		{QueryParam{Service: "_http._tcp", WantUnicastResponse: true}, false, true},
+		{QueryParam{Service: "_http._tcp", WantUnicastResponse: true}, true, false},
+		{QueryParam{Service: "_http._tcp", WantUnicastResponse: true, UnicastRetransmissions: true}, true, true},
+	} {
+		m := serviceQuestion(test.par, test.retransmission)
+		if got := m.Question[0].Qclass&(1<<15) != 0; got != test.want {
+			t.Errorf("%+v (retransmission %v): got QU %v, want %v", test.par, test.retransmission, got, test.want)
+		}
+		if got, want := m.Question[0].Name, "_http._tcp.local."; got != want {
+			t.Errorf("got question %q, want %q", got, want)
+		}
+	}
+}
+
+func TestGroupQuestions(t *testing.T) {
+	params := []QueryParam{
+		{Service: "_http._tcp", Domain: "local"},
+		{Service: "_ipp._tcp", Domain: "local", WantUnicastResponse: true},
+		{Service: "_http._tcp", Domain: "local"},
+		{Service: "_ssh._tcp", Domain: "local", DisableIPv6: true},
+	}
+	groups := groupQuestions(params, false)
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+	if len(groups[0].msgs) != 1 || len(groups[0].names) != 3 {
+		t.Fatalf("bad: %+v", groups[0])
+	}
+	qs := groups[0].msgs[0].Question
+	if len(qs) != 2 || qs[0].Name != "_http._tcp.local." || qs[1].Name != "_ipp._tcp.local." || qs[1].Qclass&(1<<15) == 0 {
+		t.Fatalf("bad questions: %v", qs)
+	}
+	if qs := groups[1].msgs[0].Question; len(qs) != 1 || qs[0].Name != "_ssh._tcp.local." {
+		t.Fatalf("bad questions: %v", qs)
+	}
+
+	// Questions are split across packets once they no longer fit
+	params = nil
+	for i := 0; i < 100; i++ {
+		params = append(params, QueryParam{Service: fmt.Sprintf("_service-number-%03d._tcp", i), Domain: "local"})
+	}
+	groups = groupQuestions(params, false)
+	if len(groups) != 1 || len(groups[0].msgs) < 2 {
+		t.Fatalf("bad: %+v", groups)
+	}
+	n := 0
+	for _, m := range groups[0].msgs {
+		if m.Len() > maxQuestionPacket {
+			t.Fatalf("packet of %d bytes", m.Len())
+		}
+		n += len(m.Question)
+	}
+	if n != 100 {
+		t.Fatalf("got %d questions, want 100", n)
+	}
+}
+
+// avahiResponse returns the response of Avahi to a PTR query that lists
+// several instances, as captured in testdata/quirks: the PTR records in the
+// answer section, followed by the SRV, TXT, and address records of every
+// instance in the additional section.
+func avahiResponse(t *testing.T) *dns.Msg {
+	return readQuirkCapture(t, "testdata/quirks/avahi-ipp-response.hex").msg
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return rr
+}
+
+func TestClient_DispatchSlowQuery(t *testing.T) {
+	c := &Client{cache: newCache(), closedCh: make(chan struct{})}
+	slow, fast := c.subscribe(), c.subscribe()
+	defer c.unsubscribe(slow)
+	defer c.unsubscribe(fast)
+
+	// A query that falls behind keeps the newest messages, and does not hold
+	// up the others
+	const n = 2 * subscriptionQueue
+	for i := 0; i < n; i++ {
+		c.dispatch(&msgAddr{msg: &dns.Msg{Answer: []dns.RR{
+			mustRR(t, fmt.Sprintf("host%d.local. 120 IN A 10.0.0.1", i)),
+		}}})
+		if m := <-fast.ch; m.msg.Answer[0].Header().Name != fmt.Sprintf("host%d.local.", i) {
+			t.Fatalf("got %v at %d", m.msg.Answer[0], i)
+		}
+	}
+	for i := n - subscriptionQueue; i < n; i++ {
+		if m := <-slow.ch; m.msg.Answer[0].Header().Name != fmt.Sprintf("host%d.local.", i) {
+			t.Fatalf("got %v, want host%d", m.msg.Answer[0], i)
+		}
+	}
+	if len(slow.ch) != 0 {
+		t.Fatalf("%d messages left", len(slow.ch))
+	}
+}
+
+func TestClient_MultiInstanceResponse(t *testing.T) {
+	c := &Client{cache: newCache()}
+	resp := &msgAddr{
+		msg: avahiResponse(t),
+		src: &net.UDPAddr{IP: net.ParseIP("fe80::2"), Port: 5353, Zone: "eth0"},
+	}
+	c.cache.insert(resp.msg, resp.src)
+
+	inprogress := make(map[string]*ServiceEntry)
+	services := map[string]*QueryParam{"_ipp._tcp.local.": {Service: "_ipp._tcp", ID: "ipp"}}
+	entries := c.updateEntries(inprogress, services, resp)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2: %v", len(entries), entries)
+	}
+
+	for i, want := range []struct {
+		name, host, ty string
+		port           int
+		ip             string
+	}{
+		{`Printer\ A._ipp._tcp.local.`, "hosta.local.", "ty=Printer A", 631, "192.168.1.11"},
+		{`Printer\ B._ipp._tcp.local.`, "hostb.local.", "ty=Printer B", 632, "192.168.1.12"},
+	} {
+		e := entries[i]
+		if e.Name != want.name || e.Host != want.host || e.Port != want.port || e.QueryID != "ipp" {
+			t.Errorf("bad entry %d: %+v", i, e)
+		}
+		if len(e.InfoFields) != 2 || e.InfoFields[1] != want.ty {
+			t.Errorf("entry %d has the wrong TXT: %v", i, e.InfoFields)
+		}
+		if !e.AddrV4.Equal(net.ParseIP(want.ip)) {
+			t.Errorf("entry %d has the wrong address: %v", i, e.AddrV4)
+		}
+	}
+	if entries[0].AddrV6IPAddr != nil {
+		t.Errorf("hosta has no IPv6 address: %v", entries[0].AddrV6IPAddr)
+	}
+	if got := entries[1].AddrV6IPAddr; got == nil || got.Zone != "eth0" {
+		t.Errorf("hostb should have a zoned link-local address: %v", got)
+	}
+}
+
+func TestClient_ReceivedOn(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil || len(ifaces) == 0 {
+		t.Skip("no interfaces")
+	}
+	ifi := ifaces[0]
+	c := &Client{cache: newCache()}
+	services := map[string]*QueryParam{"_ipp._tcp.local.": {Service: "_ipp._tcp"}}
+	for _, tc := range []struct {
+		ifIndex int
+		want    string
+	}{
+		{ifi.Index, ifi.Name},
+		{0, ""}, // not reported by the transport
+	} {
+		resp := &msgAddr{
+			msg:     avahiResponse(t),
+			src:     &net.UDPAddr{IP: net.ParseIP("192.168.1.11"), Port: 5353},
+			ifIndex: tc.ifIndex,
+		}
+		c.cache.insert(resp.msg, resp.src)
+		entries := c.updateEntries(make(map[string]*ServiceEntry), services, resp)
+		if len(entries) != 2 {
+			t.Fatalf("got %d entries, want 2: %v", len(entries), entries)
+		}
+		for _, e := range entries {
+			if e.ReceivedOn != tc.want {
+				t.Fatalf("got %q, want %q", e.ReceivedOn, tc.want)
+			}
+		}
+	}
+
+	// Names are looked up once, and replaced when the interface watch lists
+	// the interfaces
+	c.ifaceNames.reset(map[int]interfaceState{ifi.Index: {ifi: net.Interface{Index: ifi.Index, Name: "renamed0"}}})
+	if name := c.ifaceNames.name(ifi.Index); name != "renamed0" {
+		t.Fatalf("got %q, want the listed name", name)
+	}
+}
+
+// ptrZone answers PTR questions with a single PTR record and nothing else.
+type ptrZone struct{ ptr string }
+
+func (z ptrZone) Records(q dns.Question) []dns.RR {
+	if q.Qtype != dns.TypePTR {
+		return nil
+	}
+	return []dns.RR{&dns.PTR{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120},
+		Ptr: z.ptr,
+	}}
+}
+
+func TestClient_ResolveTimeout(t *testing.T) {
+	c := &Client{
+		log:      log.Default(),
+		cache:    newCache(),
+		use_ipv4: true,
+	}
+	c.AddLocalZone(ptrZone{ptr: "hostname._foobar._tcp.local."})
+
+	params := []QueryParam{{
+		Service:        "_foobar._tcp",
+		Domain:         "local",
+		Timeout:        time.Second,
+		ResolveTimeout: 200 * time.Millisecond,
+	}}
+	entries := make(chan *ServiceEntry, 1)
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := c.query(context.Background(), &params, entries); err != nil {
+			t.Errorf("err: %v", err)
+		}
+	}()
+	defer func() { <-done }()
+
+	select {
+	case e := <-entries:
+		if elapsed := time.Since(start); elapsed < params[0].ResolveTimeout {
+			t.Fatalf("incomplete entry sent after %v", elapsed)
+		}
+		if e.Name != "hostname._foobar._tcp.local." || e.Port != 0 {
+			t.Fatalf("bad entry: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("entry was not sent when its resolve timeout expired")
+	}
+}
+
+func TestClient_DisableStacks(t *testing.T) {
+	c := &Client{
+		log:      log.Default(),
+		cache:    newCache(),
+		use_ipv4: true,
+	}
+	params := []QueryParam{{Service: "_foobar._tcp", Domain: "local", DisableIPv4: true}}
+	if err := c.query(context.Background(), &params, make(chan *ServiceEntry)); err == nil {
+		t.Fatalf("query without a usable stack should fail")
+	}
+
+	v4 := net.ParseIP("192.168.1.11")
+	v6 := net.ParseIP("fe80::2")
+	only4 := (&QueryParam{DisableIPv6: true}).stacks()
+	only6 := (&QueryParam{DisableIPv4: true}).stacks()
+	if !only4.allows(v4) || only4.allows(v6) || only6.allows(v4) || !only6.allows(v6) {
+		t.Fatalf("bad stack filtering")
+	}
+	if via := only4.union(only6); !via.allows(v4) || !via.allows(v6) {
+		t.Fatalf("bad union: %+v", via)
+	}
+
+	// A v4-only query ignores PTR records that arrived over IPv6
+	c.cache.insert(avahiResponse(t), &net.UDPAddr{IP: v6, Port: 5353})
+	inprogress := make(map[string]*ServiceEntry)
+	services := map[string]*QueryParam{"_ipp._tcp.local.": {Service: "_ipp._tcp", ID: "ipp", DisableIPv6: true}}
+	for _, e := range c.updateEntries(inprogress, services, &msgAddr{msg: avahiResponse(t), src: &net.UDPAddr{IP: v6, Port: 5353}}) {
+		if e.QueryID == "ipp" {
+			t.Fatalf("entry claimed over a disabled stack: %+v", e)
+		}
+	}
+}
+
+func TestQueryParam_Interface(t *testing.T) {
+	src := &net.UDPAddr{IP: net.ParseIP("192.168.1.11"), Port: 5353}
+	par := &QueryParam{Interface: &net.Interface{Index: 3, Name: "vlan10"}}
+	if !par.accepts(&msgAddr{src: src, ifIndex: 3}) {
+		t.Fatalf("response on the query's interface was rejected")
+	}
+	if par.accepts(&msgAddr{src: src, ifIndex: 4}) {
+		t.Fatalf("response on another interface was accepted")
+	}
+	if !par.accepts(&msgAddr{src: src}) {
+		t.Fatalf("response on an unknown interface was rejected")
+	}
+}
+
+func TestClient_QueryInterface(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var iface *net.Interface
+	for i := range ifaces {
+		if eligibleInterface(&ifaces[i]) {
+			iface = &ifaces[i]
+			break
+		}
+	}
+	if iface == nil {
+		t.Skip("no multicast interface")
+	}
+
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_iface._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	client, err := NewClient(true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer client.Close()
+
+	entries := make(chan *ServiceEntry, 1)
+	params := &[]QueryParam{{Service: "_iface._tcp", Interface: iface}}
+	if err := Query(params, entries, client); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case e := <-entries:
+		if e.Name != "hostname._iface._tcp.local." {
+			t.Fatalf("bad: %+v", e)
+		}
+	default:
+		t.Fatalf("service was not discovered on %s", iface.Name)
+	}
+}
+
+func BenchmarkClient_Load(b *testing.B) {
+	gen := loadgen.New(loadgen.Config{
+		Devices:      1000,
+		ServiceTypes: 5,
+		ChurnRate:    50,
+	})
+	c := &Client{cache: newCache(), closedCh: make(chan struct{})}
+	src := &net.UDPAddr{IP: net.IPv4(198, 18, 0, 1), Port: 5353}
+
+	now := time.Now()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		now = now.Add(time.Second)
+		for _, msg := range gen.Step(now) {
+			c.handleMsg(msg, src, 0)
+		}
+	}
+	b.ReportMetric(float64(c.CacheStats().Records), "records")
+}
+
+func TestClient_QueryTimeouts(t *testing.T) {
+	c := &Client{
+		log:      log.Default(),
+		cache:    newCache(),
+		use_ipv4: true,
+	}
+	c.AddLocalZone(MultiZone{
+		ptrZone{ptr: "fast._fast._tcp.local."},
+		ptrZone{ptr: "slow._slow._tcp.local."},
+	})
+
+	// The slow query keeps the lookup going after the fast one is done. The
+	// zones only give PTR records, so entries are sent as discovered
+	always := func(*ServiceEntry) bool { return true }
+	params := []QueryParam{
+		{Service: "_fast._tcp", Domain: "local", Timeout: 50 * time.Millisecond, Complete: always},
+		{Service: "_slow._tcp", Domain: "local", Timeout: 300 * time.Millisecond, Complete: always},
+	}
+	entries := make(chan *ServiceEntry, 4)
+	start := time.Now()
+	if err := c.query(context.Background(), &params, entries); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("lookup took %v, want the longest timeout", elapsed)
+	}
+	if len(entries) == 0 {
+		t.Fatalf("no entries")
+	}
+
+	params = []QueryParam{{Service: "_fast._tcp", Domain: "local", Timeout: 50 * time.Millisecond}}
+	start = time.Now()
+	if err := c.query(context.Background(), &params, entries); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("lookup took %v, want 50ms", elapsed)
+	}
+}
+
+func TestClient_QueryCancel(t *testing.T) {
+	c := &Client{
+		log:      log.Default(),
+		cache:    newCache(),
+		use_ipv4: true,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	params := []QueryParam{{Service: "_foobar._tcp", Domain: "local", Timeout: time.Minute}}
+	start := time.Now()
+	if err := c.query(ctx, &params, make(chan *ServiceEntry)); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("query stopped after %v", elapsed)
+	}
+
+	// Nothing is sent with a cancelled context
+	if err := c.query(ctx, &params, make(chan *ServiceEntry)); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestClient_IPv6Only(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{
+		Zone:      makeServiceWithServiceName(t, "_ipv6._tcp"),
+		Transport: network.Host(net.ParseIP("fd00::1")),
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	c, err := NewClientTransport(network.Host(net.ParseIP("fd00::2")), false, true, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	entries := make(chan *ServiceEntry, 1)
+	params := &[]QueryParam{{Service: "_ipv6._tcp", Timeout: 100 * time.Millisecond}}
+	if err := Query(params, entries, c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case e := <-entries:
+		if e.Name != "hostname._ipv6._tcp.local." || !e.SrcIP.Equal(net.ParseIP("fd00::1")) {
+			t.Fatalf("bad: %+v", e)
+		}
+	default:
+		t.Fatalf("IPv6 responder was not discovered")
+	}
+}
+
+func TestWithZone(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil || len(ifaces) == 0 {
+		t.Skip("no interfaces")
+	}
+	ifi := ifaces[0]
+
+	addr := withZone(&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 5353}, ifi.Index)
+	if addr.Zone != ifi.Name {
+		t.Fatalf("got zone %q, want %q", addr.Zone, ifi.Name)
+	}
+	for _, a := range []*net.UDPAddr{
+		{IP: net.ParseIP("fe80::1"), Port: 5353, Zone: "other"},
+		{IP: net.ParseIP("fd00::1"), Port: 5353},
+		{IP: net.ParseIP("169.254.0.1"), Port: 5353},
+	} {
+		if got := withZone(a, ifi.Index); got.Zone != a.Zone {
+			t.Fatalf("%v: zone changed to %q", a, got.Zone)
+		}
+	}
+}
+
+func TestClient_Domain(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	service, err := NewMDNSService("hostname", "_http._tcp", "site.example.", "testhost.site.example.", 80,
+		[]net.IP{net.IPv4(10, 0, 0, 1)}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: service, Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	entries := make(chan *ServiceEntry, 4)
+	params := &[]QueryParam{
+		{Service: "_http._tcp", Domain: "Site.Example.", Timeout: 100 * time.Millisecond},
+		{Service: "_http._tcp", Timeout: 100 * time.Millisecond},
+	}
+	if err := Query(params, entries, c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	if e := <-entries; e.Name != "hostname._http._tcp.site.example." || e.Port != 80 || !e.AddrV4.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Fatalf("bad: %+v", e)
+	}
+}
+
+// subtypeZone publishes a service along with one of its subtypes.
+type subtypeZone struct {
+	*MDNSService
+	subtype string
+}
+
+func (z subtypeZone) Records(q dns.Question) []dns.RR {
+	if !strings.EqualFold(q.Name, z.subtype) {
+		return z.MDNSService.Records(q)
+	}
+	ptr := &dns.PTR{
+		Hdr: dns.RR_Header{Name: z.subtype, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: defaultTTL},
+		Ptr: z.instanceAddr,
+	}
+	return append([]dns.RR{ptr}, z.MDNSService.Records(dns.Question{Name: z.instanceAddr, Qtype: dns.TypeANY})...)
+}
+
+func TestClient_Subtype(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	zone := subtypeZone{makeService(t), ServiceName(SubtypeName("_printer", "_http._tcp"), "")}
+	serv, err := NewServer(&Config{Zone: zone, Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	// The instances of the subtype are named after the parent service
+	entries := make(chan *ServiceEntry, 4)
+	params := &[]QueryParam{
+		{Service: "_printer._sub._http._tcp", Timeout: 100 * time.Millisecond},
+		{Service: "_scanner._sub._http._tcp", Timeout: 50 * time.Millisecond},
+	}
+	if err := Query(params, entries, c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	if e := <-entries; e.Name != "hostname._http._tcp.local." || e.Port != 80 {
+		t.Fatalf("bad: %+v", e)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events := make(chan BrowseEvent, 4)
+	go c.Browse(ctx, "_printer._sub._http._tcp", events)
+	select {
+	case ev := <-events:
+		if ev.Type != BrowseAdded || ev.Entry.Name != "hostname._http._tcp.local." {
+			t.Fatalf("bad: %v %+v", ev.Type, ev.Entry)
+		}
+	case <-ctx.Done():
+		t.Fatalf("subtype instance not browsed")
+	}
+}
+
+func TestClient_GraceWindow(t *testing.T) {
+	for _, grace := range []time.Duration{0, 2 * time.Second} {
+		network := memnet.New(memnet.Config{Latency: memnet.Fixed(150 * time.Millisecond)})
+		serv, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer serv.Shutdown()
+		c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer c.Close()
+
+		// The answers arrive after the query returned
+		entries := make(chan *ServiceEntry, 4)
+		params := &[]QueryParam{{Service: "_http._tcp", Timeout: 50 * time.Millisecond, GraceWindow: grace}}
+		if err := Query(params, entries, c); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("late entry sent")
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		var srvs []cacheRecord
+		for len(srvs) == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			srvs = c.cache.lookup("hostname._http._tcp.local.", dns.TypeSRV)
+		}
+		if len(srvs) != 1 {
+			t.Fatalf("late answer not cached")
+		}
+		if passive := grace == 0; (srvs[0].passive != nil) != passive {
+			t.Fatalf("grace %v: passive is %v", grace, !passive)
+		}
+	}
+}
+
+func TestClient_QueryFilter(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	service := func(instance, path string) *MDNSService {
+		s, err := NewMDNSService(instance, "_http._tcp", "local.", instance+".local.", 80, []net.IP{net.ParseIP("10.0.0.1")}, []string{"path=" + path})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return s
+	}
+	serv, err := NewServer(&Config{Zone: MultiZone{service("a", "/a"), service("b", "/b")}, Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	var offered int
+	filter := func(e *ServiceEntry) bool {
+		offered++
+		return e.TXTMap()["path"] == "/b"
+	}
+	for _, resolve := range []time.Duration{0, 50 * time.Millisecond} {
+		entries := make(chan *ServiceEntry, 4)
+		params := &[]QueryParam{{Service: "_http._tcp", Timeout: 200 * time.Millisecond, ResolveTimeout: resolve, Filter: filter}}
+		if err := Query(params, entries, c); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("resolve %v: got %d entries", resolve, len(entries))
+		}
+		if e := <-entries; e.Name != "b._http._tcp.local." {
+			t.Fatalf("bad: %+v", e)
+		}
+	}
+	if offered < 4 {
+		t.Fatalf("filter called %d times", offered)
+	}
+}
+
+func TestClient_QueryUnrelatedAnswers(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	// The answers to a query for _http._tcp reach the one for _ipp._tcp
+	// sharing the Client, which must not report them
+	done := make(chan error, 1)
+	go func() {
+		entries := make(chan *ServiceEntry, 4)
+		done <- Query(&[]QueryParam{{Service: "_http._tcp", Timeout: 200 * time.Millisecond}}, entries, c)
+	}()
+	entries := make(chan *ServiceEntry, 4)
+	if err := Query(&[]QueryParam{{Service: "_ipp._tcp", Timeout: 300 * time.Millisecond}}, entries, c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("bad: %+v", <-entries)
+	}
+}
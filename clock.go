@@ -0,0 +1,77 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import "time"
+
+// clock tells the time and runs the timers of the protocol: the lifetimes of
+// cached records, the retransmissions and timeouts of queries, and the probes
+// and announcements of registered services. Clients and Servers use the
+// system clock; tests substitute one they move by hand.
+type clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) timer
+}
+
+// timer is a timer of a clock, which behaves like a time.Timer.
+type timer interface {
+	Chan() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// systemClock is the clock of the system.
+type systemClock struct{}
+
+// Now returns time.Now.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer returns a time.Timer.
+func (systemClock) NewTimer(d time.Duration) timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+// systemTimer is a time.Timer.
+type systemTimer struct {
+	*time.Timer
+}
+
+// Chan returns the channel of the timer.
+func (t systemTimer) Chan() <-chan time.Time {
+	return t.C
+}
+
+// clk returns the clock of the Client, the system clock unless it was
+// created with another.
+func (c *Client) clk() clock {
+	if c.clock == nil {
+		return systemClock{}
+	}
+	return c.clock
+}
+
+// clk returns the clock of the Server, that of its Config if set and the
+// system clock otherwise.
+func (s *Server) clk() clock {
+	if s.clock == nil {
+		return systemClock{}
+	}
+	return s.clock
+}
+
+// after returns a channel receiving the time of clk once d passed, like
+// time.After.
+func after(clk clock, d time.Duration) <-chan time.Time {
+	return clk.NewTimer(d).Chan()
+}
+
+// resetAt sets a timer to fire at t, or stops it if t is zero.
+func resetAt(clk clock, tm timer, t time.Time) {
+	tm.Stop()
+	if !t.IsZero() {
+		tm.Reset(t.Sub(clk.Now()))
+	}
+}
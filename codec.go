@@ -0,0 +1,61 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"github.com/miekg/dns"
+)
+
+// Codec converts DNS messages to and from their wire format. Clients and
+// Servers use DefaultCodec unless another is configured, which allows a
+// stricter or faster parser, or instrumentation, to be swapped in.
+type Codec interface {
+	// Pack returns the wire format of msg.
+	Pack(msg *dns.Msg) ([]byte, error)
+
+	// Unpack parses a received packet. The buffer is reused once Unpack
+	// returns, so the message must not refer to it.
+	Unpack(buf []byte) (*dns.Msg, error)
+}
+
+// DefaultCodec packs and unpacks messages with github.com/miekg/dns,
+// unpacking them with ParseMessage.
+var DefaultCodec Codec = dnsCodec{}
+
+// dnsCodec is the Codec implemented by github.com/miekg/dns.
+type dnsCodec struct{}
+
+func (dnsCodec) Pack(msg *dns.Msg) ([]byte, error) {
+	return msg.Pack()
+}
+
+func (dnsCodec) Unpack(buf []byte) (*dns.Msg, error) {
+	return ParseMessage(buf)
+}
+
+// SetCodec sets the Codec used to pack queries and unpack received packets.
+// A nil codec restores DefaultCodec.
+func (c *Client) SetCodec(codec Codec) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.codec = codec
+}
+
+// getCodec returns the Codec the Client is using.
+func (c *Client) getCodec() Codec {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.codec == nil {
+		return DefaultCodec
+	}
+	return c.codec
+}
+
+// codec returns the Codec the Server is configured with.
+func (s *Server) codec() Codec {
+	if s.config.Codec == nil {
+		return DefaultCodec
+	}
+	return s.config.Codec
+}
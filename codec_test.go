@@ -0,0 +1,65 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// countingCodec counts the messages passing through DefaultCodec.
+type countingCodec struct {
+	packed, unpacked int32
+}
+
+func (cc *countingCodec) Pack(msg *dns.Msg) ([]byte, error) {
+	atomic.AddInt32(&cc.packed, 1)
+	return DefaultCodec.Pack(msg)
+}
+
+func (cc *countingCodec) Unpack(buf []byte) (*dns.Msg, error) {
+	atomic.AddInt32(&cc.unpacked, 1)
+	return DefaultCodec.Unpack(buf)
+}
+
+func TestClient_Codec(t *testing.T) {
+	cc := &countingCodec{}
+	c := &Client{}
+	c.SetCodec(cc)
+
+	if err := c.sendQuery(context.Background(), serviceQuestion(*DefaultParams("_foobar._tcp"), false), allStacks, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := atomic.LoadInt32(&cc.packed); n != 1 {
+		t.Fatalf("packed %d messages, want 1", n)
+	}
+
+	c.SetCodec(nil)
+	if c.getCodec() != DefaultCodec {
+		t.Fatalf("nil codec should restore the default")
+	}
+}
+
+func TestServer_Codec(t *testing.T) {
+	cc := &countingCodec{}
+	s := &Server{
+		config: &Config{Codec: cc},
+		errLog: newLogSampler(log.Default(), logSampleEvery, logSampleInterval),
+	}
+
+	buf, err := makeResponse(t, makeService(t)).Pack()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := s.parsePacket(buf, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := atomic.LoadInt32(&cc.unpacked); n != 1 {
+		t.Fatalf("unpacked %d messages, want 1", n)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+// Package compat restores the API of the upstream hashicorp/mdns package on
+// top of this one, so that programs written against it can switch by changing
+// their import path, and move to the new APIs at their own pace:
+//
+//	import mdns "github.com/sloweclair/mdns/compat"
+//
+// Each query opens its own Client and closes it when done, as upstream does.
+package compat
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"github.com/sloweclair/mdns"
+)
+
+// The types shared with upstream.
+type (
+	Config       = mdns.Config
+	MDNSService  = mdns.MDNSService
+	QueryParam   = mdns.QueryParam
+	Server       = mdns.Server
+	ServiceEntry = mdns.ServiceEntry
+	Zone         = mdns.Zone
+)
+
+// NewServer is used to create a new mDNS server from a config.
+func NewServer(config *Config) (*Server, error) {
+	return mdns.NewServer(config)
+}
+
+// NewMDNSService returns a new instance of MDNSService.
+func NewMDNSService(instance, service, domain, hostName string, port int, ips []net.IP, txt []string) (*MDNSService, error) {
+	return mdns.NewMDNSService(instance, service, domain, hostName, port, ips, txt)
+}
+
+// DefaultParams is used to return a default set of QueryParam's.
+func DefaultParams(service string) *QueryParam {
+	return mdns.DefaultParams(service)
+}
+
+// Query looks up a given service, in a domain, waiting at most for a timeout
+// before finishing the query. The results are streamed to params.Entries.
+// Sends will not block, so clients should make sure to either read or buffer.
+func Query(params *QueryParam) error {
+	return QueryContext(context.Background(), params)
+}
+
+// QueryContext is like Query, but the query stops when the context ends.
+func QueryContext(ctx context.Context, params *QueryParam) error {
+	logger := params.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	client, err := mdns.NewClient(!params.DisableIPv4, !params.DisableIPv6, logger, params.Interface)
+	if client != nil {
+		defer client.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return mdns.QueryContext(ctx, &[]QueryParam{*params}, params.Entries, client)
+}
+
+// Lookup is the same as Query, however it uses all the default parameters.
+func Lookup(service string, entries chan<- *ServiceEntry) error {
+	params := DefaultParams(service)
+	params.Entries = entries
+	return Query(params)
+}
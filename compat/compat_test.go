@@ -0,0 +1,34 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package compat
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	service, err := NewMDNSService("hostname", "_compat._tcp", "", "testhost.", 80, []net.IP{net.IPv4(192, 168, 0, 42)}, []string{"compat"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: service})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	entries := make(chan *ServiceEntry, 4)
+	if err := Lookup("_compat._tcp", entries); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case e := <-entries:
+		if e.Name != "hostname._compat._tcp.local." || e.Port != 80 {
+			t.Fatalf("bad: %+v", e)
+		}
+	default:
+		t.Fatalf("service was not discovered")
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+// Completion selects the records a service instance must have resolved before
+// a query sends its entry, see QueryParam.Require.
+type Completion uint8
+
+const (
+	// RequireSRV waits for the SRV record, which gives the host and port.
+	RequireSRV Completion = 1 << iota
+
+	// RequireTXT waits for the TXT record.
+	RequireTXT
+
+	// RequireAddress waits for an IPv4 or IPv6 address of the host.
+	RequireAddress
+
+	// RequireResolved waits for what is needed to connect to the instance:
+	// its SRV record and an address of its host, but not its TXT record,
+	// which many services leave empty.
+	RequireResolved = RequireSRV | RequireAddress
+
+	// RequireAll waits for every record of the instance.
+	RequireAll = RequireSRV | RequireTXT | RequireAddress
+)
+
+// satisfied reports whether the entry has resolved the required records.
+func (c Completion) satisfied(e *ServiceEntry) bool {
+	if c&RequireSRV != 0 && e.Port == 0 {
+		return false
+	}
+	// Records the owner denied having, with NSEC, will never come
+	if c&RequireTXT != 0 && !e.hasTXT && !e.noTXT {
+		return false
+	}
+	if c&RequireAddress != 0 && e.AddrV4 == nil && e.AddrV6 == nil && e.Addr == nil && !e.noAddrs {
+		return false
+	}
+	return true
+}
+
+// completion returns the predicate deciding whether the query's entries are
+// complete.
+func (p *QueryParam) completion() func(*ServiceEntry) bool {
+	if p.Complete != nil {
+		return p.Complete
+	}
+	require := p.Require
+	if require == 0 {
+		require = RequireResolved
+	}
+	return require.satisfied
+}
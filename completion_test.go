@@ -0,0 +1,58 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCompletion_Satisfied(t *testing.T) {
+	srv := &ServiceEntry{Port: 80}
+	txt := &ServiceEntry{hasTXT: true}
+	full := &ServiceEntry{Port: 80, hasTXT: true, AddrV4: net.IPv4(192, 0, 2, 1)}
+
+	for _, test := range []struct {
+		require Completion
+		entry   *ServiceEntry
+		want    bool
+	}{
+		{0, &ServiceEntry{}, true},
+		{RequireSRV, srv, true},
+		{RequireSRV, txt, false},
+		{RequireTXT, txt, true},
+		{RequireSRV | RequireTXT, srv, false},
+		{RequireAddress, srv, false},
+		{RequireResolved, &ServiceEntry{Port: 80, AddrV6: net.IPv6loopback}, true},
+		{RequireResolved, srv, false},
+		{RequireAll, full, true},
+		{RequireAll, srv, false},
+	} {
+		if got := test.require.satisfied(test.entry); got != test.want {
+			t.Fatalf("%b satisfied by %+v: got %v, want %v", test.require, test.entry, got, test.want)
+		}
+	}
+}
+
+func TestQueryParam_Completion(t *testing.T) {
+	// By default the SRV record and an address are required, not the TXT
+	par := &QueryParam{ResolveTimeout: time.Second}
+	if complete := par.completion(); complete(&ServiceEntry{Port: 80}) {
+		t.Fatalf("entry without an address should be incomplete")
+	}
+	if complete := par.completion(); !complete(&ServiceEntry{Port: 80, AddrV4: net.IPv4(192, 0, 2, 1)}) {
+		t.Fatalf("entry with SRV and address should be complete")
+	}
+	par.Require = RequireSRV
+	if complete := par.completion(); !complete(&ServiceEntry{Port: 80}) {
+		t.Fatalf("entry with SRV should be complete")
+	}
+
+	// A custom predicate takes precedence
+	par.Complete = func(e *ServiceEntry) bool { return e.Host == "printer.local." }
+	if complete := par.completion(); complete(&ServiceEntry{Port: 80}) || !complete(&ServiceEntry{Host: "printer.local."}) {
+		t.Fatalf("custom predicate was not used")
+	}
+}
@@ -0,0 +1,678 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultComplianceWait is how long each compliance probe waits for
+	// responses by default.
+	defaultComplianceWait = time.Second
+
+	// minTruncatedDelay and maxTruncatedDelay bound the delay before a
+	// responder answers a query with the TC bit set, as per 7.2 in RFC.
+	minTruncatedDelay = 400 * time.Millisecond
+	maxTruncatedDelay = 500 * time.Millisecond
+
+	// maxLegacyTTL is the longest TTL a responder should give in a response
+	// to a legacy unicast query, as per 6.7 in RFC.
+	maxLegacyTTL = 10
+)
+
+// ComplianceTarget identifies the responder CheckCompliance probes: by its
+// address, by one of its service instances, or both. Given only an address,
+// the first instance it advertises is probed; given only an instance, the
+// responder is the one answering for it.
+type ComplianceTarget struct {
+	Addr     net.IP // Address of the responder
+	Instance string // Instance name, e.g. "My Printer._ipp._tcp.local."
+
+	// Wait is how long each probe waits for the responder's answers,
+	// default 1 second. Probes of delayed answers wait half a second more.
+	Wait time.Duration
+}
+
+// ComplianceResult is the outcome of a compliance check.
+type ComplianceResult int
+
+const (
+	// CompliancePass means the responder behaved as the RFC says it must
+	// or should.
+	CompliancePass ComplianceResult = iota
+
+	// ComplianceFail means the responder did not.
+	ComplianceFail
+
+	// ComplianceInconclusive means the check could not tell, usually because
+	// an earlier probe found nothing to build on.
+	ComplianceInconclusive
+)
+
+// String returns the name of the result.
+func (r ComplianceResult) String() string {
+	switch r {
+	case CompliancePass:
+		return "pass"
+	case ComplianceFail:
+		return "fail"
+	case ComplianceInconclusive:
+		return "inconclusive"
+	}
+	return fmt.Sprintf("ComplianceResult(%d)", int(r))
+}
+
+// MarshalText encodes the result as its name, so that reports encode
+// readably as JSON.
+func (r ComplianceResult) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// ComplianceCheck is the outcome of one check of a ComplianceReport.
+type ComplianceCheck struct {
+	Name   string           `json:"name"` // e.g. "known-answer-suppression"
+	Spec   string           `json:"spec"` // Section of RFC 6762 checked
+	Result ComplianceResult `json:"result"`
+	Detail string           `json:"detail,omitempty"` // What was observed, for failures
+}
+
+// ComplianceReport describes how a responder conforms to RFC 6762, check by
+// check, see Client.CheckCompliance.
+type ComplianceReport struct {
+	Addr     net.IP            `json:"addr"`
+	Instance string            `json:"instance"`
+	Started  time.Time         `json:"started"`
+	Duration time.Duration     `json:"duration"`
+	Checks   []ComplianceCheck `json:"checks"`
+}
+
+// Passed reports whether no check failed.
+func (r *ComplianceReport) Passed() bool {
+	for _, check := range r.Checks {
+		if check.Result == ComplianceFail {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the report as a table, one check per line.
+func (r *ComplianceReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s)\n", r.Instance, r.Addr)
+	for _, check := range r.Checks {
+		fmt.Fprintf(&b, "  %-12s %-26s %-10s %s\n", check.Result, check.Name, check.Spec, check.Detail)
+	}
+	return b.String()
+}
+
+// CheckCompliance probes a responder with queries exercising the parts of
+// RFC 6762 devices most often get wrong, and reports how it answered:
+//
+//   - multicast-response: it answers a query for its service (section 6)
+//   - response-header: its responses are authoritative, with zero opcode
+//     and rcode (section 18)
+//   - unicast-question: it answers a question asking for a unicast
+//     response (section 5.4)
+//   - known-answer-suppression: it leaves out the answers the query already
+//     lists (section 7.1)
+//   - negative-response: it answers a question for a type its instance does
+//     not have with an NSEC record (section 6.1)
+//   - truncated-query: it waits at least 400ms before answering a query
+//     whose known answers continue in another packet (section 7.2)
+//   - legacy-unicast: it answers a query from a port other than 5353 with
+//     the query's ID and question, short TTLs, and no cache-flush bits
+//     (section 6.7)
+//
+// The probes are sent one after the other, each waiting for answers, so the
+// report takes several seconds. Except for that of legacy-unicast, they are
+// sent from port 5353 through the Client's Transport, as responders treat
+// queries from other ports as legacy queries; the checks are inconclusive if
+// the port cannot be bound. Other responders are ignored. An error is
+// returned if the target cannot be found; failed checks are not errors.
+func (c *Client) CheckCompliance(ctx context.Context, target ComplianceTarget) (*ComplianceReport, error) {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return nil, ErrClientClosed
+	}
+	p := &prober{c: c, wait: target.Wait, addr: net.IPAddr{IP: target.Addr}, instance: target.Instance, senders: make(map[string]net.PacketConn)}
+	if p.wait <= 0 {
+		p.wait = defaultComplianceWait
+	}
+	defer p.close()
+	report := &ComplianceReport{Started: time.Now()}
+	if err := p.find(ctx); err != nil {
+		return nil, err
+	}
+	report.Addr, report.Instance = p.addr.IP, p.instance
+
+	for _, check := range []func(context.Context) ComplianceCheck{
+		p.checkMulticastResponse,
+		p.checkResponseHeader,
+		p.checkUnicastQuestion,
+		p.checkKnownAnswers,
+		p.checkNegativeResponse,
+		p.checkTruncatedQuery,
+		p.checkLegacyUnicast,
+	} {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.Checks = append(report.Checks, check(ctx))
+	}
+	report.Duration = time.Since(report.Started)
+	return report, nil
+}
+
+// prober runs the probes of CheckCompliance against one responder.
+type prober struct {
+	c        *Client
+	wait     time.Duration
+	addr     net.IPAddr
+	instance string
+
+	// ptr is the responder's PTR record for the instance, and responses are
+	// those to the first probe, both set by checkMulticastResponse.
+	ptr       *dns.PTR
+	responses []*dns.Msg
+
+	// senders are the sockets bound to port 5353 the probes are sent from,
+	// by network, opened as needed.
+	senders map[string]net.PacketConn
+}
+
+// probeResponse is a response from the probed responder.
+type probeResponse struct {
+	msg *dns.Msg
+	src *net.UDPAddr
+	at  time.Duration // since the query was sent
+}
+
+// service returns the name of the probed instance's service.
+func (p *prober) service() string {
+	return instanceService(p.instance)
+}
+
+// via returns the stacks the responder is reached on.
+func (p *prober) via() stacks {
+	if p.addr.IP == nil {
+		return allStacks
+	}
+	if p.addr.IP.To4() != nil {
+		return stacks{v4: true}
+	}
+	return stacks{v6: true}
+}
+
+// from reports whether a message was sent by the responder, or by any
+// responder while it is not known yet.
+func (p *prober) from(src *net.UDPAddr) bool {
+	return p.addr.IP == nil || (src != nil && src.IP.Equal(p.addr.IP))
+}
+
+// find completes the target: the responder answering for the instance, or
+// an instance advertised by the responder.
+func (p *prober) find(ctx context.Context) error {
+	switch {
+	case p.instance != "":
+		p.instance = Fqdn(p.instance)
+		if instanceService(p.instance) == "" {
+			return fmt.Errorf("mdns: %q is not a service instance name", p.instance)
+		}
+		for _, r := range p.discover(ctx, question(p.instance, dns.TypeSRV, false)) {
+			if hasRecord(r.msg, p.instance, dns.TypeSRV) {
+				p.addr = net.IPAddr{IP: r.src.IP, Zone: r.src.Zone}
+				return nil
+			}
+		}
+		return fmt.Errorf("mdns: no responder answered for %s", p.instance)
+
+	case p.addr.IP != nil:
+		var services []string
+		enum := ServiceName("_services._dns-sd._udp", "")
+		for _, r := range p.discover(ctx, question(enum, dns.TypePTR, false)) {
+			services = append(services, ptrTargets(r.msg, enum)...)
+		}
+		for _, service := range services {
+			for _, r := range p.discover(ctx, question(service, dns.TypePTR, false)) {
+				if instances := ptrTargets(r.msg, service); len(instances) > 0 {
+					p.instance = instances[0]
+					p.addr.Zone = r.src.Zone
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("mdns: %s advertises no service instance", p.addr.IP)
+	}
+	return fmt.Errorf("mdns: compliance target has neither address nor instance")
+}
+
+// discover sends a query finding the target and returns the responder's
+// responses. It is sent from port 5353 where the responder's stack is known
+// and the port can be bound, and through the Client otherwise, as finding the
+// target does not depend on how it answers.
+func (p *prober) discover(ctx context.Context, q *dns.Msg) []probeResponse {
+	if p.addr.IP != nil {
+		if out, err := p.multicast(ctx, q, p.wait); err == nil {
+			return out
+		}
+	}
+	sub := p.c.subscribe()
+	defer p.c.unsubscribe(sub)
+	start := time.Now()
+	if err := p.c.sendQuery(ctx, q, p.via(), nil); err != nil {
+		p.c.log.Printf("[ERR] mdns: Failed to send compliance probe: %v", err)
+		return nil
+	}
+	return p.collect(ctx, sub, start, p.wait, nil)
+}
+
+// multicast sends a query to the multicast group from port 5353, and returns
+// the responder's responses received within wait, by multicast or by
+// unicast to that port.
+func (p *prober) multicast(ctx context.Context, q *dns.Msg, wait time.Duration) ([]probeResponse, error) {
+	network, group := p.network()
+	conn, err := p.sender(network)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := p.c.getCodec().Pack(q)
+	if err != nil {
+		return nil, err
+	}
+	sub := p.c.subscribe()
+	defer p.c.unsubscribe(sub)
+	start := time.Now()
+	if _, err := conn.WriteTo(buf, group); err != nil {
+		return nil, err
+	}
+	unicast := make(chan []probeResponse, 1)
+	go func() {
+		unicast <- p.receive(conn, start, p.deadline(ctx, start, wait))
+	}()
+	return p.collect(ctx, sub, start, wait, unicast), nil
+}
+
+// collect returns the responder's responses dispatched to sub within wait of
+// start, along with those received by unicast, if any, in the order they
+// arrived. Responses received both ways, as where several sockets share
+// port 5353, are only counted once.
+func (p *prober) collect(ctx context.Context, sub *subscription, start time.Time, wait time.Duration, unicast <-chan []probeResponse) []probeResponse {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	var out []probeResponse
+	seen := make(map[string]bool)
+	add := func(r probeResponse) {
+		if key := r.src.String() + r.msg.String(); !seen[key] {
+			seen[key] = true
+			out = append(out, r)
+		}
+	}
+	for done := false; !done; {
+		select {
+		case m := <-sub.ch:
+			if m.msg.Response && p.from(m.src) {
+				add(probeResponse{msg: m.msg, src: m.src, at: time.Since(start)})
+			}
+		case <-timer.C:
+			done = true
+		case <-ctx.Done():
+			done = true
+		}
+	}
+	if unicast != nil {
+		for _, r := range <-unicast {
+			add(r)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].at < out[j].at })
+	return out
+}
+
+// network returns the network and multicast group of the responder's stack.
+func (p *prober) network() (string, *net.UDPAddr) {
+	if p.addr.IP.To4() == nil {
+		return "udp6", ipv6Addr
+	}
+	return "udp4", ipv4Addr
+}
+
+// sender returns the socket bound to port 5353 the probes are sent from on a
+// network, opening it if needed.
+func (p *prober) sender(network string) (net.PacketConn, error) {
+	if conn, ok := p.senders[network]; ok {
+		return conn, nil
+	}
+	conn, err := p.c.transport.ListenUDP(network, &net.UDPAddr{Port: mdnsPort})
+	if err != nil {
+		return nil, fmt.Errorf("cannot send from port %d: %w", mdnsPort, err)
+	}
+	if addr := udpAddr(conn.LocalAddr()); addr == nil || addr.Port != mdnsPort {
+		conn.Close()
+		return nil, fmt.Errorf("cannot send from port %d", mdnsPort)
+	}
+	p.senders[network] = conn
+	return conn, nil
+}
+
+// close closes the sockets the probes were sent from.
+func (p *prober) close() {
+	for _, conn := range p.senders {
+		conn.Close()
+	}
+}
+
+// deadline returns when responses to a probe sent at start stop counting:
+// after wait, or once ctx is done if sooner.
+func (p *prober) deadline(ctx context.Context, start time.Time, wait time.Duration) time.Time {
+	deadline := start.Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	return deadline
+}
+
+// receive returns the responder's responses read from conn until the
+// deadline.
+func (p *prober) receive(conn net.PacketConn, start, deadline time.Time) []probeResponse {
+	conn.SetReadDeadline(deadline)
+	var out []probeResponse
+	rbuf := make([]byte, 65536)
+	for {
+		n, from, err := conn.ReadFrom(rbuf)
+		if err != nil {
+			// The deadline passed
+			return out
+		}
+		src := udpAddr(from)
+		msg, err := p.c.getCodec().Unpack(rbuf[:n])
+		if err != nil || !msg.Response || !p.from(src) {
+			continue
+		}
+		out = append(out, probeResponse{msg: msg, src: src, at: time.Since(start)})
+	}
+}
+
+// legacy sends a query to the multicast group from a socket of its own, on
+// a port other than 5353, and returns the responder's responses to that
+// socket received within wait.
+func (p *prober) legacy(ctx context.Context, q *dns.Msg, wait time.Duration) ([]probeResponse, error) {
+	network, group := p.network()
+	conn, err := p.c.transport.ListenUDP(network, &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	buf, err := p.c.getCodec().Pack(q)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if _, err := conn.WriteTo(buf, group); err != nil {
+		return nil, err
+	}
+	return p.receive(conn, start, p.deadline(ctx, start, wait)), nil
+}
+
+func (p *prober) checkMulticastResponse(ctx context.Context) ComplianceCheck {
+	check := ComplianceCheck{Name: "multicast-response", Spec: "6"}
+	responses, err := p.multicast(ctx, question(p.service(), dns.TypePTR, false), p.wait)
+	if err != nil {
+		return inconclusive(check, err)
+	}
+	for _, r := range responses {
+		p.responses = append(p.responses, r.msg)
+		for _, rr := range r.msg.Answer {
+			if ptr, ok := rr.(*dns.PTR); ok && strings.EqualFold(ptr.Ptr, p.instance) {
+				p.ptr = ptr
+			}
+		}
+	}
+	switch {
+	case p.ptr != nil:
+		check.Result = CompliancePass
+	case len(p.responses) > 0:
+		check.Result = ComplianceFail
+		check.Detail = fmt.Sprintf("responses lack the PTR record of %s", p.instance)
+	default:
+		check.Result = ComplianceFail
+		check.Detail = fmt.Sprintf("no response to a query for %s", p.service())
+	}
+	return check
+}
+
+func (p *prober) checkResponseHeader(context.Context) ComplianceCheck {
+	check := ComplianceCheck{Name: "response-header", Spec: "18"}
+	if len(p.responses) == 0 {
+		check.Result = ComplianceInconclusive
+		check.Detail = "no response to inspect"
+		return check
+	}
+	var problems []string
+	for _, msg := range p.responses {
+		if !msg.Authoritative {
+			problems = append(problems, "AA bit clear")
+		}
+		if msg.Opcode != dns.OpcodeQuery {
+			problems = append(problems, fmt.Sprintf("opcode %d", msg.Opcode))
+		}
+		if msg.Rcode != dns.RcodeSuccess {
+			problems = append(problems, fmt.Sprintf("rcode %d", msg.Rcode))
+		}
+		if msg.Truncated || msg.RecursionDesired || msg.RecursionAvailable {
+			problems = append(problems, "TC, RD, or RA bit set")
+		}
+	}
+	return failWith(check, problems)
+}
+
+func (p *prober) checkUnicastQuestion(ctx context.Context) ComplianceCheck {
+	check := ComplianceCheck{Name: "unicast-question", Spec: "5.4"}
+	responses, err := p.multicast(ctx, question(p.instance, dns.TypeSRV, true), p.wait)
+	if err != nil {
+		return inconclusive(check, err)
+	}
+	for _, r := range responses {
+		if hasRecord(r.msg, p.instance, dns.TypeSRV) {
+			check.Result = CompliancePass
+			return check
+		}
+	}
+	check.Result = ComplianceFail
+	check.Detail = "no answer to a question with the unicast-response bit set"
+	return check
+}
+
+func (p *prober) checkKnownAnswers(ctx context.Context) ComplianceCheck {
+	check := ComplianceCheck{Name: "known-answer-suppression", Spec: "7.1"}
+	if p.ptr == nil {
+		check.Result = ComplianceInconclusive
+		check.Detail = "no PTR record to list as known"
+		return check
+	}
+	q := question(p.service(), dns.TypePTR, false)
+	q.Answer = []dns.RR{dns.Copy(p.ptr)}
+	responses, err := p.multicast(ctx, q, p.wait)
+	if err != nil {
+		return inconclusive(check, err)
+	}
+	for _, r := range responses {
+		for _, rr := range r.msg.Answer {
+			if ptr, ok := rr.(*dns.PTR); ok && strings.EqualFold(ptr.Ptr, p.instance) {
+				check.Result = ComplianceFail
+				check.Detail = "answered with the PTR record listed as known"
+				return check
+			}
+		}
+	}
+	check.Result = CompliancePass
+	return check
+}
+
+func (p *prober) checkNegativeResponse(ctx context.Context) ComplianceCheck {
+	check := ComplianceCheck{Name: "negative-response", Spec: "6.1"}
+	responses, err := p.multicast(ctx, question(p.instance, dns.TypeA, false), p.wait)
+	if err != nil {
+		return inconclusive(check, err)
+	}
+	for _, r := range responses {
+		if hasRecord(r.msg, p.instance, dns.TypeA) {
+			check.Result = ComplianceInconclusive
+			check.Detail = "the instance has an A record"
+			return check
+		}
+		for _, rr := range append(r.msg.Answer, r.msg.Extra...) {
+			nsec, ok := rr.(*dns.NSEC)
+			if !ok || !strings.EqualFold(nsec.Hdr.Name, p.instance) {
+				continue
+			}
+			for _, t := range nsec.TypeBitMap {
+				if t == dns.TypeA {
+					check.Result = ComplianceFail
+					check.Detail = "NSEC record lists the missing A record"
+					return check
+				}
+			}
+			check.Result = CompliancePass
+			return check
+		}
+	}
+	check.Result = ComplianceFail
+	check.Detail = "no NSEC record for a type the instance does not have"
+	if len(responses) == 0 {
+		check.Detail = "no response for a type the instance does not have"
+	}
+	return check
+}
+
+func (p *prober) checkTruncatedQuery(ctx context.Context) ComplianceCheck {
+	check := ComplianceCheck{Name: "truncated-query", Spec: "7.2"}
+	q := question(p.service(), dns.TypePTR, false)
+	q.Truncated = true
+	responses, err := p.multicast(ctx, q, p.wait+maxTruncatedDelay)
+	if err != nil {
+		return inconclusive(check, err)
+	}
+	if len(responses) == 0 {
+		check.Result = ComplianceFail
+		check.Detail = "no response to a query with the TC bit set"
+		return check
+	}
+	if first := responses[0].at; first < minTruncatedDelay {
+		check.Result = ComplianceFail
+		check.Detail = fmt.Sprintf("answered after %v instead of waiting for more known answers", first.Round(time.Millisecond))
+		return check
+	}
+	check.Result = CompliancePass
+	return check
+}
+
+func (p *prober) checkLegacyUnicast(ctx context.Context) ComplianceCheck {
+	check := ComplianceCheck{Name: "legacy-unicast", Spec: "6.7"}
+	q := question(p.instance, dns.TypeSRV, false)
+	q.Id = uint16(1 + rand.Intn(0xfffe))
+	responses, err := p.legacy(ctx, q, p.wait)
+	if err != nil {
+		return inconclusive(check, err)
+	}
+	if len(responses) == 0 {
+		check.Result = ComplianceFail
+		check.Detail = "no unicast response to a query from a port other than 5353"
+		return check
+	}
+	var problems []string
+	for _, r := range responses {
+		if r.msg.Id != q.Id {
+			problems = append(problems, fmt.Sprintf("ID %d instead of %d", r.msg.Id, q.Id))
+		}
+		if len(r.msg.Question) != 1 || !sameQuestion(r.msg.Question[0], q.Question[0]) {
+			problems = append(problems, "question not repeated")
+		}
+		for _, rr := range append(r.msg.Answer, r.msg.Extra...) {
+			hdr := rr.Header()
+			if hdr.Ttl > maxLegacyTTL {
+				problems = append(problems, fmt.Sprintf("TTL %d of %s %s", hdr.Ttl, hdr.Name, dns.TypeToString[hdr.Rrtype]))
+			}
+			if hdr.Class&cacheFlushBit != 0 {
+				problems = append(problems, fmt.Sprintf("cache-flush bit on %s %s", hdr.Name, dns.TypeToString[hdr.Rrtype]))
+			}
+		}
+	}
+	return failWith(check, problems)
+}
+
+// inconclusive marks a check inconclusive because its probe could not be
+// sent.
+func inconclusive(check ComplianceCheck, err error) ComplianceCheck {
+	check.Result = ComplianceInconclusive
+	check.Detail = err.Error()
+	return check
+}
+
+// failWith sets the result of a check from the problems found, if any.
+func failWith(check ComplianceCheck, problems []string) ComplianceCheck {
+	if len(problems) == 0 {
+		check.Result = CompliancePass
+		return check
+	}
+	check.Result = ComplianceFail
+	check.Detail = strings.Join(dedupStrings(problems), "; ")
+	return check
+}
+
+// dedupStrings removes the repetitions of strings, keeping their order.
+func dedupStrings(s []string) []string {
+	seen := make(map[string]bool, len(s))
+	out := s[:0]
+	for _, v := range s {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// question returns a query with one question, asking for a unicast response
+// if unicast is set.
+func question(name string, qtype uint16, unicast bool) *dns.Msg {
+	q := new(dns.Msg)
+	q.SetQuestion(name, qtype)
+	q.RecursionDesired = false
+	if unicast {
+		q.Question[0].Qclass |= 1 << 15
+	}
+	return q
+}
+
+// hasRecord reports whether a message answers with a record of the name and
+// type.
+func hasRecord(msg *dns.Msg, name string, rrtype uint16) bool {
+	for _, rr := range append(msg.Answer, msg.Extra...) {
+		if hdr := rr.Header(); hdr.Rrtype == rrtype && strings.EqualFold(hdr.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ptrTargets returns the targets of the PTR records of name in a message.
+func ptrTargets(msg *dns.Msg, name string) []string {
+	var targets []string
+	for _, rr := range msg.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok && strings.EqualFold(ptr.Hdr.Name, name) {
+			targets = append(targets, ptr.Ptr)
+		}
+	}
+	return targets
+}
@@ -0,0 +1,205 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+// complianceResponder answers for "printer._ipp._tcp.local." from ip, on
+// IPv4 or IPv6, as RFC 6762 says if compliant is set, and as a careless
+// device otherwise. It returns the count of queries it got from ports other
+// than 5353, which it treats as legacy queries.
+func complianceResponder(t *testing.T, network *memnet.Network, ip string, compliant bool) *atomic.Int32 {
+	t.Helper()
+	addr := net.ParseIP(ip)
+	netw, group := "udp4", ipv4Addr
+	if addr.To4() == nil {
+		netw, group = "udp6", ipv6Addr
+	}
+	conn, err := network.Host(addr).ListenMulticastUDP(netw, nil, group)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	const (
+		service  = "_ipp._tcp.local."
+		instance = "printer._ipp._tcp.local."
+	)
+	hdr := func(name string, rrtype uint16, ttl uint32) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	}
+	answer := func(q *dns.Msg, legacy bool) []dns.RR {
+		ttl := uint32(120)
+		if legacy && compliant {
+			ttl = 10
+		}
+		question := q.Question[0]
+		switch {
+		case question.Name == "_services._dns-sd._udp.local." && question.Qtype == dns.TypePTR:
+			return []dns.RR{&dns.PTR{Hdr: hdr(question.Name, dns.TypePTR, ttl), Ptr: service}}
+		case question.Name == service && question.Qtype == dns.TypePTR:
+			if compliant && len(q.Answer) > 0 {
+				return nil
+			}
+			return []dns.RR{&dns.PTR{Hdr: hdr(service, dns.TypePTR, ttl), Ptr: instance}}
+		case question.Name == instance && question.Qtype == dns.TypeSRV:
+			srv := &dns.SRV{Hdr: hdr(instance, dns.TypeSRV, ttl), Target: "printer.local.", Port: 631}
+			if !compliant {
+				srv.Hdr.Class |= cacheFlushBit
+			}
+			return []dns.RR{srv}
+		case question.Name == instance && compliant:
+			return []dns.RR{&dns.NSEC{Hdr: hdr(instance, dns.TypeNSEC, ttl), NextDomain: instance, TypeBitMap: []uint16{dns.TypeTXT, dns.TypeSRV}}}
+		}
+		return nil
+	}
+
+	legacyQueries := new(atomic.Int32)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q := new(dns.Msg)
+			if q.Unpack(buf[:n]) != nil || q.Response || len(q.Question) != 1 {
+				continue
+			}
+			legacy := from.(*net.UDPAddr).Port != mdnsPort
+			if legacy {
+				legacyQueries.Add(1)
+			}
+			rrs := answer(q, legacy)
+			if len(rrs) == 0 {
+				continue
+			}
+			resp := &dns.Msg{MsgHdr: dns.MsgHdr{Response: true, Authoritative: compliant}, Answer: rrs}
+			var to net.Addr = group
+			if legacy {
+				to = from
+				if compliant {
+					resp.Id = q.Id
+					resp.Question = q.Question
+				}
+			}
+			out, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			if q.Truncated && compliant {
+				time.AfterFunc(450*time.Millisecond, func() { conn.WriteTo(out, to) })
+				continue
+			}
+			conn.WriteTo(out, to)
+		}
+	}()
+	return legacyQueries
+}
+
+func TestClient_CheckCompliance(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	complianceResponder(t, network, "10.0.0.1", true)
+	complianceResponder(t, network, "10.0.0.3", false)
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	// Found by address, ignoring the other responder
+	report, err := c.CheckCompliance(context.Background(), ComplianceTarget{Addr: net.ParseIP("10.0.0.1"), Wait: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if report.Instance != "printer._ipp._tcp.local." || len(report.Checks) != 7 || !report.Passed() {
+		t.Fatalf("bad:\n%s", report)
+	}
+	for _, check := range report.Checks {
+		if check.Result != CompliancePass {
+			t.Fatalf("bad:\n%s", report)
+		}
+	}
+}
+
+func TestClient_CheckComplianceIPv6(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	legacyQueries := complianceResponder(t, network, "fd00::1", true)
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2"), net.ParseIP("fd00::2")), true, true, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	report, err := c.CheckCompliance(context.Background(), ComplianceTarget{Addr: net.ParseIP("fd00::1"), Wait: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(report.Checks) != 7 || !report.Passed() {
+		t.Fatalf("bad:\n%s", report)
+	}
+	for _, check := range report.Checks {
+		if check.Result != CompliancePass {
+			t.Fatalf("bad:\n%s", report)
+		}
+	}
+
+	// Only the legacy-unicast probe is sent from a port other than 5353
+	if n := legacyQueries.Load(); n != 1 {
+		t.Fatalf("bad: %d legacy queries", n)
+	}
+}
+
+func TestClient_CheckComplianceFailures(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	complianceResponder(t, network, "10.0.0.1", false)
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	// Found by instance
+	report, err := c.CheckCompliance(context.Background(), ComplianceTarget{Instance: "printer._ipp._tcp.local", Wait: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !report.Addr.Equal(net.ParseIP("10.0.0.1")) || report.Passed() {
+		t.Fatalf("bad:\n%s", report)
+	}
+	want := map[string]string{
+		"multicast-response":       "",
+		"response-header":          "AA bit clear",
+		"unicast-question":         "",
+		"known-answer-suppression": "listed as known",
+		"negative-response":        "no response",
+		"truncated-query":          "answered after",
+		"legacy-unicast":           "question not repeated",
+	}
+	for _, check := range report.Checks {
+		detail, ok := want[check.Name]
+		if !ok {
+			t.Fatalf("unexpected check %q", check.Name)
+		}
+		if pass := detail == ""; pass != (check.Result == CompliancePass) || !strings.Contains(check.Detail, detail) {
+			t.Fatalf("%s: bad:\n%s", check.Name, report)
+		}
+	}
+
+	// Unknown instances are an error rather than a report
+	if _, err := c.CheckCompliance(context.Background(), ComplianceTarget{Instance: "scanner._ipp._tcp.local.", Wait: 50 * time.Millisecond}); err == nil {
+		t.Fatalf("error expected")
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// daemonStartWait is how long a registration waits for the system daemon's
+// tool to fail, e.g. on a name conflict, before it is taken as published.
+const daemonStartWait = 500 * time.Millisecond
+
+// Responder publishes services on the network. A Server is a Responder; so
+// is the pass-through to a system mDNS daemon returned by NewResponder.
+type Responder interface {
+	// Register starts publishing a service.
+	Register(ctx context.Context, service *MDNSService) error
+
+	// Update replaces a registered service with updated, which has the same
+	// names but another port, addresses, or TXT records, and publishes it.
+	// From then on updated is the registered service.
+	Update(ctx context.Context, service, updated *MDNSService) error
+
+	// Deregister stops publishing a service and withdraws it from the
+	// caches of other hosts.
+	Deregister(ctx context.Context, service *MDNSService) error
+
+	// ShutdownContext deregisters every service and releases the
+	// Responder's resources.
+	ShutdownContext(ctx context.Context) error
+}
+
+// SystemDaemon identifies an mDNS responder run by the operating system.
+type SystemDaemon int
+
+const (
+	// NoSystemDaemon means no system responder was found.
+	NoSystemDaemon SystemDaemon = iota
+
+	// Avahi is the responder of most Linux distributions, driven with
+	// avahi-publish.
+	Avahi
+
+	// MDNSResponder is the responder of macOS, also known as Bonjour,
+	// driven with dns-sd.
+	MDNSResponder
+)
+
+// String returns the name of the daemon.
+func (d SystemDaemon) String() string {
+	switch d {
+	case NoSystemDaemon:
+		return "none"
+	case Avahi:
+		return "avahi"
+	case MDNSResponder:
+		return "mDNSResponder"
+	}
+	return fmt.Sprintf("SystemDaemon(%d)", int(d))
+}
+
+// daemonSockets are the sockets the system daemons listen on for clients of
+// their APIs, which are present while they run.
+var daemonSockets = map[SystemDaemon][]string{
+	Avahi:         {"/run/avahi-daemon/socket", "/var/run/avahi-daemon/socket"},
+	MDNSResponder: {"/var/run/mDNSResponder"},
+}
+
+// daemonTools are the command line tools registrations are passed through.
+var daemonTools = map[SystemDaemon]string{
+	Avahi:         "avahi-publish",
+	MDNSResponder: "dns-sd",
+}
+
+// DetectSystemDaemon returns the system mDNS responder running on this host
+// that services can be published through, or NoSystemDaemon if there is none
+// or its command line tool is not installed.
+func DetectSystemDaemon() SystemDaemon {
+	return detectSystemDaemon(os.Stat, exec.LookPath)
+}
+
+// detectSystemDaemon is DetectSystemDaemon with the file system lookups
+// replaceable.
+func detectSystemDaemon(stat func(string) (os.FileInfo, error), lookPath func(string) (string, error)) SystemDaemon {
+	for _, daemon := range []SystemDaemon{Avahi, MDNSResponder} {
+		if _, err := lookPath(daemonTools[daemon]); err != nil {
+			continue
+		}
+		for _, socket := range daemonSockets[daemon] {
+			if _, err := stat(socket); err == nil {
+				return daemon
+			}
+		}
+	}
+	return NoSystemDaemon
+}
+
+// NewResponder returns a Responder publishing through the system mDNS daemon
+// if one runs on this host, and a Server created from config otherwise, so
+// that applications work the same with and without a system responder, which
+// would otherwise compete with a Server for the mDNS port. Queries are not
+// affected: Clients work alongside the daemon either way.
+//
+// Through a daemon, services are published under the daemon's own host name
+// and addresses: the HostName and IPs of a service are not used, nor are the
+// options of config other than Logger. A Zone in config other than a single
+// *MDNSService cannot be passed through, so a Server is returned for it; an
+// *MDNSService Zone is registered at once. Use NewServer to always answer
+// queries in-process.
+func NewResponder(config *Config) (Responder, error) {
+	daemon := DetectSystemDaemon()
+	service, isService := config.Zone.(*MDNSService)
+	if daemon == NoSystemDaemon || (config.Zone != nil && !isService) {
+		return NewServer(config)
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	r := newDaemonResponder(daemon, logger)
+	if isService {
+		if err := r.Register(context.Background(), service); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// daemonResponder publishes services by running the command line tool of a
+// system daemon for each, which keeps the service registered until it exits.
+type daemonResponder struct {
+	daemon SystemDaemon
+	log    *log.Logger
+
+	// command returns the command running a tool, replaceable in tests.
+	command func(name string, args ...string) *exec.Cmd
+
+	mu    sync.Mutex
+	procs map[*MDNSService]*daemonProcess
+}
+
+// daemonProcess is a running registration.
+type daemonProcess struct {
+	cmd     *exec.Cmd
+	started chan struct{} // closed once the process was started, or failed to
+	done    chan struct{} // closed when the process exited
+	err     error         // set once done is closed
+}
+
+// newDaemonResponder returns a Responder passing registrations through to the
+// daemon.
+func newDaemonResponder(daemon SystemDaemon, logger *log.Logger) *daemonResponder {
+	return &daemonResponder{
+		daemon:  daemon,
+		log:     logger,
+		command: exec.Command,
+		procs:   make(map[*MDNSService]*daemonProcess),
+	}
+}
+
+// daemonArgs returns the arguments of the daemon's tool registering service.
+// avahi-publish parses its arguments with getopt, so "--" ends its options
+// lest an instance name or TXT string starting with "-" be taken for one.
+// dns-sd takes every argument after -R as a value, and would take "--" for
+// the instance name.
+func daemonArgs(daemon SystemDaemon, service *MDNSService) []string {
+	port := strconv.Itoa(service.Port)
+	typ, domain := TrimDot(service.Service), TrimDot(service.Domain)
+	var args []string
+	switch daemon {
+	case Avahi:
+		args = []string{"-s", "--domain=" + domain, "--", service.Instance, typ, port}
+	case MDNSResponder:
+		args = []string{"-R", service.Instance, typ, domain, port}
+	}
+	return append(args, service.TXT...)
+}
+
+// Register starts the daemon's tool for the service, failing if it exits
+// straight away, such as when the name is in use.
+func (r *daemonResponder) Register(ctx context.Context, service *MDNSService) error {
+	var stderr bytes.Buffer
+	cmd := r.command(daemonTools[r.daemon], daemonArgs(r.daemon, service)...)
+	cmd.Stderr = &stderr
+	p := &daemonProcess{cmd: cmd, started: make(chan struct{}), done: make(chan struct{})}
+
+	// The service is reserved before the process is started, so that a
+	// concurrent registration of it fails rather than starting another
+	r.mu.Lock()
+	_, ok := r.procs[service]
+	if !ok {
+		r.procs[service] = p
+	}
+	r.mu.Unlock()
+	if ok {
+		return fmt.Errorf("mdns: %s is already registered", service.instanceAddr)
+	}
+
+	if err := cmd.Start(); err != nil {
+		p.err = err
+		close(p.done)
+		close(p.started)
+		r.release(service, p)
+		return fmt.Errorf("mdns: publishing through %v: %w", r.daemon, err)
+	}
+	close(p.started)
+	go func() {
+		p.err = cmd.Wait()
+		close(p.done)
+	}()
+
+	select {
+	case <-p.done:
+		r.release(service, p)
+		return fmt.Errorf("mdns: publishing through %v: %v: %s", r.daemon, p.err, bytes.TrimSpace(stderr.Bytes()))
+	case <-ctx.Done():
+		r.release(service, p)
+		r.stop(p)
+		return ctx.Err()
+	case <-time.After(daemonStartWait):
+	}
+	r.log.Printf("[INFO] mdns: publishing %s through %v", service.instanceAddr, r.daemon)
+	return nil
+}
+
+// release removes the reservation of a registration that failed, unless it
+// was deregistered meanwhile.
+func (r *daemonResponder) release(service *MDNSService, p *daemonProcess) {
+	r.mu.Lock()
+	if r.procs[service] == p {
+		delete(r.procs, service)
+	}
+	r.mu.Unlock()
+}
+
+// Update deregisters the service and registers updated in its place.
+func (r *daemonResponder) Update(ctx context.Context, service, updated *MDNSService) error {
+	if err := r.Deregister(ctx, service); err != nil {
+		return err
+	}
+	return r.Register(ctx, updated)
+}
+
+// Deregister stops the daemon's tool for the service, upon which the daemon
+// sends its goodbye.
+func (r *daemonResponder) Deregister(ctx context.Context, service *MDNSService) error {
+	r.mu.Lock()
+	p, ok := r.procs[service]
+	delete(r.procs, service)
+	r.mu.Unlock()
+	if !ok {
+		return ErrNotRegistered
+	}
+	r.stop(p)
+	return nil
+}
+
+// ShutdownContext deregisters every service.
+func (r *daemonResponder) ShutdownContext(ctx context.Context) error {
+	r.mu.Lock()
+	procs := r.procs
+	r.procs = make(map[*MDNSService]*daemonProcess)
+	r.mu.Unlock()
+	for _, p := range procs {
+		r.stop(p)
+	}
+	return nil
+}
+
+// stop interrupts a registration's process and waits for it to exit. A
+// registration still starting is waited for.
+func (r *daemonResponder) stop(p *daemonProcess) {
+	<-p.started
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	p.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-p.done:
+	case <-time.After(time.Second):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetectSystemDaemon(t *testing.T) {
+	files := func(paths ...string) func(string) (os.FileInfo, error) {
+		return func(path string) (os.FileInfo, error) {
+			for _, p := range paths {
+				if p == path {
+					return nil, nil
+				}
+			}
+			return nil, os.ErrNotExist
+		}
+	}
+	tools := func(names ...string) func(string) (string, error) {
+		return func(name string) (string, error) {
+			for _, n := range names {
+				if n == name {
+					return "/usr/bin/" + name, nil
+				}
+			}
+			return "", exec.ErrNotFound
+		}
+	}
+
+	for _, test := range []struct {
+		stat     func(string) (os.FileInfo, error)
+		lookPath func(string) (string, error)
+		want     SystemDaemon
+	}{
+		{files("/run/avahi-daemon/socket"), tools("avahi-publish"), Avahi},
+		{files("/var/run/mDNSResponder"), tools("dns-sd"), MDNSResponder},
+		// A daemon is only used if its tool is installed
+		{files("/run/avahi-daemon/socket"), tools("dns-sd"), NoSystemDaemon},
+		{files(), tools("avahi-publish", "dns-sd"), NoSystemDaemon},
+	} {
+		if got := detectSystemDaemon(test.stat, test.lookPath); got != test.want {
+			t.Errorf("got %v, want %v", got, test.want)
+		}
+	}
+}
+
+func TestDaemonArgs(t *testing.T) {
+	service := makeService(t)
+	service.TXT = []string{"path=/", "v=1"}
+	if got, want := daemonArgs(Avahi, service), []string{"-s", "--domain=local", "--", "hostname", "_http._tcp", "80", "path=/", "v=1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if got, want := daemonArgs(MDNSResponder, service), []string{"-R", "hostname", "_http._tcp", "local", "80", "path=/", "v=1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// Values starting with "-" are not taken for options
+	service.Instance, service.TXT = "-v", []string{"-h"}
+	if got, want := daemonArgs(Avahi, service), []string{"-s", "--domain=local", "--", "-v", "_http._tcp", "80", "-h"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestDaemonResponder(t *testing.T) {
+	r := newDaemonResponder(Avahi, log.Default())
+	var ran []string
+	r.command = func(name string, args ...string) *exec.Cmd {
+		ran = append(ran, name+" "+strings.Join(args, " "))
+		if args[len(args)-1] == "fail" {
+			return exec.Command("sh", "-c", "echo name collision >&2; exit 1")
+		}
+		return exec.Command("sleep", "10")
+	}
+	ctx := context.Background()
+
+	service := makeService(t)
+	if err := r.Register(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(ran) != 1 || !strings.HasPrefix(ran[0], "avahi-publish -s") {
+		t.Fatalf("bad: %q", ran)
+	}
+	updated := makeService(t)
+	updated.Port = 8080
+	if err := r.Update(ctx, service, updated); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := r.Deregister(ctx, service); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("got %v, want ErrNotRegistered", err)
+	}
+	if err := r.Deregister(ctx, updated); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := r.Deregister(ctx, updated); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("got %v, want ErrNotRegistered", err)
+	}
+
+	// The daemon's errors are reported
+	failing := makeService(t)
+	failing.TXT = []string{"fail"}
+	if err := r.Register(ctx, failing); err == nil || !strings.Contains(err.Error(), "name collision") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// A registration is reserved while its process starts
+	r.command = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("sleep", "10")
+	}
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- r.Register(ctx, service) }()
+	}
+	if err := <-errs; err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Fatalf("bad: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := r.ShutdownContext(ctx); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(r.procs) != 0 {
+		t.Fatalf("registrations left: %v", r.procs)
+	}
+}
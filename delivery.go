@@ -0,0 +1,193 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"time"
+)
+
+// Delivery selects what a query does with an entry when the results channel
+// is not ready to receive it, see QueryParam.Delivery.
+type Delivery int
+
+const (
+	// DeliverDrop drops the entry, reporting it to QueryParam.OnDrop if set.
+	// This is the default, so that a slow consumer never holds up the query.
+	DeliverDrop Delivery = iota
+
+	// DeliverBlock waits until the channel receives the entry, the query's
+	// context is done, or the Client closes. The query handles no response
+	// meanwhile, and as received messages queue up for it, the other queries
+	// and browses of the Client are held up too.
+	DeliverBlock
+
+	// DeliverBuffer queues the entry, up to QueryParam.DeliveryBuffer
+	// entries, and sends the queued entries in order as the channel becomes
+	// ready. Entries arriving while the queue is full are dropped. When the
+	// query finishes, the entries still queued are sent before it returns,
+	// unless its context is done or the Client closes first.
+	DeliverBuffer
+)
+
+// closeTimeout is how long a query or browse that stopped waits in all for
+// its channel to receive the removals reported by QueryParam.RemoveOnClose.
+const closeTimeout = time.Second
+
+// delivery is how the entries of a query are sent to the results channel.
+type delivery struct {
+	policy Delivery
+	buffer int
+	onDrop func(*ServiceEntry)
+}
+
+// delivery returns how the query's entries are sent.
+func (p *QueryParam) delivery() delivery {
+	return delivery{policy: p.Delivery, buffer: p.DeliveryBuffer, onDrop: p.OnDrop}
+}
+
+// drop reports an entry that was not sent.
+func (d delivery) drop(e *ServiceEntry) {
+	if d.onDrop != nil {
+		d.onDrop(e)
+	}
+}
+
+// deliverable is what an outbox sends: an entry, or an event about one. Either
+// is sent as the delivery of its entry asks.
+type deliverable interface {
+	deliveredEntry() *ServiceEntry
+}
+
+// deliveredEntry returns the entry itself.
+func (s *ServiceEntry) deliveredEntry() *ServiceEntry {
+	return s
+}
+
+// deliveredEntry returns the entry the event is about.
+func (e BrowseEvent) deliveredEntry() *ServiceEntry {
+	return e.Entry
+}
+
+// dropped reports a value that was not sent to the OnDrop of its entry.
+func dropped[T deliverable](v T) {
+	e := v.deliveredEntry()
+	e.delivery.drop(e)
+}
+
+// outbox sends the entries of a query, or the events about them, to a
+// channel, queueing them as their delivery asks.
+type outbox[T deliverable] struct {
+	ctx     context.Context
+	ch      chan<- T
+	closed  <-chan struct{}
+	pending []T // queued by DeliverBuffer, oldest first
+}
+
+// newOutbox returns an outbox sending to ch until ctx is done or closed is.
+func newOutbox[T deliverable](ctx context.Context, ch chan<- T, closed <-chan struct{}) *outbox[T] {
+	return &outbox[T]{ctx: ctx, ch: ch, closed: closed}
+}
+
+// send sends a value, or queues or drops it as its delivery asks if the
+// channel is not ready.
+func (o *outbox[T]) send(v T) {
+	if o == nil || o.ch == nil {
+		return
+	}
+	d := v.deliveredEntry().delivery
+	switch d.policy {
+	case DeliverBlock:
+		// Keep the order of the values queued before
+		o.flush()
+		o.wait(v)
+	case DeliverBuffer:
+		if len(o.pending) == 0 {
+			select {
+			case o.ch <- v:
+				return
+			default:
+			}
+		}
+		if len(o.pending) >= d.buffer {
+			dropped(v)
+			return
+		}
+		o.pending = append(o.pending, v)
+	default:
+		select {
+		case o.ch <- v:
+		default:
+			dropped(v)
+		}
+	}
+}
+
+// wait sends a value, waiting until the channel is ready unless the context
+// is done or the Client closes, and reports whether it was sent.
+func (o *outbox[T]) wait(v T) bool {
+	select {
+	case o.ch <- v:
+		return true
+	case <-o.ctx.Done():
+	case <-o.closed:
+	}
+	dropped(v)
+	return false
+}
+
+// ready returns the channel to send the next queued value to, or nil if none
+// is queued, for use in a select along with next.
+func (o *outbox[T]) ready() chan<- T {
+	if o == nil || len(o.pending) == 0 {
+		return nil
+	}
+	return o.ch
+}
+
+// next returns the next queued value, or the zero value if none is.
+func (o *outbox[T]) next() T {
+	if o == nil || len(o.pending) == 0 {
+		var zero T
+		return zero
+	}
+	return o.pending[0]
+}
+
+// sent removes the next queued value once it was sent.
+func (o *outbox[T]) sent() {
+	var zero T
+	o.pending[0] = zero
+	o.pending = o.pending[1:]
+}
+
+// finish calls send, which sends the last values of a query or browse that
+// stopped, and sends them along with those queued. As the context is done or
+// the Client closed by then, they are waited for up to closeTimeout in all
+// instead.
+func (o *outbox[T]) finish(send func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	o.ctx, o.closed = ctx, nil
+	send()
+	o.flush()
+}
+
+// flush sends the queued values, waiting for the channel. Once the context
+// is done or the Client closes, the rest are dropped.
+func (o *outbox[T]) flush() {
+	if o == nil {
+		return
+	}
+	for len(o.pending) > 0 {
+		v := o.next()
+		o.sent()
+		if !o.wait(v) {
+			for _, v := range o.pending {
+				dropped(v)
+			}
+			o.pending = nil
+		}
+	}
+}
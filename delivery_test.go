@@ -0,0 +1,127 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestOutbox_Send(t *testing.T) {
+	var dropped []string
+	onDrop := func(e *ServiceEntry) { dropped = append(dropped, e.Name) }
+	entry := func(name string, d delivery) *ServiceEntry {
+		return &ServiceEntry{Name: name, delivery: d}
+	}
+
+	ch := make(chan *ServiceEntry, 1)
+	out := newOutbox(context.Background(), ch, nil)
+
+	// Dropped once the channel is full
+	drop := delivery{onDrop: onDrop}
+	out.send(entry("a", drop))
+	out.send(entry("b", drop))
+	if len(dropped) != 1 || dropped[0] != "b" || (<-ch).Name != "a" {
+		t.Fatalf("bad: %v", dropped)
+	}
+
+	// Queued up to the buffer size, in order
+	dropped = nil
+	buffer := delivery{policy: DeliverBuffer, buffer: 2, onDrop: onDrop}
+	for _, name := range []string{"c", "d", "e", "f"} {
+		out.send(entry(name, buffer))
+	}
+	if len(dropped) != 1 || dropped[0] != "f" || len(out.pending) != 2 {
+		t.Fatalf("bad: %v %v", dropped, out.pending)
+	}
+	if (<-ch).Name != "c" || out.ready() != chan<- *ServiceEntry(ch) || out.next().Name != "d" {
+		t.Fatalf("bad: %v", out.pending)
+	}
+	out.ready() <- out.next()
+	out.sent()
+	if (<-ch).Name != "d" || out.next().Name != "e" {
+		t.Fatalf("bad: %v", out.pending)
+	}
+
+	// Blocking sends the queued entries first
+	dropped = nil
+	out.send(entry("g", buffer))
+	out.send(entry("h", buffer))
+	if len(dropped) != 1 || dropped[0] != "h" {
+		t.Fatalf("bad: %v", dropped)
+	}
+	go out.send(entry("i", delivery{policy: DeliverBlock}))
+	for _, want := range []string{"e", "g", "i"} {
+		select {
+		case got := <-ch:
+			if got.Name != want {
+				t.Fatalf("got %q, want %q", got.Name, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+}
+
+func TestOutbox_FlushStopsWithContext(t *testing.T) {
+	var dropped int
+	buffer := delivery{policy: DeliverBuffer, buffer: 4, onDrop: func(*ServiceEntry) { dropped++ }}
+	ctx, cancel := context.WithCancel(context.Background())
+	out := newOutbox(ctx, make(chan *ServiceEntry), nil)
+	for i := 0; i < 3; i++ {
+		out.send(&ServiceEntry{delivery: buffer})
+	}
+	cancel()
+	out.flush()
+	if dropped != 3 || len(out.pending) != 0 {
+		t.Fatalf("bad: %d %v", dropped, out.pending)
+	}
+}
+
+func TestClient_QueryDelivery(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	// Nobody receives while the query runs, so the entry is dropped...
+	dropped := make(chan *ServiceEntry, 1)
+	params := []QueryParam{{Service: "_http._tcp", Timeout: 200 * time.Millisecond, OnDrop: func(e *ServiceEntry) { dropped <- e }}}
+	if err := QueryContext(context.Background(), &params, make(chan *ServiceEntry), c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(dropped) != 1 {
+		t.Fatalf("entry not reported as dropped")
+	}
+
+	// ...unless it is buffered until the consumer catches up
+	entries := make(chan *ServiceEntry)
+	params = []QueryParam{{Service: "_http._tcp", Timeout: 200 * time.Millisecond, Delivery: DeliverBuffer, DeliveryBuffer: 1}}
+	errCh := make(chan error, 1)
+	go func() { errCh <- QueryContext(context.Background(), &params, entries, c) }()
+	time.Sleep(400 * time.Millisecond)
+	select {
+	case e := <-entries:
+		if e.Name != "hostname._http._tcp.local." {
+			t.Fatalf("bad: %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("entry not delivered")
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
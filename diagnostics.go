@@ -0,0 +1,126 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// diagnosticsService is the service type of the diagnostics beacon.
+	diagnosticsService = "_mdns-debug._udp"
+
+	// modulePath is the import path of this library, used to find its version
+	// in the build information.
+	modulePath = "github.com/sloweclair/mdns"
+)
+
+// diagnosticsZone answers for the diagnostics beacon, filling in its TXT
+// records with the Server's current statistics at query time.
+type diagnosticsZone struct {
+	s       *Server
+	service *MDNSService
+}
+
+// Records returns DNS records in response to a DNS question.
+func (d *diagnosticsZone) Records(q dns.Question) []dns.RR {
+	return d.current().Records(q)
+}
+
+// current returns the beacon with the TXT records it advertises now.
+func (d *diagnosticsZone) current() *MDNSService {
+	service := *d.service
+	service.TXT = d.s.diagnosticsTXT()
+	return &service
+}
+
+// EnableDiagnostics starts advertising a _mdns-debug._udp beacon for this
+// Server, whose TXT records carry the library version and the Server's query
+// statistics. This lets operators locate and inspect instances on the network.
+// The context bounds probing and announcing of the beacon.
+func (s *Server) EnableDiagnostics(ctx context.Context) error {
+	host, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("could not determine host: %v", err)
+	}
+	instance := fmt.Sprintf("%s (%d)", host, os.Getpid())
+
+	var ips []net.IP
+	var hostName string
+	if zone, ok := s.config.Zone.(*MDNSService); ok {
+		// Reuse the host information of the service being published
+		hostName, ips = zone.HostName, zone.IPs
+	}
+	service, err := NewMDNSService(instance, diagnosticsService, "", hostName, mdnsPort, ips, nil)
+	if err != nil {
+		return err
+	}
+	service.TXT = s.diagnosticsTXT()
+
+	if err := s.probe(ctx, service); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.diagnostics = &diagnosticsZone{s: s, service: service}
+	s.mu.Unlock()
+	s.answers.invalidate()
+	_, err = s.announce(ctx, service, announceCount)
+	return err
+}
+
+// DisableDiagnostics stops advertising the diagnostics beacon and sends a
+// goodbye for it, with the TXT records it last advertised.
+func (s *Server) DisableDiagnostics(ctx context.Context) error {
+	s.mu.Lock()
+	diagnostics := s.diagnostics
+	s.diagnostics = nil
+	s.mu.Unlock()
+	s.answers.invalidate()
+
+	if diagnostics == nil {
+		return nil
+	}
+	return s.goodbye(ctx, diagnostics.current(), s.zone())
+}
+
+// diagnosticsTXT returns the TXT records advertised by the diagnostics beacon.
+func (s *Server) diagnosticsTXT() []string {
+	s.mu.Lock()
+	services := len(s.services)
+	s.mu.Unlock()
+
+	return []string{
+		"version=" + libraryVersion(),
+		fmt.Sprintf("uptime=%d", int(time.Since(s.started).Seconds())),
+		fmt.Sprintf("services=%d", services),
+		fmt.Sprintf("queries=%d", atomic.LoadUint64(&s.queries)),
+		fmt.Sprintf("responses=%d", atomic.LoadUint64(&s.responses)),
+	}
+}
+
+// libraryVersion returns the version of this library recorded in the build
+// information of the running binary.
+func libraryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
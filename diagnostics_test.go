@@ -0,0 +1,71 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestServer_Diagnostics(t *testing.T) {
+	rec := &Recorder{}
+	serv, err := NewServer(&Config{Zone: makeService(t), Recorder: rec})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	if err := serv.EnableDiagnostics(context.Background()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	q := dns.Question{Name: "_mdns-debug._udp.local.", Qtype: dns.TypePTR}
+	var txt *dns.TXT
+	for _, rr := range serv.zone().Records(q) {
+		if rr, ok := rr.(*dns.TXT); ok {
+			txt = rr
+		}
+	}
+	if txt == nil {
+		t.Fatalf("no TXT record for the beacon")
+	}
+	joined := strings.Join(txt.Txt, " ")
+	for _, key := range []string{"version=", "uptime=", "queries=", "responses="} {
+		if !strings.Contains(joined, key) {
+			t.Errorf("beacon TXT %q is missing %q", joined, key)
+		}
+	}
+
+	// The goodbye carries the statistics advertised at the time, not those
+	// of when the beacon was enabled
+	rec.Reset()
+	atomic.AddUint64(&serv.queries, 7)
+	if err := serv.DisableDiagnostics(context.Background()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	packets := rec.Packets()
+	if len(packets) != 1 {
+		t.Fatalf("recorded %d packets, want a goodbye", len(packets))
+	}
+	bye, err := ParseMessage(packets[0].Data)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txt = nil
+	for _, rr := range bye.Answer {
+		if rr, ok := rr.(*dns.TXT); ok && rr.Hdr.Ttl == 0 {
+			txt = rr
+		}
+	}
+	if txt == nil || !strings.Contains(strings.Join(txt.Txt, " "), "queries=7") {
+		t.Fatalf("goodbye has a stale TXT: %v", bye)
+	}
+	if recs := serv.zone().Records(q); len(recs) != 0 {
+		t.Fatalf("beacon should no longer be advertised: %v", recs)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// logDryRun logs a packet a server in dry-run mode would have sent to the
+// given destination, on a single line.
+func (s *Server) logDryRun(kind, to string, msg *dns.Msg) {
+	var recs []string
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			recs = append(recs, strings.ReplaceAll(rr.String(), "\t", " "))
+		}
+	}
+	s.config.Logger.Printf("[INFO] mdns: dry run: would send %s to %s: %s", kind, to, strings.Join(recs, "; "))
+}
+
+// multicastKind names what an unsolicited multicast packet is for.
+func multicastKind(msg *dns.Msg) string {
+	if !msg.Response {
+		return "probe"
+	}
+	for _, rr := range msg.Answer {
+		if rr.Header().Ttl != 0 {
+			return "announcement"
+		}
+	}
+	return "goodbye"
+}
@@ -0,0 +1,75 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sloweclair/mdns/memnet"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServer_DryRun(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	var logs lockedBuffer
+	recorder := &Recorder{}
+	serv, err := NewServer(&Config{
+		Transport: network.Host(net.ParseIP("10.0.0.1")),
+		Logger:    log.New(&logs, "", 0),
+		Recorder:  recorder,
+		DryRun:    true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	service := makeServiceWithServiceName(t, "_dryrun._tcp")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := serv.Register(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := serv.Deregister(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if sent := network.Stats().Sent; sent != 0 {
+		t.Fatalf("%d packets were sent", sent)
+	}
+	if len(recorder.Packets()) == 0 {
+		t.Fatalf("packets were not recorded")
+	}
+	for _, kind := range []string{"probe", "announcement", "goodbye"} {
+		if !strings.Contains(logs.String(), "would send "+kind+" to multicast: ") {
+			t.Fatalf("missing %s in log:\n%s", kind, logs.String())
+		}
+	}
+	if !strings.Contains(logs.String(), "hostname._dryrun._tcp.local.") {
+		t.Fatalf("records were not logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// echoWindow is how long a packet the Server sent is recognized when it
+	// is received back.
+	echoWindow = 2 * time.Second
+
+	// maxEchoes bounds the number of sent packets remembered.
+	maxEchoes = 64
+)
+
+// echoFilter remembers the packets a Server sent recently, so that they are
+// recognized when received back. Multicast loopback already hands a host its
+// own packets, but where several of its interfaces are bridged together each
+// packet also comes back through the other interfaces, and processing these
+// copies makes the Server answer its own probes and announcements.
+type echoFilter struct {
+	mu   sync.Mutex
+	sent []sentPacket // oldest first
+}
+
+// sentPacket is a packet sent by the Server.
+type sentPacket struct {
+	sum uint64
+	at  time.Time
+}
+
+// add remembers a packet being sent.
+func (f *echoFilter) add(buf []byte, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire(now)
+	if len(f.sent) >= maxEchoes {
+		f.sent = f.sent[1:]
+	}
+	f.sent = append(f.sent, sentPacket{sum: packetSum(buf), at: now})
+}
+
+// seen reports whether a received packet is identical to one sent within
+// echoWindow.
+func (f *echoFilter) seen(buf []byte, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire(now)
+	if len(f.sent) == 0 {
+		return false
+	}
+	sum := packetSum(buf)
+	for _, p := range f.sent {
+		if p.sum == sum {
+			return true
+		}
+	}
+	return false
+}
+
+// expire forgets the packets sent before echoWindow. The caller must hold
+// the lock.
+func (f *echoFilter) expire(now time.Time) {
+	i := 0
+	for i < len(f.sent) && now.Sub(f.sent[i].at) > echoWindow {
+		i++
+	}
+	f.sent = f.sent[i:]
+}
+
+// packetSum returns the hash packets are compared by.
+func packetSum(buf []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(buf)
+	return h.Sum64()
+}
+
+// isEcho reports whether a received packet is one the Server sent: one it
+// sent recently, received from its own mDNS port and address. Packets from
+// the other processes of the host, and identical queries from other hosts,
+// are not echoes.
+func (s *Server) isEcho(buf []byte, from net.Addr) bool {
+	addr, ok := from.(*net.UDPAddr)
+	if !ok || addr.Port != mdnsPort {
+		return false
+	}
+	if !s.echoes.seen(buf, time.Now()) {
+		return false
+	}
+	return s.isOwnAddr(addr.IP)
+}
+
+// isOwnAddr reports whether ip is an address of the Server's host.
+func (s *Server) isOwnAddr(ip net.IP) bool {
+	for _, l := range []net.PacketConn{s.ipv4List, s.ipv6List} {
+		if l == nil {
+			continue
+		}
+		if addr, ok := l.LocalAddr().(*net.UDPAddr); ok && addr.IP.Equal(ip) {
+			return true
+		}
+	}
+	// Listeners bound to the group or a wildcard address do not tell,
+	// but this is only reached for the rare packets identical to ones sent
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
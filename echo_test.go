@@ -0,0 +1,78 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestEchoFilter(t *testing.T) {
+	var f echoFilter
+	now := time.Now()
+	f.add([]byte("one"), now)
+	f.add([]byte("two"), now.Add(time.Second))
+
+	if !f.seen([]byte("one"), now.Add(time.Second)) || f.seen([]byte("three"), now.Add(time.Second)) {
+		t.Fatalf("bad: %+v", f.sent)
+	}
+	// Packets are forgotten after the window
+	if f.seen([]byte("one"), now.Add(echoWindow+time.Millisecond)) || !f.seen([]byte("two"), now.Add(echoWindow)) {
+		t.Fatalf("bad: %+v", f.sent)
+	}
+
+	for i := 0; i < 2*maxEchoes; i++ {
+		f.add([]byte{byte(i)}, now.Add(time.Second))
+	}
+	if len(f.sent) != maxEchoes {
+		t.Fatalf("bad: %d", len(f.sent))
+	}
+}
+
+func TestServer_IgnoresEchoes(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	s, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s.Shutdown()
+
+	q := new(dns.Msg)
+	q.SetQuestion("_http._tcp.local.", dns.TypePTR)
+	buf, err := q.Pack()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	s.echoes.add(buf, time.Now())
+
+	send := func(host string) {
+		t.Helper()
+		conn, err := network.Host(net.ParseIP(host)).ListenUDP("udp4", &net.UDPAddr{Port: mdnsPort})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.WriteTo(buf, ipv4Addr); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// The packet the server sent, received back from its own address
+	send("10.0.0.1")
+	if n := atomic.LoadUint64(&s.queries); n != 0 {
+		t.Fatalf("echo handled: %d", n)
+	}
+
+	// The same packet from another host is a query
+	send("10.0.0.2")
+	if n := atomic.LoadUint64(&s.queries); n != 1 {
+		t.Fatalf("query not handled: %d", n)
+	}
+}
@@ -0,0 +1,494 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecordTTLs holds the TTL of each type of record a ServiceEntry was built
+// from, zero for types that were not cached. Where several records of a type
+// were used, such as the A records of a host with several addresses, the
+// shortest TTL is kept.
+type RecordTTLs struct {
+	PTR  time.Duration
+	SRV  time.Duration
+	TXT  time.Duration
+	A    time.Duration
+	AAAA time.Duration
+}
+
+// entryJSON is the stable JSON schema of a ServiceEntry:
+//
+//	{
+//	  "name":        "My Printer._ipp._tcp.local.", // instance name
+//	  "host":        "printer.local.",             // SRV target
+//	  "port":        631,
+//	  "priority":    10,                           // of the SRV record
+//	  "weight":      1,
+//	  "ipv4":        "192.168.1.10",
+//	  "ipv6":        "fe80::1%eth0",               // zone included if link-local
+//	  "ipv4_addrs":  ["192.168.1.10", "10.0.0.10"], // every address of the host
+//	  "ipv6_addrs":  ["fe80::1%eth0"],
+//	  "txt":         ["txtvers=1", "rp=printer"],
+//	  "source":      "192.168.1.10",               // address the response came from
+//	  "received_on": "eth0",                       // interface it arrived on
+//	  "query_id":    "printers",
+//	  "ttl":         120,                          // seconds
+//	  "ttls":        {"ptr": 4500, "srv": 120, "txt": 4500, "a": 120}, // seconds, by record type
+//	  "expires":     "2024-05-01T12:02:00Z"        // RFC 3339
+//	}
+//
+// Empty fields are omitted. The deprecated Addr and AddrV6 fields and Info are
+// derived from the others when decoding.
+type entryJSON struct {
+	Name       string    `json:"name"`
+	Host       string    `json:"host,omitempty"`
+	Port       int       `json:"port,omitempty"`
+	Prio       int       `json:"priority,omitempty"`
+	Weight     int       `json:"weight,omitempty"`
+	IPv4       string    `json:"ipv4,omitempty"`
+	IPv6       string    `json:"ipv6,omitempty"`
+	IPv4s      []string  `json:"ipv4_addrs,omitempty"`
+	IPv6s      []string  `json:"ipv6_addrs,omitempty"`
+	TXT        []string  `json:"txt,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	ReceivedOn string    `json:"received_on,omitempty"`
+	QueryID    string    `json:"query_id,omitempty"`
+	TTL        uint32    `json:"ttl,omitempty"`
+	TTLs       *ttlsJSON `json:"ttls,omitempty"`
+	Expires    string    `json:"expires,omitempty"`
+}
+
+// ttlsJSON is the "ttls" object of entryJSON, holding RecordTTLs in seconds.
+type ttlsJSON struct {
+	PTR  uint32 `json:"ptr,omitempty"`
+	SRV  uint32 `json:"srv,omitempty"`
+	TXT  uint32 `json:"txt,omitempty"`
+	A    uint32 `json:"a,omitempty"`
+	AAAA uint32 `json:"aaaa,omitempty"`
+}
+
+// newTTLsJSON encodes the TTLs of an entry, returning nil if none is known.
+func newTTLsJSON(t RecordTTLs) *ttlsJSON {
+	if t == (RecordTTLs{}) {
+		return nil
+	}
+	return &ttlsJSON{
+		PTR:  seconds(t.PTR),
+		SRV:  seconds(t.SRV),
+		TXT:  seconds(t.TXT),
+		A:    seconds(t.A),
+		AAAA: seconds(t.AAAA),
+	}
+}
+
+// recordTTLs decodes the TTLs of an entry, zero for a nil object.
+func (j *ttlsJSON) recordTTLs() RecordTTLs {
+	if j == nil {
+		return RecordTTLs{}
+	}
+	return RecordTTLs{
+		PTR:  time.Duration(j.PTR) * time.Second,
+		SRV:  time.Duration(j.SRV) * time.Second,
+		TXT:  time.Duration(j.TXT) * time.Second,
+		A:    time.Duration(j.A) * time.Second,
+		AAAA: time.Duration(j.AAAA) * time.Second,
+	}
+}
+
+// seconds returns d in whole seconds, as TTLs are given.
+func seconds(d time.Duration) uint32 {
+	return uint32(d / time.Second)
+}
+
+// MarshalJSON encodes the entry with a stable schema, so that it can be sent
+// over APIs and logged consistently. See entryJSON for the schema.
+func (s ServiceEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(entryJSON{
+		Name:       s.Name,
+		Host:       s.Host,
+		Port:       s.Port,
+		Prio:       s.Priority,
+		Weight:     s.Weight,
+		IPv4:       ipString(s.AddrV4),
+		IPv6:       s.ipv6String(),
+		IPv4s:      ipStrings(s.AddrsV4),
+		IPv6s:      ipAddrStrings(s.AddrsV6),
+		TXT:        s.InfoFields,
+		Source:     ipString(s.SrcIP),
+		ReceivedOn: s.ReceivedOn,
+		QueryID:    s.QueryID,
+		TTL:        s.TTL,
+		TTLs:       newTTLsJSON(s.TTLs),
+		Expires:    timeString(s.ExpiresAt),
+	})
+}
+
+// UnmarshalJSON decodes an entry encoded by MarshalJSON.
+func (s *ServiceEntry) UnmarshalJSON(data []byte) error {
+	var j entryJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	e := ServiceEntry{
+		Name:       j.Name,
+		Host:       j.Host,
+		Port:       j.Port,
+		Priority:   j.Prio,
+		Weight:     j.Weight,
+		InfoFields: j.TXT,
+		Info:       strings.Join(j.TXT, "|"),
+		ReceivedOn: j.ReceivedOn,
+		QueryID:    j.QueryID,
+		TTL:        j.TTL,
+		TTLs:       j.TTLs.recordTTLs(),
+		hasTXT:     j.TXT != nil,
+	}
+	var err error
+	if e.AddrV4, err = parseIP("ipv4", j.IPv4); err != nil {
+		return err
+	}
+	if e.SrcIP, err = parseIP("source", j.Source); err != nil {
+		return err
+	}
+	if j.Expires != "" {
+		if e.ExpiresAt, err = time.Parse(time.RFC3339Nano, j.Expires); err != nil {
+			return fmt.Errorf("mdns: invalid expiry %q", j.Expires)
+		}
+	}
+	if j.IPv6 != "" {
+		ip, zone, _ := strings.Cut(j.IPv6, "%")
+		addr, err := parseIP("ipv6", ip)
+		if err != nil {
+			return err
+		}
+		e.AddrV6 = addr
+		e.AddrV6IPAddr = &net.IPAddr{IP: addr, Zone: zone}
+	}
+	for _, a := range j.IPv4s {
+		ip, err := parseIP("ipv4", a)
+		if err != nil {
+			return err
+		}
+		e.AddrsV4 = append(e.AddrsV4, ip)
+	}
+	for _, a := range j.IPv6s {
+		a, zone, _ := strings.Cut(a, "%")
+		ip, err := parseIP("ipv6", a)
+		if err != nil {
+			return err
+		}
+		e.AddrsV6 = append(e.AddrsV6, net.IPAddr{IP: ip, Zone: zone})
+	}
+	e.Addr = e.AddrV4
+	if e.AddrV6 != nil {
+		e.Addr = e.AddrV6
+	}
+	*s = e
+	return nil
+}
+
+// MarshalText encodes the entry as a single line of space-separated key=value
+// pairs, with the same keys as the JSON schema but for ttls, for logging. TXT
+// records and address lists are repeated txt=, ipv4_addrs=, and ipv6_addrs=
+// pairs; strings are quoted.
+func (s ServiceEntry) MarshalText() ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("name=" + strconv.Quote(s.Name))
+	if s.Host != "" {
+		b.WriteString(" host=" + strconv.Quote(s.Host))
+	}
+	if s.Port != 0 {
+		b.WriteString(" port=" + strconv.Itoa(s.Port))
+	}
+	if s.Priority != 0 {
+		b.WriteString(" priority=" + strconv.Itoa(s.Priority))
+	}
+	if s.Weight != 0 {
+		b.WriteString(" weight=" + strconv.Itoa(s.Weight))
+	}
+	if s.AddrV4 != nil {
+		b.WriteString(" ipv4=" + ipString(s.AddrV4))
+	}
+	if v6 := s.ipv6String(); v6 != "" {
+		b.WriteString(" ipv6=" + v6)
+	}
+	for _, ip := range s.AddrsV4 {
+		b.WriteString(" ipv4_addrs=" + ipString(ip))
+	}
+	for _, addr := range s.AddrsV6 {
+		b.WriteString(" ipv6_addrs=" + addr.String())
+	}
+	for _, txt := range s.InfoFields {
+		b.WriteString(" txt=" + strconv.Quote(txt))
+	}
+	if s.SrcIP != nil {
+		b.WriteString(" source=" + ipString(s.SrcIP))
+	}
+	if s.ReceivedOn != "" {
+		b.WriteString(" received_on=" + strconv.Quote(s.ReceivedOn))
+	}
+	if s.QueryID != "" {
+		b.WriteString(" query_id=" + strconv.Quote(s.QueryID))
+	}
+	if s.TTL != 0 {
+		b.WriteString(" ttl=" + strconv.FormatUint(uint64(s.TTL), 10))
+	}
+	if !s.ExpiresAt.IsZero() {
+		b.WriteString(" expires=" + timeString(s.ExpiresAt))
+	}
+	return []byte(b.String()), nil
+}
+
+// Key returns a key identifying the entry's instance, for indexing entries in
+// maps: its name in lower case, fully qualified, and with only dots and
+// backslashes escaped. Entries of the same instance have the same key
+// whichever response they were built from, and updates to the instance do
+// not change it; a renamed instance is a different instance.
+func (s *ServiceEntry) Key() string {
+	return canonicalName(s.Name)
+}
+
+// TXTMap returns the key/value pairs of the entry's TXT record, following
+// RFC 6763, section 6: keys are case-insensitive and returned in lower case,
+// only the first of several strings with the same key counts, and strings
+// with an empty key are ignored. A boolean attribute, a key without "=", maps
+// to an empty value like "key=" does; use TXTFlag to tell them apart. The map
+// is a copy the caller may modify.
+func (s *ServiceEntry) TXTMap() map[string]string {
+	txt := s.txt
+	if txt == nil {
+		// The entry was not built from received records, e.g. it was
+		// decoded from JSON
+		txt = parseTXT(s.InfoFields)
+	}
+	m := make(map[string]string, len(txt))
+	for k, v := range txt {
+		m[k] = v
+	}
+	return m
+}
+
+// TXTFlag reports whether the entry's TXT record has the key, and whether it
+// has a value, even an empty one, following the rules of TXTMap: a boolean
+// attribute, "key" alone, is present without a value, while "key=" is present
+// with an empty value, see RFC 6763, section 6.4.
+func (s *ServiceEntry) TXTFlag(key string) (present, hasValue bool) {
+	for _, field := range s.InfoFields {
+		k, _, found := strings.Cut(field, "=")
+		if k != "" && strings.EqualFold(k, key) {
+			return true, found
+		}
+	}
+	return false, false
+}
+
+// parseTXT parses the strings of a TXT record into key/value pairs, see
+// TXTMap.
+func parseTXT(fields []string) map[string]string {
+	m := make(map[string]string, len(fields))
+	for _, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		if key == "" {
+			continue
+		}
+		key = strings.ToLower(key)
+		if _, ok := m[key]; !ok {
+			m[key] = value
+		}
+	}
+	return m
+}
+
+// ipv6String returns the IPv6 address of the entry with its zone, if any.
+func (s *ServiceEntry) ipv6String() string {
+	if s.AddrV6IPAddr != nil {
+		return s.AddrV6IPAddr.String()
+	}
+	return ipString(s.AddrV6)
+}
+
+// ipString formats an address, returning "" for a nil one.
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// ipStrings formats addresses, returning nil for none.
+func ipStrings(ips []net.IP) []string {
+	var out []string
+	for _, ip := range ips {
+		out = append(out, ipString(ip))
+	}
+	return out
+}
+
+// ipAddrStrings formats addresses with their zones, returning nil for none.
+func ipAddrStrings(addrs []net.IPAddr) []string {
+	var out []string
+	for _, a := range addrs {
+		out = append(out, a.String())
+	}
+	return out
+}
+
+// parseIP parses an address field, returning nil for an empty one.
+func parseIP(field, s string) (net.IP, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("mdns: invalid %s address %q", field, s)
+	}
+	return ip, nil
+}
+
+// timeString formats t in RFC 3339, or returns "" for the zero time.
+func timeString(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// SortEntries sorts entries by service, instance name, and host, ignoring
+// case, so that results collected from the network come out in the same
+// order regardless of when the responses arrived.
+func SortEntries(entries []*ServiceEntry) {
+	type key struct{ service, instance, host string }
+	keys := make(map[*ServiceEntry]key, len(entries))
+	for _, e := range entries {
+		instance, service, domain, err := SplitInstanceName(e.Name)
+		if err != nil {
+			instance = e.Name
+		}
+		keys[e] = key{
+			service:  strings.ToLower(service + "." + domain),
+			instance: strings.ToLower(instance),
+			host:     strings.ToLower(e.Host),
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := keys[entries[i]], keys[entries[j]]
+		if a.service != b.service {
+			return a.service < b.service
+		}
+		if a.instance != b.instance {
+			return a.instance < b.instance
+		}
+		return a.host < b.host
+	})
+}
+
+// MergeEntries combines the entries of the same instance, as identified by
+// Key, into one entry holding the addresses of all of them. This is for
+// results gathered by several queries or Clients, such as one per interface
+// or IP version, where a device answering on each is otherwise seen once per
+// query. The entries are returned in the order their instances first appear.
+//
+// Later entries take precedence: their host, port, TXT record, source, and
+// TTLs are kept, and the fields they lack are taken from earlier entries. The
+// entries passed are not modified.
+func MergeEntries(entries []*ServiceEntry) []*ServiceEntry {
+	merged := make(map[string]*ServiceEntry, len(entries))
+	var out []*ServiceEntry
+	for _, e := range entries {
+		key := e.Key()
+		m, ok := merged[key]
+		if !ok {
+			m = &ServiceEntry{}
+			merged[key] = m
+			out = append(out, m)
+		}
+		m.merge(e)
+	}
+	return out
+}
+
+// merge updates the entry with a later entry of the same instance.
+func (s *ServiceEntry) merge(later *ServiceEntry) {
+	prev := *s
+	*s = *later
+	if s.Host == "" {
+		s.Host, s.Port, s.Priority, s.Weight, s.TTL = prev.Host, prev.Port, prev.Priority, prev.Weight, prev.TTL
+	}
+	if !s.hasTXT && s.InfoFields == nil {
+		s.Info, s.InfoFields, s.hasTXT, s.txt = prev.Info, prev.InfoFields, prev.hasTXT, prev.txt
+	}
+	if s.SrcIP == nil {
+		s.SrcIP, s.ReceivedOn = prev.SrcIP, prev.ReceivedOn
+	}
+	if s.QueryID == "" {
+		s.QueryID = prev.QueryID
+	}
+	if s.ExpiresAt.IsZero() {
+		s.TTLs, s.ExpiresAt = prev.TTLs, prev.ExpiresAt
+	}
+
+	// The addresses of the later entry come last, so that AddrV4 and
+	// AddrV6IPAddr remain the last of the lists
+	var v4s []net.IP
+	for _, ip := range prev.addrsV4() {
+		if !containsIP(later.addrsV4(), ip) {
+			v4s = append(v4s, ip)
+		}
+	}
+	v4s = append(v4s, later.addrsV4()...)
+	var v6s []net.IPAddr
+	for _, addr := range prev.addrsV6() {
+		if !containsIPAddr(later.addrsV6(), addr) {
+			v6s = append(v6s, addr)
+		}
+	}
+	v6s = append(v6s, later.addrsV6()...)
+	s.AddrsV4, s.AddrsV6 = v4s, v6s
+	s.AddrV4, s.AddrV6, s.AddrV6IPAddr = nil, nil, nil
+	if len(v4s) > 0 {
+		s.AddrV4 = v4s[len(v4s)-1]
+		s.Addr = s.AddrV4 // @Deprecated
+	}
+	if len(v6s) > 0 {
+		last := v6s[len(v6s)-1]
+		s.AddrV6 = last.IP // @Deprecated
+		s.AddrV6IPAddr = &last
+		s.Addr = last.IP // @Deprecated
+	}
+}
+
+// addrsV4 returns the IPv4 addresses of the entry, falling back on AddrV4
+// for entries built without the list.
+func (s *ServiceEntry) addrsV4() []net.IP {
+	if len(s.AddrsV4) == 0 && s.AddrV4 != nil {
+		return []net.IP{s.AddrV4}
+	}
+	return s.AddrsV4
+}
+
+// addrsV6 returns the IPv6 addresses of the entry, like addrsV4.
+func (s *ServiceEntry) addrsV6() []net.IPAddr {
+	if len(s.AddrsV6) == 0 && s.AddrV6IPAddr != nil {
+		return []net.IPAddr{*s.AddrV6IPAddr}
+	}
+	return s.AddrsV6
+}
+
+// containsIPAddr reports whether addrs contains addr, zone included.
+func containsIPAddr(addrs []net.IPAddr, addr net.IPAddr) bool {
+	for _, a := range addrs {
+		if a.IP.Equal(addr.IP) && a.Zone == addr.Zone {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,296 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func makeEntry() *ServiceEntry {
+	v6 := net.ParseIP("fe80::1")
+	return &ServiceEntry{
+		Name:         "My Printer._ipp._tcp.local.",
+		Host:         "printer.local.",
+		AddrV4:       net.ParseIP("192.168.1.10"),
+		AddrV6:       v6,
+		AddrV6IPAddr: &net.IPAddr{IP: v6, Zone: "eth0"},
+		Addr:         v6,
+		Port:         631,
+		Info:         "txtvers=1|rp=printer",
+		InfoFields:   []string{"txtvers=1", "rp=printer"},
+		SrcIP:        net.ParseIP("192.168.1.10"),
+		QueryID:      "printers",
+		TTL:          120,
+		hasTXT:       true,
+	}
+}
+
+func TestServiceEntry_JSON(t *testing.T) {
+	e := makeEntry()
+	buf, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	want := `{"name":"My Printer._ipp._tcp.local.","host":"printer.local.","port":631,"ipv4":"192.168.1.10","ipv6":"fe80::1%eth0","txt":["txtvers=1","rp=printer"],"source":"192.168.1.10","query_id":"printers","ttl":120}`
+	if string(buf) != want {
+		t.Fatalf("got %s\nwant %s", buf, want)
+	}
+
+	var out ServiceEntry
+	if err := json.Unmarshal(buf, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(&out, e) {
+		t.Fatalf("round trip mismatch:\ngot  %+v\nwant %+v", out, *e)
+	}
+
+	if err := json.Unmarshal([]byte(`{"name":"x","ipv4":"bogus"}`), &out); err == nil {
+		t.Fatalf("expected an error for an invalid address")
+	}
+}
+
+func TestServiceEntry_JSONAddrs(t *testing.T) {
+	e := makeEntry()
+	e.AddrsV4 = []net.IP{net.ParseIP("10.0.0.10"), e.AddrV4}
+	e.AddrsV6 = []net.IPAddr{{IP: net.ParseIP("2001:db8::1")}, *e.AddrV6IPAddr}
+	buf, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !strings.Contains(string(buf), `"ipv4_addrs":["10.0.0.10","192.168.1.10"],"ipv6_addrs":["2001:db8::1","fe80::1%eth0"]`) {
+		t.Fatalf("bad: %s", buf)
+	}
+	var out ServiceEntry
+	if err := json.Unmarshal(buf, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(&out, e) {
+		t.Fatalf("round trip mismatch:\ngot  %+v\nwant %+v", out, *e)
+	}
+	if text, _ := e.MarshalText(); !strings.Contains(string(text), " ipv4_addrs=10.0.0.10 ipv4_addrs=192.168.1.10 ipv6_addrs=2001:db8::1 ipv6_addrs=fe80::1%eth0 ") {
+		t.Fatalf("bad: %s", text)
+	}
+}
+
+func TestServiceEntry_JSONPriority(t *testing.T) {
+	e := makeEntry()
+	e.Priority, e.Weight = 10, 3
+	buf, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !strings.Contains(string(buf), `"port":631,"priority":10,"weight":3,`) {
+		t.Fatalf("bad: %s", buf)
+	}
+	var out ServiceEntry
+	if err := json.Unmarshal(buf, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.Priority != 10 || out.Weight != 3 {
+		t.Fatalf("bad: %+v", out)
+	}
+	if text, _ := e.MarshalText(); !strings.Contains(string(text), " port=631 priority=10 weight=3 ") {
+		t.Fatalf("bad: %s", text)
+	}
+}
+
+func TestServiceEntry_JSONReceivedOn(t *testing.T) {
+	e := makeEntry()
+	e.ReceivedOn = "eth0"
+	buf, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !strings.Contains(string(buf), `"source":"192.168.1.10","received_on":"eth0",`) {
+		t.Fatalf("bad: %s", buf)
+	}
+	var out ServiceEntry
+	if err := json.Unmarshal(buf, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.ReceivedOn != "eth0" {
+		t.Fatalf("bad: %+v", out)
+	}
+	if text, _ := e.MarshalText(); !strings.Contains(string(text), ` source=192.168.1.10 received_on="eth0" `) {
+		t.Fatalf("bad: %s", text)
+	}
+}
+
+func TestServiceEntry_JSONExpires(t *testing.T) {
+	e := makeEntry()
+	e.TTLs = RecordTTLs{PTR: 4500 * time.Second, SRV: 120 * time.Second, TXT: 4500 * time.Second, A: 120 * time.Second}
+	e.ExpiresAt = time.Date(2024, 5, 1, 12, 2, 0, 0, time.UTC)
+	buf, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !strings.HasSuffix(string(buf), `"ttl":120,"ttls":{"ptr":4500,"srv":120,"txt":4500,"a":120},"expires":"2024-05-01T12:02:00Z"}`) {
+		t.Fatalf("bad: %s", buf)
+	}
+	var out ServiceEntry
+	if err := json.Unmarshal(buf, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(&out, e) {
+		t.Fatalf("round trip mismatch:\ngot  %+v\nwant %+v", out, *e)
+	}
+	if err := json.Unmarshal([]byte(`{"name":"x","expires":"soon"}`), &out); err == nil {
+		t.Fatalf("expected an error for an invalid expiry")
+	}
+}
+
+func TestServiceEntry_Text(t *testing.T) {
+	buf, err := makeEntry().MarshalText()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	want := `name="My Printer._ipp._tcp.local." host="printer.local." port=631 ipv4=192.168.1.10 ipv6=fe80::1%eth0 txt="txtvers=1" txt="rp=printer" source=192.168.1.10 query_id="printers" ttl=120`
+	if string(buf) != want {
+		t.Fatalf("got %s\nwant %s", buf, want)
+	}
+}
+
+func TestSortEntries(t *testing.T) {
+	entries := []*ServiceEntry{
+		{Name: "b._ipp._tcp.local.", Host: "h1.local."},
+		{Name: "A._ipp._tcp.local.", Host: "h2.local."},
+		{Name: "a._http._tcp.local.", Host: "h3.local."},
+		{Name: "a._ipp._tcp.local.", Host: "h1.local."},
+	}
+	SortEntries(entries)
+
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name+" "+e.Host)
+	}
+	want := []string{
+		"a._http._tcp.local. h3.local.",
+		"a._ipp._tcp.local. h1.local.",
+		"A._ipp._tcp.local. h2.local.",
+		"b._ipp._tcp.local. h1.local.",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestServiceEntry_TXTMap(t *testing.T) {
+	e := &ServiceEntry{InfoFields: []string{"txtvers=1", "Path=/index", "path=/other", "secure", "empty=", "=ignored", "note=a=b"}}
+	want := map[string]string{"txtvers": "1", "path": "/index", "secure": "", "empty": "", "note": "a=b"}
+	got := e.TXTMap()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// Boolean attributes are told apart from empty values
+	for key, want := range map[string][2]bool{"secure": {true, false}, "EMPTY": {true, true}, "path": {true, true}, "missing": {}, "": {}} {
+		if present, hasValue := e.TXTFlag(key); present != want[0] || hasValue != want[1] {
+			t.Fatalf("TXTFlag(%q) = %v, %v, want %v", key, present, hasValue, want)
+		}
+	}
+
+	// The map is a copy
+	got["txtvers"] = "2"
+	if e.TXTMap()["txtvers"] != "1" {
+		t.Fatalf("map shared with the entry")
+	}
+
+	// Entries built from received records are parsed when cached
+	c := newCache()
+	c.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: "x._http._tcp.local.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 120},
+		Txt: []string{"A=1"},
+	}}}, nil)
+	if cached := c.entry("x._http._tcp.local."); cached == nil || cached.txt["a"] != "1" {
+		t.Fatalf("bad: %+v", cached)
+	}
+
+	// Decoded entries are parsed too
+	var decoded ServiceEntry
+	if err := json.Unmarshal([]byte(`{"name":"x._http._tcp.local.","txt":["A=1"]}`), &decoded); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if v, ok := decoded.TXTMap()["a"]; !ok || v != "1" {
+		t.Fatalf("bad: %v", decoded.TXTMap())
+	}
+}
+
+func TestServiceEntry_Key(t *testing.T) {
+	key := (&ServiceEntry{Name: InstanceName("Living Room.2", "_airplay._tcp", "local")}).Key()
+	if want := `living room\.2._airplay._tcp.local.`; key != want {
+		t.Fatalf("got %q, want %q", key, want)
+	}
+	// The same instance, escaped and capitalized differently
+	for _, name := range []string{
+		`Living\032Room\0462._AirPlay._tcp.local.`,
+		`living\ room\.2._airplay._tcp.local`,
+	} {
+		if got := (&ServiceEntry{Name: name}).Key(); got != key {
+			t.Fatalf("%q: got %q, want %q", name, got, key)
+		}
+	}
+	if got := (&ServiceEntry{Name: `Living Room2._airplay._tcp.local.`}).Key(); got == key {
+		t.Fatalf("distinct instances share key %q", got)
+	}
+}
+
+func TestMergeEntries(t *testing.T) {
+	// The same printer, answering on two interfaces and IP versions
+	v4 := &ServiceEntry{
+		Name:       "My Printer._ipp._tcp.local.",
+		Host:       "printer.local.",
+		Port:       631,
+		AddrV4:     net.ParseIP("192.168.1.10"),
+		AddrsV4:    []net.IP{net.ParseIP("192.168.1.10")},
+		InfoFields: []string{"txtvers=1"},
+		SrcIP:      net.ParseIP("192.168.1.10"),
+		ReceivedOn: "eth0",
+		hasTXT:     true,
+	}
+	v6 := &ServiceEntry{
+		Name:         "my printer._ipp._tcp.local",
+		AddrV6:       net.ParseIP("fe80::1"),
+		AddrV6IPAddr: &net.IPAddr{IP: net.ParseIP("fe80::1"), Zone: "wlan0"},
+		SrcIP:        net.ParseIP("fe80::1"),
+		ReceivedOn:   "wlan0",
+	}
+	other := &ServiceEntry{Name: "Scanner._ipp._tcp.local.", Port: 80}
+
+	out := MergeEntries([]*ServiceEntry{v4, other, v6})
+	if len(out) != 2 || out[1].Name != "Scanner._ipp._tcp.local." {
+		t.Fatalf("bad: %v", out)
+	}
+	e := out[0]
+	if e.Host != "printer.local." || e.Port != 631 || len(e.InfoFields) != 1 {
+		t.Fatalf("lost the records of the first entry: %+v", e)
+	}
+	if !e.AddrV4.Equal(net.ParseIP("192.168.1.10")) || len(e.AddrsV4) != 1 {
+		t.Fatalf("bad IPv4: %+v", e)
+	}
+	if e.AddrV6IPAddr == nil || e.AddrV6IPAddr.Zone != "wlan0" || len(e.AddrsV6) != 1 {
+		t.Fatalf("bad IPv6: %+v", e)
+	}
+	if e.ReceivedOn != "wlan0" || !e.SrcIP.Equal(net.ParseIP("fe80::1")) {
+		t.Fatalf("the latest source should be kept: %+v", e)
+	}
+	if v4.AddrV6IPAddr != nil || v6.Port != 0 {
+		t.Fatalf("inputs modified")
+	}
+
+	// Addresses seen again move to the end of the lists
+	again := *v4
+	again.AddrV4 = net.ParseIP("10.0.0.10")
+	again.AddrsV4 = []net.IP{net.ParseIP("10.0.0.10")}
+	out = MergeEntries([]*ServiceEntry{v4, &again, v4})
+	if got := out[0].AddrsV4; len(got) != 2 || !got[1].Equal(net.ParseIP("192.168.1.10")) || !out[0].AddrV4.Equal(got[1]) {
+		t.Fatalf("bad: %v", got)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"time"
+)
+
+// queryEvents reports the changes to the entries of the queries with Events,
+// as a browse does, with a browseState for each Events channel.
+type queryEvents struct {
+	states        map[chan<- BrowseEvent]*browseState
+	order         []*browseState // in the order of the queries
+	removeOnClose map[*browseState]bool
+}
+
+// newQueryEvents returns the queryEvents of the queries for the instances in
+// cache, which send their events, stamped with the time of clk, until ctx is
+// done or closed is.
+func newQueryEvents(ctx context.Context, clk clock, cache *cache, params []QueryParam, closed <-chan struct{}) *queryEvents {
+	q := &queryEvents{
+		states:        make(map[chan<- BrowseEvent]*browseState),
+		removeOnClose: make(map[*browseState]bool),
+	}
+	for _, par := range params {
+		if par.Events == nil {
+			continue
+		}
+		b, ok := q.states[par.Events]
+		if !ok {
+			b = newBrowseState(ctx, clk, cache, par.Events, nil, closed)
+			q.states[par.Events] = b
+			q.order = append(q.order, b)
+		}
+		if par.RemoveOnClose {
+			q.removeOnClose[b] = true
+		}
+	}
+	return q
+}
+
+// active reports whether any query asks for events.
+func (q *queryEvents) active() bool {
+	return len(q.order) > 0
+}
+
+// observe reports an entry that was sent, or that changed since it was last
+// reported.
+func (q *queryEvents) observe(inp *ServiceEntry) {
+	if !inp.sent || inp.events == nil {
+		return
+	}
+	if b := q.states[inp.events]; b != nil {
+		b.deliver(inp)
+	}
+}
+
+// sweep reports the entries reported whose instance no PTR record names any
+// more, by a goodbye or because it expired, and forgets them so that an
+// instance coming back is found again.
+func (q *queryEvents) sweep(c *cache, inprogress map[string]*ServiceEntry, now time.Time) {
+	for _, b := range q.order {
+		for key, inp := range inprogress {
+			if _, ok := b.delivered[inp]; ok && len(c.instancePTRs(inp.Name)) == 0 {
+				delete(inprogress, key)
+				b.remove(inp, now)
+			}
+		}
+		b.expire(now)
+	}
+}
+
+// pending returns the outbox of the first channel with events queued, or nil
+// if none has, for use in a select.
+func (q *queryEvents) pending() *outbox[BrowseEvent] {
+	for _, b := range q.order {
+		if b.out.ready() != nil {
+			return b.out
+		}
+	}
+	return nil
+}
+
+// close reports the instances still known as removed where the query asks
+// for it, see QueryParam.RemoveOnClose, and sends the events still queued.
+func (q *queryEvents) close() {
+	for _, b := range q.order {
+		if q.removeOnClose[b] {
+			b.close()
+		}
+		b.out.flush()
+	}
+}
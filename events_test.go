@@ -0,0 +1,154 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestClient_QueryEvents(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	entries := make(chan *ServiceEntry, 16)
+	events := make(chan BrowseEvent, 16)
+	params := []QueryParam{{Service: "_events._tcp", Timeout: 8 * time.Second, Events: events}}
+	go QueryContext(ctx, &params, entries, c)
+	next := func(want BrowseEventType) *ServiceEntry {
+		t.Helper()
+		select {
+		case ev := <-events:
+			if ev.Type != want || ev.Entry.Name != "hostname._events._tcp.local." {
+				t.Fatalf("got %v %+v, want %v", ev.Type, ev.Entry, want)
+			}
+			return ev.Entry
+		case <-ctx.Done():
+			t.Fatalf("missing %v event", want)
+		}
+		return nil
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	service := makeServiceWithServiceName(t, "_events._tcp")
+	if err := serv.Register(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if e := next(BrowseAdded); e.Port != 80 {
+		t.Fatalf("bad: %+v", e)
+	}
+	if e := <-entries; e.Port != 80 {
+		t.Fatalf("bad: %+v", e)
+	}
+
+	// A record replacing the SRV record updates the entry
+	srv := &dns.SRV{
+		Hdr:    dns.RR_Header{Name: "hostname._events._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET | cacheFlushBit, Ttl: 120},
+		Target: "testhost.",
+		Port:   8080,
+	}
+	c.handleMsg(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{srv}}, &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5353}, 0)
+	if e := next(BrowseUpdated); e.Port != 8080 {
+		t.Fatalf("bad: %+v", e)
+	}
+
+	// Goodbyes remove it
+	if err := serv.Deregister(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if e := next(BrowseRemoved); e.Port != 8080 {
+		t.Fatalf("bad: %+v", e)
+	}
+
+	// It is added again when it comes back
+	if err := serv.Register(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	next(BrowseAdded)
+}
+
+func TestClient_QueryRemoveOnClose(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	events := make(chan BrowseEvent, 16)
+	params := []QueryParam{{Service: "_http._tcp", Timeout: 200 * time.Millisecond, Events: events, RemoveOnClose: true}}
+	if err := QueryContext(context.Background(), &params, make(chan *ServiceEntry, 16), c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var got []BrowseEventType
+	for len(events) > 0 {
+		got = append(got, (<-events).Type)
+	}
+	if len(got) != 2 || got[0] != BrowseAdded || got[1] != BrowseRemoved {
+		t.Fatalf("bad: %v", got)
+	}
+}
+
+func TestClient_QueryEventsDelivery(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	// Events are queued for a consumer that is not ready, rather than dropped
+	events := make(chan BrowseEvent)
+	params := []QueryParam{{
+		Service:        "_http._tcp",
+		Timeout:        200 * time.Millisecond,
+		Events:         events,
+		RemoveOnClose:  true,
+		Delivery:       DeliverBuffer,
+		DeliveryBuffer: 4,
+	}}
+	done := make(chan error, 1)
+	go func() { done <- QueryContext(context.Background(), &params, make(chan *ServiceEntry, 16), c) }()
+	time.Sleep(300 * time.Millisecond)
+	for _, want := range []BrowseEventType{BrowseAdded, BrowseRemoved} {
+		select {
+		case ev := <-events:
+			if ev.Type != want {
+				t.Fatalf("got %v, want %v", ev.Type, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("missing %v event", want)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
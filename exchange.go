@@ -0,0 +1,113 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// beginLookup starts a one-shot lookup, such as ResolveHost: ctx is given the
+// default timeout of 3 seconds unless it has a deadline of its own, and a
+// query slot is waited for. The returned function releases both.
+func (c *Client) beginLookup(ctx context.Context) (context.Context, func(), error) {
+	var cancel context.CancelFunc
+	if _, ok := ctx.Deadline(); ok {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, resolveInstanceTimeout)
+	}
+	if err := c.acquireQuery(ctx); err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return ctx, func() {
+		c.releaseQuery()
+		cancel()
+	}, nil
+}
+
+// exchange is the question of a one-shot lookup, retransmitted at increasing
+// intervals until the lookup is done, see RFC 6762, section 5.2.
+type exchange struct {
+	c        *Client
+	sub      *subscription
+	what     string          // what is asked, for logging
+	question func() *dns.Msg // asks for what is still missing
+	schedule *retransmission
+	timer    timer
+}
+
+// ask sends a question and returns its exchange, which the caller must close.
+// The responses are subscribed to before the question is sent, so that none
+// is missed.
+func (c *Client) ask(ctx context.Context, what string, question func() *dns.Msg) (*exchange, error) {
+	x := &exchange{c: c, sub: c.subscribe(), what: what, question: question}
+	if err := c.sendQuery(ctx, x.next(), allStacks, nil); err != nil {
+		c.unsubscribe(x.sub)
+		return nil, err
+	}
+	x.schedule = newRetransmission(QueryParam{}, c.clk().Now())
+	x.timer = c.clk().NewTimer(x.schedule.next.Sub(c.clk().Now()))
+	return x, nil
+}
+
+// responses returns the channel receiving the messages of the exchange.
+func (x *exchange) responses() <-chan *msgAddr {
+	return x.sub.ch
+}
+
+// due returns the channel receiving the time once the question is due to be
+// retransmitted, see retransmit.
+func (x *exchange) due() <-chan time.Time {
+	return x.timer.Chan()
+}
+
+// retransmit sends the question again once it is due, and schedules the next
+// retransmission.
+func (x *exchange) retransmit(ctx context.Context) {
+	x.send(ctx)
+	x.schedule.advance(x.c.clk().Now())
+	resetAt(x.c.clk(), x.timer, x.schedule.next)
+}
+
+// send sends the question at once, as when what it asks for changed.
+func (x *exchange) send(ctx context.Context) {
+	if err := x.c.sendQuery(ctx, x.next(), allStacks, nil); err != nil {
+		x.c.log.Printf("[ERR] mdns: Failed to query %s: %v", x.what, err)
+	}
+}
+
+// next returns the question to send, recording the names it asks about.
+func (x *exchange) next() *dns.Msg {
+	q := x.question()
+	for _, question := range q.Question {
+		x.c.asking(x.sub, question.Name)
+	}
+	return q
+}
+
+// close stops the exchange.
+func (x *exchange) close() {
+	x.timer.Stop()
+	x.c.unsubscribe(x.sub)
+}
+
+// askQuestions sends the questions of queries, browses, and the like, which
+// retransmit them on schedules of their own, see retransmissions. It returns
+// the subscription to their responses, which the caller must unsubscribe,
+// and which is made before the questions are sent so that none is missed.
+func (c *Client) askQuestions(ctx context.Context, params []QueryParam) (*subscription, error) {
+	sub := c.subscribe()
+	for _, par := range params {
+		c.asking(sub, ServiceName(par.Service, par.Domain))
+	}
+	if err := c.sendQuestions(ctx, params, false); err != nil {
+		c.unsubscribe(sub)
+		return nil, err
+	}
+	return sub, nil
+}
@@ -0,0 +1,200 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// defaultFailoverInterval is how often the carrier of the active
+	// interface is checked by default.
+	defaultFailoverInterval = 5 * time.Second
+)
+
+// FailoverConfig configures automatic failover between interfaces.
+type FailoverConfig struct {
+	// Interfaces are the candidates to fail over to, in order of preference.
+	// If empty, every multicast-capable interface is a candidate.
+	Interfaces []net.Interface
+
+	// Interval is how often the active interface is checked, default 5
+	// seconds.
+	Interval time.Duration
+
+	// OnFailover, if set, is called after every failover.
+	OnFailover func(FailoverEvent)
+}
+
+// FailoverEvent describes a failover from one interface to another.
+type FailoverEvent struct {
+	From *net.Interface // Interface that lost carrier
+	To   *net.Interface // Interface now in use
+	Time time.Time      // When the failover happened
+	Err  error          // Non-nil if switching to To failed
+}
+
+// EnableFailover starts monitoring the Client's interface. When it goes down
+// or loses carrier, the Client switches to the first eligible candidate,
+// joins the mDNS group on it, and re-issues the questions of every active
+// query. Failover requires the Client to use an explicit interface, set with
+// NewClient or SetInterface; it is a no-op while the system default is used,
+// or while queries are sent on several interfaces, see SetInterfaces. The
+// monitoring runs until the returned function is called or the Client is
+// closed; enabling it again replaces it.
+func (c *Client) EnableFailover(cfg FailoverConfig) (stop func()) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultFailoverInterval
+	}
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() { close(done) })
+	}
+	c.mu.Lock()
+	prev := c.failover
+	c.failover = stop
+	c.mu.Unlock()
+	if prev != nil {
+		prev()
+	}
+	go c.monitorFailover(cfg, done)
+	return stop
+}
+
+// monitorFailover periodically checks the active interface until done is
+// closed or the Client is.
+func (c *Client) monitorFailover(cfg FailoverConfig, done <-chan struct{}) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.checkFailover(cfg)
+		case <-done:
+			return
+		case <-c.closedCh:
+			return
+		}
+	}
+}
+
+// checkFailover fails over to another interface if the active one is down.
+func (c *Client) checkFailover(cfg FailoverConfig) {
+	c.mu.Lock()
+	current := c.iface
+	fanout := len(c.ifaces) > 0
+	c.mu.Unlock()
+	if current == nil || fanout {
+		return
+	}
+	if ifi, err := net.InterfaceByIndex(current.Index); err == nil && !interfaceFailed(ifi) {
+		return
+	}
+
+	candidates := cfg.Interfaces
+	if len(candidates) == 0 {
+		var err error
+		if candidates, err = net.Interfaces(); err != nil {
+			c.log.Printf("[ERR] mdns: Failed to list interfaces for failover: %v", err)
+			return
+		}
+	}
+	next := nextInterface(current, candidates)
+	if next == nil {
+		return
+	}
+
+	event := FailoverEvent{From: current, To: next, Time: time.Now()}
+	if err := c.SetInterface(next); err != nil {
+		event.Err = err
+	} else {
+		c.joinGroups(next)
+		c.resendQueries()
+	}
+	if event.Err != nil {
+		c.log.Printf("[ERR] mdns: Failed to fail over from %s to %s: %v", current.Name, next.Name, event.Err)
+	} else {
+		c.log.Printf("[INFO] mdns: Failed over from %s to %s", current.Name, next.Name)
+	}
+	if cfg.OnFailover != nil {
+		cfg.OnFailover(event)
+	}
+}
+
+// interfaceFailed reports whether the active interface went down or lost
+// carrier. Other flags, such as that of a loopback interface a Client was
+// deliberately set to, are not failures.
+func interfaceFailed(ifi *net.Interface) bool {
+	return ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagRunning == 0
+}
+
+// eligibleInterface reports whether an interface can carry mDNS traffic: it
+// is up, has carrier, and supports multicast.
+func eligibleInterface(ifi *net.Interface) bool {
+	return ifi.Flags&net.FlagUp != 0 &&
+		ifi.Flags&net.FlagRunning != 0 &&
+		ifi.Flags&net.FlagMulticast != 0 &&
+		ifi.Flags&net.FlagLoopback == 0
+}
+
+// nextInterface returns the first eligible candidate other than current, or
+// nil if there is none.
+func nextInterface(current *net.Interface, candidates []net.Interface) *net.Interface {
+	for i := range candidates {
+		ifi := &candidates[i]
+		if ifi.Index != current.Index && eligibleInterface(ifi) {
+			return ifi
+		}
+	}
+	return nil
+}
+
+// joinGroups joins the mDNS multicast groups on an interface, so that the
+// multicast listeners receive its traffic, after a failover or when queries
+// are sent on several interfaces, or nil for the system default. Groups
+// already joined are left as they are.
+func (c *Client) joinGroups(iface *net.Interface) {
+	cc := c.conns()
+	if isSocket(cc.multicast4) {
+		p := ipv4.NewPacketConn(cc.multicast4)
+		if err := p.JoinGroup(iface, ipv4Addr); err != nil && !errors.Is(err, syscall.EADDRINUSE) {
+			c.log.Printf("[ERR] mdns: Failed to join IPv4 group on %s: %v", interfaceLabel(iface), err)
+		}
+	}
+	if isSocket(cc.multicast6) {
+		p := ipv6.NewPacketConn(cc.multicast6)
+		if err := p.JoinGroup(iface, ipv6Addr); err != nil && !errors.Is(err, syscall.EADDRINUSE) {
+			c.log.Printf("[ERR] mdns: Failed to join IPv6 group on %s: %v", interfaceLabel(iface), err)
+		}
+	}
+}
+
+// interfaceLabel names an interface in log messages, nil being the system
+// default.
+func interfaceLabel(iface *net.Interface) string {
+	if iface == nil {
+		return "default interface"
+	}
+	return iface.Name
+}
+
+// resendQueries asks every active query to re-issue its questions.
+func (c *Client) resendQueries() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for sub := range c.subs {
+		select {
+		case sub.resend <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestNextInterface(t *testing.T) {
+	up := net.FlagUp | net.FlagRunning | net.FlagMulticast
+	current := &net.Interface{Index: 2, Name: "wlan0", Flags: up}
+	candidates := []net.Interface{
+		{Index: 1, Name: "lo", Flags: up | net.FlagLoopback},
+		{Index: 2, Name: "wlan0", Flags: up},
+		{Index: 3, Name: "eth0", Flags: net.FlagUp | net.FlagMulticast}, // no carrier
+		{Index: 4, Name: "eth1", Flags: up},
+	}
+	if got := nextInterface(current, candidates); got == nil || got.Name != "eth1" {
+		t.Fatalf("got %v, want eth1", got)
+	}
+	if got := nextInterface(current, candidates[:3]); got != nil {
+		t.Fatalf("got %v, want none", got)
+	}
+}
+
+func TestInterfaceFailed(t *testing.T) {
+	up := net.FlagUp | net.FlagRunning
+	for _, test := range []struct {
+		flags  net.Flags
+		failed bool
+	}{
+		{up | net.FlagMulticast, false},
+		{up | net.FlagLoopback, false}, // a Client may be set to loopback
+		{up, false},
+		{net.FlagUp | net.FlagMulticast, true}, // no carrier
+		{net.FlagMulticast, true},
+	} {
+		if got := interfaceFailed(&net.Interface{Flags: test.flags}); got != test.failed {
+			t.Errorf("%v: got %v, want %v", test.flags, got, test.failed)
+		}
+	}
+}
+
+func TestClient_ResendQueries(t *testing.T) {
+	c := &Client{}
+	sub := c.subscribe()
+	defer c.unsubscribe(sub)
+
+	// Repeated requests coalesce rather than block
+	c.resendQueries()
+	c.resendQueries()
+	select {
+	case <-sub.resend:
+	default:
+		t.Fatalf("query was not asked to resend")
+	}
+	select {
+	case <-sub.resend:
+		t.Fatalf("resend requests should coalesce")
+	default:
+	}
+}
+
+func TestClient_EnableFailover(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+	gone := testInterface(9001, "gone0", true)
+	if err := c.SetInterface(&gone); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var mu sync.Mutex
+	var failovers []string
+	enable := func(name string) func() {
+		return c.EnableFailover(FailoverConfig{
+			Interval:   10 * time.Millisecond,
+			Interfaces: []net.Interface{testInterface(9002, "spare0", true)},
+			OnFailover: func(FailoverEvent) {
+				mu.Lock()
+				defer mu.Unlock()
+				failovers = append(failovers, name)
+			},
+		})
+	}
+
+	// Enabling failover again replaces the monitoring
+	enable("first")
+	stop := enable("second")
+	defer stop()
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(failovers)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no failover")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failovers) != 1 || failovers[0] != "second" {
+		t.Fatalf("bad: %v", failovers)
+	}
+}
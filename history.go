@@ -0,0 +1,180 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// recentQueryWindow is how long after it was last sent a question still
+// counts as recently asked, see RFC 6762, section 7.3.
+const recentQueryWindow = time.Second
+
+// questionHistory records when the questions for each service were last sent
+// and how many queries for it are running, and when other hosts last asked
+// them.
+type questionHistory struct {
+	mu     sync.Mutex
+	asked  map[string]time.Time       // lower-cased service name to last send
+	active map[string]int             // lower-cased service name to running queries
+	heard  map[heardKey]heardQuestion // questions of other hosts
+}
+
+// heardKey identifies the question for a service heard on an IP version.
+type heardKey struct {
+	name string // lower-cased
+	v6   bool
+}
+
+// heardQuestion is when another host last asked a question, and on which
+// interface, zero if unknown.
+type heardQuestion struct {
+	at      time.Time
+	ifIndex int
+}
+
+// sent records that the question for a service was just sent, and forgets
+// the services that have not been asked about recently.
+func (h *questionHistory) sent(name string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.asked == nil {
+		h.asked = make(map[string]time.Time)
+	}
+	for n, t := range h.asked {
+		if now.Sub(t) >= recentQueryWindow && h.active[n] == 0 {
+			delete(h.asked, n)
+		}
+	}
+	h.asked[strings.ToLower(name)] = now
+}
+
+// start records that a query for a service is running.
+func (h *questionHistory) start(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.active == nil {
+		h.active = make(map[string]int)
+	}
+	h.active[strings.ToLower(name)]++
+}
+
+// done records that a query for a service finished.
+func (h *questionHistory) done(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	name = strings.ToLower(name)
+	if h.active[name]--; h.active[name] <= 0 {
+		delete(h.active, name)
+	}
+}
+
+// heardFrom records that another host just multicast the question for a
+// service, and forgets the questions heard too long ago for running queries.
+func (h *questionHistory) heardFrom(name string, v6 bool, ifIndex int, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.heard == nil {
+		h.heard = make(map[heardKey]heardQuestion)
+	}
+	for k, q := range h.heard {
+		if now.Sub(q.at) >= recentQueryWindow && h.active[k.name] == 0 {
+			delete(h.heard, k)
+		}
+	}
+	h.heard[heardKey{strings.ToLower(name), v6}] = heardQuestion{at: now, ifIndex: ifIndex}
+}
+
+// redundant reports whether other hosts asked the question for a service on
+// every stack of via since it was last sent, on ifi if it is not nil, which
+// makes sending it again a duplicate.
+func (h *questionHistory) redundant(name string, via stacks, ifi *net.Interface) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	name = strings.ToLower(name)
+	last, ok := h.asked[name]
+	if !ok || (!via.v4 && !via.v6) {
+		return false
+	}
+	for _, v6 := range []bool{false, true} {
+		if (v6 && !via.v6) || (!v6 && !via.v4) {
+			continue
+		}
+		q, ok := h.heard[heardKey{name, v6}]
+		if !ok || !q.at.After(last) || (ifi != nil && q.ifIndex != 0 && q.ifIndex != ifi.Index) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup returns when the question for a service was last sent, and whether
+// that was recent or a query for it is still running.
+func (h *questionHistory) lookup(name string, now time.Time) (time.Time, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	name = strings.ToLower(name)
+	last, ok := h.asked[name]
+	if !ok {
+		return time.Time{}, false
+	}
+	return last, h.active[name] > 0 || now.Sub(last) < recentQueryWindow
+}
+
+// RecentlyQueried reports whether the Client recently asked for a service in
+// a domain, either because a query for it is running or because its question
+// was sent within the last second, and when the question was last sent. Layers
+// that issue lookups on behalf of many components can use it to avoid piling
+// redundant queries onto the network. An empty domain means "local".
+func (c *Client) RecentlyQueried(service, domain string) (time.Time, bool) {
+	return c.history.lookup(ServiceName(service, domain), time.Now())
+}
+
+// heardQuery records the questions of a query multicast by another host that
+// a retransmission of ours would duplicate, see RFC 6762, section 7.3: the
+// QM questions for services, provided that its Known-Answer Section lists no
+// record the Client does not have. The Client's own queries, looped back,
+// carry the ID of a query it sent, and legacy queries are answered to their
+// sender only.
+func (c *Client) heardQuery(msg *dns.Msg, src *net.UDPAddr, ifIndex int) {
+	now := time.Now()
+	if src == nil || src.Port != mdnsPort || c.outstanding.match(msg, now) {
+		return
+	}
+	for _, rr := range msg.Answer {
+		if !c.cache.has(rr) {
+			return
+		}
+	}
+	for _, q := range msg.Question {
+		if q.Qtype == dns.TypePTR && q.Qclass&(1<<15) == 0 {
+			c.history.heardFrom(q.Name, src.IP.To4() == nil, ifIndex, now)
+		}
+	}
+}
+
+// withoutDuplicates drops the queries whose question other hosts asked since
+// it was last sent, and records it as sent, as RFC 6762, section 7.3, has a
+// querier do. Queries sending to peers keep their questions, as the peers may
+// not have heard the other hosts.
+func (c *Client) withoutDuplicates(params []QueryParam) []QueryParam {
+	cc := c.conns()
+	now := time.Now()
+	var out []QueryParam
+	for _, par := range params {
+		name := ServiceName(par.Service, par.Domain)
+		via := stacks{v4: cc.v4 && !par.DisableIPv4, v6: cc.v6 && !par.DisableIPv6}
+		if len(par.Peers) == 0 && c.history.redundant(name, via, par.Interface) {
+			c.history.sent(name, now)
+			continue
+		}
+		out = append(out, par)
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestQuestionHistory(t *testing.T) {
+	var h questionHistory
+	now := time.Now()
+	if _, ok := h.lookup("_foo._tcp.local.", now); ok {
+		t.Fatalf("nothing was asked yet")
+	}
+
+	h.sent("_Foo._tcp.local.", now)
+	if last, ok := h.lookup("_foo._tcp.local.", now.Add(500*time.Millisecond)); !ok || !last.Equal(now) {
+		t.Fatalf("bad: %v %v", last, ok)
+	}
+	if _, ok := h.lookup("_foo._tcp.local.", now.Add(recentQueryWindow)); ok {
+		t.Fatalf("question should no longer be recent")
+	}
+
+	// A running query keeps the service recent
+	h.start("_foo._tcp.local.")
+	if _, ok := h.lookup("_foo._tcp.local.", now.Add(time.Hour)); !ok {
+		t.Fatalf("running query should count as recent")
+	}
+	h.done("_foo._tcp.local.")
+
+	// Stale services are forgotten on the next send
+	h.sent("_bar._tcp.local.", now.Add(time.Hour))
+	if _, ok := h.asked["_foo._tcp.local."]; ok {
+		t.Fatalf("stale service was not forgotten")
+	}
+}
+
+func TestClient_RecentlyQueried(t *testing.T) {
+	c := &Client{}
+	if err := c.sendQuestions(context.Background(), []QueryParam{*DefaultParams("_foobar._tcp")}, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, ok := c.RecentlyQueried("_foobar._tcp", "local"); !ok {
+		t.Fatalf("service should have been recently queried")
+	}
+	if _, ok := c.RecentlyQueried("_other._tcp", ""); ok {
+		t.Fatalf("service was never queried")
+	}
+}
+
+func TestClient_DuplicateQuestions(t *testing.T) {
+	c := &Client{use_ipv4: true, cache: newCache(), closedCh: make(chan struct{})}
+	params := []QueryParam{{Service: "_http._tcp", Domain: "local", DisableIPv6: true}}
+	if err := c.sendQuestions(context.Background(), params, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	other := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: mdnsPort}
+	query := func(qclass uint16, known ...dns.RR) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion("_http._tcp.local.", dns.TypePTR)
+		q.Id = 0
+		q.Question[0].Qclass = qclass
+		q.Answer = known
+		return q
+	}
+	known := &dns.PTR{Hdr: dns.RR_Header{Name: "_http._tcp.local.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120}, Ptr: "a._http._tcp.local."}
+
+	// Questions a retransmission would not duplicate: a QU question, one
+	// listing an answer we do not have, one from a legacy querier, and our
+	// own looped back
+	c.handleMsg(query(dns.ClassINET|1<<15), other, 0)
+	c.handleMsg(query(dns.ClassINET, known), other, 0)
+	c.handleMsg(query(dns.ClassINET), &net.UDPAddr{IP: other.IP, Port: 50000}, 0)
+	own := serviceQuestion(params[0], true)
+	c.outstanding.add(own, time.Now())
+	c.handleMsg(own, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: mdnsPort}, 0)
+	if got := c.withoutDuplicates(params); len(got) != 1 {
+		t.Fatalf("retransmission suppressed")
+	}
+
+	// Another host asking the same, with answers we have, counts as our own
+	// retransmission
+	c.cache.insert(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{known}}, other)
+	before, _ := c.RecentlyQueried("_http._tcp", "")
+	time.Sleep(time.Millisecond)
+	c.handleMsg(query(dns.ClassINET, known), other, 0)
+	if got := c.withoutDuplicates(params); len(got) != 0 {
+		t.Fatalf("retransmission not suppressed")
+	}
+	if after, _ := c.RecentlyQueried("_http._tcp", ""); !after.After(before) {
+		t.Fatalf("suppressed retransmission not recorded as sent")
+	}
+	if got := c.withoutDuplicates(params); len(got) != 1 {
+		t.Fatalf("next retransmission suppressed")
+	}
+
+	// Peers have not heard the other host
+	c.handleMsg(query(dns.ClassINET), other, 0)
+	params[0].Peers = []net.IP{net.IPv4(192, 0, 2, 3)}
+	if got := c.withoutDuplicates(params); len(got) != 1 {
+		t.Fatalf("retransmission to peers suppressed")
+	}
+}
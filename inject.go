@@ -0,0 +1,82 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// InjectRecords adds records learned out of band, such as from configuration
+// files, a cloud registry, or a previous run, to the Client's cache, and hands
+// them to the running queries and browses as if they had been received from
+// source, so that applications see the devices found on the network and
+// elsewhere through the same APIs. source is the address of the device the
+// records describe, or nil if unknown, in which case they are accepted by
+// every query and merged into any entry. The records are copied, and expire
+// with their TTL like received records; a TTL of zero removes a record.
+func (c *Client) InjectRecords(records []dns.RR, source net.IP) error {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return ErrClientClosed
+	}
+	msg := &dns.Msg{MsgHdr: dns.MsgHdr{Response: true}}
+	for i, rr := range records {
+		if rr == nil {
+			return fmt.Errorf("mdns: record %d is nil", i)
+		}
+		msg.Answer = append(msg.Answer, dns.Copy(rr))
+	}
+	if len(msg.Answer) == 0 {
+		return nil
+	}
+	src := &net.UDPAddr{IP: source, Port: mdnsPort}
+	usage := c.memoryUsage()
+	c.cache.reserve(usage.reserved())
+	c.cache.insert(msg, src)
+
+	c.injected.push(c, &msgAddr{msg: msg, src: src})
+	return nil
+}
+
+// injectQueue dispatches the injected messages in the order they were
+// injected, from a goroutine of its own, as the caller of InjectRecords may be
+// consuming the results of a query and must not be blocked.
+type injectQueue struct {
+	mu      sync.Mutex
+	pending []*msgAddr
+	running bool // set while a goroutine drains pending
+}
+
+// push queues a message, starting a goroutine to dispatch it unless one is
+// running.
+func (q *injectQueue) push(c *Client, m *msgAddr) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, m)
+	if !q.running {
+		q.running = true
+		go q.drain(c)
+	}
+}
+
+// drain dispatches the queued messages until none is left.
+func (q *injectQueue) drain(c *Client) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		m := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		c.dispatch(m)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestClient_InjectRecords(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events := make(chan BrowseEvent, 16)
+	go c.Browse(ctx, "_http._tcp", events)
+	time.Sleep(100 * time.Millisecond)
+
+	// Nobody on the network publishes the service, but a registry knows it
+	records := makeResponse(t, makeService(t)).Answer
+	if err := c.InjectRecords(records, net.ParseIP("10.0.0.9")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case ev := <-events:
+		if ev.Type != BrowseAdded || ev.Entry.Port != 80 || !ev.Entry.SrcIP.Equal(net.ParseIP("10.0.0.9")) {
+			t.Fatalf("bad: %v %+v", ev.Type, ev.Entry)
+		}
+	case <-ctx.Done():
+		t.Fatalf("injected instance not found")
+	}
+
+	// The records were copied into the cache
+	records[0].Header().Ttl = 0
+	if e := c.cache.entry("hostname._http._tcp.local."); e == nil || e.Host != "testhost." {
+		t.Fatalf("bad: %+v", e)
+	}
+
+	// Records of unknown source are accepted by queries of either stack
+	v4 := QueryParam{DisableIPv6: true}
+	if !v4.accepts(&msgAddr{src: &net.UDPAddr{}}) {
+		t.Fatalf("record of unknown source rejected")
+	}
+
+	if err := c.InjectRecords([]dns.RR{nil}, nil); err == nil {
+		t.Fatalf("expected an error for a nil record")
+	}
+	c.Close()
+	if err := c.InjectRecords(records, nil); err != ErrClientClosed {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestClient_InjectRecordsOrder(t *testing.T) {
+	c := &Client{cache: newCache(), closedCh: make(chan struct{})}
+	sub := c.subscribe()
+	defer c.unsubscribe(sub)
+
+	// Messages are dispatched in the order the records were injected, also
+	// when the subscriber falls behind
+	const n = subscriptionQueue
+	for i := 0; i < n; i++ {
+		rr := mustRR(t, fmt.Sprintf("host%d.local. 120 IN A 10.0.0.1", i))
+		if err := c.InjectRecords([]dns.RR{rr}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case m := <-sub.ch:
+			if name := m.msg.Answer[0].Header().Name; name != fmt.Sprintf("host%d.local.", i) {
+				t.Fatalf("got %s at %d", name, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("missing message %d", i)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"net"
+)
+
+// MulticastInterfaces returns the interfaces that can carry mDNS traffic:
+// those that are up, have carrier, and support multicast, loopback excluded.
+// Passing them to SetInterfaces has a Client query on every link of the host.
+func MulticastInterfaces() ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var out []net.Interface
+	for i := range all {
+		if eligibleInterface(&all[i]) {
+			out = append(out, all[i])
+		}
+	}
+	return out, nil
+}
+
+// SetInterfaces has the Client send its queries on each of ifaces and join
+// the mDNS group on them, so that the responders of every link are heard,
+// such as on a host with Wi-Fi, Ethernet, and a VPN. The responses of all
+// links are merged into the same results. An empty list restores the single
+// interface of SetInterface.
+//
+// Queries whose QueryParam.Interface is set are still sent on that interface
+// only. Sending on several interfaces requires UDP sockets, whose packets can
+// be steered to an interface; with other transports, queries are sent once.
+// Failover does not apply while several interfaces are used.
+func (c *Client) SetInterfaces(ifaces []net.Interface) error {
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagMulticast == 0 {
+			return fmt.Errorf("mdns: interface %s does not support multicast", ifaces[i].Name)
+		}
+	}
+	set := make([]*net.Interface, len(ifaces))
+	for i := range ifaces {
+		ifi := ifaces[i]
+		set[i] = &ifi
+	}
+	c.mu.Lock()
+	c.ifaces = set
+	c.mu.Unlock()
+	for _, ifi := range set {
+		c.joinGroups(ifi)
+	}
+	return nil
+}
+
+// interfaceValues copies a list of interfaces, nil if it is empty.
+func interfaceValues(ifaces []*net.Interface) []net.Interface {
+	var out []net.Interface
+	for _, ifi := range ifaces {
+		out = append(out, *ifi)
+	}
+	return out
+}
+
+// queryInterfaces returns the interfaces to send a query on: ifi if the query
+// names one, otherwise those of SetInterfaces where packets can be steered to
+// them, and otherwise nil for the Client's own.
+func (c *Client) queryInterfaces(ifi *net.Interface, cc clientConns) []*net.Interface {
+	if ifi != nil {
+		return []*net.Interface{ifi}
+	}
+	c.mu.Lock()
+	ifaces := c.ifaces
+	c.mu.Unlock()
+	if len(ifaces) == 0 || !(isSocket(cc.unicast4) || isSocket(cc.unicast6)) {
+		return []*net.Interface{nil}
+	}
+	return ifaces
+}
+
+// groupInterfaces returns the interfaces the multicast sockets are to be
+// members on: those of SetInterfaces, or the Client's own, nil for the
+// system default.
+func (c *Client) groupInterfaces() []*net.Interface {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.ifaces) > 0 {
+		return c.ifaces
+	}
+	return []*net.Interface{c.iface}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestClient_SetInterfaces(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	eth := net.Interface{Index: 2, Name: "eth0", Flags: net.FlagUp | net.FlagMulticast}
+	wlan := net.Interface{Index: 3, Name: "wlan0", Flags: net.FlagUp | net.FlagMulticast}
+	tun := net.Interface{Index: 4, Name: "tun0", Flags: net.FlagUp | net.FlagPointToPoint}
+	if err := c.SetInterfaces([]net.Interface{eth, tun}); err == nil {
+		t.Fatalf("error expected")
+	}
+	if err := c.SetInterfaces([]net.Interface{eth, wlan}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := c.Capabilities().Interfaces; len(got) != 2 || got[0].Name != "eth0" || got[1].Name != "wlan0" {
+		t.Fatalf("bad: %v", got)
+	}
+
+	// Packets of other transports cannot be steered, so queries are sent
+	// once
+	if got := c.queryInterfaces(nil, c.conns()); len(got) != 1 || got[0] != nil {
+		t.Fatalf("bad: %v", got)
+	}
+	entries := make(chan *ServiceEntry, 4)
+	if err := Query(&[]QueryParam{{Service: "_http._tcp", Timeout: 50 * time.Millisecond}}, entries, c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("bad: %d entries", len(entries))
+	}
+
+	// UDP sockets send on each interface, unless the query names one
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer udp.Close()
+	if got := c.queryInterfaces(nil, clientConns{unicast4: udp}); len(got) != 2 || got[0].Index != 2 || got[1].Index != 3 {
+		t.Fatalf("bad: %v", got)
+	}
+	if got := c.queryInterfaces(&wlan, clientConns{unicast4: udp}); len(got) != 1 || got[0].Index != 3 {
+		t.Fatalf("bad: %v", got)
+	}
+
+	// An empty list restores the Client's own interface
+	if err := c.SetInterfaces(nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := c.queryInterfaces(nil, clientConns{unicast4: udp}); len(got) != 1 || got[0] != nil {
+		t.Fatalf("bad: %v", got)
+	}
+	if got := c.Capabilities().Interfaces; got != nil {
+		t.Fatalf("bad: %v", got)
+	}
+}
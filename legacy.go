@@ -0,0 +1,337 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// outstandingWindow is how long the ID of a sent query is remembered, which
+// bounds how late a legacy unicast response to it may arrive.
+const outstandingWindow = 10 * time.Second
+
+// outstandingQueries remembers the IDs and questions of the queries a Client
+// sent recently. Queries sent from a port other than 5353 are answered like
+// conventional DNS queries, see RFC 6762, section 6.7: the response is
+// unicast, carries the query's ID, and repeats its question. Such a response
+// is only accepted if it maps back to one of our queries.
+type outstandingQueries struct {
+	mu   sync.Mutex
+	sent map[uint16]outstandingQuery
+}
+
+// outstandingQuery is a query sent recently.
+type outstandingQuery struct {
+	questions []dns.Question
+	time      time.Time
+}
+
+// add remembers a query that was just sent, and forgets the ones sent too
+// long ago.
+func (o *outstandingQueries) add(q *dns.Msg, now time.Time) {
+	if q.Id == 0 {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.sent == nil {
+		o.sent = make(map[uint16]outstandingQuery)
+	}
+	for id, oq := range o.sent {
+		if now.Sub(oq.time) >= outstandingWindow {
+			delete(o.sent, id)
+		}
+	}
+	o.sent[q.Id] = outstandingQuery{questions: q.Question, time: now}
+}
+
+// match reports whether a legacy unicast response answers one of the queries
+// sent within the window: it carries the query's ID and repeats one of its
+// questions.
+func (o *outstandingQueries) match(resp *dns.Msg, now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	oq, ok := o.sent[resp.Id]
+	if !ok || now.Sub(oq.time) >= outstandingWindow {
+		return false
+	}
+	for _, rq := range resp.Question {
+		for _, q := range oq.questions {
+			if sameQuestion(q, rq) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isLegacyResponse reports whether a response answers a query as in
+// conventional DNS: multicast responses carry a zero ID and no questions.
+func isLegacyResponse(msg *dns.Msg) bool {
+	return msg.Id != 0 && len(msg.Question) > 0
+}
+
+// isLegacyConn reports whether queries sent from l are legacy unicast
+// queries, as it is not bound to the mDNS port, RFC 6762 section 6.7.
+func isLegacyConn(l net.PacketConn) bool {
+	addr, ok := l.LocalAddr().(*net.UDPAddr)
+	return !ok || addr.Port != mdnsPort
+}
+
+// sameQuestion reports whether two questions ask the same thing, ignoring the
+// case of the names and the unicast-response bit of the class.
+func sameQuestion(a, b dns.Question) bool {
+	const classMask = 1<<15 - 1
+	return strings.EqualFold(a.Name, b.Name) && a.Qtype == b.Qtype &&
+		a.Qclass&classMask == b.Qclass&classMask
+}
+
+// LegacyLookup looks up the instances of a service once as a legacy unicast
+// querier, see LegacyLookupTransport.
+func LegacyLookup(ctx context.Context, service string, opts ...Option) ([]*ServiceEntry, error) {
+	return LegacyLookupTransport(ctx, DefaultTransport, service, opts...)
+}
+
+// LegacyLookupTransport looks up the instances of a service once, as a
+// legacy unicast querier does in RFC 6762, section 6.7: the questions are
+// sent from an ephemeral port rather than 5353, and only the unicast
+// responses carrying their ID and repeating their question are accepted. As
+// no multicast listener is needed, this works where binding port 5353 or
+// joining the group is restricted, at the cost of the continuous querying a
+// Client does: every question is sent once, and responders holding back their
+// answers are not heard.
+//
+// It takes the options of LookupAll and returns its entries likewise, but
+// without a Client and its cache: the records received, whose TTL responders
+// cap at 10 seconds in such responses, only serve this lookup. Instances
+// found without the records QueryParam.Require asks for are queried for
+// them. The Interface, Peers, Require, Filter, and DisableIPv4/6 fields of
+// the params of WithParams are honored; those of continuous querying, such as
+// retransmissions and delivery, are not.
+func LegacyLookupTransport(ctx context.Context, transport Transport, service string, opts ...Option) ([]*ServiceEntry, error) {
+	var o lookupOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	par := o.params
+	par.Service = service
+	par = par.withDefaults()
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < par.Timeout {
+		par.Timeout = time.Until(deadline)
+	}
+	logger := par.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	l := &legacyLookup{par: &par, log: logger, cache: newCache(), asked: make(map[string]bool), found: make(map[string]*ServiceEntry)}
+	if err := l.listen(transport); err != nil {
+		return nil, err
+	}
+	defer l.close()
+
+	serviceAddr := ServiceName(par.Service, par.Domain)
+	l.ask(serviceAddr, dns.TypePTR)
+	timer := time.NewTimer(par.Timeout)
+	defer timer.Stop()
+wait:
+	for {
+		select {
+		case resp := <-l.responses:
+			if !resp.msg.Response || !l.outstanding.match(resp.msg, time.Now()) {
+				continue
+			}
+			l.cache.insert(resp.msg, resp.src)
+			l.update(serviceAddr, resp)
+		case <-timer.C:
+			break wait
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				break wait
+			}
+			return nil, ctx.Err()
+		}
+	}
+
+	out := make([]*ServiceEntry, 0, len(l.order))
+	for _, key := range l.order {
+		out = append(out, l.found[key])
+	}
+	if o.rank {
+		return RankEntries(ctx, out, o.scorers...), nil
+	}
+	SortEntries(out)
+	return out, nil
+}
+
+// legacyLookup is the state of a LegacyLookupTransport.
+type legacyLookup struct {
+	par   *QueryParam
+	log   *log.Logger
+	socks []legacySocket
+
+	responses   chan *msgAddr
+	outstanding outstandingQueries
+
+	// cache holds the records of the responses, only for this lookup.
+	cache *cache
+
+	ifaceNames interfaceNames // names the interfaces responses arrive on
+
+	asked map[string]bool          // the names queried for, by name and type
+	found map[string]*ServiceEntry // the complete entries, by Key
+	order []string                 // the keys of found, in the order found
+}
+
+// legacySocket is the ephemeral socket of a stack, and the group it sends
+// to.
+type legacySocket struct {
+	conn  net.PacketConn
+	group *net.UDPAddr
+}
+
+// listen opens the sockets of the stacks the lookup uses and starts reading
+// from them.
+func (l *legacyLookup) listen(transport Transport) error {
+	l.responses = make(chan *msgAddr, 16)
+	via := l.par.stacks()
+	var errs []error
+	for _, sock := range []struct {
+		network string
+		group   *net.UDPAddr
+		use     bool
+	}{{"udp4", ipv4Addr, via.v4}, {"udp6", ipv6Addr, via.v6}} {
+		if !sock.use {
+			continue
+		}
+		conn, err := transport.ListenUDP(sock.network, &net.UDPAddr{})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if l.par.Interface != nil && isSocket(conn) {
+			if sock.group == ipv6Addr {
+				err = ipv6.NewPacketConn(conn).SetMulticastInterface(l.par.Interface)
+			} else {
+				err = ipv4.NewPacketConn(conn).SetMulticastInterface(l.par.Interface)
+			}
+			if err != nil {
+				conn.Close()
+				errs = append(errs, err)
+				continue
+			}
+		}
+		l.socks = append(l.socks, legacySocket{conn: conn, group: sock.group})
+		go l.read(conn)
+	}
+	if len(l.socks) == 0 {
+		return fmt.Errorf("mdns: failed to bind to any unicast udp port: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// read passes the messages received on conn to the lookup until the socket
+// is closed.
+func (l *legacyLookup) read(conn net.PacketConn) {
+	buf := make([]byte, 65536)
+	read := packetReader(conn)
+	for {
+		n, from, ifIndex, err := read(buf)
+		if err != nil {
+			return
+		}
+		msg, err := DefaultCodec.Unpack(buf[:n])
+		if err != nil {
+			continue
+		}
+		select {
+		case l.responses <- &msgAddr{msg: msg, src: from, ifIndex: ifIndex}:
+		default:
+			// The lookup is done, or too slow to keep up
+		}
+	}
+}
+
+// close closes the sockets, which stops their readers.
+func (l *legacyLookup) close() {
+	for _, sock := range l.socks {
+		sock.conn.Close()
+	}
+}
+
+// ask sends a question to the group and the peers of the lookup from every
+// socket, unless it was already asked.
+func (l *legacyLookup) ask(name string, qtype uint16) {
+	key := strings.ToLower(name) + "/" + dns.TypeToString[qtype]
+	if l.asked[key] {
+		return
+	}
+	l.asked[key] = true
+
+	q := new(dns.Msg)
+	q.SetQuestion(name, qtype)
+	q.RecursionDesired = false
+	buf, err := DefaultCodec.Pack(q)
+	if err != nil {
+		l.log.Printf("[ERR] mdns: Failed to pack legacy query: %v", err)
+		return
+	}
+	l.outstanding.add(q, time.Now())
+	for _, sock := range l.socks {
+		if _, err := sock.conn.WriteTo(buf, sock.group); err != nil {
+			l.log.Printf("[ERR] mdns: Failed to send legacy query to %s: %v", sock.group, err)
+		}
+		for _, peer := range l.par.Peers {
+			if (peer.To4() != nil) != (sock.group == ipv4Addr) {
+				continue
+			}
+			if _, err := sock.conn.WriteTo(buf, &net.UDPAddr{IP: peer, Port: mdnsPort}); err != nil {
+				l.log.Printf("[ERR] mdns: Failed to send legacy query to peer %s: %v", peer, err)
+			}
+		}
+	}
+}
+
+// update records the entries the cache completes, and asks for the records
+// still missing from the others. Entries completed by the response are
+// attributed to its sender.
+func (l *legacyLookup) update(serviceAddr string, resp *msgAddr) {
+	for _, instance := range l.cache.instances(serviceAddr) {
+		inp := l.cache.entry(instance)
+		if inp == nil {
+			inp = &ServiceEntry{Name: instance}
+		}
+		claimEntry(inp, l.par, time.Now())
+		if !inp.complete() {
+			if inp.Host == "" {
+				l.ask(instance, dns.TypeANY)
+			} else {
+				l.ask(inp.Host, dns.TypeANY)
+			}
+			continue
+		}
+		if inp.filter != nil && !inp.filter(inp) {
+			continue
+		}
+		key := inp.Key()
+		if prev, ok := l.found[key]; ok {
+			inp.SrcIP, inp.ReceivedOn = prev.SrcIP, prev.ReceivedOn
+		} else {
+			inp.SrcIP, inp.ReceivedOn = resp.src.IP, l.ifaceNames.name(resp.ifIndex)
+			l.order = append(l.order, key)
+		}
+		l.found[key] = inp
+	}
+}
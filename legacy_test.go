@@ -0,0 +1,225 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestOutstandingQueries(t *testing.T) {
+	var o outstandingQueries
+	q := new(dns.Msg)
+	q.SetQuestion("_foobar._tcp.local.", dns.TypePTR)
+	q.Question[0].Qclass |= 1 << 15
+	now := time.Now()
+	o.add(q, now)
+
+	resp := new(dns.Msg)
+	resp.SetQuestion("_FOOBAR._tcp.local.", dns.TypePTR)
+	resp.Id = q.Id
+	resp.Response = true
+	if !o.match(resp, now.Add(time.Second)) {
+		t.Fatalf("response should match the query")
+	}
+	if o.match(resp, now.Add(outstandingWindow)) {
+		t.Fatalf("response arrived too late")
+	}
+
+	resp.Id++
+	if o.match(resp, now) {
+		t.Fatalf("response with another ID should not match")
+	}
+	resp.Id--
+	resp.Question[0].Qtype = dns.TypeSRV
+	if o.match(resp, now) {
+		t.Fatalf("response with another question should not match")
+	}
+}
+
+func TestClient_LegacyResponse(t *testing.T) {
+	c := &Client{cache: newCache(), closedCh: make(chan struct{})}
+	q := serviceQuestion(*DefaultParams("_foobar._tcp"), false)
+	q.Id = 1234
+	if err := c.sendQuery(context.Background(), q, allStacks, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+
+	// A legacy response to someone else's query is ignored
+	other := makeResponse(t, makeService(t))
+	other.Id = 4321
+	other.Question = q.Question
+	c.handleMsgOn(other, src, 0, true)
+	if n := c.CacheStats().Records; n != 0 {
+		t.Fatalf("cached %d records of an unrelated response", n)
+	}
+
+	// One answering our query is accepted
+	resp := makeResponse(t, makeService(t))
+	resp.Id = q.Id
+	resp.Question = q.Question
+	c.handleMsgOn(resp, src, 0, true)
+	if n := c.CacheStats().Records; n == 0 {
+		t.Fatalf("legacy response was dropped")
+	}
+}
+
+func TestClient_MulticastResponseID(t *testing.T) {
+	c := &Client{cache: newCache(), closedCh: make(chan struct{})}
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+
+	// The ID and questions of a multicast response are ignored
+	resp := makeResponse(t, makeService(t))
+	resp.Id = 4321
+	resp.Question = []dns.Question{{Name: "_http._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET}}
+	c.handleMsg(resp, src, 0)
+	if n := c.CacheStats().Records; n == 0 {
+		t.Fatalf("multicast response with an ID was dropped")
+	}
+}
+
+// legacyResponder answers the legacy unicast queries sent to the group on
+// network for service, giving only the records asked for, with a TTL of 10
+// seconds. Each answer is preceded by one with another ID, which must be
+// ignored.
+func legacyResponder(t *testing.T, network *memnet.Network, ip string, service *MDNSService) {
+	t.Helper()
+	conn, err := network.Host(net.ParseIP(ip)).ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q := new(dns.Msg)
+			if q.Unpack(buf[:n]) != nil || q.Response || len(q.Question) != 1 || from.(*net.UDPAddr).Port == mdnsPort {
+				continue
+			}
+			rrs := service.Records(q.Question[0])
+			if len(rrs) == 0 {
+				continue
+			}
+			for _, rr := range rrs {
+				rr.Header().Ttl = 10
+			}
+			resp := &dns.Msg{MsgHdr: dns.MsgHdr{Id: q.Id + 1, Response: true, Authoritative: true}, Question: q.Question, Answer: rrs}
+			if out, err := resp.Pack(); err == nil {
+				conn.WriteTo(out, from)
+			}
+			resp.Id = q.Id
+			if out, err := resp.Pack(); err == nil {
+				conn.WriteTo(out, from)
+			}
+		}
+	}()
+}
+
+func TestLegacyLookup(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	legacyResponder(t, network, "10.0.0.1", makeService(t))
+	host := network.Host(net.ParseIP("10.0.0.2"))
+
+	// The instance is queried for the records the PTR record lacks
+	params := QueryParam{Require: RequireAll, DisableIPv6: true}
+	entries, err := LegacyLookupTransport(context.Background(), host, "_http._tcp", WithParams(params), WithTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("bad: %v", entries)
+	}
+	e := entries[0]
+	if e.Name != "hostname._http._tcp.local." || e.Port != 80 || e.AddrV4 == nil || e.TTL != 10 || !e.SrcIP.Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("bad: %+v", e)
+	}
+
+	// Cancellation is an error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := LegacyLookupTransport(ctx, host, "_http._tcp"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestLegacyLookup_Server(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	params := QueryParam{Require: RequireAll, DisableIPv6: true}
+	entries, err := LegacyLookupTransport(context.Background(), network.Host(net.ParseIP("10.0.0.2")), "_http._tcp", WithParams(params), WithTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("bad: %v", entries)
+	}
+	if e := entries[0]; e.Name != "hostname._http._tcp.local." || e.Port != 80 || e.AddrV4 == nil || e.TTL != legacyTTL {
+		t.Fatalf("bad: %+v", e)
+	}
+}
+
+func TestServer_LegacyResponse(t *testing.T) {
+	service := makeService(t)
+	s, conn := answerServer(t, service, false)
+	ask := func(from net.Addr) *dns.Msg {
+		t.Helper()
+		q := new(dns.Msg)
+		q.SetQuestion("HostName._http._tcp.local.", dns.TypeSRV)
+		q.Id = 1234
+		if err := s.handleQuery(q, from); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		buf := make([]byte, 9000)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(buf[:n]); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return resp
+	}
+
+	// The ID and question are repeated, and the TTLs capped
+	resp := ask(conn.LocalAddr())
+	if resp.Id != 1234 || len(resp.Question) != 1 || resp.Question[0].Name != "HostName._http._tcp.local." {
+		t.Fatalf("bad: %v", resp)
+	}
+	if len(resp.Answer) == 0 {
+		t.Fatalf("no answers: %v", resp)
+	}
+	for _, rr := range resp.Answer {
+		if rr.Header().Ttl > legacyTTL || rr.Header().Class&cacheFlushBit != 0 {
+			t.Fatalf("bad: %v", rr)
+		}
+	}
+
+	// Queries from the mDNS port are not legacy ones
+	port := *conn.LocalAddr().(*net.UDPAddr)
+	port.Port = mdnsPort
+	if !isLegacyQuerier(conn.LocalAddr()) || isLegacyQuerier(&port) {
+		t.Fatalf("bad legacy querier detection")
+	}
+	if legacyResponse(&dns.Msg{}, nil) != nil {
+		t.Fatalf("empty response")
+	}
+}
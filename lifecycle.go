@@ -0,0 +1,129 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ComponentError reports the failure of one component of a Lifecycle.
+type ComponentError struct {
+	Name string // Name the component was added with
+	Op   string // "start" or "stop"
+	Err  error
+}
+
+func (e *ComponentError) Error() string {
+	return fmt.Sprintf("mdns: %s %s: %v", e.Op, e.Name, e.Err)
+}
+
+func (e *ComponentError) Unwrap() error {
+	return e.Err
+}
+
+// component is a named pair of start and stop functions.
+type component struct {
+	name  string
+	start func(context.Context) error
+	stop  func(context.Context) error
+}
+
+// Lifecycle starts and stops the components of an application embedding
+// Clients and Servers with one call. Components are started in the order they
+// were added and stopped in the reverse order, so a Client should be added
+// before the Servers answering alongside it, and anything that must happen
+// last on shutdown, such as persisting state, should be added first.
+type Lifecycle struct {
+	mu         sync.Mutex
+	components []component
+	started    int // number of components started
+}
+
+// Add adds a component. Either function may be nil.
+func (l *Lifecycle) Add(name string, start, stop func(context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.components = append(l.components, component{name: name, start: start, stop: stop})
+}
+
+// AddClient adds a Client, which is closed when the Lifecycle stops.
+func (l *Lifecycle) AddClient(name string, c *Client) {
+	l.Add(name, nil, func(context.Context) error { return c.Close() })
+}
+
+// AddState adds the saved state of a Client, see SaveState: starting it
+// restores the state from store, and stopping it saves the state again. Add
+// it first, so that the state is saved last, once nothing changes it anymore.
+func (l *Lifecycle) AddState(name string, c *Client, store Store) {
+	start := func(context.Context) error { return c.LoadState(store) }
+	stop := func(context.Context) error { return c.SaveState(store) }
+	l.Add(name, start, stop)
+}
+
+// AddServer adds a Server. Starting it registers the services, and stopping
+// it sends their goodbyes before its sockets are closed.
+func (l *Lifecycle) AddServer(name string, s *Server, services ...*MDNSService) {
+	l.AddResponder(name, s, services...)
+}
+
+// AddResponder adds a Responder, such as one returned by NewResponder.
+// Starting it registers the services, and stopping it shuts it down.
+func (l *Lifecycle) AddResponder(name string, r Responder, services ...*MDNSService) {
+	start := func(ctx context.Context) error {
+		for _, service := range services {
+			if err := r.Register(ctx, service); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	l.Add(name, start, r.ShutdownContext)
+}
+
+// Start starts every component in order. If one fails, those already started
+// are stopped again and the returned error reports every failure as a
+// *ComponentError.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.started < len(l.components) {
+		c := l.components[l.started]
+		if c.start != nil {
+			if err := c.start(ctx); err != nil {
+				// Stop the component itself too, it may have half started
+				l.started++
+				return errors.Join(&ComponentError{Name: c.name, Op: "start", Err: err}, l.stop(ctx))
+			}
+		}
+		l.started++
+	}
+	return nil
+}
+
+// Stop stops the started components in reverse order. Every component is
+// stopped even if others fail; the returned error reports every failure as a
+// *ComponentError.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stop(ctx)
+}
+
+// stop stops the started components. The caller must hold the lock.
+func (l *Lifecycle) stop(ctx context.Context) error {
+	var errs []error
+	for ; l.started > 0; l.started-- {
+		c := l.components[l.started-1]
+		if c.stop == nil {
+			continue
+		}
+		if err := c.stop(ctx); err != nil {
+			errs = append(errs, &ComponentError{Name: c.name, Op: "stop", Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLifecycle(t *testing.T) {
+	var events []string
+	add := func(l *Lifecycle, name string, startErr, stopErr error) {
+		l.Add(name, func(context.Context) error {
+			events = append(events, "start "+name)
+			return startErr
+		}, func(context.Context) error {
+			events = append(events, "stop "+name)
+			return stopErr
+		})
+	}
+
+	errStop := errors.New("stop failed")
+	l := &Lifecycle{}
+	add(l, "cache", nil, nil)
+	add(l, "client", nil, errStop)
+	add(l, "server", nil, nil)
+
+	ctx := context.Background()
+	if err := l.Start(ctx); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	err := l.Stop(ctx)
+	var cerr *ComponentError
+	if !errors.As(err, &cerr) || cerr.Name != "client" || cerr.Op != "stop" || !errors.Is(err, errStop) {
+		t.Fatalf("bad error: %v", err)
+	}
+	want := []string{"start cache", "start client", "start server", "stop server", "stop client", "stop cache"}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("got %v, want %v", events, want)
+	}
+
+	// A failed start stops what was started
+	events = nil
+	errStart := errors.New("start failed")
+	l = &Lifecycle{}
+	add(l, "client", nil, nil)
+	add(l, "server", errStart, nil)
+	add(l, "never", nil, nil)
+	if err := l.Start(ctx); !errors.Is(err, errStart) {
+		t.Fatalf("got %v, want %v", err, errStart)
+	}
+	want = []string{"start client", "start server", "stop server", "stop client"}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("got %v, want %v", events, want)
+	}
+}
+
+func TestLifecycle_AddState(t *testing.T) {
+	c := &Client{cache: newCache()}
+	c.cache.insert(makeResponse(t, makeService(t)), nil)
+	store := NewMemoryStore()
+
+	l := &Lifecycle{}
+	l.AddState("state", c, store)
+	ctx := context.Background()
+	if err := l.Start(ctx); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := l.Stop(ctx); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	restored := &Client{cache: newCache()}
+	if err := restored.LoadState(store); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if e := restored.cache.entry("hostname._http._tcp.local."); e == nil || e.Port != 80 {
+		t.Fatalf("bad: %+v", e)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	// defaultMaxQueries is the default limit on concurrent queries per Client.
+	defaultMaxQueries = 64
+)
+
+var (
+	// ErrTooManyQueries is returned when a query is started while the
+	// Client is already running its maximum number of concurrent queries and
+	// is configured to reject rather than queue excess queries.
+	ErrTooManyQueries = errors.New("mdns: too many concurrent queries")
+
+	// ErrClientClosed is returned by the methods of a Client that is
+	// closed, and to queries and browses running when it is closed.
+	ErrClientClosed = errors.New("mdns: client closed")
+)
+
+// SetQueryLimit sets the maximum number of queries the Client runs
+// concurrently. Once the limit is reached, further queries wait for a running
+// query to finish if queue is true, or fail with ErrTooManyQueries otherwise.
+// A limit of zero disables it. By default at most 64 queries run at once and
+// excess queries are queued.
+func (c *Client) SetQueryLimit(max int, queue bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxQueries = max
+	c.queueQueries = queue
+	c.wakeQueries()
+}
+
+// acquireQuery reserves a query slot, waiting for one if the Client queues
+// excess queries.
+func (c *Client) acquireQuery(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.maxQueries <= 0 || c.activeQueries < c.maxQueries {
+			c.activeQueries++
+			c.mu.Unlock()
+			return nil
+		}
+		if !c.queueQueries {
+			c.mu.Unlock()
+			return ErrTooManyQueries
+		}
+		if c.queryFreed == nil {
+			c.queryFreed = make(chan struct{})
+		}
+		freed := c.queryFreed
+		c.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.closedCh:
+			return ErrClientClosed
+		}
+	}
+}
+
+// releaseQuery frees a query slot.
+func (c *Client) releaseQuery() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.activeQueries--
+	c.wakeQueries()
+}
+
+// wakeQueries wakes the queries waiting for a slot. The caller must hold the
+// lock.
+func (c *Client) wakeQueries() {
+	if c.queryFreed != nil {
+		close(c.queryFreed)
+		c.queryFreed = nil
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClient_QueryLimit(t *testing.T) {
+	c := &Client{closedCh: make(chan struct{})}
+	c.SetQueryLimit(1, false)
+
+	ctx := context.Background()
+	if err := c.acquireQuery(ctx); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.acquireQuery(ctx); !errors.Is(err, ErrTooManyQueries) {
+		t.Fatalf("got %v, want ErrTooManyQueries", err)
+	}
+
+	// Queued queries wait for a slot
+	c.SetQueryLimit(1, true)
+	acquired := make(chan error, 1)
+	go func() { acquired <- c.acquireQuery(ctx) }()
+	select {
+	case err := <-acquired:
+		t.Fatalf("query should be queued, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.releaseQuery()
+	if err := <-acquired; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Queued queries give up when their context ends
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := c.acquireQuery(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+
+	// Lifting the limit releases waiting queries
+	go func() { acquired <- c.acquireQuery(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	c.SetQueryLimit(0, true)
+	if err := <-acquired; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
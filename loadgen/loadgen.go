@@ -0,0 +1,183 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+// Package loadgen synthesizes mDNS traffic for soak tests and benchmarks. It
+// simulates a population of devices, each publishing a number of service
+// types, that announce themselves periodically and are replaced by new devices
+// at a configurable churn rate.
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Config describes the simulated network.
+type Config struct {
+	Devices          int           // Number of devices on the network
+	ServiceTypes     int           // Number of service types each device publishes, default 1
+	ChurnRate        float64       // Devices replaced per second
+	AnnounceInterval time.Duration // How often each device re-announces, default 1 minute
+	TTL              uint32        // TTL of the announced records, default 120 seconds
+	Domain           string        // Domain of the services, default "local."
+	Seed             int64         // Seed for the choice of churned devices
+}
+
+// Sink receives the generated messages.
+type Sink interface {
+	Send(msg *dns.Msg) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(msg *dns.Msg) error
+
+// Send calls f(msg).
+func (f SinkFunc) Send(msg *dns.Msg) error {
+	return f(msg)
+}
+
+// device is a simulated device.
+type device struct {
+	id   int
+	next time.Time // time of the next announcement
+}
+
+// Generator produces the traffic of a simulated network.
+type Generator struct {
+	cfg Config
+	rng *rand.Rand
+
+	devices []*device
+	nextID  int
+
+	last  time.Time // time of the previous step
+	churn float64   // fractional devices owed to churn
+}
+
+// New returns a Generator for the given configuration.
+func New(cfg Config) *Generator {
+	if cfg.ServiceTypes <= 0 {
+		cfg.ServiceTypes = 1
+	}
+	if cfg.AnnounceInterval <= 0 {
+		cfg.AnnounceInterval = time.Minute
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = 120
+	}
+	if cfg.Domain == "" {
+		cfg.Domain = "local."
+	}
+	g := &Generator{
+		cfg: cfg,
+		rng: rand.New(rand.NewSource(cfg.Seed)),
+	}
+	for i := 0; i < cfg.Devices; i++ {
+		g.devices = append(g.devices, g.newDevice())
+	}
+	return g
+}
+
+// ServiceTypes returns the service types published by the devices, e.g.
+// "_loadgen0._tcp".
+func (g *Generator) ServiceTypes() []string {
+	types := make([]string, g.cfg.ServiceTypes)
+	for i := range types {
+		types[i] = fmt.Sprintf("_loadgen%d._tcp", i)
+	}
+	return types
+}
+
+// Step advances the simulation to now and returns the messages sent in the
+// meantime: goodbyes from departing devices, and announcements from new
+// devices and from devices due to re-announce. The first step announces every
+// device.
+func (g *Generator) Step(now time.Time) []*dns.Msg {
+	var msgs []*dns.Msg
+
+	if !g.last.IsZero() && len(g.devices) > 0 {
+		// Replace distinct devices, so that a device joining in this step
+		// doesn't leave again before announcing itself
+		g.churn += g.cfg.ChurnRate * now.Sub(g.last).Seconds()
+		replaced := make(map[int]bool)
+		for ; g.churn >= 1 && len(replaced) < len(g.devices); g.churn-- {
+			i := g.rng.Intn(len(g.devices))
+			for replaced[i] {
+				i = (i + 1) % len(g.devices)
+			}
+			replaced[i] = true
+			msgs = append(msgs, g.message(g.devices[i], 0))
+			g.devices[i] = g.newDevice()
+		}
+	}
+	g.last = now
+
+	for _, d := range g.devices {
+		if d.next.After(now) {
+			continue
+		}
+		msgs = append(msgs, g.message(d, g.cfg.TTL))
+		d.next = now.Add(g.cfg.AnnounceInterval)
+	}
+	return msgs
+}
+
+// Run steps the simulation every tick, sending the messages to sink, until
+// the context is cancelled or the sink fails.
+func (g *Generator) Run(ctx context.Context, tick time.Duration, sink Sink) error {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	now := time.Now()
+	for {
+		for _, msg := range g.Step(now) {
+			if err := sink.Send(msg); err != nil {
+				return err
+			}
+		}
+		select {
+		case now = <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// newDevice returns a device with a fresh identity.
+func (g *Generator) newDevice() *device {
+	d := &device{id: g.nextID}
+	g.nextID++
+	return d
+}
+
+// message returns the announcement of a device, or its goodbye if ttl is 0.
+func (g *Generator) message(d *device, ttl uint32) *dns.Msg {
+	host := fmt.Sprintf("device-%d.%s", d.id, g.cfg.Domain)
+	hdr := func(name string, rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	}
+
+	msg := &dns.Msg{MsgHdr: dns.MsgHdr{Response: true, Authoritative: true}}
+	for _, service := range g.ServiceTypes() {
+		serviceAddr := fmt.Sprintf("%s.%s", service, g.cfg.Domain)
+		instance := fmt.Sprintf("device-%d.%s", d.id, serviceAddr)
+		msg.Answer = append(msg.Answer, &dns.PTR{Hdr: hdr(serviceAddr, dns.TypePTR), Ptr: instance})
+		msg.Extra = append(msg.Extra,
+			&dns.SRV{Hdr: hdr(instance, dns.TypeSRV), Target: host, Port: 8000},
+			&dns.TXT{Hdr: hdr(instance, dns.TypeTXT), Txt: []string{fmt.Sprintf("id=%d", d.id)}},
+		)
+	}
+	msg.Extra = append(msg.Extra, &dns.A{Hdr: hdr(host, dns.TypeA), A: deviceAddr(d.id)})
+	return msg
+}
+
+// deviceAddr returns the address of a device in the 198.18.0.0/15 range
+// reserved for benchmarking.
+func deviceAddr(id int) net.IP {
+	return net.IPv4(198, 18+byte(id>>16&1), byte(id>>8), byte(id))
+}
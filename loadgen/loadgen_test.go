@@ -0,0 +1,72 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package loadgen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestGenerator_Step(t *testing.T) {
+	g := New(Config{
+		Devices:          10,
+		ServiceTypes:     3,
+		ChurnRate:        2,
+		AnnounceInterval: time.Minute,
+	})
+	start := time.Now()
+
+	msgs := g.Step(start)
+	if len(msgs) != 10 {
+		t.Fatalf("got %d messages, want an announcement per device", len(msgs))
+	}
+	if got := len(msgs[0].Answer); got != 3 {
+		t.Fatalf("got %d PTR records, want one per service type", got)
+	}
+	if _, err := msgs[0].Pack(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Two devices are replaced per second: two goodbyes, two announcements
+	msgs = g.Step(start.Add(time.Second))
+	if len(msgs) != 4 {
+		t.Fatalf("got %d messages, want 4", len(msgs))
+	}
+	goodbyes := 0
+	for _, msg := range msgs {
+		if msg.Answer[0].Header().Ttl == 0 {
+			goodbyes++
+		}
+	}
+	if goodbyes != 2 {
+		t.Fatalf("got %d goodbyes, want 2", goodbyes)
+	}
+
+	// Every remaining device re-announces after the interval
+	msgs = g.Step(start.Add(time.Minute))
+	if len(msgs) < 8 {
+		t.Fatalf("got %d messages, want re-announcements", len(msgs))
+	}
+}
+
+func TestGenerator_Deterministic(t *testing.T) {
+	cfg := Config{Devices: 50, ChurnRate: 5, Seed: 42}
+	a, b := New(cfg), New(cfg)
+	start := time.Now()
+	a.Step(start)
+	b.Step(start)
+
+	next := start.Add(time.Second)
+	ma, mb := a.Step(next), b.Step(next)
+	if len(ma) != len(mb) {
+		t.Fatalf("got %d and %d messages", len(ma), len(mb))
+	}
+	for i := range ma {
+		if !dns.IsDuplicate(ma[i].Answer[0], mb[i].Answer[0]) {
+			t.Fatalf("message %d differs: %v vs %v", i, ma[i].Answer[0], mb[i].Answer[0])
+		}
+	}
+}
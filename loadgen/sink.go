@@ -0,0 +1,55 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package loadgen
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+)
+
+// mdnsAddr is the IPv4 mDNS multicast group.
+var mdnsAddr = &net.UDPAddr{IP: net.ParseIP("224.0.0.251"), Port: 5353}
+
+// MulticastSink sends generated messages to the IPv4 mDNS multicast group,
+// for soak tests against a real network.
+type MulticastSink struct {
+	conn *net.UDPConn
+}
+
+// NewMulticastSink returns a sink multicasting on the given interface, or the
+// system default if iface is nil. Multicast loopback is enabled so that
+// clients on the same host see the traffic.
+func NewMulticastSink(iface *net.Interface) (*MulticastSink, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		return nil, err
+	}
+	p := ipv4.NewPacketConn(conn)
+	if err := p.SetMulticastInterface(iface); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := p.SetMulticastLoopback(true); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &MulticastSink{conn: conn}, nil
+}
+
+// Send multicasts a message.
+func (s *MulticastSink) Send(msg *dns.Msg) error {
+	buf, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.WriteToUDP(buf, mdnsAddr)
+	return err
+}
+
+// Close closes the underlying socket.
+func (s *MulticastSink) Close() error {
+	return s.conn.Close()
+}
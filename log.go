@@ -0,0 +1,125 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// logSampleEvery is how often a repeated message is logged after its
+	// first occurrence.
+	logSampleEvery = 100
+
+	// logSampleInterval is how often a summary of suppressed messages is
+	// logged.
+	logSampleInterval = time.Hour
+)
+
+// logSampler deduplicates repetitive log lines, such as the unpack failures
+// caused by a single misbehaving device. Messages are grouped by their format
+// string. The first occurrence of a message is always logged, after that only
+// every Nth repetition is, and at the end of every interval in which lines were
+// suppressed a summary of their number is written, without waiting for the
+// next line.
+type logSampler struct {
+	logger   *log.Logger
+	every    int
+	interval time.Duration
+
+	mu         sync.Mutex
+	counts     map[string]int
+	suppressed map[string]int
+	since      time.Time   // start of the current interval
+	timer      *time.Timer // writes the summary at the end of the interval
+	closed     bool
+
+	now func() time.Time
+}
+
+// newLogSampler returns a logSampler writing to logger.
+func newLogSampler(logger *log.Logger, every int, interval time.Duration) *logSampler {
+	return &logSampler{
+		logger:     logger,
+		every:      every,
+		interval:   interval,
+		counts:     make(map[string]int),
+		suppressed: make(map[string]int),
+		now:        time.Now,
+	}
+}
+
+// Printf logs the message subject to sampling.
+func (l *logSampler) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.since.IsZero() {
+		l.since = now
+	}
+	if now.Sub(l.since) >= l.interval {
+		l.summarize(now)
+	}
+
+	l.counts[format]++
+	n := l.counts[format]
+	switch {
+	case n == 1:
+		l.logger.Printf(format, v...)
+	case l.every > 0 && n%l.every == 0:
+		l.logger.Printf("%s (repeated %d times)", fmt.Sprintf(format, v...), n)
+	default:
+		l.suppressed[format]++
+		if l.timer == nil && !l.closed {
+			l.timer = time.AfterFunc(l.since.Add(l.interval).Sub(now), l.flush)
+		}
+	}
+}
+
+// flush writes the summary of the interval that ended, as the timer started
+// by the first suppressed line of the interval asks.
+func (l *logSampler) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.summarize(l.now())
+}
+
+// close writes the summary of the lines suppressed so far, and stops the
+// timer that would have.
+func (l *logSampler) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.summarize(l.now())
+}
+
+// summarize logs how many lines were suppressed since the start of the
+// interval, and starts the next one at now.
+func (l *logSampler) summarize(now time.Time) {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	formats := make([]string, 0, len(l.suppressed))
+	for format := range l.suppressed {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	elapsed := now.Sub(l.since).Round(time.Millisecond)
+	if elapsed >= time.Second {
+		elapsed = elapsed.Round(time.Second)
+	}
+	for _, format := range formats {
+		l.logger.Printf("[INFO] mdns: suppressed %d repetitions of %q in the last %v",
+			l.suppressed[format], format, elapsed)
+	}
+	l.counts = make(map[string]int)
+	l.suppressed = make(map[string]int)
+	l.since = now
+}
@@ -0,0 +1,71 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogSampler(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Now()
+	l := newLogSampler(log.New(&buf, "", 0), 10, time.Hour)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 25; i++ {
+		l.Printf("[ERR] mdns: Failed to unpack packet: %v", i)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("want first occurrence plus every 10th, got: %q", lines)
+	}
+	if got, want := lines[2], "[ERR] mdns: Failed to unpack packet: 19 (repeated 20 times)"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	now = now.Add(time.Hour)
+	l.Printf("[ERR] mdns: Failed to unpack packet: %v", 25)
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want summary and first occurrence, got: %q", lines)
+	}
+	if !strings.Contains(lines[0], "suppressed 22 repetitions") || !strings.HasSuffix(lines[0], "in the last 1h0m0s") {
+		t.Fatalf("bad summary: %q", lines[0])
+	}
+}
+
+func TestLogSampler_Timer(t *testing.T) {
+	var buf lockedBuffer
+	l := newLogSampler(log.New(&buf, "", 0), 10, 50*time.Millisecond)
+	output := buf.String
+
+	// The summary is written at the end of the interval, with the time
+	// that actually passed, without waiting for another line
+	for i := 0; i < 5; i++ {
+		l.Printf("[ERR] mdns: Failed to unpack packet: %v", i)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(output(), "suppressed 4 repetitions") {
+		if time.Now().After(deadline) {
+			t.Fatalf("no summary: %q", output())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(output(), "ms\n") {
+		t.Fatalf("bad elapsed time: %q", output())
+	}
+
+	// Closing writes the summary of the lines suppressed so far
+	l.Printf("[ERR] mdns: Failed to unpack packet: %v", 5)
+	l.Printf("[ERR] mdns: Failed to unpack packet: %v", 6)
+	l.close()
+	if !strings.Contains(output(), "suppressed 1 repetitions") {
+		t.Fatalf("no summary on close: %q", output())
+	}
+}
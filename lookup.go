@@ -0,0 +1,149 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"log"
+	"time"
+)
+
+// Option tunes LookupAll and Discover.
+type Option func(*lookupOptions)
+
+// lookupOptions are the settings of a LookupAll.
+type lookupOptions struct {
+	params  QueryParam
+	rank    bool
+	scorers []Scorer
+}
+
+// WithDomain looks up the service in domain instead of "local".
+func WithDomain(domain string) Option {
+	return func(o *lookupOptions) { o.params.Domain = domain }
+}
+
+// WithTimeout waits for responses for d instead of 1 second. The lookup ends
+// earlier if the context has an earlier deadline.
+func WithTimeout(d time.Duration) Option {
+	return func(o *lookupOptions) { o.params.Timeout = d }
+}
+
+// WithParams starts from params, for the settings without an option of their
+// own. The Service of params is replaced by the one looked up, and its
+// Entries and Delivery are not used.
+func WithParams(params QueryParam) Option {
+	return func(o *lookupOptions) { o.params = params }
+}
+
+// WithRanking orders the entries with RankEntries and the given scorers,
+// instead of by name with SortEntries.
+func WithRanking(scorers ...Scorer) Option {
+	return func(o *lookupOptions) {
+		o.rank = true
+		o.scorers = scorers
+	}
+}
+
+// LookupAll looks up the instances of a service with a Client of its own, see
+// Client.LookupAll.
+func LookupAll(ctx context.Context, service string, opts ...Option) ([]*ServiceEntry, error) {
+	client, err := NewClient(true, true, log.Default(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.LookupAll(ctx, service, opts...)
+}
+
+// LookupAll runs a query for the instances of a service to completion, and
+// returns the entries found, one per instance, ordered by SortEntries unless
+// WithRanking is given. The query lasts 1 second, see WithTimeout, or until
+// the deadline of ctx if that is sooner, which should then leave time for
+// the scorers of WithRanking; if ctx is cancelled first, its error is
+// returned.
+func (c *Client) LookupAll(ctx context.Context, service string, opts ...Option) ([]*ServiceEntry, error) {
+	var o lookupOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	par := o.params
+	par.Service = service
+	par.Entries = nil
+	par.Delivery = DeliverBlock
+	par = par.withDefaults()
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < par.Timeout {
+		par.Timeout = time.Until(deadline)
+	}
+
+	// Collect the entries as they come, merging those of the same instance
+	entries := make(chan *ServiceEntry)
+	var found []*ServiceEntry
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range entries {
+			found = append(found, e)
+		}
+	}()
+	err := QueryContext(ctx, &[]QueryParam{par}, entries, c)
+	close(entries)
+	<-done
+	if err != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, err
+	}
+
+	out := MergeEntries(found)
+	if o.rank {
+		return RankEntries(ctx, out, o.scorers...), nil
+	}
+	SortEntries(out)
+	return out, nil
+}
+
+// Discover returns the entries of a query for the instances of a service as
+// a sequence, yielding each as soon as it is found, for use in a range loop:
+//
+//	for entry := range client.Discover(ctx, "_http._tcp") {
+//		...
+//	}
+//
+// Each loop runs a query of its own, which lasts as long as one run by
+// LookupAll with the same options, and stops early when the loop does. The
+// sequence ends without an error when ctx is done or the query fails; use
+// LookupAll to tell why. WithRanking does not apply, as entries are yielded
+// in the order they are found.
+func (c *Client) Discover(ctx context.Context, service string, opts ...Option) iter.Seq[*ServiceEntry] {
+	return func(yield func(*ServiceEntry) bool) {
+		var o lookupOptions
+		for _, opt := range opts {
+			opt(&o)
+		}
+		par := o.params
+		par.Service = service
+		par.Entries = nil
+		par.Delivery = DeliverBlock
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		entries := make(chan *ServiceEntry)
+		go func() {
+			defer close(entries)
+			if err := QueryContext(ctx, &[]QueryParam{par}, entries, c); err != nil && ctx.Err() == nil {
+				c.log.Printf("[ERR] mdns: Discovery of %s failed: %v", service, err)
+			}
+		}()
+		for e := range entries {
+			if !yield(e) {
+				// Stop the query, which returns once it sees ctx is done
+				cancel()
+				for range entries {
+				}
+				return
+			}
+		}
+	}
+}
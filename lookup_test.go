@@ -0,0 +1,117 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestClient_LookupAll(t *testing.T) {
+	network := memnet.New(memnet.Config{Duplicate: 0.5})
+	service := func(instance string) *MDNSService {
+		s, err := NewMDNSService(instance, "_http._tcp", "local.", instance+".local.", 80, []net.IP{net.ParseIP("10.0.0.1")}, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return s
+	}
+	serv, err := NewServer(&Config{Zone: MultiZone{service("b"), service("a"), service("c")}, Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+	names := func(entries []*ServiceEntry) string {
+		var s string
+		for _, e := range entries {
+			s += e.Name[:1]
+		}
+		return s
+	}
+
+	entries, err := c.LookupAll(context.Background(), "_http._tcp", WithTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := names(entries); got != "abc" {
+		t.Fatalf("bad: %q", got)
+	}
+
+	// Ranked, until the deadline of the context. Entries of the same score
+	// and SRV priority come in a weighted random order
+	favor := func(_ context.Context, e *ServiceEntry) float64 {
+		if e.Name[0] == 'c' {
+			return 1
+		}
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	entries, err = c.LookupAll(ctx, "_http._tcp", WithRanking(favor), WithTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := names(entries); got != "cab" && got != "cba" {
+		t.Fatalf("bad: %q", got)
+	}
+
+	// Cancellation is an error
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.LookupAll(ctx, "_http._tcp"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestClient_Discover(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	var found []*ServiceEntry
+	for e := range c.Discover(context.Background(), "_http._tcp", WithTimeout(200*time.Millisecond)) {
+		found = append(found, e)
+	}
+	if len(found) != 1 || found[0].Name != "hostname._http._tcp.local." || found[0].Port != 80 {
+		t.Fatalf("bad: %v", found)
+	}
+
+	// Breaking out of the loop stops the query
+	start := time.Now()
+	for range c.Discover(context.Background(), "_http._tcp", WithTimeout(time.Minute)) {
+		break
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("query not stopped: %v", d)
+	}
+
+	// So does the context
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	for range c.Discover(ctx, "_ipp._tcp", WithTimeout(time.Minute)) {
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("query not stopped: %v", d)
+	}
+}
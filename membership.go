@@ -0,0 +1,103 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// defaultMembershipInterval is how often multicast group membership is
+	// verified by default.
+	defaultMembershipInterval = time.Minute
+)
+
+// MembershipConfig configures the verification of multicast group membership.
+type MembershipConfig struct {
+	// Interval is how often membership is verified, default 1 minute.
+	Interval time.Duration
+
+	// OnHeal, if set, is called whenever a lost membership was found, whether
+	// or not it could be rejoined.
+	OnHeal func(MembershipEvent)
+}
+
+// MembershipEvent describes a multicast group membership that was found to be
+// lost and rejoined.
+type MembershipEvent struct {
+	Interface *net.Interface // Interface the group was joined on, nil for the system default
+	Group     net.IP         // The mDNS group, 224.0.0.251 or ff02::fb
+	Time      time.Time      // When the membership was healed
+	Err       error          // Non-nil if membership could not be verified or rejoined
+}
+
+// EnableMembershipCheck starts verifying that the Client's multicast sockets
+// are still members of the mDNS groups, and rejoins them when they are not.
+// Some drivers silently drop memberships, for example after a suspend, which
+// leaves the Client unable to hear multicast responses.
+//
+// Membership is verified by joining the group again: the system refuses to
+// join a group twice, so a successful join means the membership had been lost
+// and is now restored.
+func (c *Client) EnableMembershipCheck(cfg MembershipConfig) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultMembershipInterval
+	}
+	go c.monitorMembership(cfg)
+}
+
+// monitorMembership periodically verifies group membership until the Client
+// is closed.
+func (c *Client) monitorMembership(cfg MembershipConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.checkMembership(cfg)
+		case <-c.closedCh:
+			return
+		}
+	}
+}
+
+// checkMembership rejoins the mDNS groups the multicast sockets are no longer
+// members of.
+func (c *Client) checkMembership(cfg MembershipConfig) {
+	cc := c.conns()
+	for _, iface := range c.groupInterfaces() {
+		if isSocket(cc.multicast4) {
+			err := ipv4.NewPacketConn(cc.multicast4).JoinGroup(iface, ipv4Addr)
+			c.healed(cfg, iface, ipv4Addr.IP, err)
+		}
+		if isSocket(cc.multicast6) {
+			err := ipv6.NewPacketConn(cc.multicast6).JoinGroup(iface, ipv6Addr)
+			c.healed(cfg, iface, ipv6Addr.IP, err)
+		}
+	}
+}
+
+// healed reports the outcome of rejoining a group. A join refused because the
+// socket is already a member means nothing needed healing.
+func (c *Client) healed(cfg MembershipConfig, iface *net.Interface, group net.IP, err error) {
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return
+	}
+
+	name := interfaceLabel(iface)
+	if err != nil {
+		c.errLog.Printf("[ERR] mdns: Failed to verify membership of %s on %s: %v", group, name, err)
+	} else {
+		c.log.Printf("[WARN] mdns: Rejoined lost multicast group %s on %s", group, name)
+	}
+	if cfg.OnHeal != nil {
+		cfg.OnHeal(MembershipEvent{Interface: iface, Group: group, Time: time.Now(), Err: err})
+	}
+}
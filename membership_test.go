@@ -0,0 +1,44 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"log"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestClient_MembershipCheck(t *testing.T) {
+	c, err := NewClient(true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	var events []MembershipEvent
+	cfg := MembershipConfig{OnHeal: func(e MembershipEvent) { events = append(events, e) }}
+
+	// Still a member, nothing to heal
+	c.checkMembership(cfg)
+	if len(events) != 0 {
+		t.Fatalf("unexpected events: %v", events)
+	}
+
+	// Drop the membership behind the Client's back
+	if err := ipv4.NewPacketConn(c.ipv4MulticastConn).LeaveGroup(nil, ipv4Addr); err != nil {
+		t.Skipf("cannot leave the mDNS group: %v", err)
+	}
+	c.checkMembership(cfg)
+	if len(events) != 1 || events[0].Err != nil || !events[0].Group.Equal(ipv4Addr.IP) {
+		t.Fatalf("bad events: %+v", events)
+	}
+
+	// Healed
+	events = nil
+	c.checkMembership(cfg)
+	if len(events) != 0 {
+		t.Fatalf("unexpected events: %v", events)
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+// Package memnet is an in-memory multicast network for testing mDNS clients
+// and responders without real sockets. Every Host on a Network implements the
+// mdns.Transport interface. The network can drop, duplicate, reorder, and
+// delay packets to exercise retransmission, deduplication, and timeout logic
+// under adverse conditions.
+package memnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// firstEphemeralPort is the first port handed out to connections that
+	// do not ask for one.
+	firstEphemeralPort = 49152
+
+	// queueLen is the number of packets a connection buffers before
+	// further packets are dropped, as a full socket buffer would.
+	queueLen = 1024
+)
+
+// errClosed is returned when using a closed connection. Like the error of a
+// closed socket, it wraps net.ErrClosed.
+var errClosed = fmt.Errorf("memnet: %w", net.ErrClosed)
+
+// Latency returns the delay of a single packet.
+type Latency func(r *rand.Rand) time.Duration
+
+// Fixed delays every packet by d.
+func Fixed(d time.Duration) Latency {
+	return func(*rand.Rand) time.Duration { return d }
+}
+
+// Uniform delays packets by a duration drawn uniformly from [min, max).
+func Uniform(min, max time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+// Exponential delays packets by a duration drawn from an exponential
+// distribution with the given mean, which models occasional long stalls.
+func Exponential(mean time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
+
+// Config describes the impairments of a Network. Probabilities are applied to
+// every delivery of a packet separately, so one multicast packet may reach
+// some members and not others.
+type Config struct {
+	Loss      float64 // Probability that a packet is dropped
+	Duplicate float64 // Probability that a packet is delivered twice
+	Reorder   float64 // Probability that a packet is held back by ReorderDelay
+	Latency   Latency // Delay of every packet, nil for none
+
+	// ReorderDelay is how long reordered packets are held back, default 10ms.
+	ReorderDelay time.Duration
+
+	// Seed seeds the random source deciding the fate of packets.
+	Seed int64
+}
+
+// Stats counts what happened to the packets sent on a Network.
+type Stats struct {
+	Sent       uint64 // Packets written by connections
+	Delivered  uint64 // Packets queued on a receiving connection
+	Dropped    uint64 // Deliveries lost to Config.Loss or full queues
+	Duplicated uint64 // Extra deliveries caused by Config.Duplicate
+	Reordered  uint64 // Deliveries held back by Config.Reorder
+}
+
+// Network is an in-memory multicast network.
+type Network struct {
+	mu       sync.Mutex
+	cfg      Config
+	rng      *rand.Rand
+	conns    map[*Conn]struct{}
+	nextPort int
+	stats    Stats
+}
+
+// New returns a Network with the given impairments.
+func New(cfg Config) *Network {
+	return &Network{
+		cfg:      withDefaults(cfg),
+		rng:      rand.New(rand.NewSource(cfg.Seed)),
+		conns:    make(map[*Conn]struct{}),
+		nextPort: firstEphemeralPort,
+	}
+}
+
+// withDefaults fills in the unset fields of a Config.
+func withDefaults(cfg Config) Config {
+	if cfg.ReorderDelay == 0 {
+		cfg.ReorderDelay = 10 * time.Millisecond
+	}
+	return cfg
+}
+
+// SetConfig changes the impairments of the network. The random source is
+// not reseeded.
+func (n *Network) SetConfig(cfg Config) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cfg = withDefaults(cfg)
+}
+
+// Stats returns the packet counters of the network.
+func (n *Network) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stats
+}
+
+// Host returns a host attached to the network with the given addresses. The
+// first IPv4 address is used by "udp4" connections and the first IPv6
+// address by "udp6" connections.
+func (n *Network) Host(addrs ...net.IP) *Host {
+	h := &Host{net: n}
+	for _, ip := range addrs {
+		if ip4 := ip.To4(); ip4 != nil {
+			if h.ip4 == nil {
+				h.ip4 = ip4
+			}
+		} else if h.ip6 == nil {
+			h.ip6 = ip
+		}
+	}
+	return h
+}
+
+// Host is a machine attached to a Network. It implements mdns.Transport.
+type Host struct {
+	net      *Network
+	ip4, ip6 net.IP
+}
+
+// ip returns the address of the host for the network, "udp4" or "udp6".
+func (h *Host) ip(network string) (net.IP, error) {
+	var ip net.IP
+	switch network {
+	case "udp4":
+		ip = h.ip4
+	case "udp6":
+		ip = h.ip6
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+	if ip == nil {
+		return nil, errors.New("memnet: host has no " + network + " address")
+	}
+	return ip, nil
+}
+
+// ListenUDP opens a connection on the host. The address of laddr is ignored
+// in favour of the host's; a zero port picks an unused one.
+func (h *Host) ListenUDP(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+	ip, err := h.ip(network)
+	if err != nil {
+		return nil, err
+	}
+	port := 0
+	if laddr != nil {
+		port = laddr.Port
+	}
+	return h.net.listen(&net.UDPAddr{IP: ip, Port: port}, nil), nil
+}
+
+// ListenMulticastUDP opens a connection on the host that receives packets
+// sent to the group as well as those sent to the host on the group's port.
+// The interface is ignored.
+func (h *Host) ListenMulticastUDP(network string, ifi *net.Interface, gaddr *net.UDPAddr) (net.PacketConn, error) {
+	ip, err := h.ip(network)
+	if err != nil {
+		return nil, err
+	}
+	return h.net.listen(&net.UDPAddr{IP: ip, Port: gaddr.Port}, gaddr.IP), nil
+}
+
+// listen registers a connection.
+func (n *Network) listen(addr *net.UDPAddr, group net.IP) *Conn {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if addr.Port == 0 {
+		addr.Port = n.nextPort
+		n.nextPort++
+	}
+	c := &Conn{
+		net:    n,
+		addr:   addr,
+		group:  group,
+		queue:  make(chan packet, queueLen),
+		closed: make(chan struct{}),
+	}
+	n.conns[c] = struct{}{}
+	return c
+}
+
+// send delivers a packet to every connection it is addressed to, applying
+// the network's impairments.
+func (n *Network) send(b []byte, from, to *net.UDPAddr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stats.Sent++
+	for c := range n.conns {
+		if !c.receives(to) {
+			continue
+		}
+		if n.rng.Float64() < n.cfg.Loss {
+			n.stats.Dropped++
+			continue
+		}
+		copies := 1
+		if n.rng.Float64() < n.cfg.Duplicate {
+			copies++
+			n.stats.Duplicated++
+		}
+		for i := 0; i < copies; i++ {
+			var delay time.Duration
+			if n.cfg.Latency != nil {
+				delay = n.cfg.Latency(n.rng)
+			}
+			if n.rng.Float64() < n.cfg.Reorder {
+				delay += n.cfg.ReorderDelay
+				n.stats.Reordered++
+			}
+			p := packet{data: append([]byte(nil), b...), from: from}
+			if delay <= 0 {
+				n.deliver(c, p)
+				continue
+			}
+			c := c
+			time.AfterFunc(delay, func() {
+				n.mu.Lock()
+				defer n.mu.Unlock()
+				n.deliver(c, p)
+			})
+		}
+	}
+}
+
+// deliver queues a packet on a connection. The caller must hold the lock.
+func (n *Network) deliver(c *Conn, p packet) {
+	if _, ok := n.conns[c]; !ok {
+		return
+	}
+	select {
+	case c.queue <- p:
+		n.stats.Delivered++
+	default:
+		n.stats.Dropped++
+	}
+}
+
+// packet is a packet queued on a connection.
+type packet struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// Conn is a connection on a Network. It implements net.PacketConn.
+type Conn struct {
+	net   *Network
+	addr  *net.UDPAddr
+	group net.IP // multicast group joined, if any
+
+	queue     chan packet
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+// receives reports whether packets sent to addr reach the connection.
+func (c *Conn) receives(to *net.UDPAddr) bool {
+	if to.Port != c.addr.Port {
+		return false
+	}
+	if to.IP.IsMulticast() {
+		return c.group != nil && c.group.Equal(to.IP)
+	}
+	return to.IP.Equal(c.addr.IP)
+}
+
+// ReadFrom reads the next packet delivered to the connection.
+func (c *Conn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case p := <-c.queue:
+		return copy(b, p.data), p.from, nil
+	case <-c.closed:
+		return 0, nil, errClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo sends a packet to addr, which must be a *net.UDPAddr.
+func (c *Conn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, errClosed
+	default:
+	}
+	to, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, errors.New("memnet: address is not a *net.UDPAddr")
+	}
+	from := &net.UDPAddr{IP: c.addr.IP, Port: c.addr.Port}
+	c.net.send(b, from, to)
+	return len(b), nil
+}
+
+// Close detaches the connection from the network.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.net.mu.Lock()
+		delete(c.net.conns, c)
+		c.net.mu.Unlock()
+		close(c.closed)
+	})
+	return nil
+}
+
+// LocalAddr returns the address of the connection.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// SetDeadline sets the read deadline; writes never block.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future ReadFrom calls.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+// SetWriteDeadline has no effect, as writes never block.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
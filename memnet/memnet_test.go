@@ -0,0 +1,122 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package memnet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+var group = &net.UDPAddr{IP: net.ParseIP("224.0.0.251"), Port: 5353}
+
+func listen(t *testing.T, n *Network, ip string) (unicast, multicast net.PacketConn) {
+	h := n.Host(net.ParseIP(ip))
+	u, err := h.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	m, err := h.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return u, m
+}
+
+// read returns the packets received by conn within the timeout.
+func read(conn net.PacketConn, timeout time.Duration) []string {
+	var out []string
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return out
+		}
+		out = append(out, string(buf[:n]))
+	}
+}
+
+func TestNetwork_Delivery(t *testing.T) {
+	n := New(Config{})
+	ua, _ := listen(t, n, "10.0.0.1")
+	ub, mb := listen(t, n, "10.0.0.2")
+
+	// Multicast reaches group members, unicast only its destination
+	ua.WriteTo([]byte("query"), group)
+	if got := read(mb, 10*time.Millisecond); len(got) != 1 || got[0] != "query" {
+		t.Fatalf("bad multicast delivery: %v", got)
+	}
+	ub.WriteTo([]byte("answer"), ua.LocalAddr())
+	if got := read(ua, 10*time.Millisecond); len(got) != 1 || got[0] != "answer" {
+		t.Fatalf("bad unicast delivery: %v", got)
+	}
+	if got := read(mb, 10*time.Millisecond); len(got) != 0 {
+		t.Fatalf("unicast leaked: %v", got)
+	}
+
+	ua.Close()
+	if _, err := ua.WriteTo([]byte("late"), group); !errors.Is(err, errClosed) {
+		t.Fatalf("got %v, want errClosed", err)
+	}
+	if _, _, err := mb.ReadFrom(nil); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestNetwork_Impairments(t *testing.T) {
+	n := New(Config{Loss: 1})
+	ua, _ := listen(t, n, "10.0.0.1")
+	_, mb := listen(t, n, "10.0.0.2")
+
+	ua.WriteTo([]byte("lost"), group)
+	if got := read(mb, 10*time.Millisecond); len(got) != 0 {
+		t.Fatalf("packet should be lost: %v", got)
+	}
+
+	n.SetConfig(Config{Duplicate: 1})
+	ua.WriteTo([]byte("twice"), group)
+	if got := read(mb, 10*time.Millisecond); len(got) != 2 {
+		t.Fatalf("packet should be duplicated: %v", got)
+	}
+
+	n.SetConfig(Config{Latency: Fixed(30 * time.Millisecond)})
+	ua.WriteTo([]byte("slow"), group)
+	if got := read(mb, 10*time.Millisecond); len(got) != 0 {
+		t.Fatalf("packet arrived early: %v", got)
+	}
+	if got := read(mb, 50*time.Millisecond); len(got) != 1 {
+		t.Fatalf("delayed packet was not delivered: %v", got)
+	}
+
+	// A reordered packet is overtaken by the next one
+	n.SetConfig(Config{Reorder: 1, ReorderDelay: 20 * time.Millisecond})
+	ua.WriteTo([]byte("first"), group)
+	n.SetConfig(Config{})
+	ua.WriteTo([]byte("second"), group)
+	if got := read(mb, 50*time.Millisecond); len(got) != 2 || got[0] != "second" || got[1] != "first" {
+		t.Fatalf("packets were not reordered: %v", got)
+	}
+
+	// Multicast is looped back to the sender's host too, so every packet
+	// has two deliveries
+	s := n.Stats()
+	if s.Sent != 5 || s.Dropped != 2 || s.Duplicated != 2 || s.Reordered != 2 || s.Delivered != 10 {
+		t.Fatalf("bad stats: %+v", s)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	n := New(Config{Seed: 1})
+	for i := 0; i < 100; i++ {
+		if d := Uniform(10*time.Millisecond, 20*time.Millisecond)(n.rng); d < 10*time.Millisecond || d >= 20*time.Millisecond {
+			t.Fatalf("uniform latency out of range: %v", d)
+		}
+		if d := Exponential(time.Millisecond)(n.rng); d < 0 {
+			t.Fatalf("negative latency: %v", d)
+		}
+	}
+}
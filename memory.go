@@ -0,0 +1,105 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+const (
+	// recordOverhead approximates the memory a cached record takes beyond its
+	// wire size: the parsed record, its list element, and its map slot.
+	recordOverhead = 160
+
+	// entryOverhead approximates the memory of an entry being resolved by a
+	// query or browse.
+	entryOverhead = 512
+
+	// messageOverhead approximates the memory of a received message waiting
+	// to be handled by a query or browse.
+	messageOverhead = 1024
+
+	// eventOverhead approximates the memory of a kept browse event, along
+	// with its entries.
+	eventOverhead = 1024
+)
+
+// ShedPolicy selects the records a Client drops first to stay within its
+// memory budget, see SetMemoryBudget.
+type ShedPolicy int
+
+const (
+	// ShedPassiveFirst drops passive records, those received in responses
+	// that no running query or browse asked for, before any other, least
+	// recently used first.
+	// Records that answer what the application asks for are kept longest.
+	ShedPassiveFirst ShedPolicy = iota
+
+	// ShedOldest drops the least recently used records first, whether they
+	// were asked for or overheard.
+	ShedOldest
+)
+
+// MemoryStats reports the approximate memory used by a Client, in bytes.
+type MemoryStats struct {
+	Cache      int    // Cached records, see CacheStats
+	InProgress int    // Entries being resolved by queries and browses
+	Queued     int    // Received messages waiting to be handled by queries and browses
+	History    int    // Browse events kept, see SetBrowseHistory
+	Total      int    // Sum of the above
+	Budget     int    // Limit set with SetMemoryBudget, zero if none
+	Shed       uint64 // Records dropped to stay within the budget
+}
+
+// SetMemoryBudget limits the approximate memory used by the Client's cache,
+// in-progress entries, queued messages, and browse history to budget bytes,
+// so that it can run in a container with little memory. Only the cache can
+// give memory back: whenever the Client is over budget, cached records are
+// dropped in the order selected by policy until it is within it again, or the
+// cache is empty. A budget of zero, the default, disables it. Records dropped
+// are reported as evicted.
+func (c *Client) SetMemoryBudget(budget int, policy ShedPolicy) {
+	c.cache.reserve(c.memoryUsage().reserved())
+	c.cache.setBudget(budget, policy)
+}
+
+// MemoryStats returns the approximate memory used by the Client.
+func (c *Client) MemoryStats() MemoryStats {
+	usage := c.memoryUsage()
+	c.cache.mu.Lock()
+	stats := MemoryStats{
+		Cache:  c.cache.memory(),
+		Budget: c.cache.budget,
+		Shed:   c.cache.shed,
+	}
+	c.cache.mu.Unlock()
+	stats.InProgress = usage.inProgress
+	stats.Queued = usage.queued
+	stats.History = usage.history
+	stats.Total = stats.Cache + usage.reserved()
+	return stats
+}
+
+// memoryUsage is the memory used by the parts of a Client other than its
+// cache.
+type memoryUsage struct {
+	inProgress int
+	queued     int
+	history    int
+}
+
+// reserved returns the memory the cache must leave to the other parts.
+func (u memoryUsage) reserved() int {
+	return u.inProgress + u.queued + u.history
+}
+
+// memoryUsage measures the memory used by the parts of the Client other than
+// its cache.
+func (c *Client) memoryUsage() memoryUsage {
+	var u memoryUsage
+	c.mu.Lock()
+	for sub := range c.subs {
+		u.inProgress += int(sub.entries.Load()) * entryOverhead
+		u.queued += len(sub.ch) * messageOverhead
+	}
+	c.mu.Unlock()
+	u.history = c.browseHistory.size() * eventOverhead
+	return u
+}
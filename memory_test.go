@@ -0,0 +1,130 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestCache_Budget(t *testing.T) {
+	ptr := func(i int) dns.RR {
+		return &dns.PTR{
+			Hdr: dns.RR_Header{Name: "_http._tcp.local.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120},
+			Ptr: fmt.Sprintf("i%d._http._tcp.local.", i),
+		}
+	}
+	fill := func(policy ShedPolicy) *cache {
+		c := newCache()
+		for i := 0; i < 10; i++ {
+			// Every other record is overheard
+			c.addFrom(ptr(i), "", nil, i%2 == 1)
+		}
+		c.setBudget(c.memory()-1, policy)
+		return c
+	}
+
+	c := fill(ShedPassiveFirst)
+	if s := c.stats(); s.Records != 9 || c.shed != 1 {
+		t.Fatalf("bad: %+v, shed %d", s, c.shed)
+	}
+	if got := c.instances("_http._tcp.local."); containsString(got, "i1._http._tcp.local.") || !containsString(got, "i0._http._tcp.local.") {
+		t.Fatalf("passive record not shed first: %v", got)
+	}
+
+	c = fill(ShedOldest)
+	if got := c.instances("_http._tcp.local."); containsString(got, "i0._http._tcp.local.") || !containsString(got, "i1._http._tcp.local.") {
+		t.Fatalf("oldest record not shed first: %v", got)
+	}
+
+	// Memory used elsewhere leaves less room for the cache
+	c.reserve(c.memory())
+	if s := c.stats(); s.Records != 0 || s.Evictions != 10 {
+		t.Fatalf("bad: %+v", s)
+	}
+	c.add(ptr(0), "")
+	if s := c.stats(); s.Records != 0 {
+		t.Fatalf("record cached beyond the budget: %+v", s)
+	}
+}
+
+func TestClient_AskedFor(t *testing.T) {
+	c := &Client{cache: newCache(), closedCh: make(chan struct{})}
+	sub := c.subscribe()
+	defer c.unsubscribe(sub)
+	c.asking(sub, "_IPP._tcp.local.")
+
+	// Records are passive unless a running query asks about them, whatever
+	// else is running
+	c.handleMsg(makeResponse(t, makeService(t)), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: mdnsPort}, 0)
+	if srvs := c.cache.lookup("hostname._http._tcp.local.", dns.TypeSRV); len(srvs) != 1 || srvs[0].passive == nil {
+		t.Fatalf("record nothing asked for is not passive: %v", srvs)
+	}
+	c.handleMsg(avahiResponse(t), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: mdnsPort}, 0)
+	if srvs := c.cache.lookup(`Printer\ A._ipp._tcp.local.`, dns.TypeSRV); len(srvs) != 1 || srvs[0].passive != nil {
+		t.Fatalf("record asked for is passive: %v", srvs)
+	}
+
+	// An instance of a service asked for is asked about too
+	c.handleMsg(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{
+		mustRR(t, `Printer\ C._ipp._tcp.local. 120 IN SRV 0 0 631 hostc.local.`),
+	}}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: mdnsPort}, 0)
+	if srvs := c.cache.lookup(`Printer\ C._ipp._tcp.local.`, dns.TypeSRV); len(srvs) != 1 || srvs[0].passive != nil {
+		t.Fatalf("instance asked for is passive: %v", srvs)
+	}
+
+	// The least recently used passive record is shed first
+	c.cache.get("hostname._http._tcp.local.", dns.TypeSRV)
+	c.cache.mu.Lock()
+	victim := c.cache.shedVictim()
+	c.cache.mu.Unlock()
+	if victim.passive == nil || victim.rr.Header().Rrtype == dns.TypeSRV {
+		t.Fatalf("bad victim: %v", victim.rr)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClient_MemoryStats(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	c.SetBrowseHistory(4, 0)
+	c.browseHistory.add(BrowseEvent{})
+	c.handleMsg(makeResponse(t, makeService(t)), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: mdnsPort}, 0)
+	s := c.MemoryStats()
+	if s.Cache <= 0 || s.History != eventOverhead || s.InProgress != 0 || s.Queued != 0 || s.Budget != 0 {
+		t.Fatalf("bad: %+v", s)
+	}
+	if s.Total != s.Cache+s.History {
+		t.Fatalf("bad total: %+v", s)
+	}
+
+	// Nothing was asked for, so the records are passive and shed first
+	c.cache.add(mustRR(t, "asked._http._tcp.local. 120 IN PTR a._http._tcp.local."), "")
+	c.SetMemoryBudget(eventOverhead+recordOverhead+100, ShedPassiveFirst)
+	s = c.MemoryStats()
+	if s.Total > s.Budget || s.Shed == 0 {
+		t.Fatalf("bad: %+v", s)
+	}
+	if got := c.cache.get("asked._http._tcp.local.", dns.TypePTR); len(got) != 1 {
+		t.Fatalf("record asked for was shed: %+v", s)
+	}
+}
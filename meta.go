@@ -0,0 +1,187 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// metaQueryWindow is how long QueryPresent waits for the service types of a
+// domain to be reported.
+const metaQueryWindow = time.Second
+
+// QueryPresent is like QueryContext, but first asks which service types exist
+// in each domain with the service type enumeration meta-query of RFC 6763,
+// section 9, and then only queries for the ones that do. On networks where
+// most of the requested types are absent, this sends a single question per
+// domain instead of a series per type. Types are matched case-insensitively.
+func QueryPresent(ctx context.Context, params *[]QueryParam, respChan chan<- *ServiceEntry, queryClient *Client) error {
+	asked := make(map[string]bool)
+	present := make(map[string]bool)
+	for _, par := range *params {
+		enumAddr := ServiceName("_services._dns-sd._udp", par.withDefaults().Domain)
+		if asked[strings.ToLower(enumAddr)] {
+			continue
+		}
+		asked[strings.ToLower(enumAddr)] = true
+		types, err := queryClient.serviceTypes(ctx, enumAddr)
+		if err != nil {
+			return err
+		}
+		for _, t := range types {
+			present[strings.ToLower(t)] = true
+		}
+	}
+
+	var found []QueryParam
+	for _, par := range *params {
+		if present[strings.ToLower(ServiceName(ParentService(par.Service), par.withDefaults().Domain))] {
+			found = append(found, par)
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	return QueryContext(ctx, &found, respChan, queryClient)
+}
+
+// serviceTypes sends the service type enumeration meta-query and returns the
+// service types known once the responses had time to arrive.
+func (c *Client) serviceTypes(ctx context.Context, enumAddr string) ([]string, error) {
+	if err := c.acquireQuery(ctx); err != nil {
+		return nil, err
+	}
+	defer c.releaseQuery()
+
+	m := new(dns.Msg)
+	m.SetQuestion(enumAddr, dns.TypePTR)
+	m.RecursionDesired = false
+	if err := c.sendQuery(ctx, m, allStacks, nil); err != nil {
+		return nil, fmt.Errorf("mdns: failed to enumerate service types: %v", err)
+	}
+
+	// Responses are cached as they arrive
+	select {
+	case <-time.After(metaQueryWindow):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.closedCh:
+		return nil, ErrClientClosed
+	}
+	return c.cache.instances(enumAddr), nil
+}
+
+// ServiceType is a service type present on the network, as reported by
+// EnumerateServiceTypes.
+type ServiceType struct {
+	Service string // Service type, e.g. "_http._tcp"
+	Domain  string // Domain, e.g. "local"
+	Name    string // Fully qualified name, e.g. "_http._tcp.local."
+	SrcIP   net.IP // Address of the host that reported it, nil if it was cached
+}
+
+// newServiceType returns the service type a fully qualified name describes.
+func newServiceType(name string, src net.IP) (ServiceType, bool) {
+	labels := Labels(name)
+	if len(labels) < 3 {
+		return ServiceType{}, false
+	}
+	return ServiceType{
+		Service: labels[0] + "." + labels[1],
+		Domain:  strings.Join(labels[2:], "."),
+		Name:    Fqdn(name),
+		SrcIP:   src,
+	}, true
+}
+
+// EnumerateServiceTypes asks which service types are present in the domain of
+// params with the service type enumeration meta-query of RFC 6763, section 9,
+// and sends each type to types once, as soon as it is known, so that discovery
+// interfaces can list what the network offers without knowing the service
+// types in advance. The types already cached are sent first. The questions are
+// retransmitted like those of a query until its Timeout; the Service and
+// Entries fields of params are ignored. Types are dropped if types is not
+// ready to receive them, so it should be buffered.
+func (c *Client) EnumerateServiceTypes(ctx context.Context, params QueryParam, types chan<- ServiceType) error {
+	params.Service = "_services._dns-sd._udp"
+	par := params.withDefaults()
+	via := par.stacks()
+	if !via.usable(c) {
+		return fmt.Errorf("mdns: query disables every IP stack the Client uses")
+	}
+	if err := c.acquireQuery(ctx); err != nil {
+		return err
+	}
+	defer c.releaseQuery()
+	enumAddr := ServiceName(par.Service, par.Domain)
+
+	sub, err := c.askQuestions(ctx, []QueryParam{par})
+	if err != nil {
+		return err
+	}
+	defer c.unsubscribe(sub)
+
+	seen := make(map[string]bool)
+	found := func(name string, src net.IP) {
+		if seen[strings.ToLower(name)] {
+			return
+		}
+		seen[strings.ToLower(name)] = true
+		if t, ok := newServiceType(name, src); ok {
+			select {
+			case types <- t:
+			default:
+			}
+		}
+	}
+	for _, name := range c.cache.instances(enumAddr) {
+		found(name, nil)
+	}
+
+	retransmits := newRetransmissions([]QueryParam{par}, c.clk().Now())
+	retransmit := c.clk().NewTimer(0)
+	defer retransmit.Stop()
+	retransmits.reset(c.clk(), retransmit)
+	finish := c.clk().NewTimer(par.Timeout)
+	defer finish.Stop()
+
+	for {
+		select {
+		case <-retransmit.Chan():
+			due := retransmits.due([]QueryParam{par}, c.clk().Now())
+			retransmits.reset(c.clk(), retransmit)
+			if len(due) == 0 {
+				continue
+			}
+			if err := c.sendQuestions(ctx, due, true); err != nil {
+				c.log.Printf("[ERR] mdns: Failed to retransmit query: %v", err)
+			}
+
+		case resp := <-sub.ch:
+			if !resp.msg.Response || !par.accepts(resp) {
+				continue
+			}
+			for _, rr := range append(resp.msg.Answer, resp.msg.Extra...) {
+				if ptr, ok := rr.(*dns.PTR); ok && ptr.Hdr.Ttl > 0 && strings.EqualFold(ptr.Hdr.Name, enumAddr) {
+					found(ptr.Ptr, resp.src.IP)
+				}
+			}
+
+		case <-finish.Chan():
+			return nil
+
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-c.closedCh:
+			return ErrClientClosed
+		}
+	}
+}
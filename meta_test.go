@@ -0,0 +1,90 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestQueryPresent(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_present._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	client, err := NewClient(true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	entries := make(chan *ServiceEntry, 4)
+	params := &[]QueryParam{{Service: "_absent._tcp"}, {Service: "_present._tcp"}}
+	if err := QueryPresent(ctx, params, entries, client); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	select {
+	case e := <-entries:
+		if e.Name != "hostname._present._tcp.local." {
+			t.Fatalf("bad: %+v", e)
+		}
+	default:
+		t.Fatalf("present service was not discovered")
+	}
+	if _, ok := client.RecentlyQueried("_absent._tcp", ""); ok {
+		t.Fatalf("absent service should not have been queried")
+	}
+}
+
+func TestClient_EnumerateServiceTypes(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_enumerated._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	client, err := NewClient(true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer client.Close()
+
+	types := make(chan ServiceType, 16)
+	params := QueryParam{Timeout: 200 * time.Millisecond}
+	if err := client.EnumerateServiceTypes(context.Background(), params, types); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	close(types)
+
+	seen := 0
+	for typ := range types {
+		if typ.Service != "_enumerated._tcp" {
+			continue
+		}
+		seen++
+		if typ.Domain != "local" || typ.Name != "_enumerated._tcp.local." {
+			t.Fatalf("bad: %+v", typ)
+		}
+	}
+	if seen != 1 {
+		t.Fatalf("service type reported %d times", seen)
+	}
+}
+
+func TestNewServiceType(t *testing.T) {
+	typ, ok := newServiceType("_http._tcp.site.example.", nil)
+	if !ok || typ.Service != "_http._tcp" || typ.Domain != "site.example" || typ.Name != "_http._tcp.site.example." {
+		t.Fatalf("bad: %+v", typ)
+	}
+	if _, ok := newServiceType("local.", nil); ok {
+		t.Fatalf("name without a service type was accepted")
+	}
+}
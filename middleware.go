@@ -0,0 +1,42 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// Handler answers a question received by a Server from the host at from.
+type Handler interface {
+	ServeQuestion(q dns.Question, from net.Addr) []dns.RR
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(q dns.Question, from net.Addr) []dns.RR
+
+// ServeQuestion calls f(q, from).
+func (f HandlerFunc) ServeQuestion(q dns.Question, from net.Addr) []dns.RR {
+	return f(q, from)
+}
+
+// Middleware wraps the Handler answering questions, so that policies such as
+// logging, access control, rewriting, or metrics can be applied to every
+// zone served. A Middleware may answer a question itself, call the next
+// Handler with the same or another question, and modify the records it
+// returns.
+type Middleware func(next Handler) Handler
+
+// handler returns the Handler answering questions: the Server's zones wrapped
+// in the configured middleware, the first of which is outermost.
+func (s *Server) handler() Handler {
+	var h Handler = HandlerFunc(func(q dns.Question, from net.Addr) []dns.RR {
+		return s.zone().Records(q)
+	})
+	for i := len(s.config.Middleware) - 1; i >= 0; i-- {
+		h = s.config.Middleware[i](h)
+	}
+	return h
+}
@@ -0,0 +1,56 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestServer_Middleware(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(q dns.Question, from net.Addr) []dns.RR {
+				order = append(order, name)
+				return next.ServeQuestion(q, from)
+			})
+		}
+	}
+	// Only answer hosts on 192.168.0.0/24
+	_, allowed, _ := net.ParseCIDR("192.168.0.0/24")
+	acl := func(next Handler) Handler {
+		return HandlerFunc(func(q dns.Question, from net.Addr) []dns.RR {
+			if addr, ok := from.(*net.UDPAddr); !ok || !allowed.Contains(addr.IP) {
+				return nil
+			}
+			return next.ServeQuestion(q, from)
+		})
+	}
+
+	s := &Server{config: &Config{
+		Zone:       makeService(t),
+		Middleware: []Middleware{trace("outer"), acl, trace("inner")},
+	}}
+	q := dns.Question{Name: "_http._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET}
+
+	mrecs, _ := s.handleQuestion(s.handler(), q, &net.UDPAddr{IP: net.ParseIP("192.168.0.7"), Port: 5353})
+	if len(mrecs) == 0 {
+		t.Fatalf("allowed host got no answer")
+	}
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Fatalf("bad middleware order: %v", order)
+	}
+
+	order = nil
+	mrecs, _ = s.handleQuestion(s.handler(), q, &net.UDPAddr{IP: net.ParseIP("10.0.0.7"), Port: 5353})
+	if len(mrecs) != 0 {
+		t.Fatalf("denied host was answered: %v", mrecs)
+	}
+	if len(order) != 1 {
+		t.Fatalf("inner middleware should not run for denied hosts: %v", order)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultDomain is the domain used when none is given.
+	defaultDomain = "local"
+
+	// subtypeLabel separates a subtype from the service it belongs to, as
+	// described in RFC 6763, section 7.1.
+	subtypeLabel = "_sub"
+)
+
+// TrimDot trims the dots from the start or end of a name.
+func TrimDot(s string) string {
+	return strings.Trim(s, ".")
+}
+
+// Fqdn returns name as a fully qualified domain name, ending in a single
+// period.
+func Fqdn(name string) string {
+	return TrimDot(name) + "."
+}
+
+// Labels splits a name into its labels. Escaped characters, such as dots
+// within an instance name, are left escaped.
+func Labels(name string) []string {
+	return dns.SplitDomainName(name)
+}
+
+// ServiceName returns the fully qualified name of a service in a domain, e.g.
+// "_http._tcp.local.". An empty domain means "local".
+func ServiceName(service, domain string) string {
+	if TrimDot(domain) == "" {
+		domain = defaultDomain
+	}
+	return fmt.Sprintf("%s.%s.", TrimDot(service), TrimDot(domain))
+}
+
+// SubtypeName returns the name of a subtype of a service, e.g.
+// "_printer._sub._http._tcp" for the subtype "_printer" of "_http._tcp". It
+// can be queried like any other service, and finds the instances of the
+// service that have the subtype.
+func SubtypeName(subtype, service string) string {
+	return TrimDot(subtype) + "." + subtypeLabel + "." + TrimDot(service)
+}
+
+// ParentService returns the service a subtype belongs to, e.g. "_http._tcp"
+// for "_printer._sub._http._tcp", or the service itself if it is not a
+// subtype. A fully qualified name stays fully qualified.
+func ParentService(service string) string {
+	labels := Labels(service)
+	if len(labels) < 3 || !strings.EqualFold(labels[1], subtypeLabel) {
+		return service
+	}
+	parent := strings.Join(labels[2:], ".")
+	if strings.HasSuffix(service, ".") {
+		parent += "."
+	}
+	return parent
+}
+
+// InstanceName returns the fully qualified name of a service instance, e.g.
+// "My Printer._ipp._tcp.local.". Dots and backslashes in the instance name
+// are escaped. An empty domain means "local". The instances of a subtype are
+// named after the service it belongs to.
+func InstanceName(instance, service, domain string) string {
+	return escapeLabel(instance) + "." + ServiceName(ParentService(service), domain)
+}
+
+// SplitInstanceName splits a fully qualified instance name, as reported in
+// ServiceEntry.Name, into the unescaped instance name, the service, and the
+// domain.
+func SplitInstanceName(name string) (instance, service, domain string, err error) {
+	labels := Labels(name)
+	if len(labels) < 4 {
+		return "", "", "", fmt.Errorf("%q is not a service instance name", name)
+	}
+	instance, err = unescapeLabel(labels[0])
+	if err != nil {
+		return "", "", "", err
+	}
+	service = labels[1] + "." + labels[2]
+	domain = strings.Join(labels[3:], ".")
+	return instance, service, domain, nil
+}
+
+// canonicalName returns name in a canonical form for comparisons: lower
+// case, fully qualified, and with only dots and backslashes escaped, however
+// they were escaped in name. Labels with a malformed escape are kept as is.
+func canonicalName(name string) string {
+	var b strings.Builder
+	for _, label := range Labels(name) {
+		if unescaped, err := unescapeLabel(label); err == nil {
+			label = escapeLabel(unescaped)
+		}
+		b.WriteString(strings.ToLower(label))
+		b.WriteByte('.')
+	}
+	if b.Len() == 0 {
+		return "."
+	}
+	return b.String()
+}
+
+// escapeLabel escapes the characters of a label that would otherwise be
+// interpreted as part of the name's syntax.
+func escapeLabel(label string) string {
+	var b strings.Builder
+	for i := 0; i < len(label); i++ {
+		switch c := label[i]; c {
+		case '.', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// unescapeLabel reverses the escaping of a label in presentation format,
+// handling both \X and \DDD escapes.
+func unescapeLabel(label string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+3 < len(label) && isDigit(label[i+1]) && isDigit(label[i+2]) && isDigit(label[i+3]) {
+			n := int(label[i+1]-'0')*100 + int(label[i+2]-'0')*10 + int(label[i+3]-'0')
+			if n > 255 {
+				return "", fmt.Errorf("bad escape in label %q", label)
+			}
+			b.WriteByte(byte(n))
+			i += 3
+			continue
+		}
+		if i+1 >= len(label) {
+			return "", fmt.Errorf("trailing backslash in label %q", label)
+		}
+		i++
+		b.WriteByte(label[i])
+	}
+	return b.String(), nil
+}
+
+// isDigit reports whether c is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,88 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServiceName(t *testing.T) {
+	for _, test := range []struct {
+		service, domain, want string
+	}{
+		{"_http._tcp", "local", "_http._tcp.local."},
+		{"_http._tcp.", ".local.", "_http._tcp.local."},
+		{"_http._tcp", "", "_http._tcp.local."},
+		{"_http._tcp", "example.com", "_http._tcp.example.com."},
+	} {
+		if got := ServiceName(test.service, test.domain); got != test.want {
+			t.Errorf("ServiceName(%q, %q) = %q, want %q", test.service, test.domain, got, test.want)
+		}
+	}
+}
+
+func TestFqdn(t *testing.T) {
+	for in, want := range map[string]string{
+		"host":        "host.",
+		"host.local.": "host.local.",
+		".host..":     "host.",
+	} {
+		if got := Fqdn(in); got != want {
+			t.Errorf("Fqdn(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSubtypeName(t *testing.T) {
+	if got := SubtypeName("_printer", "_http._tcp."); got != "_printer._sub._http._tcp" {
+		t.Fatalf("bad: %q", got)
+	}
+	for in, want := range map[string]string{
+		"_printer._sub._http._tcp":        "_http._tcp",
+		"_printer._SUB._http._tcp.local.": "_http._tcp.local.",
+		"_http._tcp":                      "_http._tcp",
+		"_http._tcp.local.":               "_http._tcp.local.",
+		"_sub._http._tcp":                 "_sub._http._tcp",
+	} {
+		if got := ParentService(in); got != want {
+			t.Errorf("ParentService(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := InstanceName("Printer", "_printer._sub._http._tcp", ""); got != "Printer._http._tcp.local." {
+		t.Fatalf("bad: %q", got)
+	}
+}
+
+func TestInstanceName_RoundTrip(t *testing.T) {
+	name := InstanceName(`My.Printer\2`, "_ipp._tcp", "local")
+	if want := `My\.Printer\\2._ipp._tcp.local.`; name != want {
+		t.Fatalf("got %q, want %q", name, want)
+	}
+	if got, want := Labels(name), []string{`My\.Printer\\2`, "_ipp", "_tcp", "local"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	instance, service, domain, err := SplitInstanceName(name)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if instance != `My.Printer\2` || service != "_ipp._tcp" || domain != "local" {
+		t.Fatalf("bad split: %q %q %q", instance, service, domain)
+	}
+}
+
+func TestSplitInstanceName(t *testing.T) {
+	instance, _, _, err := SplitInstanceName(`Living\032Room._airplay._tcp.local.`)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if instance != "Living Room" {
+		t.Fatalf("got %q, want %q", instance, "Living Room")
+	}
+
+	if _, _, _, err := SplitInstanceName("_http._tcp.local."); err == nil {
+		t.Fatalf("error expected for a service name")
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultInterfaceWatchInterval is how often the interfaces are listed
+	// by default to find the changes the system did not report.
+	defaultInterfaceWatchInterval = 5 * time.Second
+
+	// interfaceSettleDelay is how long a reported change is left to settle
+	// before the interfaces are listed, as bringing a link up comes with a
+	// burst of notifications, its addresses following its carrier.
+	interfaceSettleDelay = 250 * time.Millisecond
+)
+
+// InterfaceWatchConfig configures the tracking of network interface changes.
+type InterfaceWatchConfig struct {
+	// Interval is how often the interfaces are listed, default 5 seconds.
+	// Where the system reports changes, as Linux does through netlink, they
+	// are also noticed as they happen.
+	Interval time.Duration
+
+	// AllInterfaces keeps the interfaces the Client queries on, see
+	// SetInterfaces, to those returned by MulticastInterfaces, so that links
+	// such as a VPN are used as they come and go.
+	AllInterfaces bool
+
+	// OnChange, if set, is called for every change found, once the Client
+	// was rebound.
+	OnChange func(InterfaceEvent)
+}
+
+// InterfaceChange is the kind of change an InterfaceEvent describes.
+type InterfaceChange int
+
+const (
+	// InterfaceUp is sent when an interface appears or becomes able to
+	// carry mDNS traffic, see MulticastInterfaces.
+	InterfaceUp InterfaceChange = iota
+
+	// InterfaceDown is sent when an interface disappears or can no longer
+	// carry mDNS traffic, such as when it loses carrier.
+	InterfaceDown
+
+	// InterfaceAddrsChanged is sent when the addresses of an interface that
+	// is up change, such as when Wi-Fi reconnects to another network.
+	InterfaceAddrsChanged
+)
+
+// String returns the change as it is logged, e.g. "up".
+func (t InterfaceChange) String() string {
+	switch t {
+	case InterfaceUp:
+		return "up"
+	case InterfaceDown:
+		return "down"
+	case InterfaceAddrsChanged:
+		return "addresses changed"
+	}
+	return fmt.Sprintf("InterfaceChange(%d)", int(t))
+}
+
+// InterfaceEvent describes a change to a network interface.
+type InterfaceEvent struct {
+	Change    InterfaceChange
+	Interface net.Interface // The interface as last seen
+	Time      time.Time     // When the change was found
+	Err       error         // Non-nil if rebinding the Client failed
+}
+
+// EnableInterfaceWatch starts tracking the network interfaces of the host, so
+// that the Client keeps working as they change, such as when Wi-Fi reconnects
+// or a VPN is toggled. When an interface comes up or its addresses change,
+// the Client rejoins the mDNS groups, rebinds its sockets to the interfaces
+// it uses where they were recreated under a new index, and re-issues the
+// questions of every active query. Sockets are bound to the wildcard address,
+// so they need not be reopened. The watch runs until the returned function is
+// called or the Client is closed; enabling it again replaces it.
+func (c *Client) EnableInterfaceWatch(cfg InterfaceWatchConfig) (stop func()) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterfaceWatchInterval
+	}
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() { close(done) })
+	}
+	c.mu.Lock()
+	prev := c.interfaceWatch
+	c.interfaceWatch = stop
+	c.mu.Unlock()
+	if prev != nil {
+		prev()
+	}
+	go c.watchInterfaces(cfg, done)
+	return stop
+}
+
+// watchInterfaces checks the interfaces periodically and when the system
+// reports a change, until done is closed or the Client is.
+func (c *Client) watchInterfaces(cfg InterfaceWatchConfig, done <-chan struct{}) {
+	prev, err := snapshotInterfaces()
+	if err != nil {
+		c.log.Printf("[ERR] mdns: Failed to list interfaces: %v", err)
+	}
+	stopped := make(chan struct{})
+	defer close(stopped)
+	changes := interfaceChanges(stopped)
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	var settle <-chan time.Time // set while a reported change settles
+	for {
+		select {
+		case <-ticker.C:
+		case <-changes:
+			if settle == nil {
+				settle = time.After(interfaceSettleDelay)
+			}
+			continue
+		case <-settle:
+			settle = nil
+		case <-done:
+			return
+		case <-c.closedCh:
+			return
+		}
+		cur, err := snapshotInterfaces()
+		if err != nil {
+			c.log.Printf("[ERR] mdns: Failed to list interfaces: %v", err)
+			continue
+		}
+		events := diffInterfaces(prev, cur, time.Now())
+		prev = cur
+		c.ifaceNames.reset(cur)
+		if len(events) > 0 {
+			c.interfacesChanged(cfg, cur, events)
+		}
+	}
+}
+
+// interfacesChanged rebinds the Client after the interfaces changed, and
+// reports the changes.
+func (c *Client) interfacesChanged(cfg InterfaceWatchConfig, cur map[int]interfaceState, events []InterfaceEvent) {
+	for _, e := range events {
+		c.log.Printf("[INFO] mdns: Interface %s %s", e.Interface.Name, e.Change)
+	}
+	err := c.rebindInterfaces(cfg, cur)
+	if err != nil {
+		c.log.Printf("[ERR] mdns: Failed to rebind after an interface change: %v", err)
+	}
+
+	// Rejoin the groups, which an interface that went away and came back
+	// is no longer a member of, or which the system default interface may
+	// now name another of
+	used := c.groupInterfaces()
+	for _, ifi := range used {
+		c.joinGroups(ifi)
+	}
+	for _, e := range events {
+		if e.Change != InterfaceDown && usesInterface(used, e.Interface.Name) {
+			c.resendQueries()
+			break
+		}
+	}
+
+	if cfg.OnChange != nil {
+		for _, e := range events {
+			e.Err = err
+			cfg.OnChange(e)
+		}
+	}
+}
+
+// rebindInterfaces points the Client at the current instances of the
+// interfaces it uses, matched by name, as an interface that was removed and
+// created again, such as the tunnel of a VPN, has a new index.
+func (c *Client) rebindInterfaces(cfg InterfaceWatchConfig, cur map[int]interfaceState) error {
+	if cfg.AllInterfaces {
+		var ifaces []net.Interface
+		for _, s := range sortedStates(cur) {
+			if eligibleInterface(&s.ifi) {
+				ifaces = append(ifaces, s.ifi)
+			}
+		}
+		if sameInterfaces(c.Capabilities().Interfaces, ifaces) {
+			return nil
+		}
+		return c.SetInterfaces(ifaces)
+	}
+
+	c.mu.Lock()
+	iface, ifaces := c.iface, c.ifaces
+	c.mu.Unlock()
+	if len(ifaces) > 0 {
+		stale := false
+		refreshed := interfaceValues(ifaces)
+		for i := range refreshed {
+			if s, ok := stateByName(cur, refreshed[i].Name); ok && s.ifi.Index != refreshed[i].Index {
+				refreshed[i], stale = s.ifi, true
+			}
+		}
+		if !stale {
+			return nil
+		}
+		return c.SetInterfaces(refreshed)
+	}
+	if iface != nil {
+		if s, ok := stateByName(cur, iface.Name); ok && s.ifi.Index != iface.Index {
+			ifi := s.ifi
+			return c.SetInterface(&ifi)
+		}
+	}
+	return nil
+}
+
+// usesInterface reports whether the interfaces of groupInterfaces include the
+// one named, which the system default interface may be.
+func usesInterface(used []*net.Interface, name string) bool {
+	for _, ifi := range used {
+		if ifi == nil || ifi.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sameInterfaces reports whether two lists hold the same interfaces in the
+// same order.
+func sameInterfaces(a, b []net.Interface) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Index != b[i].Index || a[i].Name != b[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
+// interfaceState is an interface as listed, with its addresses.
+type interfaceState struct {
+	ifi   net.Interface
+	addrs string // sorted and joined, for comparison
+}
+
+// snapshotInterfaces lists the interfaces of the host by index.
+func snapshotInterfaces() (map[int]interfaceState, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int]interfaceState, len(all))
+	for _, ifi := range all {
+		var addrs []string
+		if as, err := ifi.Addrs(); err == nil {
+			for _, a := range as {
+				addrs = append(addrs, a.String())
+			}
+		}
+		sort.Strings(addrs)
+		out[ifi.Index] = interfaceState{ifi: ifi, addrs: strings.Join(addrs, ",")}
+	}
+	return out, nil
+}
+
+// diffInterfaces returns the changes between two lists of interfaces, in the
+// order of their indexes. Interfaces that cannot carry mDNS traffic in
+// either list, such as the loopback, are ignored.
+func diffInterfaces(prev, cur map[int]interfaceState, now time.Time) []InterfaceEvent {
+	var events []InterfaceEvent
+	for _, s := range sortedStates(cur) {
+		p, seen := prev[s.ifi.Index]
+		was := seen && eligibleInterface(&p.ifi)
+		switch is := eligibleInterface(&s.ifi); {
+		case is && !was:
+			events = append(events, InterfaceEvent{Change: InterfaceUp, Interface: s.ifi, Time: now})
+		case !is && was:
+			events = append(events, InterfaceEvent{Change: InterfaceDown, Interface: s.ifi, Time: now})
+		case is && p.addrs != s.addrs:
+			events = append(events, InterfaceEvent{Change: InterfaceAddrsChanged, Interface: s.ifi, Time: now})
+		}
+	}
+	for _, p := range sortedStates(prev) {
+		if _, ok := cur[p.ifi.Index]; !ok && eligibleInterface(&p.ifi) {
+			events = append(events, InterfaceEvent{Change: InterfaceDown, Interface: p.ifi, Time: now})
+		}
+	}
+	return events
+}
+
+// sortedStates returns the interfaces of a list in the order of their
+// indexes.
+func sortedStates(states map[int]interfaceState) []interfaceState {
+	out := make([]interfaceState, 0, len(states))
+	for _, s := range states {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ifi.Index < out[j].ifi.Index })
+	return out
+}
+
+// stateByName returns the interface of a list with the given name.
+func stateByName(states map[int]interfaceState, name string) (interfaceState, bool) {
+	for _, s := range states {
+		if s.ifi.Name == name {
+			return s, true
+		}
+	}
+	return interfaceState{}, false
+}
@@ -0,0 +1,56 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+//go:build linux
+
+package mdns
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// interfaceChanges returns a channel that receives a value whenever the
+// kernel reports a change to the links or addresses of the host through
+// netlink, until done is closed. It returns nil if netlink is unavailable,
+// leaving the changes to be found by listing the interfaces.
+func interfaceChanges(done <-chan struct{}) <-chan struct{} {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil
+	}
+	sa := &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR,
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil
+	}
+
+	// A non-blocking file is read through the runtime poller, so closing it
+	// stops the reader
+	f := os.NewFile(uintptr(fd), "netlink")
+	ch := make(chan struct{}, 1)
+	go func() {
+		<-done
+		f.Close()
+	}()
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			// The messages need not be parsed, as the interfaces are listed
+			// anyway; a lost message is a change too
+			if _, err := f.Read(buf); err != nil && !errors.Is(err, unix.ENOBUFS) {
+				return
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch
+}
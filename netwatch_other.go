@@ -0,0 +1,12 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+
+package mdns
+
+// interfaceChanges returns nil where the system does not report changes to
+// its interfaces, leaving them to be found by listing the interfaces.
+func interfaceChanges(done <-chan struct{}) <-chan struct{} {
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func testInterface(index int, name string, up bool) net.Interface {
+	flags := net.FlagMulticast
+	if up {
+		flags |= net.FlagUp | net.FlagRunning
+	}
+	return net.Interface{Index: index, Name: name, Flags: flags}
+}
+
+func TestDiffInterfaces(t *testing.T) {
+	now := time.Now()
+	prev := map[int]interfaceState{
+		1: {ifi: net.Interface{Index: 1, Name: "lo", Flags: net.FlagUp | net.FlagRunning | net.FlagLoopback | net.FlagMulticast}},
+		2: {ifi: testInterface(2, "eth0", true), addrs: "192.168.1.2/24"},
+		3: {ifi: testInterface(3, "wlan0", true), addrs: "10.0.0.2/24"},
+		4: {ifi: testInterface(4, "tun0", true)},
+		5: {ifi: testInterface(5, "eth1", false)},
+	}
+	cur := map[int]interfaceState{
+		1: {ifi: net.Interface{Index: 1, Name: "lo", Flags: net.FlagLoopback}},
+		2: {ifi: testInterface(2, "eth0", true), addrs: "192.168.1.2/24"},
+		3: {ifi: testInterface(3, "wlan0", true), addrs: "10.1.0.2/24"},
+		5: {ifi: testInterface(5, "eth1", true)},
+		6: {ifi: testInterface(6, "tun0", true)},
+	}
+	events := diffInterfaces(prev, cur, now)
+	want := []struct {
+		change InterfaceChange
+		name   string
+	}{
+		{InterfaceAddrsChanged, "wlan0"},
+		{InterfaceUp, "eth1"},
+		{InterfaceUp, "tun0"},
+		{InterfaceDown, "tun0"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("bad: %v", events)
+	}
+	for i, w := range want {
+		if events[i].Change != w.change || events[i].Interface.Name != w.name || events[i].Time != now {
+			t.Fatalf("event %d: got %v, want %s %s", i, events[i], w.name, w.change)
+		}
+	}
+	if events := diffInterfaces(cur, cur, now); len(events) != 0 {
+		t.Fatalf("bad: %v", events)
+	}
+}
+
+func TestClient_InterfaceWatch(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+	stop := c.EnableInterfaceWatch(InterfaceWatchConfig{})
+	defer stop()
+	c.EnableInterfaceWatch(InterfaceWatchConfig{}) // replaces the first, stops with the Client
+	wlan := testInterface(3, "wlan0", true)
+	if err := c.SetInterface(&wlan); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sub := c.subscribe()
+	defer c.unsubscribe(sub)
+
+	// The interface was recreated under another index
+	var events []InterfaceEvent
+	cfg := InterfaceWatchConfig{OnChange: func(e InterfaceEvent) { events = append(events, e) }}
+	recreated := testInterface(7, "wlan0", true)
+	cur := map[int]interfaceState{7: {ifi: recreated}}
+	c.interfacesChanged(cfg, cur, diffInterfaces(nil, cur, time.Now()))
+	if len(events) != 1 || events[0].Change != InterfaceUp || events[0].Err != nil {
+		t.Fatalf("bad: %v", events)
+	}
+	if got := c.Capabilities().Interface; got == nil || got.Index != 7 {
+		t.Fatalf("not rebound: %v", got)
+	}
+	select {
+	case <-sub.resend:
+	default:
+		t.Fatalf("queries not re-issued")
+	}
+
+	// Interfaces the Client does not use do not disturb its queries
+	other := testInterface(8, "eth0", true)
+	cur[8] = interfaceState{ifi: other}
+	c.interfacesChanged(cfg, cur, diffInterfaces(map[int]interfaceState{7: {ifi: recreated}}, cur, time.Now()))
+	select {
+	case <-sub.resend:
+		t.Fatalf("unexpected resend")
+	default:
+	}
+
+	// Or, with AllInterfaces, the Client queries on every one
+	cfg.AllInterfaces = true
+	c.interfacesChanged(cfg, cur, nil)
+	if got := c.Capabilities().Interfaces; len(got) != 2 || got[0].Index != 7 || got[1].Index != 8 {
+		t.Fatalf("bad: %v", got)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// observeBuffer is how many record events are buffered on their way to an
+// observer.
+const observeBuffer = 64
+
+// ObservationKind tells what an Observation describes.
+type ObservationKind int
+
+const (
+	// ObservedRecord is a change to a cached record, whether it was learned
+	// from one of our queries or overheard from other hosts.
+	ObservedRecord ObservationKind = iota
+
+	// ObservedEntry is a service instance found by a query.
+	ObservedEntry
+)
+
+// String returns the name of the kind.
+func (k ObservationKind) String() string {
+	switch k {
+	case ObservedRecord:
+		return "record"
+	case ObservedEntry:
+		return "entry"
+	default:
+		return "unknown"
+	}
+}
+
+// Observation is anything discovery-related a Client saw.
+type Observation struct {
+	Kind    ObservationKind
+	Service string        // Fully qualified service name, empty if unknown, e.g. for address records
+	QueryID string        // ID of the QueryParam that found an entry
+	Record  *RecordEvent  // Set for ObservedRecord
+	Entry   *ServiceEntry // Set for ObservedEntry
+	Time    time.Time
+}
+
+// Observe sends every record change in the Client's cache and every entry
+// found by any of its queries to ch, until the returned function is called.
+// It lets logging or auditing components follow all discovery without
+// subscribing per service. Observations are dropped if ch is not ready to
+// receive them, so it should be buffered.
+func (c *Client) Observe(ch chan<- Observation) (stop func()) {
+	return c.observe(&observer{ch: ch})
+}
+
+// ObservePooled is like Observe, but sends observations taken from a pool,
+// which avoids allocating for every observation when there are thousands a
+// minute. The consumer must call Release on each observation once done with
+// it, and Clone any observation it keeps.
+func (c *Client) ObservePooled(ch chan<- *PooledObservation) (stop func()) {
+	return c.observe(&observer{pooled: ch})
+}
+
+// PooledObservation is an Observation sent by ObservePooled. It, and the
+// record event or entry it points to, is reused once released.
+type PooledObservation struct {
+	Observation
+
+	record RecordEvent
+	entry  ServiceEntry
+}
+
+// observationPool holds the released observations.
+var observationPool = sync.Pool{
+	New: func() any { return new(PooledObservation) },
+}
+
+// Release returns the observation to the pool. Neither it nor the record
+// event or entry it points to may be used afterwards.
+func (o *PooledObservation) Release() {
+	*o = PooledObservation{}
+	observationPool.Put(o)
+}
+
+// Clone returns a copy of the observation that stays valid after Release.
+func (o *PooledObservation) Clone() Observation {
+	obs := o.Observation
+	if obs.Record != nil {
+		ev := *obs.Record
+		obs.Record = &ev
+	}
+	if obs.Entry != nil {
+		e := *obs.Entry
+		obs.Entry = &e
+	}
+	return obs
+}
+
+// observer receives observations, see Observe and ObservePooled.
+type observer struct {
+	ch     chan<- Observation
+	pooled chan<- *PooledObservation
+}
+
+// observe registers an observer until the returned function is called.
+func (c *Client) observe(o *observer) (stop func()) {
+	records := make(chan RecordEvent, observeBuffer)
+	stopRecords := c.cache.watch(records)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case ev := <-records:
+				c.mu.Lock()
+				if _, ok := c.observers[o]; ok {
+					o.deliver(ObservedRecord, recordService(ev), &ev, nil, ev.Time)
+				}
+				c.mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	c.mu.Lock()
+	if c.observers == nil {
+		c.observers = make(map[*observer]struct{})
+	}
+	c.observers[o] = struct{}{}
+	c.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			delete(c.observers, o)
+			c.mu.Unlock()
+			stopRecords()
+			close(done)
+		})
+	}
+}
+
+// observeEntry reports an entry sent by a query to the observers.
+func (c *Client) observeEntry(entry *ServiceEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.observers) == 0 {
+		return
+	}
+	service := instanceService(entry.Name)
+	now := time.Now()
+	for o := range c.observers {
+		o.deliver(ObservedEntry, service, nil, entry, now)
+	}
+}
+
+// deliver sends an observation without blocking. The record event or entry
+// is copied, so that every observer owns its own.
+func (o *observer) deliver(kind ObservationKind, service string, ev *RecordEvent, entry *ServiceEntry, t time.Time) {
+	if o.pooled != nil {
+		p := observationPool.Get().(*PooledObservation)
+		p.Observation = Observation{Kind: kind, Service: service, Time: t}
+		if ev != nil {
+			p.record = *ev
+			p.Record = &p.record
+		}
+		if entry != nil {
+			p.entry = *entry
+			p.Entry = &p.entry
+			p.QueryID = entry.QueryID
+		}
+		select {
+		case o.pooled <- p:
+		default:
+			p.Release()
+		}
+		return
+	}
+
+	obs := Observation{Kind: kind, Service: service, Time: t}
+	if ev != nil {
+		cp := *ev
+		obs.Record = &cp
+	}
+	if entry != nil {
+		cp := *entry
+		obs.Entry = &cp
+		obs.QueryID = entry.QueryID
+	}
+	select {
+	case o.ch <- obs:
+	default:
+	}
+}
+
+// recordService returns the service a record event concerns: the owner of a
+// PTR record, or the service of the instance owning an SRV or TXT record.
+func recordService(ev RecordEvent) string {
+	rr := ev.New
+	if rr == nil {
+		rr = ev.Old
+	}
+	if rr == nil {
+		return ""
+	}
+	name := rr.Header().Name
+	switch rr.Header().Rrtype {
+	case dns.TypePTR:
+		if strings.HasPrefix(strings.ToLower(name), "_services._dns-sd._udp.") {
+			return ""
+		}
+		return name
+	case dns.TypeSRV, dns.TypeTXT:
+		return instanceService(name)
+	}
+	return ""
+}
+
+// instanceService returns the service of a fully qualified instance name,
+// everything after its first unescaped dot, or "" if the name has too few
+// labels. Unlike SplitInstanceName it does not allocate, as it runs for every
+// observation.
+func instanceService(name string) string {
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '\\':
+			i++
+		case '.':
+			service := name[i+1:]
+			if strings.Count(service, ".") < 3 {
+				return ""
+			}
+			return service
+		}
+	}
+	return ""
+}
@@ -0,0 +1,129 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestClient_Observe(t *testing.T) {
+	c := &Client{cache: newCache(), closedCh: make(chan struct{})}
+	ch := make(chan Observation, 16)
+	stop := c.Observe(ch)
+	defer stop()
+
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+	c.handleMsg(makeResponse(t, makeService(t)), src, 0)
+
+	// Every record is observed, attributed to its service where possible
+	seen := make(map[uint16]string)
+	for len(seen) < 5 {
+		select {
+		case o := <-ch:
+			if o.Kind != ObservedRecord || o.Record.Type != RecordAdded {
+				t.Fatalf("bad: %+v", o)
+			}
+			seen[o.Record.New.Header().Rrtype] = o.Service
+		case <-time.After(time.Second):
+			t.Fatalf("only observed %v", seen)
+		}
+	}
+	for _, rrtype := range []uint16{dns.TypePTR, dns.TypeSRV, dns.TypeTXT} {
+		if seen[rrtype] != "_http._tcp.local." {
+			t.Fatalf("bad service for %s: %q", dns.TypeToString[rrtype], seen[rrtype])
+		}
+	}
+	if seen[dns.TypeA] != "" {
+		t.Fatalf("address records have no service: %q", seen[dns.TypeA])
+	}
+
+	// Entries sent by queries are observed as well
+	c.sendEntry(&ServiceEntry{Name: "hostname._http._tcp.local.", QueryID: "web"}, nil)
+	select {
+	case o := <-ch:
+		if o.Kind != ObservedEntry || o.Service != "_http._tcp.local." || o.QueryID != "web" || o.Entry == nil {
+			t.Fatalf("bad: %+v", o)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("entry was not observed")
+	}
+
+	stop()
+	c.sendEntry(&ServiceEntry{Name: "other._http._tcp.local."}, nil)
+	select {
+	case o := <-ch:
+		t.Fatalf("observed after stop: %+v", o)
+	default:
+	}
+}
+
+func TestClient_ObservePooled(t *testing.T) {
+	c := &Client{cache: newCache(), closedCh: make(chan struct{})}
+	ch := make(chan *PooledObservation, 1)
+	stop := c.ObservePooled(ch)
+	defer stop()
+
+	c.sendEntry(&ServiceEntry{Name: "hostname._http._tcp.local.", QueryID: "web"}, nil)
+	var kept Observation
+	select {
+	case o := <-ch:
+		if o.Kind != ObservedEntry || o.Entry.Name != "hostname._http._tcp.local." || o.QueryID != "web" {
+			t.Fatalf("bad: %+v", o)
+		}
+		kept = o.Clone()
+		o.Release()
+	case <-time.After(time.Second):
+		t.Fatalf("entry was not observed")
+	}
+	if kept.Entry == nil || kept.Entry.Name != "hostname._http._tcp.local." {
+		t.Fatalf("clone did not survive release: %+v", kept)
+	}
+}
+
+// benchmarkObserve measures delivering entries to a single observer.
+func benchmarkObserve(b *testing.B, pooled bool) {
+	c := &Client{cache: newCache(), closedCh: make(chan struct{})}
+	done := make(chan struct{})
+	defer close(done)
+	if pooled {
+		ch := make(chan *PooledObservation, 1024)
+		defer c.ObservePooled(ch)()
+		go func() {
+			for {
+				select {
+				case o := <-ch:
+					o.Release()
+				case <-done:
+					return
+				}
+			}
+		}()
+	} else {
+		ch := make(chan Observation, 1024)
+		defer c.Observe(ch)()
+		go func() {
+			for {
+				select {
+				case <-ch:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	entry := &ServiceEntry{Name: "hostname._http._tcp.local.", Port: 80}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.observeEntry(entry)
+	}
+}
+
+func BenchmarkClient_Observe(b *testing.B)       { benchmarkObserve(b, false) }
+func BenchmarkClient_ObservePooled(b *testing.B) { benchmarkObserve(b, true) }
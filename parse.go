@@ -0,0 +1,63 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// maxMessageSize is the largest mDNS message accepted, the limit of RFC
+	// 6762, section 17.
+	maxMessageSize = 9000
+
+	// maxQuestions and maxRecords bound the number of questions and of
+	// records across the other sections of an accepted message. They are
+	// well above what a real responder sends, but keep crafted packets from
+	// costing more than a few microseconds to parse.
+	maxQuestions = 64
+	maxRecords   = 512
+
+	// headerSize is the size of a DNS message header.
+	headerSize = 12
+)
+
+// ErrMessageRejected is wrapped by the errors ParseMessage returns for
+// packets exceeding its limits.
+var ErrMessageRejected = errors.New("mdns: message rejected")
+
+// ParseMessage parses an untrusted mDNS packet, such as one read from the
+// network or a capture, applying the limits DefaultCodec uses for every packet
+// Clients and Servers receive: messages over 9000 bytes, with more than 64
+// questions, or with more than 512 records are rejected. The limits on
+// counts are checked against the header before anything is parsed. The
+// message does not refer to buf once ParseMessage returns.
+func ParseMessage(buf []byte) (*dns.Msg, error) {
+	if len(buf) > maxMessageSize {
+		return nil, fmt.Errorf("%w: %d bytes exceeds %d", ErrMessageRejected, len(buf), maxMessageSize)
+	}
+	if len(buf) < headerSize {
+		return nil, fmt.Errorf("%w: %d bytes is shorter than a header", ErrMessageRejected, len(buf))
+	}
+	if n := binary.BigEndian.Uint16(buf[4:]); n > maxQuestions {
+		return nil, fmt.Errorf("%w: %d questions exceeds %d", ErrMessageRejected, n, maxQuestions)
+	}
+	records := 0
+	for off := 6; off < headerSize; off += 2 {
+		records += int(binary.BigEndian.Uint16(buf[off:]))
+	}
+	if records > maxRecords {
+		return nil, fmt.Errorf("%w: %d records exceeds %d", ErrMessageRejected, records, maxRecords)
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(buf); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
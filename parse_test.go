@@ -0,0 +1,60 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseMessage(t *testing.T) {
+	buf, err := makeResponse(t, makeService(t)).Pack()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	msg, err := ParseMessage(buf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(msg.Answer) != 5 {
+		t.Fatalf("bad: %v", msg)
+	}
+
+	withCount := func(off int, n uint16) []byte {
+		b := append([]byte(nil), buf...)
+		binary.BigEndian.PutUint16(b[off:], n)
+		return b
+	}
+	for name, b := range map[string][]byte{
+		"too large":       make([]byte, maxMessageSize+1),
+		"short":           buf[:headerSize-1],
+		"questions":       withCount(4, maxQuestions+1),
+		"records":         withCount(10, maxRecords),
+		"answer overflow": withCount(6, 0xffff),
+	} {
+		if _, err := ParseMessage(b); !errors.Is(err, ErrMessageRejected) {
+			t.Fatalf("%s: got %v, want ErrMessageRejected", name, err)
+		}
+	}
+}
+
+func FuzzParseMessage(f *testing.F) {
+	m := new(dns.Msg)
+	m.SetQuestion("_http._tcp.local.", dns.TypePTR)
+	query, _ := m.Pack()
+	f.Add(query)
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		msg, err := ParseMessage(buf)
+		if err != nil {
+			return
+		}
+		if len(msg.Question) > maxQuestions || len(msg.Answer)+len(msg.Ns)+len(msg.Extra) > maxRecords {
+			t.Fatalf("limits not applied: %v", msg)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRotateInterval is how often a private service's names rotate by
+	// default.
+	defaultRotateInterval = time.Hour
+
+	// privateNameAttempts is how many random names are tried before giving up
+	// on a conflict.
+	privateNameAttempts = 5
+)
+
+// PrivacyConfig configures a service registered with RegisterPrivate.
+type PrivacyConfig struct {
+	// Interval is how often the names rotate, default 1 hour.
+	Interval time.Duration
+
+	// ID, if set, is published as an "id=" TXT record so that peers that know
+	// it can recognize the service under its changing names.
+	ID string
+
+	// OnRotate, if set, is called after every rotation.
+	OnRotate func(RotateEvent)
+}
+
+// RotateEvent describes the rotation of a private service's names.
+type RotateEvent struct {
+	Old  *MDNSService // Service published before the rotation
+	New  *MDNSService // Service published now, nil if the rotation failed
+	Time time.Time    // When the rotation happened
+	Err  error        // Non-nil if the new names could not be registered
+}
+
+// PrivateService is a service published under random instance and host names
+// that rotate periodically, which makes it harder to track a host across
+// networks.
+type PrivateService struct {
+	server *Server
+	base   *MDNSService
+	cfg    PrivacyConfig
+
+	rotateLock sync.Mutex // serializes rotations
+
+	mu      sync.Mutex
+	current *MDNSService
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// RegisterPrivate registers a copy of the service under a random instance name
+// and host name, and rotates both every cfg.Interval. Each new name is probed
+// like any other registration, and the old names are only said goodbye to once
+// the new ones are claimed. Aliases of the service are not published, since
+// they would identify the host.
+func (s *Server) RegisterPrivate(ctx context.Context, service *MDNSService, cfg PrivacyConfig) (*PrivateService, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultRotateInterval
+	}
+	p := &PrivateService{
+		server: s,
+		base:   service,
+		cfg:    cfg,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	current, err := p.register(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.current = current
+	go p.run()
+	return p, nil
+}
+
+// Current returns the service as currently published.
+func (p *PrivateService) Current() *MDNSService {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.current
+}
+
+// Rotate registers the service under new random names and then deregisters
+// the old ones. If the new names cannot be registered, the old ones stay in
+// use.
+func (p *PrivateService) Rotate(ctx context.Context) error {
+	p.rotateLock.Lock()
+	defer p.rotateLock.Unlock()
+
+	old := p.Current()
+	event := RotateEvent{Old: old}
+	next, err := p.register(ctx)
+	if err == nil {
+		p.mu.Lock()
+		p.current = next
+		p.mu.Unlock()
+		event.New = next
+		err = p.server.Deregister(ctx, old)
+	}
+	event.Time = time.Now()
+	event.Err = err
+
+	if err != nil {
+		p.server.config.Logger.Printf("[ERR] mdns: Failed to rotate private service %s: %v", old.instanceAddr, err)
+	}
+	if p.cfg.OnRotate != nil {
+		p.cfg.OnRotate(event)
+	}
+	return err
+}
+
+// Stop stops rotating the names and deregisters the service.
+func (p *PrivateService) Stop(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stopCh) })
+	<-p.doneCh
+
+	p.rotateLock.Lock()
+	defer p.rotateLock.Unlock()
+	return p.server.Deregister(ctx, p.Current())
+}
+
+// run rotates the names every interval until stopped or the server shuts down.
+func (p *PrivateService) run() {
+	defer close(p.doneCh)
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.Rotate(context.Background())
+		case <-p.stopCh:
+			return
+		case <-p.server.shutdownCh:
+			return
+		}
+	}
+}
+
+// register registers the service under fresh random names, picking new ones
+// when they conflict with another host.
+func (p *PrivateService) register(ctx context.Context) (*MDNSService, error) {
+	var err error
+	for i := 0; i < privateNameAttempts; i++ {
+		var service *MDNSService
+		if service, err = p.randomize(); err != nil {
+			return nil, err
+		}
+		if err = p.server.Register(ctx, service); err == nil {
+			return service, nil
+		}
+		if !errors.Is(err, ErrNameConflict) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// randomize returns a copy of the base service with random instance and host
+// names, carrying the configured ID in its TXT records.
+func (p *PrivateService) randomize() (*MDNSService, error) {
+	instance, err := randomLabel()
+	if err != nil {
+		return nil, err
+	}
+	host, err := randomLabel()
+	if err != nil {
+		return nil, err
+	}
+	txt := append([]string(nil), p.base.TXT...)
+	if p.cfg.ID != "" {
+		txt = append(txt, "id="+p.cfg.ID)
+	}
+	return NewMDNSService(instance, p.base.Service, p.base.Domain, host+"."+Fqdn(p.base.Domain), p.base.Port, p.base.IPs, txt)
+}
+
+// randomLabel returns a random 12 character hexadecimal label.
+func randomLabel() (string, error) {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
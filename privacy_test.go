@@ -0,0 +1,60 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestServer_RegisterPrivate(t *testing.T) {
+	serv, err := NewServer(&Config{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	base := makeServiceWithServiceName(t, "_private._tcp")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rotated := make(chan RotateEvent, 1)
+	p, err := serv.RegisterPrivate(ctx, base, PrivacyConfig{
+		ID:       "abc",
+		OnRotate: func(e RotateEvent) { rotated <- e },
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	first := p.Current()
+	if first.Instance == base.Instance || first.HostName == base.HostName {
+		t.Fatalf("names were not randomized: %+v", first)
+	}
+	if txt := first.TXT; len(txt) == 0 || txt[len(txt)-1] != "id=abc" {
+		t.Fatalf("bad TXT: %v", txt)
+	}
+	if serv.isRegistered(base) || !serv.isRegistered(first) {
+		t.Fatalf("the randomized copy should be registered")
+	}
+
+	if err := p.Rotate(ctx); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	e := <-rotated
+	if e.Old != first || e.New != p.Current() || e.Err != nil {
+		t.Fatalf("bad event: %+v", e)
+	}
+	if e.New.Instance == first.Instance || serv.isRegistered(first) {
+		t.Fatalf("names were not rotated")
+	}
+
+	if err := p.Stop(ctx); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := serv.Deregister(ctx, p.Current()); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("got %v, want ErrNotRegistered", err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import "time"
+
+// Profile is a bundle of query tuning options. Select one with Apply, and
+// override any of its options by setting the field of the QueryParam
+// afterwards.
+type Profile struct {
+	Timeout               time.Duration // How long the query listens for responses
+	RetransmitInterval    time.Duration // Delay before the first retransmission, at least 1 second
+	MaxRetransmitInterval time.Duration // Cap on the interval between retransmissions, zero for the default
+	MaxRetransmissions    int           // Cap on the number of retransmissions, zero for no limit
+	MinQueryInterval      time.Duration // Least time between two packets of questions, zero for no limit
+	ResolveTimeout        time.Duration // Time each instance is given to resolve, zero to send entries at once
+	WantUnicastResponse   bool          // Ask for unicast responses to the first question
+	KnownAnswers          bool          // List the cached answers in the questions
+}
+
+var (
+	// ProfileAggressive suits interactive device pickers: queries listen
+	// long enough to retransmit their questions, as often as RFC 6762,
+	// section 5.2, allows, and questions re-issued after a network change
+	// are held back to one a second, the least interval the RFC allows
+	// between the first questions. Answers already cached are asked for
+	// again to learn at once which are gone.
+	ProfileAggressive = Profile{
+		Timeout:            3 * time.Second,
+		RetransmitInterval: queryRetransmitInterval,
+		MinQueryInterval:   time.Second,
+	}
+
+	// ProfileStandard matches the package defaults and suits daemons.
+	ProfileStandard = Profile{
+		Timeout:            time.Second,
+		RetransmitInterval: queryRetransmitInterval,
+	}
+
+	// ProfilePolite suits battery powered devices and networks dense with
+	// IoT devices: questions are retransmitted rarely and a few times only,
+	// and never sent within two seconds of each other, responders are asked
+	// to reply by unicast and to leave out the answers already cached to keep
+	// multicast traffic down, and instances are given time to resolve rather
+	// than being queried right away.
+	ProfilePolite = Profile{
+		Timeout:               3 * time.Second,
+		RetransmitInterval:    2 * time.Second,
+		MaxRetransmitInterval: 16 * time.Second,
+		MaxRetransmissions:    4,
+		MinQueryInterval:      2 * time.Second,
+		ResolveTimeout:        time.Second,
+		WantUnicastResponse:   true,
+		KnownAnswers:          true,
+	}
+)
+
+// Apply returns par with the tuning fields set to the profile's options,
+// whatever they were, so that the fields set afterwards override them:
+//
+//	par := mdns.ProfilePolite.Apply(mdns.QueryParam{Service: "_http._tcp"})
+//	par.WantUnicastResponse = false
+func (p Profile) Apply(par QueryParam) QueryParam {
+	par.Timeout = p.Timeout
+	par.RetransmitInterval = p.RetransmitInterval
+	par.MaxRetransmitInterval = p.MaxRetransmitInterval
+	par.MaxRetransmissions = p.MaxRetransmissions
+	par.MinQueryInterval = p.MinQueryInterval
+	par.ResolveTimeout = p.ResolveTimeout
+	par.WantUnicastResponse = p.WantUnicastResponse
+	par.KnownAnswers = p.KnownAnswers
+	return par
+}
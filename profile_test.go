@@ -0,0 +1,115 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"log"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestProfile_Apply(t *testing.T) {
+	par := ProfilePolite.Apply(QueryParam{Service: "_foobar._tcp", Timeout: 10 * time.Second}).withDefaults()
+	if par.Timeout != ProfilePolite.Timeout || par.RetransmitInterval != ProfilePolite.RetransmitInterval ||
+		par.MaxRetransmissions != ProfilePolite.MaxRetransmissions || par.ResolveTimeout != ProfilePolite.ResolveTimeout ||
+		!par.WantUnicastResponse || !par.KnownAnswers || par.Service != "_foobar._tcp" || par.Domain != "local" {
+		t.Fatalf("profile not applied: %+v", par)
+	}
+
+	// Fields set afterwards win, even when turned off
+	par = ProfilePolite.Apply(QueryParam{Service: "_foobar._tcp"})
+	par.WantUnicastResponse = false
+	par.ResolveTimeout = 0
+	par = par.withDefaults()
+	if par.WantUnicastResponse || par.ResolveTimeout != 0 || !par.KnownAnswers {
+		t.Fatalf("bad override: %+v", par)
+	}
+
+	// No profile retransmits faster than RFC 6762, section 5.2, allows
+	for _, p := range []Profile{ProfileAggressive, ProfileStandard, ProfilePolite} {
+		if p.RetransmitInterval < time.Second {
+			t.Fatalf("retransmits too fast: %+v", p)
+		}
+	}
+
+	// ProfileStandard matches the package defaults
+	def := QueryParam{Service: "_foobar._tcp"}.withDefaults()
+	std := ProfileStandard.Apply(def).withDefaults()
+	if std.Timeout != def.Timeout || std.RetransmitInterval != def.RetransmitInterval ||
+		std.MaxRetransmitInterval != def.MaxRetransmitInterval || std.MaxRetransmissions != def.MaxRetransmissions ||
+		std.ResolveTimeout != def.ResolveTimeout || std.WantUnicastResponse || std.KnownAnswers {
+		t.Fatalf("bad standard profile: %+v", std)
+	}
+}
+
+func TestProfile_Behavior(t *testing.T) {
+	// Each profile runs a query on the test clock whose questions are
+	// re-issued after half a second, as after a network change, and the
+	// times its packets of questions go out are compared
+	for _, test := range []struct {
+		name    string
+		profile Profile
+		want    []time.Duration
+	}{
+		// Standard re-issues at once and stops listening before
+		// retransmitting
+		{"standard", ProfileStandard, []time.Duration{0, 500 * time.Millisecond}},
+		// Aggressive holds the re-issue back for a second, then
+		// retransmits a second later, within its timeout
+		{"aggressive", ProfileAggressive, []time.Duration{0, time.Second, 2 * time.Second}},
+		// Polite holds the re-issue back for two seconds, and then its
+		// retransmission past its timeout
+		{"polite", ProfilePolite, []time.Duration{0, 2 * time.Second}},
+	} {
+		if got := profileSends(t, test.profile); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: sent at %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// profileSends runs a query with the profile on the test clock, re-issuing
+// its questions after half a second, and returns when its packets were sent.
+func profileSends(t *testing.T, p Profile) []time.Duration {
+	network := memnet.New(memnet.Config{})
+	clock := newTestClock()
+	c, err := newClient(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil, clock)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	start := clock.Now()
+	par := p.Apply(QueryParam{Service: "_foobar._tcp"})
+	done := make(chan error, 1)
+	go func() {
+		done <- c.query(context.Background(), &[]QueryParam{par}, make(chan *ServiceEntry, 4))
+	}()
+	var sends []time.Duration
+	var sent uint64
+	resent := false
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			return sends
+		case <-time.After(10 * time.Millisecond):
+		}
+		for n := network.Stats().Sent; sent < n; sent++ {
+			sends = append(sends, clock.Now().Sub(start))
+		}
+		if !resent && clock.Now().Sub(start) >= 500*time.Millisecond {
+			resent = true
+			c.resendQueries()
+			continue
+		}
+		clock.Set(clock.Now().Add(100 * time.Millisecond))
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// QueryRR asks the link for the records of a name of any type, such as the A,
+// AAAA, SRV, TXT, or HINFO records, or all of them with dns.TypeANY, and
+// streams them on the returned channel as they arrive. Unlike a query, no
+// service is browsed: only the records owned by name, of the type asked for,
+// are sent, whether they came as answers or as additional records, each
+// once. Cached records are sent first.
+//
+// The question is retransmitted at increasing intervals until ctx is done, or
+// for 3 seconds if ctx has no deadline; the channel is then closed. It is
+// closed early once the owner of name denies having records of the type with
+// an NSEC record, as RFC 6762, section 6.1 describes, as none will come. Records
+// not read in time are held back rather than slowing down the Client, so
+// callers need not keep up. The error is that of sending the question.
+func (c *Client) QueryRR(ctx context.Context, name string, qtype uint16) (<-chan dns.RR, error) {
+	if _, ok := dns.TypeToString[qtype]; !ok {
+		return nil, fmt.Errorf("mdns: unknown record type %d", qtype)
+	}
+	ctx, done, err := c.beginLookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name = Fqdn(name)
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.RecursionDesired = false
+	x, err := c.ask(ctx, name, func() *dns.Msg { return m })
+	if err != nil {
+		done()
+		return nil, err
+	}
+
+	ch := make(chan dns.RR)
+	q := &rrQuery{name: name, qtype: qtype}
+	q.add(c.cache.get(name, qtype))
+	go func() {
+		defer done()
+		defer x.close()
+		defer close(ch)
+
+		for {
+			if len(q.pending) == 0 && q.denied(c.cache) {
+				return
+			}
+			var out chan<- dns.RR
+			var next dns.RR
+			if len(q.pending) > 0 {
+				out, next = ch, q.pending[0]
+			}
+			select {
+			case <-x.due():
+				x.retransmit(ctx)
+
+			case resp := <-x.responses():
+				if resp.msg.Response {
+					q.add(append(resp.msg.Answer, resp.msg.Extra...))
+				}
+
+			case out <- next:
+				q.pending = q.pending[1:]
+
+			case <-ctx.Done():
+				return
+
+			case <-c.closedCh:
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// rrQuery is the state of a QueryRR.
+type rrQuery struct {
+	name  string
+	qtype uint16
+
+	seen    []dns.RR // sent or pending
+	pending []dns.RR // not yet read
+}
+
+// add queues the records answering the question that were not seen before.
+// Goodbyes are not answers.
+func (q *rrQuery) add(rrs []dns.RR) {
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if !strings.EqualFold(hdr.Name, q.name) || hdr.Ttl == 0 ||
+			(q.qtype != dns.TypeANY && hdr.Rrtype != q.qtype) {
+			continue
+		}
+		if q.seenRR(rr) {
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Class &^= cacheFlushBit
+		q.seen = append(q.seen, rr)
+		q.pending = append(q.pending, rr)
+	}
+}
+
+// denied reports whether the owner of the name denied having records of the
+// type asked for. Any type is never denied.
+func (q *rrQuery) denied(cache *cache) bool {
+	return q.qtype != dns.TypeANY && cache.denies(q.name, q.qtype, nil, nil)
+}
+
+// seenRR reports whether a record with the same data was already queued.
+func (q *rrQuery) seenRR(rr dns.RR) bool {
+	for _, s := range q.seen {
+		if dns.IsDuplicate(s, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupSRV looks up the SRV records of a service instance, such as
+// "printer._ipp._tcp.local", with a Client of its own, see Client.LookupSRV.
+func LookupSRV(ctx context.Context, name string) ([]*net.SRV, error) {
+	client, err := NewClient(true, true, log.Default(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.LookupSRV(ctx, name)
+}
+
+// LookupTXT looks up the TXT strings of a service instance with a Client of
+// its own, see Client.LookupTXT.
+func LookupTXT(ctx context.Context, name string) ([]string, error) {
+	client, err := NewClient(true, true, log.Default(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.LookupTXT(ctx, name)
+}
+
+// LookupA looks up the IPv4 addresses of a host with a Client of its own, see
+// Client.LookupA.
+func LookupA(ctx context.Context, host string) ([]net.IP, error) {
+	client, err := NewClient(true, true, log.Default(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.LookupA(ctx, host)
+}
+
+// LookupAAAA looks up the IPv6 addresses of a host with a Client of its own,
+// see Client.LookupAAAA.
+func LookupAAAA(ctx context.Context, host string) ([]net.IPAddr, error) {
+	client, err := NewClient(true, true, log.Default(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.LookupAAAA(ctx, host)
+}
+
+// LookupSRV returns the SRV records of a service instance, such as
+// "printer._ipp._tcp.local", sorted by priority and randomized by weight
+// within a priority, like net.Resolver.LookupSRV does for unicast DNS, see
+// RFC 2782. The instance name is given in full, as it is not made of a
+// service and a host name.
+//
+// Like the other typed lookups, it returns the records of the first responses,
+// waiting briefly for others once one arrived, and gives up with
+// ErrNotResolved after 3 seconds, unless ctx has a deadline of its own, or as
+// soon as the owner of the name denies having such records.
+func (c *Client) LookupSRV(ctx context.Context, name string) ([]*net.SRV, error) {
+	rrs, err := c.lookupRR(ctx, name, dns.TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+	srvs := make([]*net.SRV, 0, len(rrs))
+	for _, rr := range rrs {
+		srv := rr.(*dns.SRV)
+		srvs = append(srvs, &net.SRV{Target: srv.Target, Port: srv.Port, Priority: srv.Priority, Weight: srv.Weight})
+	}
+	sort.SliceStable(srvs, func(i, j int) bool { return srvs[i].Priority < srvs[j].Priority })
+	weight := func(srv *net.SRV) int { return int(srv.Weight) }
+	for i := 0; i < len(srvs); {
+		j := i + 1
+		for j < len(srvs) && srvs[j].Priority == srvs[i].Priority {
+			j++
+		}
+		shuffleByWeight(srvs[i:j], weight)
+		i = j
+	}
+	return srvs, nil
+}
+
+// LookupTXT returns the TXT strings of a service instance. Unlike
+// net.Resolver.LookupTXT, the strings of a record are not joined, as each is
+// a key/value pair in DNS-SD, see RFC 6763, section 6.
+func (c *Client) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	rrs, err := c.lookupRR(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	var txt []string
+	for _, rr := range rrs {
+		txt = append(txt, rr.(*dns.TXT).Txt...)
+	}
+	return txt, nil
+}
+
+// LookupA returns the IPv4 addresses of a host on the local link, such as
+// "myhost.local". A name of a single label is taken to be in the "local"
+// domain. ResolveHost asks for the addresses of both IP versions at once.
+func (c *Client) LookupA(ctx context.Context, host string) ([]net.IP, error) {
+	rrs, err := c.lookupRR(ctx, hostName(host), dns.TypeA)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(rrs))
+	for _, rr := range rrs {
+		ips = append(ips, rr.(*dns.A).A)
+	}
+	return ips, nil
+}
+
+// LookupAAAA returns the IPv6 addresses of a host on the local link, like
+// LookupA. Link-local addresses are qualified with the zone of the interface
+// they were received on, like those of ResolveHost.
+func (c *Client) LookupAAAA(ctx context.Context, host string) ([]net.IPAddr, error) {
+	host = hostName(host)
+	rrs, err := c.lookupRR(ctx, host, dns.TypeAAAA)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(rrs))
+	for _, rr := range rrs {
+		ips = append(ips, rr.(*dns.AAAA).AAAA)
+	}
+	return c.cache.zoned(host, ips), nil
+}
+
+// lookupRR runs a QueryRR until the records of the first responses arrived,
+// for the typed lookups.
+func (c *Client) lookupRR(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, resolveInstanceTimeout)
+		defer cancel()
+	}
+	qctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch, err := c.QueryRR(qctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	var rrs []dns.RR
+	var settle <-chan time.Time // set once some records are known
+	for {
+		select {
+		case rr, ok := <-ch:
+			if ok {
+				rrs = append(rrs, rr)
+				if settle == nil {
+					settle = time.After(resolveHostWait)
+				}
+				continue
+			}
+			// The query ended
+			switch {
+			case len(rrs) > 0:
+				return rrs, nil
+			case atomic.LoadInt32(&c.closed) == 1:
+				return nil, ErrClientClosed
+			case ctx.Err() == nil, errors.Is(ctx.Err(), context.DeadlineExceeded):
+				// Timed out, or the records were denied
+				return nil, ErrNotResolved
+			}
+			return nil, ctx.Err()
+
+		case <-settle:
+			return rrs, nil
+		}
+	}
+}
+
+// hostName qualifies a host name, taking a name of a single label to be in
+// the "local" domain.
+func hostName(host string) string {
+	if !strings.Contains(TrimDot(host), ".") {
+		host = TrimDot(host) + "." + defaultDomain
+	}
+	return Fqdn(host)
+}
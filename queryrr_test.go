@@ -0,0 +1,197 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestClient_QueryRR(t *testing.T) {
+	network := memnet.New(memnet.Config{Duplicate: 0.5})
+	serv, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+	query := func(name string, qtype uint16) []dns.RR {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		ch, err := c.QueryRR(ctx, name, qtype)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var out []dns.RR
+		for rr := range ch {
+			out = append(out, rr)
+		}
+		return out
+	}
+
+	// Only the records of the type asked for, once each, although packets
+	// are duplicated
+	rrs := query("hostname._http._tcp.local", dns.TypeSRV)
+	if len(rrs) != 1 {
+		t.Fatalf("bad: %v", rrs)
+	}
+	if srv, ok := rrs[0].(*dns.SRV); !ok || srv.Port != 80 || srv.Target != "testhost." {
+		t.Fatalf("bad: %v", rrs[0])
+	}
+
+	// Every type of the name
+	rrs = query("testhost.", dns.TypeANY)
+	var a, aaaa int
+	for _, rr := range rrs {
+		switch rr.(type) {
+		case *dns.A:
+			a++
+		case *dns.AAAA:
+			aaaa++
+		default:
+			t.Fatalf("bad: %v", rr)
+		}
+	}
+	if a != 1 || aaaa != 1 {
+		t.Fatalf("bad: %v", rrs)
+	}
+
+	// Nothing, once ctx is done
+	if rrs := query("testhost.", dns.TypeHINFO); len(rrs) != 0 {
+		t.Fatalf("bad: %v", rrs)
+	}
+	if _, err := c.QueryRR(context.Background(), "testhost.", 12345); err == nil {
+		t.Fatalf("error expected")
+	}
+}
+
+func TestClient_LookupTyped(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	ips := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fe80::1")}
+	service, err := NewMDNSService("hostname", "_http._tcp", "", "testhost.local.", 80, ips, []string{"path=/", "v=1"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: service, Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	srvs, err := c.LookupSRV(ctx, "hostname._http._tcp.local")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(srvs) != 1 || srvs[0].Target != "testhost.local." || srvs[0].Port != 80 || srvs[0].Priority != 10 || srvs[0].Weight != 1 {
+		t.Fatalf("bad: %+v", srvs)
+	}
+	txt, err := c.LookupTXT(ctx, "hostname._http._tcp.local.")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(txt) != 2 || txt[0] != "path=/" || txt[1] != "v=1" {
+		t.Fatalf("bad: %v", txt)
+	}
+	ips, err = c.LookupA(ctx, "testhost")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("bad: %v", ips)
+	}
+	addrs, err := c.LookupAAAA(ctx, "testhost.local")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(addrs) != 1 || !addrs[0].IP.Equal(net.ParseIP("fe80::1")) {
+		t.Fatalf("bad: %v", addrs)
+	}
+
+	// Unknown names do not resolve
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, err := c.LookupSRV(ctx, "printer._ipp._tcp.local"); err != ErrNotResolved {
+		t.Fatalf("got %v, want ErrNotResolved", err)
+	}
+}
+
+func TestHostName(t *testing.T) {
+	for in, want := range map[string]string{
+		"myhost":        "myhost.local.",
+		"myhost.":       "myhost.local.",
+		"myhost.local":  "myhost.local.",
+		"myhost.local.": "myhost.local.",
+	} {
+		if got := hostName(in); got != want {
+			t.Fatalf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+// nsecZone denies that a host has any record but an A record.
+type nsecZone struct{ host string }
+
+func (z nsecZone) Records(q dns.Question) []dns.RR {
+	if !strings.EqualFold(q.Name, z.host) || q.Qtype == dns.TypeA {
+		return nil
+	}
+	return []dns.RR{&dns.NSEC{
+		Hdr:        dns.RR_Header{Name: z.host, Rrtype: dns.TypeNSEC, Class: dns.ClassINET | cacheFlushBit, Ttl: 120},
+		NextDomain: z.host,
+		TypeBitMap: []uint16{dns.TypeA},
+	}}
+}
+
+func TestClient_LookupNSEC(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	service, err := NewMDNSService("hostname", "_http._tcp", "", "testhost.local.", 80, []net.IP{net.ParseIP("10.0.0.1")}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	zone := MultiZone{service, nsecZone{host: "testhost.local."}}
+	serv, err := NewServer(&Config{Zone: zone, Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	// The host denies having IPv6 addresses, so none are waited for
+	start := time.Now()
+	if _, err := c.LookupAAAA(context.Background(), "testhost"); err != ErrNotResolved {
+		t.Fatalf("got %v, want ErrNotResolved", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("waited %v for denied records", d)
+	}
+	addrs, err := c.ResolveHost(context.Background(), "testhost")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(addrs) != 1 || !addrs[0].IP.Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("bad: %v", addrs)
+	}
+}
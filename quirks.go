@@ -0,0 +1,220 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// quirkTTL is the TTL, in seconds, given to the records a device with
+	// QuirkZeroTTL announces with a TTL of zero: the TTL RFC 6762, section
+	// 10, recommends for host records.
+	quirkTTL = 120
+
+	// maxQuirkHosts bounds the number of fingerprinted hosts remembered.
+	maxQuirkHosts = 1024
+)
+
+// Quirk is a known misbehavior of a family of devices, which the Client
+// works around for the hosts it identifies as such devices. Quirks are
+// combined as flags.
+type Quirk uint32
+
+const (
+	// QuirkTXTPadding is the padding of TXT records with empty strings and
+	// trailing NUL bytes, as sent by Sonos speakers. The padding is removed.
+	QuirkTXTPadding Quirk = 1 << iota
+
+	// QuirkZeroTTL is the announcement of records with a TTL of zero, which
+	// marks them as goodbyes, as done by ESP8266 devices running old versions
+	// of the Arduino mDNS library. Their records are kept for 120 seconds
+	// instead, so that their real goodbyes take that long to apply.
+	QuirkZeroTTL
+
+	// QuirkDuplicateSRV is the inclusion of several SRV records for the
+	// same instance in one response, with different TTLs, as sent by Samsung
+	// televisions. Only the first is used.
+	QuirkDuplicateSRV
+
+	// QuirkAll selects every known quirk.
+	QuirkAll = QuirkTXTPadding | QuirkZeroTTL | QuirkDuplicateSRV
+)
+
+// quirkNames are the names of the quirks, in the order of their flags.
+var quirkNames = []string{"txt-padding", "zero-ttl", "duplicate-srv"}
+
+// String returns the names of the quirks, separated by "|".
+func (q Quirk) String() string {
+	if q == 0 {
+		return "none"
+	}
+	var names []string
+	for i, name := range quirkNames {
+		if q&(1<<i) != 0 {
+			names = append(names, name)
+			q &^= 1 << i
+		}
+	}
+	if q != 0 {
+		names = append(names, fmt.Sprintf("Quirk(%#x)", uint32(q)))
+	}
+	return strings.Join(names, "|")
+}
+
+// fingerprint identifies a family of devices by the records they publish.
+type fingerprint struct {
+	device string
+	quirks Quirk
+	match  func(rr dns.RR) bool
+}
+
+// fingerprints are the device families with known quirks. The captures in
+// testdata/quirks show what each sends; add one there with every new entry.
+var fingerprints = []fingerprint{
+	{"Sonos", QuirkTXTPadding, ownedBy("_sonos._tcp")},
+	{"ESP8266", QuirkZeroTTL, func(rr dns.RR) bool {
+		return ownedBy("_arduino._tcp")(rr) || hostPrefix(rr, "esp8266-")
+	}},
+	{"Samsung TV", QuirkDuplicateSRV, ownedBy("_samsungmsf._tcp")},
+}
+
+// ownedBy returns a match for the records of a service and its instances.
+func ownedBy(service string) func(rr dns.RR) bool {
+	suffix := "." + service + "."
+	return func(rr dns.RR) bool {
+		name := strings.ToLower(rr.Header().Name)
+		return strings.Contains(name, suffix) || strings.HasPrefix(name, service+".")
+	}
+}
+
+// hostPrefix reports whether rr is an address record of a host whose name
+// starts with prefix.
+func hostPrefix(rr dns.RR, prefix string) bool {
+	switch rr.(type) {
+	case *dns.A, *dns.AAAA:
+		return strings.HasPrefix(strings.ToLower(rr.Header().Name), prefix)
+	}
+	return false
+}
+
+// DetectQuirks returns the quirks of the device that sent msg, as recognized
+// from the records it holds, or zero if it matches no known device.
+func DetectQuirks(msg *dns.Msg) Quirk {
+	var q Quirk
+	for _, rr := range allRecords(msg) {
+		for _, f := range fingerprints {
+			if q&f.quirks != f.quirks && f.match(rr) {
+				q |= f.quirks
+			}
+		}
+	}
+	return q
+}
+
+// allRecords returns the records of every section of a message.
+func allRecords(msg *dns.Msg) []dns.RR {
+	rrs := make([]dns.RR, 0, len(msg.Answer)+len(msg.Ns)+len(msg.Extra))
+	rrs = append(rrs, msg.Answer...)
+	rrs = append(rrs, msg.Ns...)
+	return append(rrs, msg.Extra...)
+}
+
+// quirks remembers the quirks of the hosts heard from, as a device does not
+// include its identifying records in every message.
+type quirks struct {
+	mu sync.Mutex
+
+	// disabled are the quirks not worked around, see SetQuirks.
+	disabled Quirk
+
+	// hosts maps the address of a host to its quirks.
+	hosts map[string]Quirk
+}
+
+// SetQuirks selects the device quirks the Client works around, see Quirk.
+// Every known quirk is worked around by default; zero disables them all.
+func (c *Client) SetQuirks(q Quirk) {
+	c.quirks.mu.Lock()
+	defer c.quirks.mu.Unlock()
+	c.quirks.disabled = QuirkAll &^ q
+}
+
+// detect returns the quirks of the host that sent msg that are worked
+// around, remembering those recognized in msg.
+func (qs *quirks) detect(msg *dns.Msg, src net.IP) Quirk {
+	found := DetectQuirks(msg)
+	key := src.String()
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	if found != 0 {
+		if qs.hosts == nil || len(qs.hosts) >= maxQuirkHosts {
+			qs.hosts = make(map[string]Quirk)
+		}
+		qs.hosts[key] |= found
+	}
+	return qs.hosts[key] &^ qs.disabled
+}
+
+// apply works around the quirks of the host that sent a response, changing
+// msg in place.
+func (qs *quirks) apply(msg *dns.Msg, src net.IP) {
+	if !msg.Response {
+		return
+	}
+	q := qs.detect(msg, src)
+	if q == 0 {
+		return
+	}
+	if q&QuirkDuplicateSRV != 0 {
+		seen := make(map[string]bool)
+		msg.Answer = dropDuplicateSRV(msg.Answer, seen)
+		msg.Extra = dropDuplicateSRV(msg.Extra, seen)
+	}
+	for _, rr := range allRecords(msg) {
+		if q&QuirkZeroTTL != 0 && rr.Header().Ttl == 0 {
+			rr.Header().Ttl = quirkTTL
+		}
+		if txt, ok := rr.(*dns.TXT); ok && q&QuirkTXTPadding != 0 {
+			txt.Txt = trimTXTPadding(txt.Txt)
+		}
+	}
+}
+
+// dropDuplicateSRV removes the SRV records of instances seen before.
+func dropDuplicateSRV(rrs []dns.RR, seen map[string]bool) []dns.RR {
+	out := rrs[:0]
+	for _, rr := range rrs {
+		if srv, ok := rr.(*dns.SRV); ok {
+			name := strings.ToLower(srv.Hdr.Name)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+// trimTXTPadding removes empty strings and trailing NUL bytes from the
+// strings of a TXT record, leaving the single empty string of RFC 6763,
+// section 6.1, if nothing remains.
+func trimTXTPadding(txt []string) []string {
+	out := txt[:0]
+	for _, s := range txt {
+		if s = strings.TrimRight(s, "\x00"); s != "" {
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return []string{""}
+	}
+	return out
+}
@@ -0,0 +1,146 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// quirkCapture is a packet of testdata/quirks: a comment header naming the
+// device and the quirks it is expected to show, followed by the packet as
+// hex.
+type quirkCapture struct {
+	device string
+	quirks string
+	msg    *dns.Msg
+}
+
+// readQuirkCapture parses a capture file.
+func readQuirkCapture(t *testing.T, path string) quirkCapture {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var c quirkCapture
+	var packet bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "# device:"):
+			c.device = strings.TrimSpace(strings.TrimPrefix(line, "# device:"))
+		case strings.HasPrefix(line, "# quirks:"):
+			c.quirks = strings.TrimSpace(strings.TrimPrefix(line, "# quirks:"))
+		case line != "" && !strings.HasPrefix(line, "#"):
+			packet.WriteString(line)
+		}
+	}
+	buf, err := hex.DecodeString(packet.String())
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	if c.msg, err = ParseMessage(buf); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return c
+}
+
+func TestQuirkCorpus(t *testing.T) {
+	paths, err := filepath.Glob("testdata/quirks/*.hex")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no captures: %v", err)
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			c := readQuirkCapture(t, path)
+			q := DetectQuirks(c.msg)
+			if q.String() != c.quirks {
+				t.Fatalf("%s detected as %v, want %s", c.device, q, c.quirks)
+			}
+
+			before := c.msg.String()
+			var qs quirks
+			qs.apply(c.msg, net.IPv4(192, 168, 1, 1))
+			if q == 0 {
+				if c.msg.String() != before {
+					t.Fatalf("compliant packet changed:\n%s", c.msg)
+				}
+				return
+			}
+			srvs := make(map[string]int)
+			for _, rr := range allRecords(c.msg) {
+				if rr.Header().Ttl == 0 {
+					t.Fatalf("goodbye left: %v", rr)
+				}
+				switch rr := rr.(type) {
+				case *dns.SRV:
+					if srvs[rr.Hdr.Name]++; srvs[rr.Hdr.Name] > 1 {
+						t.Fatalf("duplicate SRV left: %v", rr)
+					}
+				case *dns.TXT:
+					for _, s := range rr.Txt {
+						if s == "" || strings.HasSuffix(s, "\x00") {
+							t.Fatalf("padding left: %q", rr.Txt)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestQuirks_RememberHost(t *testing.T) {
+	src := net.IPv4(192, 168, 1, 31)
+	var qs quirks
+	qs.apply(readQuirkCapture(t, "testdata/quirks/esp8266-announce.hex").msg, src)
+
+	// Later messages need not identify the device
+	goodbye := new(dns.Msg)
+	goodbye.Response = true
+	goodbye.Answer = []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: "device.local.", Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+		Txt: []string{"x=1"},
+	}}
+	qs.apply(goodbye, src)
+	if ttl := goodbye.Answer[0].Header().Ttl; ttl != quirkTTL {
+		t.Fatalf("bad: %d", ttl)
+	}
+
+	// Other hosts are left alone
+	goodbye.Answer[0].Header().Ttl = 0
+	qs.apply(goodbye, net.IPv4(192, 168, 1, 32))
+	if ttl := goodbye.Answer[0].Header().Ttl; ttl != 0 {
+		t.Fatalf("bad: %d", ttl)
+	}
+}
+
+func TestClient_SetQuirks(t *testing.T) {
+	c := &Client{}
+	c.SetQuirks(QuirkAll &^ QuirkZeroTTL)
+	msg := readQuirkCapture(t, "testdata/quirks/esp8266-announce.hex").msg
+	c.quirks.apply(msg, net.IPv4(192, 168, 1, 31))
+	for _, rr := range msg.Answer {
+		if rr.Header().Ttl != 0 {
+			t.Fatalf("disabled quirk worked around: %v", rr)
+		}
+	}
+}
+
+func TestQuirk_String(t *testing.T) {
+	if s := (QuirkTXTPadding | QuirkDuplicateSRV).String(); s != "txt-padding|duplicate-srv" {
+		t.Fatalf("bad: %s", s)
+	}
+	if s := Quirk(0).String(); s != "none" {
+		t.Fatalf("bad: %s", s)
+	}
+}
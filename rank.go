@@ -0,0 +1,165 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Scorer scores an entry for RankEntries; the higher the score, the better
+// the entry. It is called concurrently for the entries being ranked, and
+// should return early once ctx is done.
+type Scorer func(ctx context.Context, e *ServiceEntry) float64
+
+// RankEntries orders several instances of the same logical service, such as
+// a site's print servers, from the most to the least preferred, and returns
+// entries. Following RFC 2782, the lowest SRV priority comes first; entries
+// of the same priority are ordered by the sum of their scores, and those
+// scoring the same at random, weighted by their SRV weight as RFC 2782
+// describes, so that the load spreads as the weights ask. The scorers are run
+// for every entry at once, so that probes take as long as the slowest of them.
+func RankEntries(ctx context.Context, entries []*ServiceEntry, scorers ...Scorer) []*ServiceEntry {
+	scores := make(map[*ServiceEntry]float64, len(entries))
+	if len(scorers) > 0 {
+		var (
+			mu sync.Mutex
+			wg sync.WaitGroup
+		)
+		for _, e := range entries {
+			wg.Add(1)
+			go func(e *ServiceEntry) {
+				defer wg.Done()
+				var score float64
+				for _, scorer := range scorers {
+					score += scorer(ctx, e)
+				}
+				mu.Lock()
+				scores[e] = score
+				mu.Unlock()
+			}(e)
+		}
+		wg.Wait()
+	}
+
+	SortEntries(entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return scores[a] > scores[b]
+	})
+	weight := func(e *ServiceEntry) int { return e.Weight }
+	for i := 0; i < len(entries); {
+		j := i + 1
+		for j < len(entries) && entries[j].Priority == entries[i].Priority && scores[entries[j]] == scores[entries[i]] {
+			j++
+		}
+		shuffleByWeight(entries[i:j], weight)
+		i = j
+	}
+	return entries
+}
+
+// shuffleByWeight orders items of the same priority as RFC 2782 orders SRV
+// records: each next item is picked at random among the remaining ones, with
+// a chance proportional to its weight, and those of weight zero have a small
+// chance of coming first.
+func shuffleByWeight[T any](items []T, weight func(T) int) {
+	for i := 0; i < len(items)-1; i++ {
+		rest := items[i:]
+		// Those of weight zero go first, picked only if the draw is zero
+		sort.SliceStable(rest, func(a, b int) bool { return weight(rest[a]) == 0 && weight(rest[b]) != 0 })
+		total := 0
+		for _, item := range rest {
+			total += weight(item)
+		}
+		draw := rand.Intn(total + 1)
+		sum := 0
+		for j, item := range rest {
+			sum += weight(item)
+			if sum >= draw {
+				rest[0], rest[j] = rest[j], rest[0]
+				break
+			}
+		}
+	}
+}
+
+// SubnetProximity returns a Scorer giving 1 to the entries with an address on
+// one of the given networks, and 0 to the others, so that nearby instances
+// are preferred over those reached through a router. Without networks, those
+// of the host's interfaces at the time of the call are used.
+func SubnetProximity(networks ...*net.IPNet) Scorer {
+	if len(networks) == 0 {
+		addrs, _ := net.InterfaceAddrs()
+		for _, addr := range addrs {
+			if n, ok := addr.(*net.IPNet); ok && !n.IP.IsLoopback() {
+				networks = append(networks, n)
+			}
+		}
+	}
+	return func(_ context.Context, e *ServiceEntry) float64 {
+		for _, addr := range entryAddrs(e) {
+			for _, n := range networks {
+				if n.Contains(addr.IP) {
+					return 1
+				}
+			}
+		}
+		return 0
+	}
+}
+
+// LatencyProbe returns a Scorer timing a TCP connection to the port of each
+// entry, on its first address that accepts one within timeout. The score is
+// the negated connection time in seconds, so that faster instances score
+// higher, and negative infinity for instances that cannot be reached.
+func LatencyProbe(timeout time.Duration) Scorer {
+	return func(ctx context.Context, e *ServiceEntry) float64 {
+		if e.Port == 0 {
+			return math.Inf(-1)
+		}
+		var d net.Dialer
+		for _, addr := range entryAddrs(e) {
+			host := addr.IP.String()
+			if addr.Zone != "" {
+				host += "%" + addr.Zone
+			}
+			dctx, cancel := context.WithTimeout(ctx, timeout)
+			start := time.Now()
+			conn, err := d.DialContext(dctx, "tcp", net.JoinHostPort(host, strconv.Itoa(e.Port)))
+			elapsed := time.Since(start)
+			cancel()
+			if err == nil {
+				conn.Close()
+				return -elapsed.Seconds()
+			}
+		}
+		return math.Inf(-1)
+	}
+}
+
+// entryAddrs returns the addresses of an entry's host, IPv4 first.
+func entryAddrs(e *ServiceEntry) []net.IPAddr {
+	var addrs []net.IPAddr
+	for _, ip := range e.AddrsV4 {
+		addrs = append(addrs, net.IPAddr{IP: ip})
+	}
+	if len(e.AddrsV4) == 0 && e.AddrV4 != nil {
+		addrs = append(addrs, net.IPAddr{IP: e.AddrV4})
+	}
+	addrs = append(addrs, e.AddrsV6...)
+	if len(e.AddrsV6) == 0 && e.AddrV6IPAddr != nil {
+		addrs = append(addrs, *e.AddrV6IPAddr)
+	}
+	return addrs
+}
@@ -0,0 +1,117 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRankEntries(t *testing.T) {
+	entries := []*ServiceEntry{
+		{Name: "d._ipp._tcp.local.", Priority: 20, Weight: 50},
+		{Name: "c._ipp._tcp.local.", Priority: 10, Weight: 1},
+		{Name: "b._ipp._tcp.local.", Priority: 10, Weight: 5},
+		{Name: "a._ipp._tcp.local.", Priority: 10, Weight: 5},
+	}
+	names := func() []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.Name[:1])
+		}
+		return out
+	}
+
+	// Priority first
+	RankEntries(context.Background(), entries)
+	if got := names(); got[3] != "d" {
+		t.Fatalf("bad: %v", got)
+	}
+
+	// Scores come before weight, but not before priority
+	favor := func(_ context.Context, e *ServiceEntry) float64 {
+		switch e.Name[0] {
+		case 'c':
+			return 2
+		case 'd':
+			return 10
+		}
+		return 0
+	}
+	bonus := func(_ context.Context, e *ServiceEntry) float64 {
+		if e.Name[0] == 'b' {
+			return 3
+		}
+		return 0
+	}
+	RankEntries(context.Background(), entries, favor, bonus)
+	if got := names(); !reflect.DeepEqual(got, []string{"b", "c", "a", "d"}) {
+		t.Fatalf("bad: %v", got)
+	}
+}
+
+func TestShuffleByWeight(t *testing.T) {
+	weight := func(w int) int { return w }
+	first := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		items := []int{0, 10, 90}
+		shuffleByWeight(items, weight)
+		first[items[0]]++
+	}
+	// Each comes first about as often as its weight asks, those of weight
+	// zero seldom
+	if first[90] < 800 || first[10] < 50 || first[0] > 30 {
+		t.Fatalf("bad: %v", first)
+	}
+}
+
+func TestSubnetProximity(t *testing.T) {
+	_, local, _ := net.ParseCIDR("192.168.1.0/24")
+	score := SubnetProximity(local)
+	near := &ServiceEntry{AddrsV4: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("192.168.1.7")}}
+	far := &ServiceEntry{AddrV4: net.ParseIP("192.168.2.7")}
+	if s := score(context.Background(), near); s != 1 {
+		t.Fatalf("bad: %v", s)
+	}
+	if s := score(context.Background(), far); s != 0 {
+		t.Fatalf("bad: %v", s)
+	}
+}
+
+func TestLatencyProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	probe := LatencyProbe(time.Second)
+	up := &ServiceEntry{AddrV4: net.ParseIP("127.0.0.1"), Port: port}
+	if s := probe(context.Background(), up); s > 0 || math.IsInf(s, -1) {
+		t.Fatalf("bad: %v", s)
+	}
+
+	// Unreachable instances score lowest
+	l.Close()
+	if s := probe(context.Background(), up); !math.IsInf(s, -1) {
+		t.Fatalf("bad: %v", s)
+	}
+	if s := probe(context.Background(), &ServiceEntry{AddrV4: net.ParseIP("127.0.0.1")}); !math.IsInf(s, -1) {
+		t.Fatalf("bad: %v", s)
+	}
+}
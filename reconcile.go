@@ -0,0 +1,260 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// Spec is the desired state of an application's mDNS usage: the services it
+// browses and those it publishes, see Reconciler.
+type Spec struct {
+	// Browses are the browses to run. A browse is identified by its service,
+	// domain, and interface; the Entries and Timeout fields are ignored as
+	// by BrowseParams. Functions cannot be compared, so a browse is not
+	// restarted when its Complete, Filter, or OnDrop function is replaced by
+	// another, only when one is set or cleared.
+	Browses []QueryParam
+
+	// Services are the services to publish, identified by their instance
+	// name.
+	Services []*MDNSService
+}
+
+// Reconciler keeps browses and registrations in line with a Spec, for
+// applications driven by a configuration they reload: each Apply starts the
+// browses and registers the services that are new, stops the browses and
+// deregisters the services that are gone, and applies the changes to the
+// others, so that the application only declares what it wants.
+type Reconciler struct {
+	client    *Client
+	responder Responder
+	events    chan<- BrowseEvent
+
+	// mu serializes Apply and Close.
+	mu       sync.Mutex
+	browses  map[string]*reconciledBrowse
+	services map[string]*MDNSService // as registered, owned by the Reconciler
+}
+
+// reconciledBrowse is a browse started by a Reconciler.
+type reconciledBrowse struct {
+	params QueryParam
+	cancel context.CancelFunc
+	done   chan struct{} // closed once the browse returned
+}
+
+// NewReconciler returns a Reconciler running browses on c, which send their
+// events to events, and publishing services through r. Either of c and r may
+// be nil if no Spec applied browses or publishes services respectively.
+func NewReconciler(c *Client, r Responder, events chan<- BrowseEvent) *Reconciler {
+	return &Reconciler{
+		client:    c,
+		responder: r,
+		events:    events,
+		browses:   make(map[string]*reconciledBrowse),
+		services:  make(map[string]*MDNSService),
+	}
+}
+
+// Apply reconciles the browses and registrations with spec. Browses whose
+// parameters changed are restarted. Services whose port, addresses, or TXT
+// records changed are updated, announcing the new records. Those whose host
+// name or aliases changed are deregistered, sending goodbyes for the old
+// names so that no cache keeps them, and registered again, probing the new
+// ones. Apply carries on past failures, returning them all; a service that
+// failed to register is retried by the next Apply.
+// The ctx bounds the registrations, but not the browses, which run until the
+// Spec drops them or the Reconciler is closed.
+func (r *Reconciler) Apply(ctx context.Context, spec Spec) error {
+	browses := make(map[string]QueryParam, len(spec.Browses))
+	for _, par := range spec.Browses {
+		key := browseKey(par)
+		if _, ok := browses[key]; ok {
+			return fmt.Errorf("mdns: %s is browsed twice", key)
+		}
+		browses[key] = par
+	}
+	services := make(map[string]*MDNSService, len(spec.Services))
+	for _, service := range spec.Services {
+		key := serviceKey(service)
+		if _, ok := services[key]; ok {
+			return fmt.Errorf("mdns: %s is published twice", key)
+		}
+		services[key] = service
+	}
+	if len(browses) > 0 && r.client == nil {
+		return fmt.Errorf("mdns: browsing without a Client")
+	}
+	if len(services) > 0 && r.responder == nil {
+		return fmt.Errorf("mdns: publishing without a Responder")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Stop the browses that are gone or changed before starting their
+	// replacements, so that their events do not interleave
+	for key, b := range r.browses {
+		if par, ok := browses[key]; !ok || !sameParams(par, b.params) {
+			b.stop()
+			delete(r.browses, key)
+		}
+	}
+	for key, par := range browses {
+		if _, ok := r.browses[key]; !ok {
+			r.browses[key] = r.startBrowse(par)
+		}
+	}
+
+	var errs []error
+	for key, registered := range r.services {
+		if _, ok := services[key]; ok {
+			continue
+		}
+		delete(r.services, key)
+		if err := r.responder.Deregister(ctx, registered); err != nil && !errors.Is(err, ErrNotRegistered) {
+			errs = append(errs, fmt.Errorf("mdns: deregistering %s: %w", key, err))
+		}
+	}
+	for key, service := range services {
+		if err := r.reconcileService(ctx, key, service); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reconcileService registers a service of the Spec, or updates it, or
+// registers it again if its names changed. The caller must hold the lock.
+func (r *Reconciler) reconcileService(ctx context.Context, key string, service *MDNSService) error {
+	if registered, ok := r.services[key]; ok {
+		if sameService(registered, service) {
+			return nil
+		}
+		if sameNames(registered, service) {
+			updated := cloneService(service)
+			if err := r.responder.Update(ctx, registered, updated); err != nil {
+				return fmt.Errorf("mdns: updating %s: %w", key, err)
+			}
+			r.services[key] = updated
+			return nil
+		}
+		delete(r.services, key)
+		if err := r.responder.Deregister(ctx, registered); err != nil && !errors.Is(err, ErrNotRegistered) {
+			return fmt.Errorf("mdns: deregistering %s: %w", key, err)
+		}
+	}
+	// Register a copy, so that the caller may keep changing service for the
+	// next Spec
+	registered := cloneService(service)
+	if err := r.responder.Register(ctx, registered); err != nil {
+		return fmt.Errorf("mdns: registering %s: %w", key, err)
+	}
+	r.services[key] = registered
+	return nil
+}
+
+// startBrowse starts a browse in the background. The caller must hold the
+// lock.
+func (r *Reconciler) startBrowse(par QueryParam) *reconciledBrowse {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &reconciledBrowse{params: par, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(b.done)
+		err := r.client.BrowseParams(ctx, par, r.events)
+		if err != nil && ctx.Err() == nil && !errors.Is(err, ErrClientClosed) {
+			r.client.log.Printf("[ERR] mdns: Browse of %s stopped: %v", browseKey(par), err)
+		}
+	}()
+	return b
+}
+
+// stop stops the browse and waits for it to return.
+func (b *reconciledBrowse) stop() {
+	b.cancel()
+	<-b.done
+}
+
+// Close stops every browse and deregisters every service, sending their
+// goodbyes. The Reconciler may be used again afterwards, starting from
+// nothing. The Client and Responder are left open.
+func (r *Reconciler) Close(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, b := range r.browses {
+		b.stop()
+		delete(r.browses, key)
+	}
+	var errs []error
+	for key, registered := range r.services {
+		delete(r.services, key)
+		if err := r.responder.Deregister(ctx, registered); err != nil && !errors.Is(err, ErrNotRegistered) {
+			errs = append(errs, fmt.Errorf("mdns: deregistering %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// browseKey identifies a browse of a Spec.
+func browseKey(par QueryParam) string {
+	key := canonicalName(ServiceName(par.Service, par.Domain))
+	if par.Interface != nil {
+		key += "%" + par.Interface.Name
+	}
+	return key
+}
+
+// serviceKey identifies a service of a Spec.
+func serviceKey(service *MDNSService) string {
+	name := service.instanceAddr
+	if name == "" {
+		name = InstanceName(service.Instance, service.Service, service.Domain)
+	}
+	return canonicalName(name)
+}
+
+// sameParams reports whether two browses of a Spec have the same parameters.
+// Functions cannot be compared, so they are taken to be the same if both are
+// set or both are not, even if they differ.
+func sameParams(a, b QueryParam) bool {
+	if (a.Complete == nil) != (b.Complete == nil) || (a.OnDrop == nil) != (b.OnDrop == nil) ||
+		(a.Filter == nil) != (b.Filter == nil) {
+		return false
+	}
+	a.Complete, b.Complete = nil, nil
+	a.OnDrop, b.OnDrop = nil, nil
+	a.Filter, b.Filter = nil, nil
+	a.Entries, b.Entries = nil, nil
+	a.Timeout, b.Timeout = 0, 0
+	return reflect.DeepEqual(a, b)
+}
+
+// sameService reports whether two versions of a service publish the same
+// records.
+func sameService(a, b *MDNSService) bool {
+	return sameNames(a, b) && a.Port == b.Port && slices.Equal(a.TXT, b.TXT) &&
+		slices.EqualFunc(a.IPs, b.IPs, net.IP.Equal)
+}
+
+// sameNames reports whether two versions of a service have the same host
+// name and aliases, which Update cannot change.
+func sameNames(a, b *MDNSService) bool {
+	return canonicalName(a.HostName) == canonicalName(b.HostName) && slices.Equal(a.Aliases, b.Aliases)
+}
+
+// cloneService returns a copy of a service that shares no slices with it.
+func cloneService(service *MDNSService) *MDNSService {
+	clone := *service
+	clone.IPs = slices.Clone(service.IPs)
+	clone.TXT = slices.Clone(service.TXT)
+	clone.Aliases = slices.Clone(service.Aliases)
+	return &clone
+}
@@ -0,0 +1,162 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestReconciler_Apply(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	// The web server runs on a host of its own, whose addresses the printer's
+	// announcements do not flush
+	webService := makeService(t)
+	webService.HostName = "webhost."
+	web, err := NewServer(&Config{Zone: webService, Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer web.Shutdown()
+	serv, err := NewServer(&Config{Transport: network.Host(net.ParseIP("10.0.0.3"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	events := make(chan BrowseEvent, 16)
+	responder := &countingResponder{Responder: serv}
+	r := NewReconciler(c, responder, events)
+	ctx := context.Background()
+	lookup := func() []*ServiceEntry {
+		t.Helper()
+		entries := make(chan *ServiceEntry, 4)
+		params := []QueryParam{{Service: "_printer._tcp", Timeout: 100 * time.Millisecond}}
+		if err := QueryContext(ctx, &params, entries, c); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		close(entries)
+		var out []*ServiceEntry
+		for e := range entries {
+			out = append(out, e)
+		}
+		return out
+	}
+	nextEvent := func() BrowseEvent {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no event")
+		}
+		return BrowseEvent{}
+	}
+
+	// Everything is started
+	printer := makeServiceWithServiceName(t, "_printer._tcp")
+	spec := Spec{
+		Browses:  []QueryParam{{Service: "_http._tcp", RemoveOnClose: true}},
+		Services: []*MDNSService{printer},
+	}
+	if err := r.Apply(ctx, spec); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if e := nextEvent(); e.Type != BrowseAdded || e.Entry.Name != "hostname._http._tcp.local." {
+		t.Fatalf("bad: %+v", e)
+	}
+	if found := lookup(); len(found) != 1 || found[0].Port != 80 {
+		t.Fatalf("bad: %v", found)
+	}
+
+	// A changed service is updated, and unchanged browses keep running
+	printer.Port = 631
+	if err := r.Apply(ctx, spec); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	// The last announcement flushes the old records once the Client got it
+	time.Sleep(100 * time.Millisecond)
+	if found := lookup(); len(found) != 1 || found[0].Port != 631 {
+		t.Fatalf("bad: %v", found)
+	}
+	if len(events) != 0 {
+		t.Fatalf("browse restarted: %+v", <-events)
+	}
+	if responder.deregistered != 0 {
+		t.Fatalf("service was registered again")
+	}
+
+	// One whose host name changed is registered again
+	printer.HostName = "printer."
+	if err := r.Apply(ctx, spec); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if responder.deregistered != 1 {
+		t.Fatalf("service was not registered again")
+	}
+	if found := lookup(); len(found) != 1 || found[0].Host != "printer." {
+		t.Fatalf("bad: %v", found)
+	}
+
+	// Everything is stopped
+	if err := r.Apply(ctx, Spec{}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if e := nextEvent(); e.Type != BrowseRemoved {
+		t.Fatalf("bad: %+v", e)
+	}
+	// Goodbyes expire the records a second later, as per 10.1 in RFC
+	time.Sleep(1100 * time.Millisecond)
+	if found := lookup(); len(found) != 0 {
+		t.Fatalf("deregistered service found: %v", found)
+	}
+
+	// Duplicates are rejected, and Apply needs what the Spec uses
+	if err := r.Apply(ctx, Spec{Services: []*MDNSService{printer, printer}}); err == nil {
+		t.Fatalf("error expected")
+	}
+	if err := NewReconciler(nil, serv, nil).Apply(ctx, spec); err == nil {
+		t.Fatalf("error expected")
+	}
+}
+
+func TestSameParams(t *testing.T) {
+	a := QueryParam{Service: "_http._tcp", Timeout: time.Second, Peers: []net.IP{net.ParseIP("10.0.0.1")}}
+	b := a
+	b.Timeout = 0
+	b.Peers = []net.IP{net.ParseIP("10.0.0.1")}
+	if !sameParams(a, b) {
+		t.Fatalf("timeouts and copies should not matter")
+	}
+	b.Complete = func(*ServiceEntry) bool { return true }
+	if sameParams(a, b) {
+		t.Fatalf("predicate added")
+	}
+	b.Complete = nil
+	b.Require = RequireSRV
+	if sameParams(a, b) {
+		t.Fatalf("requirement changed")
+	}
+}
+
+// countingResponder counts the services a Responder deregistered.
+type countingResponder struct {
+	Responder
+	deregistered int
+}
+
+func (r *countingResponder) Deregister(ctx context.Context, service *MDNSService) error {
+	r.deregistered++
+	return r.Responder.Deregister(ctx, service)
+}
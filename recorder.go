@@ -0,0 +1,67 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// recordMulticast is the destination recorded for multicast packets, which
+// are sent to the mDNS group on every listener.
+const recordMulticast = "multicast"
+
+// Packet is a packet sent by a Server, as captured by a Recorder.
+type Packet struct {
+	// To is "multicast" for packets sent to the mDNS group, or the address
+	// of the host a unicast response was sent to.
+	To string
+
+	// Data is the packet in wire format.
+	Data []byte
+}
+
+// Recorder captures every packet a Server sends, so that the probing,
+// announcing, and goodbye sequences of a scripted scenario can be compared
+// byte for byte against a golden file. Set it with Config.Recorder.
+type Recorder struct {
+	mu      sync.Mutex
+	packets []Packet
+}
+
+// record captures a packet sent to the given destination.
+func (r *Recorder) record(to string, buf []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.packets = append(r.packets, Packet{To: to, Data: append([]byte(nil), buf...)})
+}
+
+// Packets returns the packets recorded so far, in the order they were sent.
+func (r *Recorder) Packets() []Packet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Packet(nil), r.packets...)
+}
+
+// Reset discards the recorded packets.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.packets = nil
+}
+
+// WriteTo writes the recorded packets in the golden file format: for each
+// packet, a line naming its position and destination followed by a hex dump
+// of its contents.
+func (r *Recorder) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for i, p := range r.Packets() {
+		fmt.Fprintf(&buf, "packet %d to %s\n", i+1, p.To)
+		buf.WriteString(hex.Dump(p.Data))
+	}
+	return buf.WriteTo(w)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// checkGolden compares the recorded packets with a golden file in testdata,
+// rewriting the file instead when the -update flag is given.
+func checkGolden(t *testing.T, name string, rec *Recorder) {
+	var got bytes.Buffer
+	if _, err := rec.WriteTo(&got); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Fatalf("packets differ from %s (run with -update to accept):\n%s", path, got.String())
+	}
+}
+
+func TestServer_RecordRegister(t *testing.T) {
+	rec := &Recorder{}
+	serv, err := NewServer(&Config{Recorder: rec})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	service := makeServiceWithServiceName(t, "_golden._tcp")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := serv.Register(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := serv.Deregister(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Three probes, two announcements, and a goodbye
+	if n := len(rec.Packets()); n != 6 {
+		t.Fatalf("recorded %d packets, want 6", n)
+	}
+	checkGolden(t, "register", rec)
+}
@@ -0,0 +1,420 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// probeCount and probeInterval define the probing schedule of RFC 6762,
+	// section 8.1: three probes, 250ms apart.
+	probeCount    = 3
+	probeInterval = 250 * time.Millisecond
+
+	// announceCount and announceInterval define the announcement schedule of
+	// RFC 6762, section 8.3: at least two responses, one second apart.
+	announceCount    = 2
+	announceInterval = time.Second
+)
+
+var (
+	// ErrNameConflict is returned by Register when another host on the
+	// network already uses the instance name being registered.
+	ErrNameConflict = errors.New("mdns: name conflict")
+
+	// ErrNotRegistered is returned when updating or deregistering a service
+	// that was not registered with the Server.
+	ErrNotRegistered = errors.New("mdns: service not registered")
+
+	// errServerShutdown is returned by operations interrupted by Shutdown.
+	errServerShutdown = errors.New("mdns: server shut down")
+)
+
+// probe tracks an in-progress probe for a set of names.
+type probe struct {
+	names map[string]bool // lower-cased names being claimed
+	own   []dns.RR        // records we intend to publish
+
+	once     sync.Once
+	conflict chan struct{}
+}
+
+// signal records that a conflicting record was seen.
+func (p *probe) signal() {
+	p.once.Do(func() { close(p.conflict) })
+}
+
+// Register probes the network to make sure the service's instance name is not
+// in use, then announces the service and starts answering queries for it.
+// ErrNameConflict is returned if another host answers for the name. The
+// context bounds how long probing and announcing may take; if it is cancelled
+// the service is not registered, and a goodbye withdraws the announcements
+// already sent.
+func (s *Server) Register(ctx context.Context, service *MDNSService) error {
+	if s.isRegistered(service) {
+		return fmt.Errorf("mdns: %s is already registered", service.instanceAddr)
+	}
+	if err := s.probe(ctx, service); err != nil {
+		return err
+	}
+	if !s.add(service) {
+		return fmt.Errorf("mdns: %s is already registered", service.instanceAddr)
+	}
+
+	sent, err := s.announce(ctx, service, announceCount)
+	if err != nil {
+		s.remove(service)
+		if sent > 0 {
+			// The context is likely done, so the goodbye gets its own
+			gctx, cancel := context.WithTimeout(context.Background(), announceInterval)
+			defer cancel()
+			if gerr := s.goodbye(gctx, service, s.zone()); gerr != nil {
+				s.config.Logger.Printf("[ERR] mdns: Failed to withdraw %s: %v", service.instanceAddr, gerr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Update replaces a registered service with updated, which has the same
+// instance name, host name, and aliases but another port, addresses, or TXT
+// records, and announces it. From then on updated is the registered service,
+// to be passed to Deregister or the next Update; service is left as it was.
+// To change the names of a service, deregister it and register the new one.
+func (s *Server) Update(ctx context.Context, service, updated *MDNSService) error {
+	if !strings.EqualFold(service.instanceAddr, updated.instanceAddr) || !sameNames(service, updated) {
+		return fmt.Errorf("mdns: update of %s changes its names", service.instanceAddr)
+	}
+	if !s.replace(service, updated) {
+		return ErrNotRegistered
+	}
+	_, err := s.announce(ctx, updated, announceCount)
+	return err
+}
+
+// Deregister stops answering queries for a registered service and sends a
+// goodbye so that other hosts remove it from their caches.
+func (s *Server) Deregister(ctx context.Context, service *MDNSService) error {
+	if !s.remove(service) {
+		return ErrNotRegistered
+	}
+	return s.goodbye(ctx, service, s.zone())
+}
+
+// ShutdownContext sends goodbyes for all registered services before shutting
+// down the listener. If the context ends before the goodbyes are sent, the
+// listener is shut down regardless and the context's error is returned.
+func (s *Server) ShutdownContext(ctx context.Context) error {
+	s.mu.Lock()
+	services := append([]*MDNSService(nil), s.services...)
+	s.services = nil
+	s.mu.Unlock()
+	s.answers.invalidate()
+
+	var err error
+	for i, service := range services {
+		remaining := make(MultiZone, 0, len(services)-i-1)
+		for _, other := range services[i+1:] {
+			remaining = append(remaining, other)
+		}
+		if err = s.goodbye(ctx, service, remaining); err != nil {
+			break
+		}
+	}
+	if serr := s.Shutdown(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// remove drops a registered service, reporting whether it was registered.
+func (s *Server) remove(service *MDNSService) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, svc := range s.services {
+		if svc == service {
+			s.services = append(s.services[:i], s.services[i+1:]...)
+			s.answers.invalidate()
+			return true
+		}
+	}
+	return false
+}
+
+// add adds a service to those registered, reporting whether it was not
+// registered already.
+func (s *Server) add(service *MDNSService) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, svc := range s.services {
+		if svc == service {
+			return false
+		}
+	}
+	s.services = append(s.services, service)
+	s.answers.invalidate()
+	return true
+}
+
+// replace swaps a registered service for updated, reporting whether service
+// was registered.
+func (s *Server) replace(service, updated *MDNSService) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, svc := range s.services {
+		if svc == service {
+			s.services[i] = updated
+			s.answers.invalidate()
+			return true
+		}
+	}
+	return false
+}
+
+// isRegistered reports whether the service was registered with Register.
+func (s *Server) isRegistered(service *MDNSService) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, svc := range s.services {
+		if svc == service {
+			return true
+		}
+	}
+	return false
+}
+
+// zone returns the zone answering queries: the configured Zone along with
+// every registered service and the diagnostics beacon.
+func (s *Server) zone() Zone {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zone := make(MultiZone, 0, len(s.services)+2)
+	if s.config.Zone != nil {
+		zone = append(zone, s.config.Zone)
+	}
+	for _, service := range s.services {
+		zone = append(zone, service)
+	}
+	if s.diagnostics != nil {
+		zone = append(zone, s.diagnostics)
+	}
+	return zone
+}
+
+// probe runs the probing sequence of RFC 6762, section 8.1, for the service's
+// instance name.
+func (s *Server) probe(ctx context.Context, service *MDNSService) error {
+	var own []dns.RR
+	for _, rr := range service.Records(dns.Question{Name: service.instanceAddr, Qtype: dns.TypeANY}) {
+		if strings.EqualFold(rr.Header().Name, service.instanceAddr) {
+			own = append(own, rr)
+		}
+	}
+
+	p := s.startProbe([]string{service.instanceAddr}, own)
+	defer s.stopProbe(p)
+
+	// 8.2: the records being claimed go in the Authority Section
+	m := new(dns.Msg)
+	m.SetQuestion(service.instanceAddr, dns.TypeANY)
+	m.Id = 0 // 18.1: multicast queries use a zero ID
+	m.RecursionDesired = false
+	m.Ns = own
+
+	for i := 0; i < probeCount; i++ {
+		if _, err := s.schedule(ctx, TransmitProbe, service.instanceAddr); err != nil {
+			return err
+		}
+		if err := s.sendMulticast(m); err != nil {
+			return err
+		}
+		select {
+		case <-after(s.clk(), probeInterval):
+		case <-p.conflict:
+			return fmt.Errorf("%w: %s", ErrNameConflict, service.instanceAddr)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.shutdownCh:
+			return errServerShutdown
+		}
+	}
+	return nil
+}
+
+// startProbe registers a probe so that responses claiming any of the names
+// with records other than our own are flagged as conflicts.
+func (s *Server) startProbe(names []string, own []dns.RR) *probe {
+	p := &probe{
+		names:    make(map[string]bool),
+		own:      own,
+		conflict: make(chan struct{}),
+	}
+	for _, name := range names {
+		p.names[strings.ToLower(name)] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.probes == nil {
+		s.probes = make(map[*probe]struct{})
+	}
+	s.probes[p] = struct{}{}
+	return p
+}
+
+// stopProbe unregisters a probe.
+func (s *Server) stopProbe(p *probe) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.probes, p)
+}
+
+// handleResponse is used to handle an incoming response, checking it for
+// records that conflict with in-progress probes.
+func (s *Server) handleResponse(resp *dns.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for p := range s.probes {
+		for _, rr := range append(resp.Answer, resp.Extra...) {
+			if !p.names[strings.ToLower(rr.Header().Name)] {
+				continue
+			}
+			if !containsRR(p.own, rr) {
+				p.signal()
+			}
+		}
+	}
+}
+
+// containsRR reports whether recs contains a record with the same name, type,
+// class, and data as rr.
+func containsRR(recs []dns.RR, rr dns.RR) bool {
+	for _, r := range recs {
+		if dns.IsDuplicate(r, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// announce multicasts the service's records count times, announceInterval
+// apart, as described in RFC 6762, section 8.3, and returns the number of
+// announcements sent.
+func (s *Server) announce(ctx context.Context, service *MDNSService, count int) (int, error) {
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			select {
+			case <-after(s.clk(), announceInterval):
+			case <-ctx.Done():
+				return i, ctx.Err()
+			case <-s.shutdownCh:
+				return i, errServerShutdown
+			}
+		}
+		if _, err := s.schedule(ctx, TransmitAnnounce, service.instanceAddr); err != nil {
+			return i, err
+		}
+		p, err := s.announcement(service)
+		if err != nil {
+			return i, err
+		}
+		if err := s.sendPackedMulticast(p); err != nil {
+			return i, err
+		}
+	}
+	return count, nil
+}
+
+// goodbye multicasts the service's records with a TTL of zero, as described in
+// RFC 6762, section 10.1. Records that remaining still publishes, such as the
+// addresses of a host other services run on, are left out.
+func (s *Server) goodbye(ctx context.Context, service *MDNSService, remaining Zone) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var recs []dns.RR
+	for _, rr := range service.Records(dns.Question{Name: service.serviceAddr, Qtype: dns.TypePTR}) {
+		hdr := rr.Header()
+		if containsRR(remaining.Records(dns.Question{Name: hdr.Name, Qtype: hdr.Rrtype, Qclass: dns.ClassINET}), rr) {
+			continue
+		}
+		hdr.Ttl = 0
+		recs = append(recs, rr)
+	}
+	if len(recs) == 0 {
+		return nil
+	}
+	if _, err := s.schedule(ctx, TransmitGoodbye, service.instanceAddr); err != nil {
+		return err
+	}
+	return s.sendMulticast(unsolicitedResponse(recs))
+}
+
+// unsolicitedResponse returns a response message carrying recs, with the
+// headers set as for any other response (see section 18 of RFC 6762).
+func unsolicitedResponse(recs []dns.RR) *dns.Msg {
+	return &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Response:      true,
+			Opcode:        dns.OpcodeQuery,
+			Authoritative: true,
+		},
+		Compress: true,
+		Answer:   recs,
+	}
+}
+
+// sendMulticast is used to multicast a message on every listener. It only
+// fails if the message could not be sent on any of them.
+func (s *Server) sendMulticast(msg *dns.Msg) error {
+	p, err := s.pack(msg)
+	if err != nil {
+		return err
+	}
+	return s.sendPackedMulticast(p)
+}
+
+// sendPackedMulticast is like sendMulticast for a message already packed.
+func (s *Server) sendPackedMulticast(p *packedMsg) error {
+	defer s.config.Timings.done(StageSend, s.config.Timings.start())
+	buf, msg := p.buf, p.msg
+	if s.config.Recorder != nil {
+		s.config.Recorder.record(recordMulticast, buf)
+	}
+	if s.config.DryRun {
+		s.logDryRun(multicastKind(msg), recordMulticast, msg)
+		return nil
+	}
+	s.echoes.add(buf, time.Now())
+	var err error
+	sent := false
+	if s.ipv4List != nil {
+		if _, err = s.ipv4List.WriteTo(buf, ipv4Addr); err == nil {
+			sent = true
+		}
+	}
+	if s.ipv6List != nil {
+		var err6 error
+		if _, err6 = s.ipv6List.WriteTo(buf, ipv6Addr); err6 == nil {
+			sent = true
+		} else if err == nil {
+			err = err6
+		}
+	}
+	if sent {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,221 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestServer_Register(t *testing.T) {
+	serv, err := NewServer(&Config{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	service := makeServiceWithServiceName(t, "_register._tcp")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := serv.Register(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	client, err := NewClient(true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer client.Close()
+
+	entries := make(chan *ServiceEntry, 1)
+	params := &[]QueryParam{{Service: "_register._tcp", Domain: "local"}}
+	if err := Query(params, entries, client); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case e := <-entries:
+		if e.Name != "hostname._register._tcp.local." {
+			t.Fatalf("bad: %+v", e)
+		}
+	default:
+		t.Fatalf("registered service was not discovered")
+	}
+
+	// A second registration of the same service is rejected
+	if err := serv.Register(ctx, service); err == nil {
+		t.Fatalf("registered twice")
+	}
+
+	updated := makeServiceWithServiceName(t, "_register._tcp")
+	updated.Port = 8080
+	renamed := makeServiceWithServiceName(t, "_register._tcp")
+	renamed.HostName = "otherhost."
+	if err := serv.Update(ctx, service, renamed); err == nil {
+		t.Fatalf("update changed the host name")
+	}
+	if err := serv.Update(ctx, service, updated); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if service.Port != 80 {
+		t.Fatalf("registered service changed: %+v", service)
+	}
+	if err := serv.Update(ctx, service, updated); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("got %v, want ErrNotRegistered", err)
+	}
+	if err := serv.ShutdownContext(ctx); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := serv.Deregister(ctx, updated); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("got %v, want ErrNotRegistered", err)
+	}
+}
+
+func TestServer_RegisterCanceled(t *testing.T) {
+	serv, err := NewServer(&Config{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	service := makeService(t)
+	if err := serv.Register(ctx, service); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if err := serv.Update(context.Background(), service, makeService(t)); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("got %v, want ErrNotRegistered", err)
+	}
+}
+
+func TestServer_RegisterCanceledAnnouncing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rec := &Recorder{}
+	serv, err := NewServer(&Config{
+		Recorder: rec,
+		SendHook: func(tr Transmission) time.Duration {
+			// Cancel once the first announcement is about to go out
+			if tr.Kind == TransmitAnnounce {
+				cancel()
+			}
+			return 0
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	if err := serv.Register(ctx, makeService(t)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+
+	// The announcement that went out is withdrawn
+	packets := rec.Packets()
+	bye, err := ParseMessage(packets[len(packets)-1].Data)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(bye.Answer) == 0 {
+		t.Fatalf("no goodbye: %v", bye)
+	}
+	for _, rr := range bye.Answer {
+		if rr.Header().Ttl != 0 {
+			t.Fatalf("not a goodbye: %v", bye)
+		}
+	}
+}
+
+func TestServer_ProbeConflict(t *testing.T) {
+	serv := &Server{}
+	service := makeService(t)
+	own := service.Records(dns.Question{Name: service.instanceAddr, Qtype: dns.TypeTXT})
+	p := serv.startProbe([]string{service.instanceAddr}, own)
+
+	// Our own records don't conflict
+	serv.handleResponse(&dns.Msg{Answer: own})
+	select {
+	case <-p.conflict:
+		t.Fatalf("identical record flagged as conflict")
+	default:
+	}
+
+	other := makeService(t)
+	other.TXT = []string{"someone else"}
+	serv.handleResponse(&dns.Msg{
+		Answer: other.Records(dns.Question{Name: service.instanceAddr, Qtype: dns.TypeTXT}),
+	})
+	select {
+	case <-p.conflict:
+	default:
+		t.Fatalf("conflicting record was not flagged")
+	}
+}
+
+func TestServer_DeregisterSharedHost(t *testing.T) {
+	rec := &Recorder{}
+	serv, err := NewServer(&Config{Recorder: rec})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	// Two services on the same host, sharing its address records
+	ctx := context.Background()
+	first := makeServiceWithServiceName(t, "_first._tcp")
+	second := makeServiceWithServiceName(t, "_second._tcp")
+	for _, service := range []*MDNSService{first, second} {
+		if err := serv.Register(ctx, service); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	goodbye := func() *dns.Msg {
+		packets := rec.Packets()
+		if len(packets) != 1 {
+			t.Fatalf("recorded %d packets, want a goodbye", len(packets))
+		}
+		bye, err := ParseMessage(packets[0].Data)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return bye
+	}
+	addrs := func(m *dns.Msg) (n int) {
+		for _, rr := range m.Answer {
+			switch rr.(type) {
+			case *dns.A, *dns.AAAA:
+				n++
+			}
+		}
+		return n
+	}
+
+	rec.Reset()
+	if err := serv.Deregister(ctx, first); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	bye := goodbye()
+	if n := addrs(bye); n != 0 {
+		t.Fatalf("goodbye retracts %d addresses still published: %v", n, bye)
+	}
+	if len(bye.Answer) == 0 {
+		t.Fatalf("empty goodbye")
+	}
+
+	// The last service on the host retracts its addresses
+	rec.Reset()
+	if err := serv.Deregister(ctx, second); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if bye := goodbye(); addrs(bye) != 2 {
+		t.Fatalf("goodbye should retract the addresses: %v", bye)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// renameWindow is how long a browse holds back the removal of an instance in
+// case the device reappears under a new name.
+const renameWindow = 2 * time.Second
+
+// instanceIdentity returns what identifies the device behind an instance
+// across renames: its deviceID if it has one, and its host, port, and TXT
+// records otherwise. It is empty until the host is known.
+func instanceIdentity(e *ServiceEntry) string {
+	if id := deviceID(e); id != "" {
+		return id
+	}
+	if e.Host == "" {
+		return ""
+	}
+	txt := append([]string(nil), e.InfoFields...)
+	sort.Strings(txt)
+	return strings.ToLower(e.Host) + ":" + strconv.Itoa(e.Port) + "|" + strings.Join(txt, "\x00")
+}
+
+// deviceID returns the value of the instance's "id=" TXT field, prefixed
+// with "id=", or "" if it has none. Unlike the host, port, and TXT records,
+// which instances sharing a host may have in common, it tells two live
+// instances apart.
+func deviceID(e *ServiceEntry) string {
+	for _, field := range e.InfoFields {
+		if strings.HasPrefix(strings.ToLower(field), "id=") && len(field) > 3 {
+			return "id=" + field[3:]
+		}
+	}
+	return ""
+}
+
+// pendingRemoval is an instance that went away, held back for renameWindow.
+type pendingRemoval struct {
+	entry ServiceEntry
+	at    time.Time
+}
+
+// renames correlates the instances going away with those appearing, so that
+// a device changing its instance name, e.g. from "Printer" to "Printer (2)"
+// after a conflict, is reported as renamed rather than removed and added.
+type renames struct {
+	pending map[string]pendingRemoval // by identity
+}
+
+// hold delays reporting the removal of an instance, unless it has no
+// identity to match it by. It reports whether the removal is held.
+func (r *renames) hold(e ServiceEntry, now time.Time) bool {
+	id := instanceIdentity(&e)
+	if id == "" {
+		return false
+	}
+	if r.pending == nil {
+		r.pending = make(map[string]pendingRemoval)
+	}
+	r.pending[id] = pendingRemoval{entry: e, at: now}
+	return true
+}
+
+// match returns the held instance of the same device as an appearing
+// instance, if any: either the instance it renames, or the same instance
+// coming back.
+func (r *renames) match(e *ServiceEntry) (ServiceEntry, bool) {
+	if id := instanceIdentity(e); id != "" {
+		if p, ok := r.pending[id]; ok {
+			delete(r.pending, id)
+			return p.entry, true
+		}
+	}
+	for id, p := range r.pending {
+		if strings.EqualFold(p.entry.Name, e.Name) {
+			delete(r.pending, id)
+			return p.entry, true
+		}
+	}
+	return ServiceEntry{}, false
+}
+
+// expire returns the held instances whose window passed; they were removed.
+func (r *renames) expire(now time.Time) []ServiceEntry {
+	var gone []ServiceEntry
+	for id, p := range r.pending {
+		if now.Sub(p.at) >= renameWindow {
+			gone = append(gone, p.entry)
+			delete(r.pending, id)
+		}
+	}
+	return gone
+}
@@ -0,0 +1,112 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInstanceIdentity(t *testing.T) {
+	a := &ServiceEntry{Name: "Printer._ipp._tcp.local.", Host: "printer.local.", Port: 631, InfoFields: []string{"rp=a", "ty=x"}}
+	b := &ServiceEntry{Name: "Printer (2)._ipp._tcp.local.", Host: "PRINTER.local.", Port: 631, InfoFields: []string{"ty=x", "rp=a"}}
+	if instanceIdentity(a) != instanceIdentity(b) {
+		t.Fatalf("same device, different identities: %q %q", instanceIdentity(a), instanceIdentity(b))
+	}
+	b.InfoFields = []string{"rp=b", "ty=x"}
+	if instanceIdentity(a) == instanceIdentity(b) {
+		t.Fatalf("different queues of a host should differ")
+	}
+
+	// An id overrides everything else
+	a.InfoFields = append(a.InfoFields, "id=42")
+	b.InfoFields = []string{"id=42"}
+	b.Host = "other.local."
+	if instanceIdentity(a) != instanceIdentity(b) || instanceIdentity(a) != "id=42" {
+		t.Fatalf("bad: %q %q", instanceIdentity(a), instanceIdentity(b))
+	}
+	if id := instanceIdentity(&ServiceEntry{Name: "x._ipp._tcp.local."}); id != "" {
+		t.Fatalf("unresolved instance has identity %q", id)
+	}
+}
+
+func TestBrowse_Renames(t *testing.T) {
+	events := make(chan BrowseEvent, 8)
+	b := newBrowseState(context.Background(), systemClock{}, nil, events, nil, nil)
+	next := func(want BrowseEventType, name, prev string) {
+		t.Helper()
+		select {
+		case ev := <-events:
+			if ev.Type != want || ev.Entry.Name != name || (prev != "") != (ev.Previous != nil) ||
+				(ev.Previous != nil && ev.Previous.Name != prev) {
+				t.Fatalf("got %v %+v %+v, want %v %s", ev.Type, ev.Entry, ev.Previous, want, name)
+			}
+		default:
+			t.Fatalf("missing %v event for %s", want, name)
+		}
+	}
+	entry := func(name string) *ServiceEntry {
+		return &ServiceEntry{Name: name, Host: "printer.local.", Port: 631}
+	}
+	now := time.Now()
+
+	// Goodbye, then the new name
+	first := entry("Printer._ipp._tcp.local.")
+	b.deliver(first)
+	next(BrowseAdded, first.Name, "")
+	b.remove(first, now)
+	if len(events) != 0 {
+		t.Fatalf("removal was not held back: %+v", <-events)
+	}
+	second := entry("Printer (2)._ipp._tcp.local.")
+	b.deliver(second)
+	next(BrowseRenamed, second.Name, first.Name)
+	b.expire(now.Add(renameWindow))
+	if len(events) != 0 {
+		t.Fatalf("unexpected event: %+v", <-events)
+	}
+
+	// Instances sharing a host, port, and TXT records are distinct while
+	// both are live
+	other := entry("Scanner._ipp._tcp.local.")
+	b.deliver(other)
+	next(BrowseAdded, other.Name, "")
+	b.remove(other, now)
+	b.expire(now.Add(renameWindow))
+	next(BrowseRemoved, other.Name, "")
+
+	// The new name while the old one is still cached, told by its id
+	second.InfoFields = []string{"id=42"}
+	b.deliver(second)
+	next(BrowseUpdated, second.Name, "")
+	third := entry("Printer (3)._ipp._tcp.local.")
+	third.InfoFields = []string{"id=42"}
+	b.deliver(third)
+	next(BrowseRenamed, third.Name, second.Name)
+	b.deliver(second)
+	b.remove(second, now)
+	if len(events) != 0 {
+		t.Fatalf("superseded instance reported: %+v", <-events)
+	}
+
+	// A device going away for good
+	b.remove(third, now)
+	b.expire(now.Add(renameWindow - time.Millisecond))
+	if len(events) != 0 {
+		t.Fatalf("unexpected event: %+v", <-events)
+	}
+	b.expire(now.Add(renameWindow))
+	next(BrowseRemoved, third.Name, "")
+
+	// An instance coming back under its name is not reported again
+	b.deliver(third)
+	next(BrowseAdded, third.Name, "")
+	b.remove(third, now)
+	b.deliver(third)
+	b.expire(now.Add(renameWindow))
+	if len(events) != 0 {
+		t.Fatalf("unexpected event: %+v", <-events)
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// resolveInstanceTimeout is how long ResolveInstance and ResolveHost
+	// wait for an answer when ctx has no deadline.
+	resolveInstanceTimeout = 3 * time.Second
+
+	// resolveHostWait is how long ResolveHost waits for the addresses of the
+	// other IP version once the host answered with those of one, and
+	// ResolveAddr for other hosts once one answered.
+	resolveHostWait = 100 * time.Millisecond
+)
+
+// ErrNotResolved is returned by ResolveInstance, ResolveHost, ResolveAddr, and
+// the typed lookups such as LookupSRV when the name did not resolve in time.
+var ErrNotResolved = errors.New("mdns: name did not resolve")
+
+// ResolveInstance looks up a service instance whose name is already known,
+// such as one saved from an earlier browse, and returns its entry once the
+// SRV, TXT, and address records have all been resolved. Unlike a query, no
+// PTR question is asked for the service, so other instances do not answer.
+// Records already cached are used as they are, and only the missing ones are
+// asked for, with the questions retransmitted at increasing intervals. An
+// empty domain means "local". It gives up with ErrNotResolved after 3
+// seconds, unless ctx has a deadline of its own.
+func (c *Client) ResolveInstance(ctx context.Context, instance, service, domain string) (*ServiceEntry, error) {
+	ctx, done, err := c.beginLookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	name := InstanceName(instance, service, domain)
+
+	e := &ServiceEntry{Name: name}
+	c.cache.fill(e)
+	if RequireAll.satisfied(e) {
+		return e, nil
+	}
+	x, err := c.ask(ctx, "instance "+name, func() *dns.Msg { return resolveQuestion(e) })
+	if err != nil {
+		return nil, err
+	}
+	defer x.close()
+
+	for {
+		select {
+		case <-x.due():
+			x.retransmit(ctx)
+
+		case resp := <-x.responses():
+			if !resp.msg.Response || !describes(resp.msg, e) {
+				continue
+			}
+			host := e.Host
+			c.cache.fill(e)
+			e.SrcIP = resp.src.IP
+			e.ReceivedOn = c.ifaceNames.name(resp.ifIndex)
+			if RequireAll.satisfied(e) {
+				return e, nil
+			}
+			if e.Host != "" && !strings.EqualFold(e.Host, host) {
+				// The SRV record named the host, so ask for its addresses
+				x.send(ctx)
+			}
+
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, ErrNotResolved
+			}
+			return nil, ctx.Err()
+
+		case <-c.closedCh:
+			return nil, ErrClientClosed
+		}
+	}
+}
+
+// resolveQuestion returns a query for the records an entry is missing: its
+// SRV and TXT records, and the addresses of its host once that is known,
+// leaving out those the owner denied having.
+func resolveQuestion(e *ServiceEntry) *dns.Msg {
+	m := new(dns.Msg)
+	m.RecursionDesired = false
+	if e.Port == 0 {
+		m.Question = append(m.Question, dns.Question{Name: e.Name, Qtype: dns.TypeSRV, Qclass: dns.ClassINET})
+	}
+	if !e.hasTXT && !e.noTXT {
+		m.Question = append(m.Question, dns.Question{Name: e.Name, Qtype: dns.TypeTXT, Qclass: dns.ClassINET})
+	}
+	if e.Host != "" && e.AddrV4 == nil && e.AddrV6 == nil && !e.noAddrs {
+		m.Question = append(m.Question,
+			dns.Question{Name: e.Host, Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			dns.Question{Name: e.Host, Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	}
+	m.Id = dns.Id()
+	return m
+}
+
+// describes reports whether a response holds records of an entry's instance
+// or of its host.
+func describes(msg *dns.Msg, e *ServiceEntry) bool {
+	for _, rr := range append(msg.Answer, msg.Extra...) {
+		name := rr.Header().Name
+		if strings.EqualFold(name, e.Name) || (e.Host != "" && strings.EqualFold(name, e.Host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupHost resolves the addresses of a host on the local link, such as
+// "myhost.local", with a Client of its own, see Client.ResolveHost.
+func LookupHost(ctx context.Context, host string) ([]net.IPAddr, error) {
+	client, err := NewClient(true, true, log.Default(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.ResolveHost(ctx, host)
+}
+
+// ResolveHost resolves the IPv4 and IPv6 addresses of a host on the local
+// link, such as "myhost.local", by asking for its A and AAAA records. A name
+// of a single label is taken to be in the "local" domain. Link-local IPv6
+// addresses carry the zone of the interface they were received on, so that
+// they can be dialed. Cached addresses are returned at once. Otherwise, once
+// the host answered with the addresses of one IP version, those of the other
+// are waited for briefly, as hosts without them may send nothing, unless the
+// host denied having them with an NSEC record. It gives up with ErrNotResolved
+// after 3 seconds, unless ctx has a deadline of its own, or at once if the host
+// denied having any address.
+func (c *Client) ResolveHost(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ctx, done, err := c.beginLookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	host = hostName(host)
+
+	if addrs := c.cache.hostIPAddrs(host); len(addrs) > 0 {
+		return addrs, nil
+	} else if c.cache.hostResolved(host, addrs) {
+		return nil, ErrNotResolved
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(host, dns.TypeA)
+	m.Question = append(m.Question, dns.Question{Name: host, Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	m.RecursionDesired = false
+	x, err := c.ask(ctx, "host "+host, func() *dns.Msg { return m })
+	if err != nil {
+		return nil, err
+	}
+	defer x.close()
+
+	var settle <-chan time.Time // set once some addresses are known
+	for {
+		select {
+		case <-x.due():
+			x.retransmit(ctx)
+
+		case resp := <-x.responses():
+			if !resp.msg.Response || !describes(resp.msg, &ServiceEntry{Host: host}) {
+				continue
+			}
+			addrs := c.cache.hostIPAddrs(host)
+			if c.cache.hostResolved(host, addrs) {
+				if len(addrs) == 0 {
+					return nil, ErrNotResolved
+				}
+				return addrs, nil
+			}
+			if len(addrs) > 0 && settle == nil {
+				settle = time.After(resolveHostWait)
+			}
+
+		case <-settle:
+			return c.cache.hostIPAddrs(host), nil
+
+		case <-ctx.Done():
+			if addrs := c.cache.hostIPAddrs(host); len(addrs) > 0 {
+				return addrs, nil
+			}
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, ErrNotResolved
+			}
+			return nil, ctx.Err()
+
+		case <-c.closedCh:
+			return nil, ErrClientClosed
+		}
+	}
+}
+
+// LookupAddr resolves an address on the local link back to the names of the
+// hosts using it, with a Client of its own, see Client.ResolveAddr.
+func LookupAddr(ctx context.Context, ip net.IP) ([]string, error) {
+	client, err := NewClient(true, true, log.Default(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.ResolveAddr(ctx, ip)
+}
+
+// ResolveAddr resolves an address seen on the local link back to the names of
+// the hosts using it, such as "myhost.local.", by asking for the PTR records
+// of its reverse name in "in-addr.arpa." or "ip6.arpa.", as described in RFC
+// 6762, section 4. Cached names are returned at once. Otherwise, once a host
+// answered, others are waited for briefly. It gives up with ErrNotResolved
+// after 3 seconds, unless ctx has a deadline of its own.
+func (c *Client) ResolveAddr(ctx context.Context, ip net.IP) ([]string, error) {
+	name, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return nil, fmt.Errorf("mdns: cannot resolve address %v: %v", ip, err)
+	}
+	ctx, done, err := c.beginLookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if names := c.cache.instances(name); len(names) > 0 {
+		return names, nil
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypePTR)
+	m.RecursionDesired = false
+	x, err := c.ask(ctx, fmt.Sprintf("address %v", ip), func() *dns.Msg { return m })
+	if err != nil {
+		return nil, err
+	}
+	defer x.close()
+
+	var settle <-chan time.Time // set once some names are known
+	for {
+		select {
+		case <-x.due():
+			x.retransmit(ctx)
+
+		case resp := <-x.responses():
+			if resp.msg.Response && settle == nil && len(c.cache.instances(name)) > 0 {
+				settle = time.After(resolveHostWait)
+			}
+
+		case <-settle:
+			return c.cache.instances(name), nil
+
+		case <-ctx.Done():
+			if names := c.cache.instances(name); len(names) > 0 {
+				return names, nil
+			}
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, ErrNotResolved
+			}
+			return nil, ctx.Err()
+
+		case <-c.closedCh:
+			return nil, ErrClientClosed
+		}
+	}
+}
+
+// ipVersions reports whether addrs holds IPv4 and IPv6 addresses.
+func ipVersions(addrs []net.IPAddr) (v4, v6 bool) {
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			v4 = true
+		} else {
+			v6 = true
+		}
+	}
+	return v4, v6
+}
@@ -0,0 +1,137 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestClient_ResolveInstance(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e, err := c.ResolveInstance(ctx, "hostname", "_http._tcp", "")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if e.Name != "hostname._http._tcp.local." || e.Host != "testhost." || e.Port != 80 {
+		t.Fatalf("bad: %+v", e)
+	}
+	if e.Info != "Local web server" || !e.AddrV4.Equal(net.IPv4(192, 168, 0, 42)) {
+		t.Fatalf("bad: %+v", e)
+	}
+
+	// No PTR question was asked, so the service has no cached instances
+	if names := c.cache.instances("_http._tcp.local."); len(names) != 0 {
+		t.Fatalf("bad: %v", names)
+	}
+
+	// An instance that nobody publishes does not resolve
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := c.ResolveInstance(ctx, "absent", "_http._tcp", ""); !errors.Is(err, ErrNotResolved) {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestResolveQuestion(t *testing.T) {
+	e := &ServiceEntry{Name: "x._http._tcp.local."}
+	if q := resolveQuestion(e).Question; len(q) != 2 || q[0].Qtype != dns.TypeSRV || q[1].Qtype != dns.TypeTXT {
+		t.Fatalf("bad: %v", q)
+	}
+
+	// Once the SRV record is known, its host's addresses are asked for
+	e.Host, e.Port, e.hasTXT = "x.local.", 80, true
+	q := resolveQuestion(e).Question
+	if len(q) != 2 || q[0].Name != "x.local." || q[0].Qtype != dns.TypeA || q[1].Qtype != dns.TypeAAAA {
+		t.Fatalf("bad: %v", q)
+	}
+}
+
+func TestClient_ResolveHost(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	ips := []net.IP{net.IPv4(10, 0, 0, 1), net.ParseIP("2001:db8::1")}
+	service, err := NewMDNSService("printer", "_ipp._tcp", "", "printer.local.", 631, ips, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: service, Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	// A single label is in the local domain
+	addrs, err := c.ResolveHost(context.Background(), "printer")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(addrs) != 2 || !addrs[0].IP.Equal(ips[0]) || !addrs[1].IP.Equal(ips[1]) {
+		t.Fatalf("bad: %v", addrs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := c.ResolveHost(ctx, "absent.local."); !errors.Is(err, ErrNotResolved) {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestClient_ResolveAddr(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	names, err := c.ResolveAddr(context.Background(), net.ParseIP("2620:0:1000:1900:b0c2:d0b2:c411:18bc"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(names) != 1 || names[0] != "testhost." {
+		t.Fatalf("bad: %v", names)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := c.ResolveAddr(ctx, net.IPv4(192, 168, 0, 43)); !errors.Is(err, ErrNotResolved) {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c.ResolveAddr(ctx, nil); err == nil {
+		t.Fatalf("invalid address resolved")
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	// maxRetransmitInterval is the default cap on the interval between the
+	// questions of a query, as described in RFC 6762, section 5.2.
+	maxRetransmitInterval = time.Hour
+
+	// retransmitJitter is the largest share of an interval, in tenths, by
+	// which a retransmission is randomly delayed, so that hosts that started
+	// together do not keep asking at the same time.
+	retransmitJitter = 1
+)
+
+// retransmission is the retransmission schedule of one query: the interval
+// starts at RetransmitInterval and doubles after every retransmission, up to
+// MaxRetransmitInterval, until MaxRetransmissions have been sent.
+type retransmission struct {
+	interval time.Duration
+	max      time.Duration
+	left     int // retransmissions left, negative for no limit
+	next     time.Time
+}
+
+// newRetransmission returns the schedule of a query whose questions were
+// first sent at now.
+func newRetransmission(par QueryParam, now time.Time) *retransmission {
+	r := &retransmission{
+		interval: par.RetransmitInterval,
+		max:      par.MaxRetransmitInterval,
+		left:     par.MaxRetransmissions,
+	}
+	if r.interval <= 0 {
+		r.interval = queryRetransmitInterval
+	}
+	if r.max <= 0 {
+		r.max = maxRetransmitInterval
+	}
+	if r.interval > r.max {
+		r.interval = r.max
+	}
+	switch {
+	case r.left == 0:
+		r.left = -1
+	case r.left < 0:
+		r.left = 0
+	}
+	r.schedule(now)
+	return r
+}
+
+// schedule sets the time of the next retransmission.
+func (r *retransmission) schedule(now time.Time) {
+	r.next = now.Add(r.interval + jitter(r.interval))
+}
+
+// advance records a retransmission sent at now and schedules the next one.
+func (r *retransmission) advance(now time.Time) {
+	if r.left > 0 {
+		r.left--
+	}
+	if r.interval *= 2; r.interval > r.max {
+		r.interval = r.max
+	}
+	r.schedule(now)
+}
+
+// done reports whether every retransmission was sent.
+func (r *retransmission) done() bool {
+	return r.left == 0
+}
+
+// jitter returns a random delay of up to a tenth of interval.
+func jitter(interval time.Duration) time.Duration {
+	n := int64(interval) * retransmitJitter / 10
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(n))
+}
+
+// retransmissions are the schedules of the queries of a lookup, by lower-cased
+// service name.
+type retransmissions map[string]*retransmission
+
+// newRetransmissions returns the schedules of queries first sent at now.
+func newRetransmissions(params []QueryParam, now time.Time) retransmissions {
+	rs := make(retransmissions)
+	for _, par := range params {
+		rs[strings.ToLower(ServiceName(par.Service, par.Domain))] = newRetransmission(par, now)
+	}
+	return rs
+}
+
+// due returns the queries whose questions are to be retransmitted at now,
+// advancing their schedules. Queries due shortly after now are included, so
+// that their questions share packets.
+func (rs retransmissions) due(params []QueryParam, now time.Time) []QueryParam {
+	var out []QueryParam
+	for _, par := range params {
+		r := rs[strings.ToLower(ServiceName(par.Service, par.Domain))]
+		if r == nil || r.done() || r.next.Sub(now) > r.interval/10 {
+			continue
+		}
+		r.advance(now)
+		out = append(out, par)
+	}
+	return out
+}
+
+// reset sets the timer to fire at the earliest retransmission, or stops it if
+// every retransmission was sent.
+func (rs retransmissions) reset(clk clock, t timer) {
+	var next time.Time
+	for _, r := range rs {
+		if !r.done() && (next.IsZero() || r.next.Before(next)) {
+			next = r.next
+		}
+	}
+	resetAt(clk, t, next)
+}
+
+// rateLimit holds back the questions of a lookup due within MinQueryInterval
+// of the last ones sent, see QueryParam.MinQueryInterval.
+type rateLimit struct {
+	min            time.Duration
+	last           time.Time
+	timer          <-chan time.Time // fires once held questions may be sent
+	held           []QueryParam
+	retransmission bool // every held question is a retransmission
+}
+
+// newRateLimit returns the rate limit of a lookup whose questions were first
+// sent at now.
+func newRateLimit(params []QueryParam, now time.Time) *rateLimit {
+	r := &rateLimit{last: now}
+	for _, par := range params {
+		r.min = max(r.min, par.MinQueryInterval)
+	}
+	return r
+}
+
+// hold reports whether questions due now must wait, keeping them to be sent
+// with those already held once ready fires.
+func (r *rateLimit) hold(clk clock, due []QueryParam, retransmission bool) bool {
+	if r.timer == nil {
+		wait := r.last.Add(r.min).Sub(clk.Now())
+		if wait <= 0 {
+			return false
+		}
+		r.timer, r.held, r.retransmission = after(clk, wait), nil, true
+	}
+	for _, par := range due {
+		if !containsQuestion(r.held, par) {
+			r.held = append(r.held, par)
+		}
+	}
+	r.retransmission = r.retransmission && retransmission
+	return true
+}
+
+// ready returns a channel receiving once the held questions may be sent, nil
+// if none are held.
+func (r *rateLimit) ready() <-chan time.Time {
+	return r.timer
+}
+
+// release returns the held questions, and whether all are retransmissions.
+func (r *rateLimit) release() ([]QueryParam, bool) {
+	held := r.held
+	r.timer, r.held = nil, nil
+	return held, r.retransmission
+}
+
+// sent records that questions were sent at now.
+func (r *rateLimit) sent(now time.Time) {
+	r.last = now
+}
+
+// containsQuestion reports whether params has a query for the service of par.
+func containsQuestion(params []QueryParam, par QueryParam) bool {
+	for _, p := range params {
+		if strings.EqualFold(ServiceName(p.Service, p.Domain), ServiceName(par.Service, par.Domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyRunning returns the queries of params still running, those in
+// services by lower-cased service name.
+func onlyRunning(params []QueryParam, services map[string]*QueryParam) []QueryParam {
+	var out []QueryParam
+	for _, par := range params {
+		if services[strings.ToLower(ServiceName(par.Service, par.Domain))] != nil {
+			out = append(out, par)
+		}
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestRetransmission_Schedule(t *testing.T) {
+	now := time.Now()
+	r := newRetransmission(QueryParam{MaxRetransmitInterval: 5 * time.Second, MaxRetransmissions: 4}, now)
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		if r.done() {
+			t.Fatalf("done after %d retransmissions", i)
+		}
+		if d := r.next.Sub(now); d < want || d > want+want/10 {
+			t.Fatalf("retransmission %d after %v, want %v", i, d, want)
+		}
+		now = r.next
+		r.advance(now)
+	}
+	if !r.done() {
+		t.Fatalf("not done after 4 retransmissions")
+	}
+
+	// Unlimited by default, and disabled by a negative limit
+	if r := newRetransmission(QueryParam{}, now); r.done() || r.max != time.Hour {
+		t.Fatalf("bad: %+v", r)
+	}
+	if r := newRetransmission(QueryParam{MaxRetransmissions: -1}, now); !r.done() {
+		t.Fatalf("bad: %+v", r)
+	}
+}
+
+func TestRetransmissions_Due(t *testing.T) {
+	now := time.Now()
+	params := []QueryParam{
+		{Service: "_a._tcp", Domain: "local"},
+		{Service: "_b._tcp", Domain: "local", RetransmitInterval: 3 * time.Second},
+		{Service: "_c._tcp", Domain: "local", MaxRetransmissions: -1},
+	}
+	rs := newRetransmissions(params, now)
+
+	if due := rs.due(params, now.Add(500*time.Millisecond)); len(due) != 0 {
+		t.Fatalf("bad: %v", due)
+	}
+	due := rs.due(params, now.Add(1100*time.Millisecond))
+	if len(due) != 1 || due[0].Service != "_a._tcp" {
+		t.Fatalf("bad: %v", due)
+	}
+	// The next questions of _a are due with those of _b, and share packets
+	due = rs.due(params, now.Add(3300*time.Millisecond))
+	if len(due) != 2 {
+		t.Fatalf("bad: %v", due)
+	}
+	if due := rs.due(params, now.Add(time.Hour)); len(due) != 2 {
+		t.Fatalf("bad: %v", due)
+	}
+}
+
+func TestClient_MaxRetransmissions(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	var mu sync.Mutex
+	retransmissions := 0
+	c.SetSendHook(func(tr Transmission) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		if tr.Kind == TransmitRetransmit {
+			retransmissions++
+		}
+		return 0
+	}, 0)
+
+	params := &[]QueryParam{{
+		Service:            "_foobar._tcp",
+		Timeout:            time.Second,
+		RetransmitInterval: 50 * time.Millisecond,
+		MaxRetransmissions: 2,
+	}}
+	if err := Query(params, make(chan *ServiceEntry, 1), c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if retransmissions != 2 {
+		t.Fatalf("got %d retransmissions, want 2", retransmissions)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package mdns
+
+import "syscall"
+
+// canReusePort reports whether reusePort shares ports on this system.
+const canReusePort = false
+
+// reusePort does nothing on systems without SO_REUSEPORT; binding a port
+// already in use fails there.
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
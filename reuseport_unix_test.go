@@ -0,0 +1,74 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"syscall"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+)
+
+// holdMDNSPort binds the mDNS port with SO_REUSEPORT only, as mDNSResponder
+// does, skipping the test if it cannot.
+func holdMDNSPort(t *testing.T) net.PacketConn {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}}
+	daemon, err := lc.ListenPacket(context.Background(), "udp4", ":5353")
+	if err != nil {
+		t.Skipf("cannot bind the mDNS port: %v", err)
+	}
+	return daemon
+}
+
+func TestTransport_SharesMulticastPort(t *testing.T) {
+	daemon := holdMDNSPort(t)
+	defer daemon.Close()
+
+	conn, err := DefaultTransport.ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+	if port := conn.LocalAddr().(*net.UDPAddr).Port; port != mdnsPort {
+		t.Fatalf("bound port %d, want %d", port, mdnsPort)
+	}
+
+	// Already a member of the group
+	if err := ipv4.NewPacketConn(conn).JoinGroup(nil, ipv4Addr); !errors.Is(err, syscall.EADDRINUSE) {
+		t.Fatalf("not a member of the group: %v", err)
+	}
+}
+
+func TestClient_SharesMDNSPort(t *testing.T) {
+	daemon := holdMDNSPort(t)
+	defer daemon.Close()
+
+	c, err := NewClient(true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+	if c.ipv4UnicastConn == nil {
+		t.Fatalf("no IPv4 unicast connection")
+	}
+	if port := c.ipv4UnicastConn.LocalAddr().(*net.UDPAddr).Port; port != mdnsPort {
+		t.Fatalf("bound port %d, want %d", port, mdnsPort)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"sync"
+	"time"
+)
+
+// eventRing keeps the most recent browse events, so that components that
+// start late can catch up, see SetBrowseHistory.
+type eventRing struct {
+	mu     sync.Mutex
+	events []BrowseEvent // circular, next is the oldest once full
+	next   int
+	full   bool
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// SetBrowseHistory makes the Client keep the last size events of all its
+// browses, for at most maxAge if it is not zero, so that a component that
+// subscribes late, or reconnects, can replay them with RecentBrowseEvents
+// instead of waiting for the next announcements. A size of zero, the default,
+// keeps no history. Events already kept are discarded.
+func (c *Client) SetBrowseHistory(size int, maxAge time.Duration) {
+	c.browseHistory.mu.Lock()
+	defer c.browseHistory.mu.Unlock()
+	c.browseHistory.events = make([]BrowseEvent, size)
+	c.browseHistory.next = 0
+	c.browseHistory.full = false
+	c.browseHistory.maxAge = maxAge
+}
+
+// RecentBrowseEvents returns the last n browse events kept, oldest first,
+// that are no older than window. Zero for either means no limit.
+// The entries they carry are shared with the events sent to the browses, and
+// must not be modified.
+func (c *Client) RecentBrowseEvents(n int, window time.Duration) []BrowseEvent {
+	return c.browseHistory.recent(n, window)
+}
+
+// size returns the number of events kept.
+func (r *eventRing) size() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.full {
+		return len(r.events)
+	}
+	return r.next
+}
+
+// add records an event, replacing the oldest one once full.
+func (r *eventRing) add(ev BrowseEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.events) == 0 {
+		return
+	}
+	r.events[r.next] = ev
+	if r.next++; r.next == len(r.events) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// recent returns the last n events no older than window or the maximum age,
+// oldest first.
+func (r *eventRing) recent(n int, window time.Duration) []BrowseEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var now time.Time
+	if r.now != nil {
+		now = r.now()
+	} else {
+		now = time.Now()
+	}
+	if window == 0 || (r.maxAge > 0 && r.maxAge < window) {
+		window = r.maxAge
+	}
+
+	var out []BrowseEvent
+	count := r.next
+	if r.full {
+		count = len(r.events)
+	}
+	for i := 0; i < count; i++ {
+		ev := r.events[(r.next-count+i+len(r.events))%len(r.events)]
+		if window > 0 && now.Sub(ev.Time) > window {
+			continue
+		}
+		out = append(out, ev)
+	}
+	if n > 0 && len(out) > n {
+		out = out[len(out)-n:]
+	}
+	return out
+}
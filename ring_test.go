@@ -0,0 +1,56 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClient_BrowseHistory(t *testing.T) {
+	c := &Client{}
+	now := time.Now()
+	c.browseHistory.now = func() time.Time { return now }
+	add := func(i int, age time.Duration) {
+		c.browseHistory.add(BrowseEvent{
+			Type:  BrowseAdded,
+			Entry: &ServiceEntry{Name: fmt.Sprintf("%d._http._tcp.local.", i)},
+			Time:  now.Add(-age),
+		})
+	}
+	names := func(events []BrowseEvent) string {
+		var s string
+		for _, ev := range events {
+			s += ev.Entry.Name[:1]
+		}
+		return s
+	}
+
+	add(0, 0)
+	if got := c.RecentBrowseEvents(0, 0); len(got) != 0 {
+		t.Fatalf("history kept by default: %v", got)
+	}
+
+	c.SetBrowseHistory(3, 0)
+	for i := 0; i < 5; i++ {
+		add(i, time.Duration(5-i)*time.Minute)
+	}
+	if got := names(c.RecentBrowseEvents(0, 0)); got != "234" {
+		t.Fatalf("got %q, want the last 3 events", got)
+	}
+	if got := names(c.RecentBrowseEvents(2, 0)); got != "34" {
+		t.Fatalf("got %q, want the last 2 events", got)
+	}
+	if got := names(c.RecentBrowseEvents(0, 2*time.Minute)); got != "34" {
+		t.Fatalf("got %q, want the events of the last 2 minutes", got)
+	}
+
+	c.SetBrowseHistory(10, 90*time.Second)
+	add(5, 2*time.Minute)
+	add(6, time.Minute)
+	if got := names(c.RecentBrowseEvents(0, 0)); got != "6" {
+		t.Fatalf("got %q, want the events younger than the maximum age", got)
+	}
+}
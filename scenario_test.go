@@ -0,0 +1,468 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+// scenarioWait bounds how long, in real time, a step waits for the packets it
+// caused to be handled.
+const scenarioWait = 2 * time.Second
+
+// scenarioTick is how long, in real time, a step that has not completed is
+// given to handle its packets before the test clock moves to the next timer.
+const scenarioTick = 10 * time.Millisecond
+
+// testClock is a clock that only moves when told to. Its timers fire as it
+// moves past them.
+type testClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*testTimer]struct{}
+}
+
+// newTestClock returns a clock stopped at an arbitrary time.
+func newTestClock() *testClock {
+	return &testClock{
+		now:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		timers: make(map[*testTimer]struct{}),
+	}
+}
+
+// Now returns the time of the clock.
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer returns a timer firing once the clock moved d ahead.
+func (c *testClock) NewTimer(d time.Duration) timer {
+	t := &testTimer{clock: c, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Set moves the clock to t, firing the timers due by then in order.
+func (c *testClock) Set(t time.Time) {
+	for {
+		next, ok := c.next()
+		if !ok || next.After(t) {
+			break
+		}
+		c.fire(next)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.now) {
+		c.now = t
+	}
+}
+
+// next returns the time of the earliest pending timer.
+func (c *testClock) next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var next time.Time
+	for t := range c.timers {
+		if next.IsZero() || t.when.Before(next) {
+			next = t.when
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// fire moves the clock to now and fires the timers due by then.
+func (c *testClock) fire(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.After(c.now) {
+		c.now = now
+	}
+	for t := range c.timers {
+		if !t.when.After(c.now) {
+			delete(c.timers, t)
+			t.ch <- c.now
+		}
+	}
+}
+
+// testTimer is a timer of a testClock.
+type testTimer struct {
+	clock *testClock
+	ch    chan time.Time
+	when  time.Time
+}
+
+// Chan returns the channel of the timer.
+func (t *testTimer) Chan() <-chan time.Time {
+	return t.ch
+}
+
+// Reset makes the timer fire once the clock moved d ahead, or at once if d
+// is not positive, and reports whether it was pending.
+func (t *testTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, pending := c.timers[t]
+	t.drain()
+	t.when = c.now.Add(d)
+	if d <= 0 {
+		delete(c.timers, t)
+		t.ch <- c.now
+	} else {
+		c.timers[t] = struct{}{}
+	}
+	return pending
+}
+
+// Stop cancels the timer and reports whether it was pending.
+func (t *testTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, pending := c.timers[t]
+	delete(c.timers, t)
+	t.drain()
+	return pending
+}
+
+// drain empties the channel of a timer that fired but was not received from,
+// as a time.Timer does when stopped or reset.
+func (t *testTimer) drain() {
+	select {
+	case <-t.ch:
+	default:
+	}
+}
+
+// scenario is a script of protocol events run against Servers and a Client
+// connected by a memnet network, for tests that read like the RFC:
+//
+//	newScenario(t).
+//		Device("A").
+//		At(0).Announces("A").
+//		At(30 * time.Second).SaysGoodbye("A").
+//		At(35 * time.Second).Queries().Finds().
+//		Run()
+//
+// Steps run in the order of their times, then in the order they were written.
+// The Client and the Servers run on the test clock, which jumps to the time of
+// each step, so record lifetimes are checked without waiting for them. While a
+// step has not completed, the clock jumps to the next pending timer, such as
+// that of a probe, an announcement, or a query timeout, so a step may end
+// later than it starts: a device registering at 0 sends its second
+// announcement at 1.75s.
+type scenario struct {
+	t       *testing.T
+	network *memnet.Network
+	clock   *testClock
+	client  *Client
+	devices map[string]*scenarioDevice
+	steps   []scenarioStep
+}
+
+// scenarioDevice is a host publishing one instance of _http._tcp.
+type scenarioDevice struct {
+	host    *memnet.Host
+	service *MDNSService
+	server  *Server
+}
+
+// scenarioStep is an action or check taken at a point of the scenario.
+type scenarioStep struct {
+	at   time.Duration
+	desc string
+	run  func(s *scenario) error
+}
+
+// newScenario returns an empty scenario.
+func newScenario(t *testing.T) *scenario {
+	return &scenario{
+		t:       t,
+		network: memnet.New(memnet.Config{}),
+		clock:   newTestClock(),
+		devices: make(map[string]*scenarioDevice),
+	}
+}
+
+// Device adds a device publishing the instance name of the _http._tcp
+// service. Devices are silent until they announce.
+func (s *scenario) Device(name string) *scenario {
+	ip := net.IPv4(10, 0, 0, byte(len(s.devices)+1))
+	service, err := NewMDNSService(name, "_http._tcp", "", strings.ToLower(name)+".local.", 80, []net.IP{ip}, nil)
+	if err != nil {
+		s.t.Fatalf("err: %v", err)
+	}
+	s.devices[name] = &scenarioDevice{host: s.network.Host(ip), service: service}
+	return s
+}
+
+// At starts a step taken t after the start of the scenario.
+func (s *scenario) At(t time.Duration) *scenarioAt {
+	return &scenarioAt{s: s, at: t}
+}
+
+// scenarioAt adds a step at a given time.
+type scenarioAt struct {
+	s  *scenario
+	at time.Duration
+}
+
+// add appends the step and returns the scenario for the next one.
+func (a *scenarioAt) add(desc string, run func(s *scenario) error) *scenario {
+	a.s.steps = append(a.s.steps, scenarioStep{at: a.at, desc: desc, run: run})
+	return a.s
+}
+
+// Announces makes a device register its instance, probing and announcing it
+// and answering queries for it from then on. A device already registered
+// announces its instance again through Update. The step completes once the
+// Client handled every announcement.
+func (a *scenarioAt) Announces(name string) *scenario {
+	return a.add(name+" announces", func(s *scenario) error {
+		d := s.device(name)
+		return s.await(name, announceCount, func() error {
+			if !d.server.isRegistered(d.service) {
+				return d.server.Register(context.Background(), d.service)
+			}
+			updated := cloneService(d.service)
+			if err := d.server.Update(context.Background(), d.service, updated); err != nil {
+				return err
+			}
+			d.service = updated
+			return nil
+		}, RecordAdded, RecordRefreshed, RecordReplaced)
+	})
+}
+
+// SaysGoodbye makes a device send a goodbye for its instance and stop
+// answering for it. The step completes once the Client dropped the instance.
+func (a *scenarioAt) SaysGoodbye(name string) *scenario {
+	return a.add(name+" says goodbye", func(s *scenario) error {
+		d := s.device(name)
+		return s.await(name, 1, func() error {
+			return d.server.Deregister(context.Background(), d.service)
+		}, RecordRemoved)
+	})
+}
+
+// Caches checks that the Client has the instance of a device in its cache.
+func (a *scenarioAt) Caches(name string) *scenario {
+	return a.add("client caches "+name, func(s *scenario) error {
+		if !s.cached(name) {
+			return fmt.Errorf("%s is not cached", name)
+		}
+		return nil
+	})
+}
+
+// Forgets checks that the Client no longer has the instance of a device in
+// its cache.
+func (a *scenarioAt) Forgets(name string) *scenario {
+	return a.add("client forgets "+name, func(s *scenario) error {
+		if s.cached(name) {
+			return fmt.Errorf("%s is still cached", name)
+		}
+		return nil
+	})
+}
+
+// Queries starts a step in which the Client queries for _http._tcp.
+func (a *scenarioAt) Queries() *scenarioQuery {
+	return &scenarioQuery{at: a}
+}
+
+// scenarioQuery is a query step awaiting its expectations.
+type scenarioQuery struct {
+	at *scenarioAt
+}
+
+// Finds checks that the query finds the instances of exactly the given
+// devices.
+func (q *scenarioQuery) Finds(names ...string) *scenario {
+	want := append([]string(nil), names...)
+	sort.Strings(want)
+	return q.at.add(fmt.Sprintf("client queries and finds %v", want), func(s *scenario) error {
+		entries := make(chan *ServiceEntry, 16)
+		params := []QueryParam{{Service: "_http._tcp", Timeout: 100 * time.Millisecond}}
+		if err := s.client.query(context.Background(), &params, entries); err != nil {
+			return err
+		}
+		close(entries)
+		got := []string{}
+		for e := range entries {
+			instance, _, _, err := SplitInstanceName(e.Name)
+			if err != nil {
+				return err
+			}
+			got = append(got, instance)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			return fmt.Errorf("found %v", got)
+		}
+		return nil
+	})
+}
+
+// Run starts the devices and the Client, then runs the steps, failing the
+// test at the first step that fails.
+func (s *scenario) Run() {
+	s.t.Helper()
+	for _, d := range s.devices {
+		server, err := NewServer(&Config{Transport: d.host, clock: s.clock})
+		if err != nil {
+			s.t.Fatalf("err: %v", err)
+		}
+		defer server.Shutdown()
+		d.server = server
+	}
+	client, err := newClient(s.network.Host(net.IPv4(10, 0, 0, 254)), true, false, log.Default(), nil, s.clock)
+	if err != nil {
+		s.t.Fatalf("err: %v", err)
+	}
+	defer client.Close()
+	s.client = client
+
+	steps := append([]scenarioStep(nil), s.steps...)
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].at < steps[j].at })
+	start := s.clock.Now()
+	for _, step := range steps {
+		s.clock.Set(start.Add(step.at))
+		if err := s.step(step); err != nil {
+			s.t.Fatalf("at %v, %s: %v", step.at, step.desc, err)
+		}
+	}
+}
+
+// step runs a step, moving the clock to the next pending timer whenever the
+// step has not completed after a tick.
+func (s *scenario) step(step scenarioStep) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- step.run(s)
+	}()
+	timeout := time.After(2 * scenarioWait)
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(scenarioTick):
+			if next, ok := s.clock.next(); ok {
+				s.clock.Set(next)
+			}
+		case <-timeout:
+			return fmt.Errorf("step did not complete")
+		}
+	}
+}
+
+// device returns the named device, failing the test if there is none.
+func (s *scenario) device(name string) *scenarioDevice {
+	d, ok := s.devices[name]
+	if !ok {
+		s.t.Fatalf("no device %q in scenario", name)
+	}
+	return d
+}
+
+// cached reports whether the Client has the SRV record of a device's instance.
+func (s *scenario) cached(name string) bool {
+	return len(s.client.cache.get(s.device(name).service.instanceAddr, dns.TypeSRV)) > 0
+}
+
+// await runs send, then waits until the Client's cache reported n of the
+// given changes to the SRV record of a device's instance.
+func (s *scenario) await(name string, n int, send func() error, types ...RecordEventType) error {
+	events := make(chan RecordEvent, 64)
+	stop := s.client.cache.watch(events)
+	defer stop()
+	if err := send(); err != nil {
+		return err
+	}
+
+	instance := s.device(name).service.instanceAddr
+	timeout := time.After(scenarioWait)
+	for {
+		select {
+		case ev := <-events:
+			rr := ev.New
+			if rr == nil {
+				rr = ev.Old
+			}
+			if rr.Header().Rrtype != dns.TypeSRV || !strings.EqualFold(rr.Header().Name, instance) {
+				continue
+			}
+			for _, t := range types {
+				if ev.Type != t {
+					continue
+				}
+				if n--; n == 0 {
+					return nil
+				}
+			}
+		case <-timeout:
+			return fmt.Errorf("client did not handle the packets sent")
+		}
+	}
+}
+
+func TestScenario_Goodbye(t *testing.T) {
+	// RFC 6762, section 10.1: a goodbye removes the records at once
+	newScenario(t).
+		Device("A").
+		At(0).Announces("A").
+		At(0).Queries().Finds("A").
+		At(30 * time.Second).SaysGoodbye("A").
+		At(31 * time.Second).Forgets("A").
+		At(35 * time.Second).Queries().Finds().
+		Run()
+}
+
+func TestScenario_Expiry(t *testing.T) {
+	// RFC 6762, section 10: records are removed when their TTL expires, and
+	// every announcement starts a new lifetime. A's last announcement goes out
+	// at 1.75s and B's at 3.5s, after A's; B's update announces again at 101s.
+	newScenario(t).
+		Device("A").
+		Device("B").
+		At(0).Announces("A").
+		At(0).Announces("B").
+		At(100 * time.Second).Announces("B").
+		At(121 * time.Second).Caches("A").
+		At(122 * time.Second).Forgets("A").
+		At(122 * time.Second).Caches("B").
+		At(220 * time.Second).Caches("B").
+		At(222 * time.Second).Forgets("B").
+		Run()
+}
+
+func TestScenario_QueryAfterExpiry(t *testing.T) {
+	// A device that is still on the link answers again after its records
+	// expired from the cache
+	newScenario(t).
+		Device("A").
+		Device("B").
+		At(0).Announces("A").
+		At(0).Announces("B").
+		At(10 * time.Second).SaysGoodbye("B").
+		At(200 * time.Second).Forgets("A").
+		At(200 * time.Second).Queries().Finds("A").
+		At(200 * time.Second).Caches("A").
+		Run()
+}
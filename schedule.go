@@ -0,0 +1,115 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"time"
+)
+
+// defaultMaxDeferral is how long a SendHook may delay a transmission by
+// default.
+const defaultMaxDeferral = 250 * time.Millisecond
+
+// TransmissionKind tells what a scheduled transmission is for.
+type TransmissionKind int
+
+const (
+	TransmitQuery      TransmissionKind = iota // First question of a query
+	TransmitRetransmit                         // Retransmitted question of a query
+	TransmitProbe                              // Probe for a name being registered
+	TransmitAnnounce                           // Announcement of a registered service
+	TransmitGoodbye                            // Goodbye of a deregistered service
+)
+
+// String returns the name of the kind.
+func (k TransmissionKind) String() string {
+	switch k {
+	case TransmitQuery:
+		return "query"
+	case TransmitRetransmit:
+		return "retransmit"
+	case TransmitProbe:
+		return "probe"
+	case TransmitAnnounce:
+		return "announce"
+	case TransmitGoodbye:
+		return "goodbye"
+	default:
+		return "unknown"
+	}
+}
+
+// Transmission describes a packet about to be sent on a schedule. Responses
+// to queries are sent immediately and are not scheduled.
+type Transmission struct {
+	Kind TransmissionKind
+	Name string    // The service or instance name the packet is about
+	Time time.Time // When the packet was scheduled to be sent
+}
+
+// SendHook lets an embedder delay scheduled transmissions, for example to
+// align them with the wake windows of a battery-powered radio. It returns how
+// long to delay the transmission, which is capped at the maximum deferral so
+// that the protocol's timing stays within the specification. A negative
+// delay vetoes the transmission; only retransmissions can be vetoed, as they
+// are optional, and other transmissions are delayed by the maximum instead.
+type SendHook func(Transmission) time.Duration
+
+// decide applies the hook to a transmission, returning how long to wait before
+// sending it and whether to send it at all.
+func (h SendHook) decide(t Transmission, maxDeferral time.Duration) (time.Duration, bool) {
+	if h == nil {
+		return 0, true
+	}
+	if maxDeferral <= 0 {
+		maxDeferral = defaultMaxDeferral
+	}
+	d := h(t)
+	if d < 0 {
+		if t.Kind == TransmitRetransmit {
+			return 0, false
+		}
+		d = maxDeferral
+	}
+	if d > maxDeferral {
+		d = maxDeferral
+	}
+	return d, true
+}
+
+// SetSendHook sets a hook deciding when the Client's queries are sent, and
+// the maximum time it may delay them, default 250 milliseconds. A nil hook
+// sends queries as soon as they are scheduled.
+func (c *Client) SetSendHook(hook SendHook, maxDeferral time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sendHook = hook
+	c.maxDeferral = maxDeferral
+}
+
+// schedule asks the send hook when a transmission of the Client may go out.
+func (c *Client) schedule(kind TransmissionKind, name string) (time.Duration, bool) {
+	c.mu.Lock()
+	hook, maxDeferral := c.sendHook, c.maxDeferral
+	c.mu.Unlock()
+	return hook.decide(Transmission{Kind: kind, Name: name, Time: c.clk().Now()}, maxDeferral)
+}
+
+// schedule waits until the send hook lets a transmission of the Server go
+// out, reporting whether it should be sent at all.
+func (s *Server) schedule(ctx context.Context, kind TransmissionKind, name string) (bool, error) {
+	d, ok := s.config.SendHook.decide(Transmission{Kind: kind, Name: name, Time: s.clk().Now()}, s.config.MaxSendDeferral)
+	if !ok || d == 0 {
+		return ok, nil
+	}
+	select {
+	case <-after(s.clk(), d):
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-s.shutdownCh:
+		return false, errServerShutdown
+	}
+}
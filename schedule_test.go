@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendHook_Decide(t *testing.T) {
+	delay := func(d time.Duration) SendHook {
+		return func(Transmission) time.Duration { return d }
+	}
+	for _, test := range []struct {
+		hook  SendHook
+		kind  TransmissionKind
+		delay time.Duration
+		send  bool
+	}{
+		{nil, TransmitQuery, 0, true},
+		{delay(10 * time.Millisecond), TransmitProbe, 10 * time.Millisecond, true},
+		{delay(time.Hour), TransmitAnnounce, defaultMaxDeferral, true},
+		{delay(-1), TransmitRetransmit, 0, false},
+		{delay(-1), TransmitProbe, defaultMaxDeferral, true},
+	} {
+		d, send := test.hook.decide(Transmission{Kind: test.kind}, 0)
+		if d != test.delay || send != test.send {
+			t.Fatalf("%s: got %v %v, want %v %v", test.kind, d, send, test.delay, test.send)
+		}
+	}
+}
+
+func TestServer_SendHook(t *testing.T) {
+	var mu sync.Mutex
+	var kinds []TransmissionKind
+	serv, err := NewServer(&Config{
+		SendHook: func(tr Transmission) time.Duration {
+			mu.Lock()
+			defer mu.Unlock()
+			kinds = append(kinds, tr.Kind)
+			return 10 * time.Millisecond
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	service := makeServiceWithServiceName(t, "_hook._tcp")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := serv.Register(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := serv.Deregister(ctx, service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []TransmissionKind{TransmitProbe, TransmitProbe, TransmitProbe, TransmitAnnounce, TransmitAnnounce, TransmitGoodbye}
+	if len(kinds) != len(want) {
+		t.Fatalf("got %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("got %v, want %v", kinds, want)
+		}
+	}
+}
+
+func TestClient_SendHook(t *testing.T) {
+	c := &Client{cache: newCache(), closedCh: make(chan struct{}), use_ipv4: true}
+	var mu sync.Mutex
+	var kinds []TransmissionKind
+	c.SetSendHook(func(tr Transmission) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		kinds = append(kinds, tr.Kind)
+		if tr.Name != "_foobar._tcp.local." {
+			t.Errorf("bad name: %s", tr.Name)
+		}
+		return -1
+	}, 0)
+
+	params := &[]QueryParam{{Service: "_foobar._tcp", RetransmitInterval: 300 * time.Millisecond}}
+	if err := Query(params, make(chan *ServiceEntry, 1), c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The query was deferred, and then the retransmissions were vetoed
+	mu.Lock()
+	defer mu.Unlock()
+	if len(kinds) < 2 || kinds[0] != TransmitQuery || kinds[1] != TransmitRetransmit {
+		t.Fatalf("bad: %v", kinds)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"log"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -33,7 +35,8 @@ var (
 
 // Config is used to configure the mDNS server
 type Config struct {
-	// Zone must be provided to support responding to queries
+	// Zone must be provided to support responding to queries, unless
+	// services are added with Server.Register instead.
 	Zone Zone
 
 	// Iface if provided binds the multicast listener to the given
@@ -47,6 +50,46 @@ type Config struct {
 
 	// Logger can optionally be set to use an alternative logger instead of the default.
 	Logger *log.Logger
+
+	// Codec packs and unpacks messages. If not provided, DefaultCodec is used.
+	Codec Codec
+
+	// Recorder, if provided, captures every packet the server sends.
+	Recorder *Recorder
+
+	// Timings, if provided, records how long each stage of the server's
+	// packets takes.
+	Timings *Timings
+
+	// Transport opens the server's connections. If not provided,
+	// DefaultTransport is used.
+	Transport Transport
+
+	// Middleware wraps the handling of every question the server answers,
+	// the first entry being outermost.
+	Middleware []Middleware
+
+	// SendHook, if provided, may delay the server's probes, announcements,
+	// and goodbyes by up to MaxSendDeferral, default 250 milliseconds.
+	SendHook        SendHook
+	MaxSendDeferral time.Duration
+
+	// DryRun makes the server log every packet it would send, including
+	// probes, announcements, goodbyes, and answers, instead of sending it.
+	// Packets are still captured by the Recorder. Probes cannot detect
+	// conflicts without being sent, so registrations always succeed.
+	DryRun bool
+
+	// CacheAnswers makes the server keep the wire format of its responses,
+	// rather than packing the same records for every query. It requires the
+	// answers of Zone and Middleware to depend only on the questions asked,
+	// and Server.InvalidateAnswers to be called whenever the records of Zone
+	// change. Announcements are always cached.
+	CacheAnswers bool
+
+	// clock runs the timers of probes, announcements, and send hooks. The
+	// system clock is used if it is nil, as it is outside of tests.
+	clock clock
 }
 
 // mDNS server is used to listen for mDNS queries and respond if we
@@ -54,18 +97,44 @@ type Config struct {
 type Server struct {
 	config *Config
 
-	ipv4List *net.UDPConn
-	ipv6List *net.UDPConn
+	// errLog samples the errors logged while handling queries.
+	errLog *logSampler
+
+	ipv4List net.PacketConn
+	ipv6List net.PacketConn
 
 	shutdown   int32
 	shutdownCh chan struct{}
+
+	mu          sync.Mutex
+	services    []*MDNSService      // services added with Register
+	probes      map[*probe]struct{} // in-progress probes
+	diagnostics *diagnosticsZone    // beacon, if enabled
+
+	// answers caches packed messages, see Config.CacheAnswers.
+	answers answerCache
+
+	// echoes recognizes the server's own packets when received back.
+	echoes echoFilter
+
+	// clock runs the timers of probes and announcements, see clk.
+	clock clock
+
+	// Statistics advertised by the diagnostics beacon
+	started   time.Time
+	queries   uint64
+	responses uint64
 }
 
 // NewServer is used to create a new mDNS server from a config
 func NewServer(config *Config) (*Server, error) {
 	// Create the listeners
-	ipv4List, _ := net.ListenMulticastUDP("udp4", config.Iface, ipv4Addr)
-	ipv6List, _ := net.ListenMulticastUDP("udp6", config.Iface, ipv6Addr)
+	transport := config.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+	ipv4List, _ := transport.ListenMulticastUDP("udp4", config.Iface, ipv4Addr)
+	ipv6List, _ := transport.ListenMulticastUDP("udp6", config.Iface, ipv6Addr)
 
 	// Check if we have any listener
 	if ipv4List == nil && ipv6List == nil {
@@ -78,9 +147,12 @@ func NewServer(config *Config) (*Server, error) {
 
 	s := &Server{
 		config:     config,
+		errLog:     newLogSampler(config.Logger, logSampleEvery, logSampleInterval),
 		ipv4List:   ipv4List,
 		ipv6List:   ipv6List,
 		shutdownCh: make(chan struct{}),
+		started:    time.Now(),
+		clock:      config.clock,
 	}
 
 	if ipv4List != nil {
@@ -102,6 +174,7 @@ func (s *Server) Shutdown() error {
 	}
 
 	close(s.shutdownCh)
+	s.errLog.close()
 
 	if s.ipv4List != nil {
 		s.ipv4List.Close()
@@ -113,35 +186,51 @@ func (s *Server) Shutdown() error {
 }
 
 // recv is a long running routine to receive packets from an interface
-func (s *Server) recv(c *net.UDPConn) {
+func (s *Server) recv(c net.PacketConn) {
 	if c == nil {
 		return
 	}
 	buf := make([]byte, 65536)
+	serverLabels.wait()
 	for atomic.LoadInt32(&s.shutdown) == 0 {
 		n, from, err := c.ReadFrom(buf)
 
 		if err != nil {
 			continue
 		}
+		if s.isEcho(buf[:n], from) {
+			continue
+		}
 		if err := s.parsePacket(buf[:n], from); err != nil {
-			s.config.Logger.Printf("[ERR] mdns: Failed to handle query: %v", err)
+			s.errLog.Printf("[ERR] mdns: Failed to handle query: %v", err)
 		}
+		serverLabels.wait()
 	}
 }
 
 // parsePacket is used to parse an incoming packet
 func (s *Server) parsePacket(packet []byte, from net.Addr) error {
-	var msg dns.Msg
-	if err := msg.Unpack(packet); err != nil {
-		s.config.Logger.Printf("[ERR] mdns: Failed to unpack packet: %v", err)
+	tm := s.config.Timings
+	serverLabels.enter(StageUnpack)
+	start := tm.start()
+	msg, err := s.codec().Unpack(packet)
+	tm.done(StageUnpack, start)
+	if err != nil {
+		s.errLog.Printf("[ERR] mdns: Failed to unpack packet: %v", err)
 		return err
 	}
-	return s.handleQuery(&msg, from)
+	serverLabels.enter(StageProcess)
+	defer tm.done(StageProcess, tm.start())
+	if msg.Response {
+		s.handleResponse(msg)
+		return nil
+	}
+	return s.handleQuery(msg, from)
 }
 
 // handleQuery is used to handle an incoming query
 func (s *Server) handleQuery(query *dns.Msg, from net.Addr) error {
+	atomic.AddUint64(&s.queries, 1)
 	if query.Opcode != dns.OpcodeQuery {
 		// "In both multicast query and multicast response messages, the OPCODE MUST
 		// be zero on transmission (only standard queries are currently supported
@@ -166,11 +255,27 @@ func (s *Server) handleQuery(query *dns.Msg, from net.Addr) error {
 		return fmt.Errorf("[ERR] mdns: support for DNS requests with high truncated bit not implemented: %v", *query)
 	}
 
+	legacy := isLegacyQuerier(from)
+	key := ""
+	if s.cachesAnswers() {
+		key = queryKey(query)
+		if legacy {
+			key = legacyQueryKey(query)
+		}
+		if r, ok := s.answers.get(key); ok {
+			if r.multicast == nil && r.unicast == nil {
+				s.logEmptyResponse(query)
+			}
+			return s.sendResponses(r.multicast, r.unicast.withID(query.Id), from)
+		}
+	}
+
 	var unicastAnswer, multicastAnswer []dns.RR
 
 	// Handle each question
+	handler := s.handler()
 	for _, q := range query.Question {
-		mrecs, urecs := s.handleQuestion(q)
+		mrecs, urecs := s.handleQuestion(handler, q, from)
 		multicastAnswer = append(multicastAnswer, mrecs...)
 		unicastAnswer = append(unicastAnswer, urecs...)
 	}
@@ -228,20 +333,101 @@ func (s *Server) handleQuery(query *dns.Msg, from net.Addr) error {
 		}
 	}
 
-	if s.config.LogEmptyResponses && len(multicastAnswer) == 0 && len(unicastAnswer) == 0 {
-		questions := make([]string, len(query.Question))
-		for i, q := range query.Question {
-			questions[i] = q.Name
+	if len(multicastAnswer) == 0 && len(unicastAnswer) == 0 {
+		s.logEmptyResponse(query)
+	}
+
+	if legacy {
+		uresp, err := s.pack(legacyResponse(query, append(multicastAnswer, unicastAnswer...)))
+		if err != nil {
+			return fmt.Errorf("mdns: error sending unicast response: %v", err)
+		}
+		if key != "" {
+			s.answers.put(key, cachedResponse{unicast: uresp})
 		}
-		s.config.Logger.Printf("no responses for query with questions: %s", strings.Join(questions, ", "))
+		return s.sendResponses(nil, uresp, from)
 	}
 
-	if mresp := resp(false); mresp != nil {
+	mresp, err := s.pack(resp(false))
+	if err != nil {
+		return fmt.Errorf("mdns: error sending multicast response: %v", err)
+	}
+	uresp, err := s.pack(resp(true))
+	if err != nil {
+		return fmt.Errorf("mdns: error sending unicast response: %v", err)
+	}
+	if key != "" {
+		s.answers.put(key, cachedResponse{multicast: mresp, unicast: uresp})
+	}
+	return s.sendResponses(mresp, uresp, from)
+}
+
+// legacyTTL is the highest TTL given in responses to legacy queriers, see
+// RFC 6762, section 6.7.
+const legacyTTL = 10
+
+// isLegacyQuerier reports whether a query was sent from a port other than
+// 5353, by a querier that is not fully compliant with RFC 6762 and expects a
+// conventional unicast DNS response, see section 6.7.
+func isLegacyQuerier(from net.Addr) bool {
+	addr, ok := from.(*net.UDPAddr)
+	return ok && addr.Port != mdnsPort
+}
+
+// legacyResponse returns the unicast response to a legacy query, nil if there
+// are no answers. As RFC 6762, section 6.7 requires, it repeats the ID and
+// the questions of the query, and gives TTLs of at most 10 seconds, so that
+// the querier's cache does not keep records it will not hear updates of.
+// Records are not marked for cache flushing, see section 10.2.
+func legacyResponse(query *dns.Msg, answers []dns.RR) *dns.Msg {
+	if len(answers) == 0 {
+		return nil
+	}
+	resp := &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Id:            query.Id,
+			Response:      true,
+			Opcode:        dns.OpcodeQuery,
+			Authoritative: true,
+		},
+		Compress: true,
+		Question: query.Question,
+	}
+	for _, rr := range answers {
+		rr = dns.Copy(rr)
+		hdr := rr.Header()
+		hdr.Class &^= cacheFlushBit
+		hdr.Ttl = min(hdr.Ttl, legacyTTL)
+		resp.Answer = append(resp.Answer, rr)
+	}
+	return resp
+}
+
+// logEmptyResponse logs a query there is no response to, if the server is
+// configured to.
+func (s *Server) logEmptyResponse(query *dns.Msg) {
+	if !s.config.LogEmptyResponses {
+		return
+	}
+	questions := make([]string, len(query.Question))
+	for i, q := range query.Question {
+		questions[i] = q.Name
+	}
+	s.config.Logger.Printf("no responses for query with questions: %s", strings.Join(questions, ", "))
+}
+
+// sendResponses sends the multicast and unicast responses to a query, either
+// of which may be nil.
+func (s *Server) sendResponses(mresp, uresp *packedMsg, from net.Addr) error {
+	serverLabels.enter(StageSend)
+	defer serverLabels.enter(StageProcess)
+	defer s.config.Timings.done(StageSend, s.config.Timings.start())
+	if mresp != nil {
 		if err := s.sendResponse(mresp, from, false); err != nil {
 			return fmt.Errorf("mdns: error sending multicast response: %v", err)
 		}
 	}
-	if uresp := resp(true); uresp != nil {
+	if uresp != nil {
 		if err := s.sendResponse(uresp, from, true); err != nil {
 			return fmt.Errorf("mdns: error sending unicast response: %v", err)
 		}
@@ -253,8 +439,8 @@ func (s *Server) handleQuery(query *dns.Msg, from net.Addr) error {
 //
 // The response to a question may be transmitted over multicast, unicast, or
 // both.  The return values are DNS records for each transmission type.
-func (s *Server) handleQuestion(q dns.Question) (multicastRecs, unicastRecs []dns.RR) {
-	records := s.config.Zone.Records(q)
+func (s *Server) handleQuestion(handler Handler, q dns.Question, from net.Addr) (multicastRecs, unicastRecs []dns.RR) {
+	records := handler.ServeQuestion(q, from)
 
 	if len(records) == 0 {
 		return nil, nil
@@ -278,21 +464,27 @@ func (s *Server) handleQuestion(q dns.Question) (multicastRecs, unicastRecs []dn
 }
 
 // sendResponse is used to send a response packet
-func (s *Server) sendResponse(resp *dns.Msg, from net.Addr, unicast bool) error {
+func (s *Server) sendResponse(resp *packedMsg, from net.Addr, unicast bool) error {
 	// TODO(reddaly): Respect the unicast argument, and allow sending responses
 	// over multicast.
-	buf, err := resp.Pack()
-	if err != nil {
-		return err
-	}
+	buf := resp.buf
+	atomic.AddUint64(&s.responses, 1)
 
 	// Determine the socket to send from
 	addr := from.(*net.UDPAddr)
+	if s.config.Recorder != nil {
+		s.config.Recorder.record(addr.String(), buf)
+	}
+	if s.config.DryRun {
+		s.logDryRun("answer", addr.String(), resp.msg)
+		return nil
+	}
+	s.echoes.add(buf, time.Now())
 	if addr.IP.To4() != nil {
-		_, err = s.ipv4List.WriteToUDP(buf, addr)
+		_, err := s.ipv4List.WriteTo(buf, addr)
 		return err
 	} else {
-		_, err = s.ipv6List.WriteToUDP(buf, addr)
+		_, err := s.ipv6List.WriteTo(buf, addr)
 		return err
 	}
 }
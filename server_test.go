@@ -5,6 +5,7 @@ package mdns
 
 import (
 	"fmt"
+	"log"
 	"testing"
 	"time"
 )
@@ -56,14 +57,20 @@ func TestServer_Lookup(t *testing.T) {
 		}
 	}()
 
-	params := &QueryParam{
+	client, err := NewClient(true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer client.Close()
+
+	params := &[]QueryParam{{
 		Service:     "_foobar._tcp",
 		Domain:      "local",
 		Timeout:     50 * time.Millisecond,
 		Entries:     entries,
 		DisableIPv6: true,
-	}
-	err = Query(params)
+	}}
+	err = Query(params, entries, client)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -73,3 +80,31 @@ func TestServer_Lookup(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 }
+
+func TestClient_LocalZone(t *testing.T) {
+	client, err := NewClient(true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer client.Close()
+	client.AddLocalZone(makeServiceWithServiceName(t, "_local._tcp"))
+
+	entries := make(chan *ServiceEntry, 1)
+	params := &[]QueryParam{{
+		Service: "_local._tcp",
+		Domain:  "local",
+		Timeout: 50 * time.Millisecond,
+	}}
+	if err := Query(params, entries, client); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	select {
+	case e := <-entries:
+		if e.Name != "hostname._local._tcp.local." || e.Port != 80 {
+			t.Fatalf("bad: %+v", e)
+		}
+	default:
+		t.Fatalf("no entry for the local zone")
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// clientConns is a snapshot of a Client's connections, which change as IP
+// stacks are enabled and disabled.
+type clientConns struct {
+	v4, v6               bool
+	unicast4, multicast4 net.PacketConn
+	unicast6, multicast6 net.PacketConn
+}
+
+// conns returns the Client's current connections.
+func (c *Client) conns() clientConns {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return clientConns{
+		v4:         c.use_ipv4,
+		v6:         c.use_ipv6,
+		unicast4:   c.ipv4UnicastConn,
+		multicast4: c.ipv4MulticastConn,
+		unicast6:   c.ipv6UnicastConn,
+		multicast6: c.ipv6MulticastConn,
+	}
+}
+
+// EnableIPv4 opens the IPv4 sockets of a live Client and starts querying over
+// IPv4. It does nothing if IPv4 is already in use.
+func (c *Client) EnableIPv4() error {
+	return c.enableStack(false)
+}
+
+// EnableIPv6 opens the IPv6 sockets of a live Client and starts querying over
+// IPv6, for example once an address was configured by DHCPv6 or router
+// advertisements. It does nothing if IPv6 is already in use.
+func (c *Client) EnableIPv6() error {
+	return c.enableStack(true)
+}
+
+// DisableIPv4 stops querying over IPv4 and closes the IPv4 sockets. The last
+// stack in use cannot be disabled.
+func (c *Client) DisableIPv4() error {
+	return c.disableStack(false)
+}
+
+// DisableIPv6 stops querying over IPv6 and closes the IPv6 sockets. The last
+// stack in use cannot be disabled.
+func (c *Client) DisableIPv6() error {
+	return c.disableStack(true)
+}
+
+// enableStack opens the sockets of a stack and starts using them.
+func (c *Client) enableStack(v6 bool) error {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return ErrClientClosed
+	}
+	if cc := c.conns(); (v6 && cc.v6) || (!v6 && cc.v4) {
+		return nil
+	}
+
+	transport := c.transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+	network, laddr, group := "udp4", ipv4UnicastAddr, ipv4Addr
+	if v6 {
+		network, laddr, group = "udp6", ipv6UnicastAddr, ipv6Addr
+	}
+	uconn, err := transport.ListenUDP(network, laddr)
+	if err != nil {
+		return fmt.Errorf("mdns: failed to bind to %s port: %v", network, err)
+	}
+	mconn, err := transport.ListenMulticastUDP(network, nil, group)
+	if err != nil {
+		uconn.Close()
+		return fmt.Errorf("mdns: failed to bind to %s multicast port: %v", network, err)
+	}
+
+	c.mu.Lock()
+	iface := c.iface
+	c.mu.Unlock()
+	if err := setStackInterface(v6, uconn, mconn, iface); err != nil {
+		uconn.Close()
+		mconn.Close()
+		return err
+	}
+
+	c.mu.Lock()
+	if atomic.LoadInt32(&c.closed) == 1 || (v6 && c.use_ipv6) || (!v6 && c.use_ipv4) {
+		// Closed or enabled concurrently
+		c.mu.Unlock()
+		uconn.Close()
+		mconn.Close()
+		return nil
+	}
+	if v6 {
+		c.ipv6UnicastConn, c.ipv6MulticastConn, c.use_ipv6 = uconn, mconn, true
+	} else {
+		c.ipv4UnicastConn, c.ipv4MulticastConn, c.use_ipv4 = uconn, mconn, true
+	}
+	ifaces := c.ifaces
+	c.mu.Unlock()
+	for _, ifi := range ifaces {
+		c.joinGroups(ifi)
+	}
+
+	go c.recv(uconn, true)
+	go c.recv(mconn, false)
+	return nil
+}
+
+// disableStack stops using the sockets of a stack and closes them.
+func (c *Client) disableStack(v6 bool) error {
+	c.mu.Lock()
+	var uconn, mconn net.PacketConn
+	switch {
+	case v6 && !c.use_ipv6, !v6 && !c.use_ipv4:
+		c.mu.Unlock()
+		return nil
+	case !c.use_ipv4 || !c.use_ipv6:
+		c.mu.Unlock()
+		return fmt.Errorf("mdns: at least one of IPv4 and IPv6 must stay enabled")
+	case v6:
+		uconn, mconn = c.ipv6UnicastConn, c.ipv6MulticastConn
+		c.ipv6UnicastConn, c.ipv6MulticastConn, c.use_ipv6 = nil, nil, false
+	default:
+		uconn, mconn = c.ipv4UnicastConn, c.ipv4MulticastConn
+		c.ipv4UnicastConn, c.ipv4MulticastConn, c.use_ipv4 = nil, nil, false
+	}
+	c.mu.Unlock()
+
+	if uconn != nil {
+		uconn.Close()
+	}
+	if mconn != nil {
+		mconn.Close()
+	}
+	return nil
+}
+
+// setStackInterface sets the multicast interface of a stack's sockets, and
+// loops queries back so that responders on this host see them.
+func setStackInterface(v6 bool, uconn, mconn net.PacketConn, iface *net.Interface) error {
+	if !isSocket(uconn) {
+		return nil
+	}
+	if v6 {
+		p := ipv6.NewPacketConn(uconn)
+		if err := p.SetMulticastInterface(iface); err != nil {
+			return err
+		}
+		if err := p.SetMulticastLoopback(true); err != nil {
+			return err
+		}
+		return ipv6.NewPacketConn(mconn).SetMulticastInterface(iface)
+	}
+	p := ipv4.NewPacketConn(uconn)
+	if err := p.SetMulticastInterface(iface); err != nil {
+		return err
+	}
+	if err := p.SetMulticastLoopback(true); err != nil {
+		return err
+	}
+	return ipv4.NewPacketConn(mconn).SetMulticastInterface(iface)
+}
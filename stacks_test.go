@@ -0,0 +1,61 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestClient_ToggleStacks(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	host := network.Host(net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1"))
+	c, err := NewClientTransport(host, true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.DisableIPv4(); err == nil {
+		t.Fatalf("disabling the only stack should fail")
+	}
+
+	if err := c.EnableIPv6(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	caps := c.Capabilities()
+	if !caps.IPv4 || !caps.IPv6 || !caps.IPv6Unicast || !caps.IPv6Multicast {
+		t.Fatalf("bad: %+v", caps)
+	}
+
+	if err := c.DisableIPv4(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	caps = c.Capabilities()
+	if caps.IPv4 || caps.IPv4Unicast || caps.IPv4Multicast || !caps.IPv6 {
+		t.Fatalf("bad: %+v", caps)
+	}
+	if err := c.sendQuestions(context.Background(), []QueryParam{*DefaultParams("_foobar._tcp")}, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.DisableIPv4(); err != nil {
+		t.Fatalf("disabling a disabled stack should do nothing: %v", err)
+	}
+
+	if err := c.EnableIPv4(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if caps = c.Capabilities(); !caps.IPv4 || !caps.IPv6 {
+		t.Fatalf("bad: %+v", caps)
+	}
+
+	c.Close()
+	if err := c.EnableIPv4(); err != ErrClientClosed {
+		t.Fatalf("got %v, want ErrClientClosed", err)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// The key prefixes the state of a Client is saved under.
+const (
+	storeCachePrefix  = "cache/"
+	storeBrowsePrefix = "browse/"
+)
+
+// Store is a key-value store the state of a Client is saved to, so that it
+// survives restarts, see SaveState. MemoryStore keeps it in memory; the
+// boltstore package keeps it in a file.
+type Store interface {
+	// Put sets the value of a key.
+	Put(key string, value []byte) error
+
+	// Get returns the value of a key, and false if it is not set.
+	Get(key string) ([]byte, bool, error)
+
+	// Delete removes a key. Deleting a key that is not set is not an error.
+	Delete(key string) error
+
+	// Iterate calls fn with every key starting with prefix and its value,
+	// in the order of the keys, stopping at the first error fn returns,
+	// which it returns. The store must not be changed from fn.
+	Iterate(prefix string, fn func(key string, value []byte) error) error
+
+	// Replace removes every key starting with one of prefixes and sets
+	// values in their place, as one change: should it fail, the keys keep
+	// the values they had.
+	Replace(prefixes []string, values map[string][]byte) error
+}
+
+// MemoryStore is a Store keeping its values in memory, for applications
+// that do not need their state to survive restarts, and for tests.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	values map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{values: make(map[string][]byte)}
+}
+
+// Put sets the value of a key.
+func (s *MemoryStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+// Get returns the value of a key, and false if it is not set.
+func (s *MemoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.values[key]
+	return append([]byte(nil), value...), ok, nil
+}
+
+// Delete removes a key.
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+// Iterate calls fn with every key starting with prefix, in order.
+func (s *MemoryStore) Iterate(prefix string, fn func(key string, value []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []string
+	for key := range s.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(key, s.values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replace removes every key starting with one of prefixes and sets values in
+// their place.
+func (s *MemoryStore) Replace(prefixes []string, values map[string][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.values {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				delete(s.values, key)
+				break
+			}
+		}
+	}
+	for key, value := range values {
+		s.values[key] = append([]byte(nil), value...)
+	}
+	return nil
+}
+
+// storedRecord is the saved form of a cached record.
+type storedRecord struct {
+	RR      string    `json:"rr"` // presentation format
+	Expires time.Time `json:"expires"`
+	Zone    string    `json:"zone,omitempty"`
+	Source  string    `json:"source,omitempty"`
+}
+
+// storedEvent is the saved form of a browse event.
+type storedEvent struct {
+	Type     BrowseEventType `json:"type"`
+	Entry    *ServiceEntry   `json:"entry"`
+	Previous *ServiceEntry   `json:"previous,omitempty"`
+	Time     time.Time       `json:"time"`
+}
+
+// SaveState saves the records the Client has cached and the browse events it
+// kept, see SetBrowseHistory, to store, replacing any state saved before.
+// Restored with LoadState after a restart, they let queries and browses
+// report known devices at once instead of waiting for them to answer again.
+func (c *Client) SaveState(store Store) error {
+	values := make(map[string][]byte)
+	for i, cr := range c.cache.snapshot() {
+		value, err := json.Marshal(storedRecord{
+			RR:      cr.rr.String(),
+			Expires: cr.expires,
+			Zone:    cr.zone,
+			Source:  ipString(cr.src),
+		})
+		if err != nil {
+			return err
+		}
+		values[fmt.Sprintf("%s%08d", storeCachePrefix, i)] = value
+	}
+	for i, ev := range c.browseHistory.recent(0, 0) {
+		value, err := json.Marshal(storedEvent{Type: ev.Type, Entry: ev.Entry, Previous: ev.Previous, Time: ev.Time})
+		if err != nil {
+			return err
+		}
+		values[fmt.Sprintf("%s%08d", storeBrowsePrefix, i)] = value
+	}
+	if err := store.Replace([]string{storeCachePrefix, storeBrowsePrefix}, values); err != nil {
+		return fmt.Errorf("mdns: failed to save state: %w", err)
+	}
+	return nil
+}
+
+// LoadState adds the state saved by SaveState to the Client. Records that
+// expired since are skipped, and the others keep their original expiry.
+// Browse events are only restored if SetBrowseHistory was called first, and
+// within its limits. Restored records are passive, see ShedPassiveFirst.
+func (c *Client) LoadState(store Store) error {
+	now := c.cache.now()
+	err := store.Iterate(storeCachePrefix, func(key string, value []byte) error {
+		var sr storedRecord
+		if err := json.Unmarshal(value, &sr); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		left := sr.Expires.Sub(now)
+		if left < time.Second {
+			return nil
+		}
+		rr, err := dns.NewRR(sr.RR)
+		if err != nil || rr == nil {
+			return fmt.Errorf("%s: invalid record %q", key, sr.RR)
+		}
+		rr.Header().Ttl = uint32(left / time.Second)
+		var src net.IP
+		if sr.Source != "" {
+			src = net.ParseIP(sr.Source)
+		}
+		c.cache.addFrom(rr, sr.Zone, src, true)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("mdns: failed to load state: %w", err)
+	}
+	err = store.Iterate(storeBrowsePrefix, func(key string, value []byte) error {
+		var se storedEvent
+		if err := json.Unmarshal(value, &se); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		c.browseHistory.add(BrowseEvent{Type: se.Type, Entry: se.Entry, Previous: se.Previous, Time: se.Time})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("mdns: failed to load state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	for _, key := range []string{"b/2", "a/1", "b/1", "c/1"} {
+		if err := s.Put(key, []byte(key)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if v, ok, err := s.Get("a/1"); err != nil || !ok || string(v) != "a/1" {
+		t.Fatalf("bad: %q %v %v", v, ok, err)
+	}
+	if err := s.Delete("a/1"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, ok, _ := s.Get("a/1"); ok {
+		t.Fatalf("deleted key still set")
+	}
+
+	var keys []string
+	err := s.Iterate("b/", func(key string, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil || strings.Join(keys, ",") != "b/1,b/2" {
+		t.Fatalf("bad: %v %v", keys, err)
+	}
+
+	// Iteration stops at the first error
+	errStop := errors.New("stop")
+	calls := 0
+	err = s.Iterate("", func(string, []byte) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Fatalf("bad: %v %d", err, calls)
+	}
+
+	// Replacing drops the keys of the prefixes only
+	if err := s.Replace([]string{"b/", "d/"}, map[string][]byte{"b/3": []byte("b/3")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keys = nil
+	err = s.Iterate("", func(key string, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil || strings.Join(keys, ",") != "b/3,c/1" {
+		t.Fatalf("bad: %v %v", keys, err)
+	}
+}
+
+func TestClient_SaveState(t *testing.T) {
+	clock := newTestClock()
+	c := &Client{cache: newCache()}
+	c.cache.now = clock.Now
+	c.SetBrowseHistory(4, 0)
+
+	service := makeService(t)
+	c.cache.insertFrom(makeResponse(t, service), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Zone: "eth0"}, false)
+	short := &dns.A{Hdr: dns.RR_Header{Name: "brief.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10}, A: net.IPv4(10, 0, 0, 9)}
+	c.cache.add(short, "")
+	ttls := RecordTTLs{PTR: 120 * time.Second, SRV: 120 * time.Second}
+	c.browseHistory.add(BrowseEvent{Type: BrowseAdded, Entry: &ServiceEntry{Name: service.instanceAddr, Port: 80, TTLs: ttls}, Time: clock.Now()})
+
+	store := NewMemoryStore()
+	store.Put("cache/stale", []byte("left over"))
+	if err := c.SaveState(store); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A minute later, a new Client picks up where the first one left off
+	clock.Set(clock.Now().Add(time.Minute))
+	restored := &Client{cache: newCache()}
+	restored.cache.now = clock.Now
+	restored.SetBrowseHistory(4, 0)
+	if err := restored.LoadState(store); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	e := restored.cache.entry(service.instanceAddr)
+	if e == nil || e.Port != 80 || e.Host != "testhost." || !e.AddrV4.Equal(net.IPv4(192, 168, 0, 42)) {
+		t.Fatalf("bad: %+v", e)
+	}
+	if e.TTL != 60 {
+		t.Fatalf("restored record should keep its expiry, TTL %d", e.TTL)
+	}
+	if got := restored.cache.get("brief.local.", dns.TypeA); len(got) != 0 {
+		t.Fatalf("expired record restored: %v", got)
+	}
+	srvs := restored.cache.lookup(service.instanceAddr, dns.TypeSRV)
+	if len(srvs) != 1 || srvs[0].zone != "eth0" || !srvs[0].src.Equal(net.IPv4(10, 0, 0, 1)) || srvs[0].passive == nil {
+		t.Fatalf("bad: %+v", srvs)
+	}
+	events := restored.RecentBrowseEvents(0, 0)
+	if len(events) != 1 || events[0].Type != BrowseAdded || events[0].Entry.Name != service.instanceAddr || events[0].Entry.TTLs != ttls {
+		t.Fatalf("bad: %+v", events)
+	}
+}
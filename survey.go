@@ -0,0 +1,363 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"bytes"
+	"maps"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultSurveyInterval is how often a survey is sent by default.
+	defaultSurveyInterval = time.Minute
+
+	// defaultSessionIdle is how long a responder may stay silent before its
+	// session is closed by default.
+	defaultSessionIdle = time.Hour
+
+	// defaultChurnWindow is the window record churn is measured over by
+	// default.
+	defaultChurnWindow = 10 * time.Minute
+
+	// defaultMaxSessions bounds the number of responders tracked by default.
+	defaultMaxSessions = 4096
+)
+
+// SurveyConfig sets how Client.Survey tracks responders. Zero values select
+// the defaults.
+type SurveyConfig struct {
+	// Interval is how often a Survey is sent, default every minute.
+	Interval time.Duration
+
+	// Idle is how long a responder may stay silent before its session is
+	// closed and left out of surveys, default an hour.
+	Idle time.Duration
+
+	// ChurnWindow is the window ChurnRate is measured over, default 10
+	// minutes.
+	ChurnWindow time.Duration
+
+	// MaxSessions bounds the number of responders tracked, default 4096.
+	// When a new responder is heard from, the least recently seen is
+	// dropped.
+	MaxSessions int
+}
+
+// withDefaults fills in the unset fields of a SurveyConfig.
+func (c SurveyConfig) withDefaults() SurveyConfig {
+	if c.Interval <= 0 {
+		c.Interval = defaultSurveyInterval
+	}
+	if c.Idle <= 0 {
+		c.Idle = defaultSessionIdle
+	}
+	if c.ChurnWindow <= 0 {
+		c.ChurnWindow = defaultChurnWindow
+	}
+	if c.MaxSessions <= 0 {
+		c.MaxSessions = defaultMaxSessions
+	}
+	return c
+}
+
+// Session is what a survey learned about one responder, identified by the
+// address its responses came from.
+type Session struct {
+	Source    net.IP    `json:"source"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Responses int       `json:"responses"`
+
+	// Hosts are the host names the responder published addresses for or
+	// named in SRV records.
+	Hosts []string `json:"hosts,omitempty"`
+
+	// Services are the service types the responder published instances of,
+	// e.g. "_http._tcp.local.".
+	Services []string `json:"services,omitempty"`
+
+	// Instances are the service instances the responder published.
+	Instances []string `json:"instances,omitempty"`
+
+	// Records is the number of records the responder currently publishes.
+	Records int `json:"records"`
+
+	// Changes counts the records the responder withdrew with a goodbye or
+	// published again with different data.
+	Changes int `json:"changes"`
+
+	// ChurnRate is the number of changes a minute over the survey's
+	// ChurnWindow.
+	ChurnRate float64 `json:"churn_per_minute"`
+}
+
+// Survey is a document listing the responders on the network, sorted by
+// address. It encodes to JSON as is.
+type Survey struct {
+	Time     time.Time `json:"time"`
+	Sessions []Session `json:"sessions"`
+}
+
+// Survey passively tracks every responder the Client hears from, whether its
+// responses answer the Client's queries or were overheard, and sends a Survey
+// of them to ch every Interval, until the returned function is called. It is
+// meant for network visibility products: running queries, or browsing for
+// "_services._dns-sd._udp", makes the survey more complete, but it sends
+// nothing itself. Surveys are dropped if ch is not ready to receive them.
+func (c *Client) Survey(cfg SurveyConfig, ch chan<- Survey) (stop func()) {
+	s := newSurveyor(cfg)
+	c.mu.Lock()
+	if c.surveyors == nil {
+		c.surveyors = make(map[*surveyor]struct{})
+	}
+	c.surveyors[s] = struct{}{}
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(s.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case ch <- s.survey():
+				default:
+				}
+			case <-done:
+				return
+			case <-c.closedCh:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			delete(c.surveyors, s)
+			c.mu.Unlock()
+			close(done)
+		})
+	}
+}
+
+// surveySession is the state of a responder's session.
+type surveySession struct {
+	first, last time.Time
+	responses   int
+	hosts       map[string]bool
+	services    map[string]bool
+	instances   map[string]bool
+
+	// records holds the data of the records published, by name and type.
+	// The data of shared records is in lower case.
+	records map[string]map[string]bool
+
+	// changes are the times of the changes within the churn window.
+	changes []time.Time
+	total   int
+}
+
+// surveyor holds the state of a Client.Survey.
+type surveyor struct {
+	cfg SurveyConfig
+	now func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*surveySession // by source address
+}
+
+// newSurveyor returns a surveyor with no sessions.
+func newSurveyor(cfg SurveyConfig) *surveyor {
+	return &surveyor{
+		cfg:      cfg.withDefaults(),
+		now:      time.Now,
+		sessions: make(map[string]*surveySession),
+	}
+}
+
+// inspect adds a received message to the session of its source.
+func (s *surveyor) inspect(msg *dns.Msg, src *net.UDPAddr) {
+	if !msg.Response || src == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	sess := s.session(src.IP.String(), now)
+	sess.last = now
+	sess.responses++
+	rrs := append(msg.Answer, msg.Extra...)
+	for _, rr := range rrs {
+		sess.learn(rr)
+	}
+	for n := sess.update(rrs); n > 0; n-- {
+		sess.changes = append(sess.changes, now)
+		sess.total++
+	}
+	sess.expire(now, s.cfg.ChurnWindow)
+}
+
+// session returns the session of a source, starting one if needed.
+func (s *surveyor) session(key string, now time.Time) *surveySession {
+	if sess, ok := s.sessions[key]; ok {
+		return sess
+	}
+	if len(s.sessions) >= s.cfg.MaxSessions {
+		var oldest string
+		for k, sess := range s.sessions {
+			if oldest == "" || sess.last.Before(s.sessions[oldest].last) {
+				oldest = k
+			}
+		}
+		delete(s.sessions, oldest)
+	}
+	sess := &surveySession{
+		first:     now,
+		hosts:     make(map[string]bool),
+		services:  make(map[string]bool),
+		instances: make(map[string]bool),
+		records:   make(map[string]map[string]bool),
+	}
+	s.sessions[key] = sess
+	return sess
+}
+
+// learn records the hosts, services, and instances a record names.
+func (sess *surveySession) learn(rr dns.RR) {
+	name := strings.ToLower(rr.Header().Name)
+	switch rr := rr.(type) {
+	case *dns.PTR:
+		if strings.HasPrefix(name, "_services._dns-sd._udp.") {
+			sess.services[strings.ToLower(rr.Ptr)] = true
+		} else if service := instanceService(rr.Ptr); strings.EqualFold(service, name) {
+			sess.services[name] = true
+			sess.instances[strings.ToLower(rr.Ptr)] = true
+		}
+	case *dns.SRV:
+		if service := instanceService(name); service != "" {
+			sess.services[service] = true
+			sess.instances[name] = true
+		}
+		sess.hosts[strings.ToLower(rr.Target)] = true
+	case *dns.A, *dns.AAAA:
+		sess.hosts[name] = true
+	}
+}
+
+// update applies the records of a response to the records the responder
+// publishes, returning the number of changes: goodbyes for published records,
+// and unique record sets published with new data. The unique records of a
+// name and type in a response replace those published before, as a host may
+// have several, such as an address record for each of its interfaces.
+func (sess *surveySession) update(rrs []dns.RR) int {
+	changes := 0
+	unique := make(map[string]map[string]bool)
+	for _, rr := range rrs {
+		h := rr.Header()
+		key := strings.ToLower(h.Name) + "/" + dns.TypeToString[h.Rrtype]
+		data := rdata(rr)
+		if isShared(rr) {
+			data = strings.ToLower(data)
+		}
+		switch {
+		case h.Ttl == 0:
+			if set := sess.records[key]; set[data] {
+				delete(set, data)
+				if len(set) == 0 {
+					delete(sess.records, key)
+				}
+				changes++
+			}
+		case isShared(rr):
+			if sess.records[key] == nil {
+				sess.records[key] = make(map[string]bool)
+			}
+			sess.records[key][data] = true
+		default:
+			if unique[key] == nil {
+				unique[key] = make(map[string]bool)
+			}
+			unique[key][data] = true
+		}
+	}
+	for key, set := range unique {
+		if old, ok := sess.records[key]; ok && !maps.Equal(old, set) {
+			changes++
+		}
+		sess.records[key] = set
+	}
+	return changes
+}
+
+// published returns the number of records the responder publishes.
+func (sess *surveySession) published() int {
+	n := 0
+	for _, set := range sess.records {
+		n += len(set)
+	}
+	return n
+}
+
+// expire forgets the changes older than the churn window.
+func (sess *surveySession) expire(now time.Time, window time.Duration) {
+	i := 0
+	for i < len(sess.changes) && now.Sub(sess.changes[i]) > window {
+		i++
+	}
+	sess.changes = sess.changes[i:]
+}
+
+// survey closes the idle sessions and returns a Survey of the others.
+func (s *surveyor) survey() Survey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	out := Survey{Time: now, Sessions: []Session{}}
+	for key, sess := range s.sessions {
+		if now.Sub(sess.last) > s.cfg.Idle {
+			delete(s.sessions, key)
+			continue
+		}
+		sess.expire(now, s.cfg.ChurnWindow)
+		out.Sessions = append(out.Sessions, Session{
+			Source:    net.ParseIP(key),
+			FirstSeen: sess.first,
+			LastSeen:  sess.last,
+			Responses: sess.responses,
+			Hosts:     sortedKeys(sess.hosts),
+			Services:  sortedKeys(sess.services),
+			Instances: sortedKeys(sess.instances),
+			Records:   sess.published(),
+			Changes:   sess.total,
+			ChurnRate: float64(len(sess.changes)) / s.cfg.ChurnWindow.Minutes(),
+		})
+	}
+	sort.Slice(out.Sessions, func(i, j int) bool {
+		return bytes.Compare(out.Sessions[i].Source.To16(), out.Sessions[j].Source.To16()) < 0
+	})
+	return out
+}
+
+// sortedKeys returns the keys of a set, sorted.
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
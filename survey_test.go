@@ -0,0 +1,149 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestSurveyor(t *testing.T) {
+	clock := newTestClock()
+	s := newSurveyor(SurveyConfig{MaxSessions: 2})
+	s.now = clock.Now
+	start := clock.Now()
+
+	service := makeService(t)
+	src := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: mdnsPort}
+	s.inspect(makeResponse(t, service), src)
+
+	// The port changes, then the instance says goodbye
+	clock.Set(start.Add(time.Minute))
+	moved := makeResponse(t, service)
+	for _, rr := range moved.Answer {
+		if srv, ok := rr.(*dns.SRV); ok {
+			srv.Port = 8080
+		}
+	}
+	s.inspect(moved, src)
+	clock.Set(start.Add(2 * time.Minute))
+	goodbye := &dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{&dns.PTR{
+		Hdr: dns.RR_Header{Name: "_http._tcp.local.", Rrtype: dns.TypePTR, Class: dns.ClassINET},
+		Ptr: service.instanceAddr,
+	}}}
+	s.inspect(goodbye, src)
+
+	survey := s.survey()
+	if len(survey.Sessions) != 1 {
+		t.Fatalf("bad: %+v", survey)
+	}
+	sess := survey.Sessions[0]
+	if !sess.Source.Equal(src.IP) || sess.Responses != 3 || sess.Changes != 2 {
+		t.Fatalf("bad: %+v", sess)
+	}
+	if !sess.FirstSeen.Equal(start) || !sess.LastSeen.Equal(start.Add(2*time.Minute)) {
+		t.Fatalf("bad: %+v", sess)
+	}
+	if strings.Join(sess.Services, ",") != "_http._tcp.local." ||
+		strings.Join(sess.Instances, ",") != "hostname._http._tcp.local." ||
+		strings.Join(sess.Hosts, ",") != "testhost." {
+		t.Fatalf("bad: %+v", sess)
+	}
+	if sess.ChurnRate != 0.2 {
+		t.Fatalf("bad: %v", sess.ChurnRate)
+	}
+
+	// The least recently seen responder makes way for new ones
+	clock.Set(start.Add(3 * time.Minute))
+	s.inspect(makeResponse(t, service), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3)})
+	s.inspect(makeResponse(t, service), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2)})
+	survey = s.survey()
+	if len(survey.Sessions) != 2 || !survey.Sessions[0].Source.Equal(net.IPv4(10, 0, 0, 2)) {
+		t.Fatalf("bad: %+v", survey)
+	}
+
+	// Idle sessions are closed
+	clock.Set(start.Add(4 * time.Hour))
+	if survey := s.survey(); len(survey.Sessions) != 0 {
+		t.Fatalf("bad: %+v", survey)
+	}
+}
+
+func TestSurveyor_SeveralAddresses(t *testing.T) {
+	s := newSurveyor(SurveyConfig{})
+	src := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: mdnsPort}
+	a := func(ip string, ttl uint32) dns.RR {
+		return &dns.A{
+			Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlushBit, Ttl: ttl},
+			A:   net.ParseIP(ip),
+		}
+	}
+
+	// A host with two addresses repeats its response, which changes nothing
+	for i := 0; i < 3; i++ {
+		s.inspect(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{a("10.0.0.1", 120), a("192.168.1.1", 120)}}, src)
+	}
+	if sess := s.survey().Sessions[0]; sess.Records != 2 || sess.Changes != 0 {
+		t.Fatalf("bad: %+v", sess)
+	}
+
+	// It moves one of its addresses, then withdraws the other
+	s.inspect(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{a("10.0.0.1", 120), a("192.168.1.2", 120)}}, src)
+	s.inspect(&dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{a("10.0.0.1", 0)}}, src)
+	if sess := s.survey().Sessions[0]; sess.Records != 1 || sess.Changes != 2 {
+		t.Fatalf("bad: %+v", sess)
+	}
+}
+
+func TestClient_Survey(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serv, err := NewServer(&Config{Zone: makeService(t), Transport: network.Host(net.ParseIP("10.0.0.1"))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	surveys := make(chan Survey, 16)
+	stop := c.Survey(SurveyConfig{Interval: 50 * time.Millisecond}, surveys)
+	defer stop()
+	params := &[]QueryParam{{Service: "_http._tcp", Timeout: 50 * time.Millisecond}}
+	if err := QueryContext(context.Background(), params, make(chan *ServiceEntry, 4), c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case survey := <-surveys:
+			if len(survey.Sessions) == 0 {
+				continue
+			}
+			data, err := json.Marshal(survey)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if !strings.Contains(string(data), `"source":"10.0.0.1"`) ||
+				!strings.Contains(string(data), `"services":["_http._tcp.local."]`) {
+				t.Fatalf("bad: %s", data)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("no responder surveyed")
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"math"
+	"math/bits"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+)
+
+// timingBuckets is the number of bounded histogram buckets. Bucket i counts
+// the durations under 2^i microseconds, so the last bounded bucket ends a
+// little over a second; longer durations go to an unbounded bucket.
+const timingBuckets = 21
+
+// Stage is a stage of the path a packet takes through a Client or Server.
+type Stage int
+
+const (
+	// StageUnpack is the parsing of a received packet.
+	StageUnpack Stage = iota
+
+	// StageProcess is the handling of a parsed message: caching a response
+	// and handing it to the running queries, or answering a query. For a
+	// Server it includes the time spent sending the answers, and for a
+	// Client the time spent waiting for a slow query to take the response.
+	StageProcess
+
+	// StageSend is the writing of an outgoing message to every socket it is
+	// sent on. A Client's queries are also packed in this stage.
+	StageSend
+
+	numStages
+)
+
+// stageNames are the names of the stages, also used as their pprof labels.
+var stageNames = [numStages]string{"unpack", "process", "send"}
+
+// String returns the name of the stage.
+func (s Stage) String() string {
+	if s < 0 || s >= numStages {
+		return "unknown"
+	}
+	return stageNames[s]
+}
+
+// Timings measures how long each Stage takes, so that performance
+// regressions can be observed in production and attributed to a stage. Set
+// it with Config.Timings or Client.SetTimings; one Timings may be shared by
+// several. The zero value is ready to use, and recording is lock free.
+//
+// Whether or not Timings are set, the goroutines receiving packets carry the
+// pprof labels "mdns" (the role, "client" or "server") and "stage" (the
+// stage, or "recv" while waiting for a packet), as do queries while they
+// send, so that CPU profiles can be broken down the same way.
+type Timings struct {
+	stages [numStages]histogram
+}
+
+// histogram counts durations in power of two buckets.
+type histogram struct {
+	counts [timingBuckets + 1]atomic.Uint64
+	sum    atomic.Int64
+}
+
+// Bucket is a bucket of a Histogram.
+type Bucket struct {
+	// UpperBound is the duration the bucket counts those under. It is the
+	// largest time.Duration for the last bucket.
+	UpperBound time.Duration
+	Count      uint64
+}
+
+// Histogram is the distribution of the durations of a Stage.
+type Histogram struct {
+	Count   uint64
+	Sum     time.Duration
+	Buckets []Bucket // Not cumulative, in increasing order of UpperBound
+}
+
+// Histogram returns the distribution of the durations of a stage recorded
+// so far.
+func (t *Timings) Histogram(s Stage) Histogram {
+	h := &t.stages[s]
+	out := Histogram{
+		Sum:     time.Duration(h.sum.Load()),
+		Buckets: make([]Bucket, len(h.counts)),
+	}
+	for i := range h.counts {
+		n := h.counts[i].Load()
+		out.Count += n
+		out.Buckets[i] = Bucket{UpperBound: bucketBound(i), Count: n}
+	}
+	return out
+}
+
+// Reset discards the durations recorded so far.
+func (t *Timings) Reset() {
+	for s := range t.stages {
+		h := &t.stages[s]
+		for i := range h.counts {
+			h.counts[i].Store(0)
+		}
+		h.sum.Store(0)
+	}
+}
+
+// Mean returns the average duration, or zero if there is none.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns an upper bound of the q-quantile of the durations, for q
+// between 0 and 1: the upper bound of the bucket holding it. It returns zero
+// if there is no duration.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.Count)))
+	var seen uint64
+	for _, b := range h.Buckets {
+		seen += b.Count
+		if seen >= rank && seen > 0 {
+			return b.UpperBound
+		}
+	}
+	return h.Buckets[len(h.Buckets)-1].UpperBound
+}
+
+// bucketBound returns the upper bound of bucket i.
+func bucketBound(i int) time.Duration {
+	if i >= timingBuckets {
+		return math.MaxInt64
+	}
+	return time.Duration(1<<i) * time.Microsecond
+}
+
+// start returns the time a stage starts, or the zero time if t is nil so
+// that nothing is measured.
+func (t *Timings) start() time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// done records the duration of a stage started at start.
+func (t *Timings) done(s Stage, start time.Time) {
+	if t == nil {
+		return
+	}
+	d := time.Since(start)
+	if d < 0 {
+		d = 0
+	}
+	i := bits.Len64(uint64(d / time.Microsecond))
+	if i > timingBuckets {
+		i = timingBuckets
+	}
+	h := &t.stages[s]
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// SetTimings sets the Timings the Client records the duration of each stage
+// of its packets to. A nil Timings stops recording.
+func (c *Client) SetTimings(t *Timings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timings = t
+}
+
+// getTimings returns the Timings the Client records to, or nil.
+func (c *Client) getTimings() *Timings {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.timings
+}
+
+// stageLabels holds the pprof labels of a role, as contexts to switch a
+// goroutine's labels to without allocating.
+type stageLabels struct {
+	recv   context.Context
+	stages [numStages]context.Context
+	send   pprof.LabelSet
+}
+
+var (
+	clientLabels = newStageLabels("client")
+	serverLabels = newStageLabels("server")
+)
+
+// newStageLabels returns the labels of a role.
+func newStageLabels(role string) *stageLabels {
+	l := &stageLabels{
+		recv: pprof.WithLabels(context.Background(), pprof.Labels("mdns", role, "stage", "recv")),
+		send: pprof.Labels("mdns", role, "stage", StageSend.String()),
+	}
+	for s := range l.stages {
+		l.stages[s] = pprof.WithLabels(context.Background(), pprof.Labels("mdns", role, "stage", Stage(s).String()))
+	}
+	return l
+}
+
+// enter switches the labels of the calling goroutine, which must be one of
+// the package's own, to those of a stage.
+func (l *stageLabels) enter(s Stage) {
+	pprof.SetGoroutineLabels(l.stages[s])
+}
+
+// wait switches the labels of the calling goroutine to those of waiting for
+// a packet.
+func (l *stageLabels) wait() {
+	pprof.SetGoroutineLabels(l.recv)
+}
@@ -0,0 +1,136 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestTimings_Histogram(t *testing.T) {
+	var tm Timings
+	now := time.Now()
+	tm.done(StageUnpack, now.Add(-3*time.Millisecond))
+	tm.done(StageUnpack, now.Add(-3*time.Millisecond))
+	tm.done(StageUnpack, now.Add(-time.Minute))
+
+	h := tm.Histogram(StageUnpack)
+	if h.Count != 3 || len(h.Buckets) != timingBuckets+1 {
+		t.Fatalf("bad: %+v", h)
+	}
+	// 3ms is under 2^12 microseconds
+	if b := h.Buckets[12]; b.UpperBound != 4096*time.Microsecond || b.Count != 2 {
+		t.Fatalf("bad: %+v", b)
+	}
+	if b := h.Buckets[timingBuckets]; b.UpperBound != math.MaxInt64 || b.Count != 1 {
+		t.Fatalf("bad: %+v", b)
+	}
+	if q := h.Quantile(0.5); q != 4096*time.Microsecond {
+		t.Fatalf("bad: %v", q)
+	}
+	if q := h.Quantile(1); q != math.MaxInt64 {
+		t.Fatalf("bad: %v", q)
+	}
+	if m := h.Mean(); m < 20*time.Second || m > 21*time.Second {
+		t.Fatalf("bad: %v", m)
+	}
+	if h := tm.Histogram(StageSend); h.Count != 0 || h.Quantile(0.5) != 0 || h.Mean() != 0 {
+		t.Fatalf("bad: %+v", h)
+	}
+
+	tm.Reset()
+	if h := tm.Histogram(StageUnpack); h.Count != 0 || h.Sum != 0 {
+		t.Fatalf("bad: %+v", h)
+	}
+
+	// Nothing is measured without Timings
+	var none *Timings
+	none.done(StageProcess, none.start())
+}
+
+func TestTimings_ClientServer(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	var st, ct Timings
+	serv, err := NewServer(&Config{
+		Zone:      makeService(t),
+		Transport: network.Host(net.ParseIP("10.0.0.1")),
+		Timings:   &st,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	c, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.2")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+	c.SetTimings(&ct)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.ResolveInstance(ctx, "hostname", "_http._tcp", ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for _, tm := range []*Timings{&st, &ct} {
+		for s := StageUnpack; s < numStages; s++ {
+			if h := tm.Histogram(s); h.Count == 0 || h.Sum <= 0 {
+				t.Fatalf("no %v timings: %+v", s, h)
+			}
+		}
+	}
+}
+
+func TestStage_String(t *testing.T) {
+	if s := StageProcess.String(); s != "process" {
+		t.Fatalf("bad: %s", s)
+	}
+	if s := Stage(42).String(); s != "unknown" {
+		t.Fatalf("bad: %s", s)
+	}
+}
+
+func benchmarkParsePacket(b *testing.B, timings bool) {
+	service := makeService(b)
+	s, conn := answerServer(b, service, false)
+	if timings {
+		s.config.Timings = new(Timings)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion(service.serviceAddr, dns.TypePTR)
+	buf, err := q.Pack()
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	// Drain the responses so that they are not dropped for a full queue
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.parsePacket(buf, conn.LocalAddr()); err != nil {
+			b.Fatalf("err: %v", err)
+		}
+	}
+}
+
+func BenchmarkServer_ParsePacket(b *testing.B)        { benchmarkParsePacket(b, false) }
+func BenchmarkServer_ParsePacketTimings(b *testing.B) { benchmarkParsePacket(b, true) }
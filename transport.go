@@ -0,0 +1,135 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Transport opens the packet connections used by Clients and Servers. The
+// default, DefaultTransport, uses UDP sockets; an in-memory implementation
+// allows the protocol to be exercised without a network, see the memnet
+// package.
+//
+// Socket options, such as the multicast interface, group membership, and
+// per-packet interface selection, only apply to connections that are
+// *net.UDPConn.
+type Transport interface {
+	// ListenUDP opens a connection for sending queries and receiving the
+	// unicast responses to them, as net.ListenUDP.
+	ListenUDP(network string, laddr *net.UDPAddr) (net.PacketConn, error)
+
+	// ListenMulticastUDP opens a connection receiving the traffic sent to
+	// the group address on an interface, as net.ListenMulticastUDP.
+	ListenMulticastUDP(network string, ifi *net.Interface, gaddr *net.UDPAddr) (net.PacketConn, error)
+}
+
+// DefaultTransport opens UDP sockets. The mDNS port is shared with the mDNS
+// daemon of the system, if any.
+var DefaultTransport Transport = udpTransport{}
+
+// CompliantTransport opens UDP sockets like DefaultTransport, except that the
+// sockets used to send queries are bound to port 5353, so that the queries
+// originate from the mDNS port as RFC 6762, section 5, expects of fully
+// compliant queriers. Some responders ignore queries from other ports. The
+// port is shared with other mDNS software using SO_REUSEADDR and SO_REUSEPORT;
+// if it still cannot be bound, the socket falls back to the requested port.
+//
+// Where several sockets share port 5353, unicast responses may be delivered
+// to any of them, so queries asking for unicast responses are less reliable
+// in this mode.
+var CompliantTransport Transport = udpTransport{compliant: true}
+
+// udpTransport is the Transport implemented by UDP sockets.
+type udpTransport struct {
+	compliant bool // bind sending sockets to port 5353
+}
+
+// ListenUDP binds laddr as net.ListenUDP does, except that the mDNS port is
+// shared with the mDNS daemon of the system with SO_REUSEADDR and
+// SO_REUSEPORT, as in ListenMulticastUDP.
+func (t udpTransport) ListenUDP(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+	if t.compliant && (laddr.Port == 0 || laddr.Port == mdnsPort) {
+		lc := net.ListenConfig{Control: reusePort}
+		addr := &net.UDPAddr{IP: laddr.IP, Port: mdnsPort, Zone: laddr.Zone}
+		if conn, err := lc.ListenPacket(context.Background(), network, addr.String()); err == nil {
+			return conn, nil
+		}
+	}
+	if canReusePort && laddr.Port == mdnsPort {
+		lc := net.ListenConfig{Control: reusePort}
+		return lc.ListenPacket(context.Background(), network, laddr.String())
+	}
+	conn, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ListenMulticastUDP binds the group's port on the wildcard address with
+// SO_REUSEADDR and SO_REUSEPORT, so that the port is shared with the mDNS
+// daemon of the system, such as Avahi or mDNSResponder, whichever of the two
+// options it set. net.ListenMulticastUDP only sets SO_REUSEADDR on Linux. Like
+// it, the socket joins the group on ifi, sends on ifi, and does not loop its
+// own packets back. Where ports cannot be shared, net.ListenMulticastUDP is
+// used as it is.
+func (udpTransport) ListenMulticastUDP(network string, ifi *net.Interface, gaddr *net.UDPAddr) (net.PacketConn, error) {
+	if !canReusePort {
+		conn, err := net.ListenMulticastUDP(network, ifi, gaddr)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+	lc := net.ListenConfig{Control: reusePort}
+	conn, err := lc.ListenPacket(context.Background(), network, net.JoinHostPort("", strconv.Itoa(gaddr.Port)))
+	if err != nil {
+		return nil, err
+	}
+	if err := joinMulticast(conn, ifi, gaddr); err != nil {
+		conn.Close()
+		return nil, &net.OpError{Op: "listen", Net: network, Addr: gaddr, Err: err}
+	}
+	return conn, nil
+}
+
+// joinMulticast sets up a socket bound to a group's port as
+// net.ListenMulticastUDP does.
+func joinMulticast(conn net.PacketConn, ifi *net.Interface, gaddr *net.UDPAddr) error {
+	if gaddr.IP.To4() != nil {
+		p := ipv4.NewPacketConn(conn)
+		if ifi != nil {
+			if err := p.SetMulticastInterface(ifi); err != nil {
+				return err
+			}
+		}
+		if err := p.SetMulticastLoopback(false); err != nil {
+			return err
+		}
+		return p.JoinGroup(ifi, gaddr)
+	}
+	p := ipv6.NewPacketConn(conn)
+	if ifi != nil {
+		if err := p.SetMulticastInterface(ifi); err != nil {
+			return err
+		}
+	}
+	if err := p.SetMulticastLoopback(false); err != nil {
+		return err
+	}
+	return p.JoinGroup(ifi, gaddr)
+}
+
+// isSocket reports whether conn is a UDP socket, to which socket options can
+// be applied.
+func isSocket(conn net.PacketConn) bool {
+	_, ok := conn.(*net.UDPConn)
+	return ok
+}
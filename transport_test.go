@@ -0,0 +1,150 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sloweclair/mdns/memnet"
+)
+
+func TestClient_AdverseNetwork(t *testing.T) {
+	// Lose everything until the first questions are gone
+	network := memnet.New(memnet.Config{Loss: 1})
+
+	serv, err := NewServer(&Config{
+		Zone:      makeServiceWithServiceName(t, "_memnet._tcp"),
+		Transport: network.Host(net.ParseIP("10.0.0.2")),
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	client, err := NewClientTransport(network.Host(net.ParseIP("10.0.0.1")), true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer client.Close()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		network.SetConfig(memnet.Config{
+			Duplicate: 1,
+			Latency:   memnet.Uniform(time.Millisecond, 20*time.Millisecond),
+		})
+	}()
+
+	entries := make(chan *ServiceEntry, 4)
+	params := &[]QueryParam{{Service: "_memnet._tcp", RetransmitInterval: 100 * time.Millisecond}}
+	if err := Query(params, entries, client); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	close(entries)
+
+	// Found through a retransmission, and reported once despite duplicates
+	var found []*ServiceEntry
+	for e := range entries {
+		found = append(found, e)
+	}
+	if len(found) != 1 || found[0].Name != "hostname._memnet._tcp.local." || found[0].Port != 80 {
+		t.Fatalf("bad entries: %v", found)
+	}
+	if s := network.Stats(); s.Dropped == 0 || s.Duplicated == 0 {
+		t.Fatalf("network was not impaired: %+v", s)
+	}
+}
+
+func TestCompliantTransport(t *testing.T) {
+	// Both sockets share the mDNS port
+	for i := 0; i < 2; i++ {
+		conn, err := CompliantTransport.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer conn.Close()
+		if port := conn.LocalAddr().(*net.UDPAddr).Port; port != mdnsPort {
+			t.Fatalf("bound port %d, want %d", port, mdnsPort)
+		}
+	}
+
+	// Other ports are left alone
+	conn, err := CompliantTransport.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 5454})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+	if port := conn.LocalAddr().(*net.UDPAddr).Port; port != 5454 {
+		t.Fatalf("bound port %d, want 5454", port)
+	}
+}
+
+// unicastOnly is a Transport whose connections drop multicast packets, as on
+// a point-to-point link.
+type unicastOnly struct {
+	Transport
+}
+
+func (u unicastOnly) ListenUDP(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+	conn, err := u.Transport.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return unicastOnlyConn{conn}, nil
+}
+
+func (u unicastOnly) ListenMulticastUDP(network string, ifi *net.Interface, gaddr *net.UDPAddr) (net.PacketConn, error) {
+	conn, err := u.Transport.ListenMulticastUDP(network, ifi, gaddr)
+	if err != nil {
+		return nil, err
+	}
+	return unicastOnlyConn{conn}, nil
+}
+
+type unicastOnlyConn struct {
+	net.PacketConn
+}
+
+func (c unicastOnlyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if udp, ok := addr.(*net.UDPAddr); ok && udp.IP.IsMulticast() {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func TestClient_QueryPeers(t *testing.T) {
+	network := memnet.New(memnet.Config{})
+	serverIP := net.ParseIP("10.0.0.2")
+	serv, err := NewServer(&Config{
+		Zone:      makeServiceWithServiceName(t, "_peer._tcp"),
+		Transport: unicastOnly{network.Host(serverIP)},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	client, err := NewClientTransport(unicastOnly{network.Host(net.ParseIP("10.0.0.1"))}, true, false, log.Default(), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer client.Close()
+
+	entries := make(chan *ServiceEntry, 1)
+	params := &[]QueryParam{{Service: "_peer._tcp", Peers: []net.IP{serverIP}}}
+	if err := Query(params, entries, client); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case e := <-entries:
+		if e.Name != "hostname._peer._tcp.local." {
+			t.Fatalf("bad: %+v", e)
+		}
+	default:
+		t.Fatalf("service was not discovered through its peer address")
+	}
+}
@@ -4,6 +4,7 @@
 package mdns
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -24,15 +25,29 @@ type Zone interface {
 	Records(q dns.Question) []dns.RR
 }
 
+// MultiZone combines several zones so that a single Server can publish
+// multiple services, or the same host under several host names.
+type MultiZone []Zone
+
+// Records returns the DNS records of every zone in response to a DNS question.
+func (z MultiZone) Records(q dns.Question) []dns.RR {
+	var recs []dns.RR
+	for _, zone := range z {
+		recs = append(recs, zone.Records(q)...)
+	}
+	return recs
+}
+
 // MDNSService is used to export a named service by implementing a Zone
 type MDNSService struct {
 	Instance string   // Instance name (e.g. "hostService name")
 	Service  string   // Service name (e.g. "_http._tcp.")
-	Domain   string   // If blank, assumes "local"
+	Domain   string   // If blank, assumes "local", e.g. "openthread.thread.home.arpa."
 	HostName string   // Host machine DNS name (e.g. "mymachine.net.")
 	Port     int      // Service Port
 	IPs      []net.IP // IP addresses for the service's host
 	TXT      []string // Service TXT records
+	Aliases  []string // Additional host names (CNAMEs) pointing at HostName, e.g. "printer.local."
 
 	serviceAddr  string // Fully qualified service address
 	instanceAddr string // Fully qualified instance address
@@ -77,7 +92,7 @@ func NewMDNSService(instance, service, domain, hostName string, port int, ips []
 
 	// Set default domain
 	if domain == "" {
-		domain = "local."
+		domain = Fqdn(defaultDomain)
 	}
 	if err := validateFQDN(domain); err != nil {
 		return nil, fmt.Errorf("domain %q is not a fully-qualified domain name: %v", domain, err)
@@ -125,34 +140,125 @@ func NewMDNSService(instance, service, domain, hostName string, port int, ips []
 		Port:         port,
 		IPs:          ips,
 		TXT:          txt,
-		serviceAddr:  fmt.Sprintf("%s.%s.", trimDot(service), trimDot(domain)),
-		instanceAddr: fmt.Sprintf("%s.%s.%s.", instance, trimDot(service), trimDot(domain)),
-		enumAddr:     fmt.Sprintf("_services._dns-sd._udp.%s.", trimDot(domain)),
+		serviceAddr:  ServiceName(service, domain),
+		instanceAddr: InstanceName(instance, service, domain),
+		enumAddr:     fmt.Sprintf("_services._dns-sd._udp.%s.", TrimDot(domain)),
 	}, nil
 }
 
-// trimDot is used to trim the dots from the start or end of a string
-func trimDot(s string) string {
-	return strings.Trim(s, ".")
+// ErrNoLinkLocal is returned by MDNSService.LinkLocalOnly when the service has
+// no IPv6 link-local address to advertise.
+var ErrNoLinkLocal = errors.New("mdns: no IPv6 link-local address")
+
+// LinkLocalOnly restricts the addresses the service advertises to its IPv6
+// link-local ones, so that globally routable and IPv4 addresses are not leaked
+// into mDNS. If the service has no link-local address, ErrNoLinkLocal is
+// returned and the service is left unchanged.
+func (m *MDNSService) LinkLocalOnly() error {
+	var ips []net.IP
+	for _, ip := range m.IPs {
+		if ip.To4() == nil && ip.IsLinkLocalUnicast() {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("%w for %s", ErrNoLinkLocal, m.HostName)
+	}
+	m.IPs = ips
+	return nil
 }
 
-// Records returns DNS records in response to a DNS question.
+// Records returns DNS records in response to a DNS question. Names are
+// matched regardless of case, as in all of DNS.
 func (m *MDNSService) Records(q dns.Question) []dns.RR {
-	switch q.Name {
-	case m.enumAddr:
+	switch {
+	case strings.EqualFold(q.Name, m.enumAddr):
 		return m.serviceEnum(q)
-	case m.serviceAddr:
+	case strings.EqualFold(q.Name, m.serviceAddr):
 		return m.serviceRecords(q)
-	case m.instanceAddr:
+	case strings.EqualFold(q.Name, m.instanceAddr):
 		return m.instanceRecords(q)
-	case m.HostName:
-		if q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA {
-			return m.instanceRecords(q)
+	case strings.EqualFold(q.Name, m.HostName) && (q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA):
+		return m.instanceRecords(q)
+	case m.isAlias(q.Name):
+		return m.aliasRecords(q)
+	case q.Qtype == dns.TypePTR || q.Qtype == dns.TypeANY:
+		return m.reverseRecords(q)
+	default:
+		return nil
+	}
+}
+
+// reverseRecords answers a reverse lookup of one of the service's addresses
+// with its host name, as described in RFC 6762, section 4. Other PTR and ANY
+// questions, such as those for services, are common, so their names are
+// checked for a reverse domain before any address is turned into one.
+func (m *MDNSService) reverseRecords(q dns.Question) []dns.RR {
+	if !isReverseName(q.Name) {
+		return nil
+	}
+	for _, ip := range m.IPs {
+		name, err := dns.ReverseAddr(ip.String())
+		if err != nil || !strings.EqualFold(q.Name, name) {
+			continue
 		}
-		fallthrough
+		return []dns.RR{&dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET,
+				Ttl:    defaultTTL,
+			},
+			Ptr: m.HostName,
+		}}
+	}
+	return nil
+}
+
+// isReverseName reports whether name is in one of the reverse lookup
+// domains, "in-addr.arpa." and "ip6.arpa.".
+func isReverseName(name string) bool {
+	name = strings.ToLower(Fqdn(name))
+	return strings.HasSuffix(name, ".in-addr.arpa.") || strings.HasSuffix(name, ".ip6.arpa.")
+}
+
+// isAlias reports whether name is one of the service's host aliases, which
+// may be given with or without the trailing dot.
+func (m *MDNSService) isAlias(name string) bool {
+	name = canonicalName(name)
+	for _, alias := range m.Aliases {
+		if canonicalName(Fqdn(alias)) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasRecords is called when the query matches one of the host aliases. The
+// CNAME is returned along with the address records of the canonical host so
+// that the querier doesn't need a second round trip.
+func (m *MDNSService) aliasRecords(q dns.Question) []dns.RR {
+	switch q.Qtype {
+	case dns.TypeANY, dns.TypeCNAME, dns.TypeA, dns.TypeAAAA:
 	default:
 		return nil
 	}
+	recs := []dns.RR{&dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   q.Name,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    defaultTTL,
+		},
+		Target: m.HostName,
+	}}
+	if q.Qtype == dns.TypeANY || q.Qtype == dns.TypeA {
+		recs = append(recs, m.instanceRecords(dns.Question{Name: m.HostName, Qtype: dns.TypeA})...)
+	}
+	if q.Qtype == dns.TypeANY || q.Qtype == dns.TypeAAAA {
+		recs = append(recs, m.instanceRecords(dns.Question{Name: m.HostName, Qtype: dns.TypeAAAA})...)
+	}
+	return recs
 }
 
 func (m *MDNSService) serviceEnum(q dns.Question) []dns.RR {
@@ -5,18 +5,20 @@ package mdns
 
 import (
 	"bytes"
+	"errors"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/miekg/dns"
 )
 
-func makeService(t *testing.T) *MDNSService {
+func makeService(t testing.TB) *MDNSService {
 	return makeServiceWithServiceName(t, "_http._tcp")
 }
 
-func makeServiceWithServiceName(t *testing.T, service string) *MDNSService {
+func makeServiceWithServiceName(t testing.TB, service string) *MDNSService {
 	m, err := NewMDNSService(
 		"hostname",
 		service,
@@ -276,3 +278,132 @@ func TestMDNSService_serviceEnum_PTR(t *testing.T) {
 		t.Fatalf("bad PTR record %v: got %v, want %v", ptr, got, want)
 	}
 }
+
+func TestMDNSService_Alias(t *testing.T) {
+	s := makeService(t)
+	s.Aliases = []string{"printer.local."}
+
+	q := dns.Question{Name: "printer.local.", Qtype: dns.TypeA}
+	recs := s.Records(q)
+	if len(recs) != 2 {
+		t.Fatalf("bad: %v", recs)
+	}
+	cname, ok := recs[0].(*dns.CNAME)
+	if !ok {
+		t.Fatalf("recs[0] should be CNAME record, got: %v", recs[0])
+	}
+	if got, want := cname.Target, "testhost."; got != want {
+		t.Fatalf("bad CNAME target: got %v, want %v", got, want)
+	}
+	if _, ok := recs[1].(*dns.A); !ok {
+		t.Fatalf("recs[1] should be A record, got: %v", recs[1])
+	}
+
+	q.Qtype = dns.TypeANY
+	if recs := s.Records(q); len(recs) != 3 {
+		t.Fatalf("bad: %v", recs)
+	}
+
+	q.Qtype = dns.TypeTXT
+	if recs := s.Records(q); len(recs) != 0 {
+		t.Fatalf("bad: %v", recs)
+	}
+
+	// Aliases match whatever their case and trailing dot
+	s.Aliases = []string{"Printer.local", "scanner.LOCAL."}
+	for _, name := range []string{"printer.local.", "PRINTER.LOCAL.", "Scanner.local."} {
+		if recs := s.Records(dns.Question{Name: name, Qtype: dns.TypeA}); len(recs) != 2 {
+			t.Fatalf("%s: bad: %v", name, recs)
+		}
+	}
+}
+
+func TestMultiZone(t *testing.T) {
+	http := makeService(t)
+	ftp := makeServiceWithServiceName(t, "_ftp._tcp")
+	z := MultiZone{http, ftp}
+
+	q := dns.Question{
+		Name:  "_services._dns-sd._udp.local.",
+		Qtype: dns.TypePTR,
+	}
+	recs := z.Records(q)
+	if len(recs) != 2 {
+		t.Fatalf("bad: %v", recs)
+	}
+
+	q = dns.Question{
+		Name:  "_ftp._tcp.local.",
+		Qtype: dns.TypePTR,
+	}
+	recs = z.Records(q)
+	if len(recs) != 5 {
+		t.Fatalf("bad: %v", recs)
+	}
+	if got, want := recs[0].(*dns.PTR).Ptr, "hostname._ftp._tcp.local."; got != want {
+		t.Fatalf("bad PTR record: got %v, want %v", got, want)
+	}
+}
+
+func TestMDNSService_LinkLocalOnly(t *testing.T) {
+	s := makeService(t)
+	if err := s.LinkLocalOnly(); !errors.Is(err, ErrNoLinkLocal) {
+		t.Fatalf("got %v, want ErrNoLinkLocal", err)
+	}
+	if len(s.IPs) != 2 {
+		t.Fatalf("service should be unchanged: %v", s.IPs)
+	}
+
+	s.IPs = append(s.IPs, net.ParseIP("fe80::1"))
+	if err := s.LinkLocalOnly(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(s.IPs) != 1 || !s.IPs[0].Equal(net.ParseIP("fe80::1")) {
+		t.Fatalf("bad: %v", s.IPs)
+	}
+	if recs := s.Records(dns.Question{Name: s.HostName, Qtype: dns.TypeA}); len(recs) != 0 {
+		t.Fatalf("IPv4 address was advertised: %v", recs)
+	}
+	if recs := s.Records(dns.Question{Name: s.HostName, Qtype: dns.TypeAAAA}); len(recs) != 1 {
+		t.Fatalf("bad: %v", recs)
+	}
+}
+
+func TestMDNSService_Domain(t *testing.T) {
+	s, err := NewMDNSService("hostname", "_http._tcp", "openthread.thread.home.arpa.", "testhost.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s.Domain != "openthread.thread.home.arpa." || s.serviceAddr != "_http._tcp.openthread.thread.home.arpa." ||
+		s.enumAddr != "_services._dns-sd._udp.openthread.thread.home.arpa." {
+		t.Fatalf("bad: %+v", s)
+	}
+
+	// Names are matched regardless of case
+	recs := s.Records(dns.Question{Name: "_HTTP._tcp.OpenThread.Thread.Home.Arpa.", Qtype: dns.TypePTR})
+	if len(recs) == 0 || recs[0].(*dns.PTR).Ptr != "hostname._http._tcp.openthread.thread.home.arpa." {
+		t.Fatalf("bad: %v", recs)
+	}
+	if recs := s.Records(dns.Question{Name: "_http._tcp.local.", Qtype: dns.TypePTR}); len(recs) != 0 {
+		t.Fatalf("answered for another domain: %v", recs)
+	}
+}
+
+func TestMDNSService_ReverseRecords(t *testing.T) {
+	s := makeService(t)
+	for _, addr := range []string{"192.168.0.42", "2620:0:1000:1900:b0c2:d0b2:c411:18bc"} {
+		name, err := dns.ReverseAddr(addr)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		recs := s.Records(dns.Question{Name: strings.ToUpper(name), Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+		if len(recs) != 1 || recs[0].(*dns.PTR).Ptr != "testhost." {
+			t.Fatalf("%s: bad: %v", addr, recs)
+		}
+	}
+	name, _ := dns.ReverseAddr("192.168.0.43")
+	if recs := s.Records(dns.Question{Name: name, Qtype: dns.TypePTR, Qclass: dns.ClassINET}); len(recs) != 0 {
+		t.Fatalf("answered for another address: %v", recs)
+	}
+}